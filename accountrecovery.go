@@ -0,0 +1,278 @@
+package occlude
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+)
+
+// recoverySaltSize is the length of the salt a recovery secret is hardened
+// with, in bytes.
+const recoverySaltSize = 16
+
+var (
+	// ErrNoRecoveryEnvelope is returned by Server.NewRecovery when the user
+	// did not register a recovery envelope.
+	ErrNoRecoveryEnvelope = errors.New("no recovery envelope registered")
+
+	// ErrIncorrectRecoverySecret is returned by Client.Recover when the
+	// recovery secret does not open the user's recovery envelope.
+	ErrIncorrectRecoverySecret = errors.New("incorrect recovery secret")
+)
+
+type (
+	// recoveryEnvelope is a second copy of the user's credentials, together
+	// with their export key, sealed under a key derived from a recovery
+	// secret instead of the OPRF output.
+	recoveryEnvelope struct {
+		Argon2 Argon2Params
+		Salt   []byte
+		c      authCiphertext
+	}
+
+	// RecoveryChallenge is the server's response to NewRecovery. It holds the
+	// user's recovery envelope, and a fresh Nonce for the client to prove that
+	// it opened it.
+	RecoveryChallenge struct {
+		ID       string
+		Nonce    []byte
+		envelope recoveryEnvelope
+	}
+
+	// RecoveryProof is the client's proof, in response to a
+	// RecoveryChallenge, that it knows the user's recovery secret. It is a
+	// signature over the challenge by the client's private key `pu`, which
+	// can only be recovered from the recovery envelope using the secret.
+	RecoveryProof struct {
+		ID        string
+		Signature []byte
+	}
+)
+
+// NewRegistrationWithRecovery is NewRegistration, additionally sealing the
+// user's credentials and export key under recoveryCode, a recovery code from
+// GenerateRecoveryCode. The resulting recovery envelope is stored by the
+// server with the password file, and allows a user who has forgotten their
+// password to recover their export key and register a new password with
+// Recover. A recoveryCode which is not a well-formed recovery code is
+// rejected with ErrInvalidRecoveryCode.
+//
+// NOTE: the recovery code is a second credential for the account, with the
+// same power as the password. Anyone who learns it can take over the account.
+// Unlike the password, it does not pass through the server's OPRF: NewRecovery
+// hands the recovery envelope to anyone who names the user, without
+// authentication, so anyone can attack the recovery code offline, without
+// compromising the server. It is therefore required to be a recovery code of
+// 128 random bits, against which such an attack is infeasible, rather than
+// something the user chooses, and should be stored offline.
+func (c *Client) NewRegistrationWithRecovery(sinfo *pendingRegistration, username string, password string, recoveryCode string) (*Registration, error) {
+	recoverySecret, err := parseRecoveryCode(recoveryCode)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(recoverySecret)
+	return c.newRegistration(sinfo, username, password, recoverySecret)
+}
+
+// NewRecovery starts an account recovery for the user id, returning the
+// user's recovery envelope for the client to open with Recover. The caller is
+// not authenticated, so the NOTE on NewRegistrationWithRecovery applies.
+func (s *Server) NewRecovery(id string) (*RecoveryChallenge, error) {
+	done, err := s.permitRegistration()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	id = s.userID(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pf, exists := s.passwordFiles[id]
+	if !exists {
+		return nil, errors.New("no such sid")
+	}
+	if pf.recovery == nil {
+		return nil, ErrNoRecoveryEnvelope
+	}
+
+	nonce := make([]byte, passwordCheckNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	s.recoveries[id] = nonce
+	return &RecoveryChallenge{ID: id, Nonce: nonce, envelope: *pf.recovery}, nil
+}
+
+// Recover opens the recovery envelope in the RecoveryChallenge with the
+// recovery code the user registered with NewRegistrationWithRecovery. It
+// returns a RecoveryProof for the server, and the user's export key, so that
+// data protected by it can be carried over to the new password. If the code is
+// malformed, as when it was mistyped, it returns ErrInvalidRecoveryCode, and
+// if it is incorrect, ErrIncorrectRecoverySecret.
+func (c *Client) Recover(challenge *RecoveryChallenge, recoveryCode string) (*RecoveryProof, []byte, error) {
+	if challenge == nil {
+		return nil, nil, ErrNilMessage
+	}
+	recoverySecret, err := parseRecoveryCode(recoveryCode)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer clear(recoverySecret)
+	env := challenge.envelope
+	if err := c.checkArgon2Params(env.Argon2); err != nil {
+		return nil, nil, err
+	}
+	key, err := c.oprf(env.Argon2, func() []byte { return recoveryKey(recoverySecret, env.Argon2, env.Salt) })
+	if err != nil {
+		return nil, nil, err
+	}
+	plaintext, err := openEnvelope(key, recoveryAD(env.Argon2), env.c)
+	if err != nil || len(plaintext) < prfSize {
+		return nil, nil, ErrIncorrectRecoverySecret
+	}
+	defer clear(plaintext)
+	exportKey := append([]byte(nil), plaintext[:prfSize]...)
+	var ca ciphertextData
+	if err := json.Unmarshal(plaintext[prfSize:], &ca); err != nil {
+		return nil, nil, err
+	}
+
+	msg := recoveryTranscript(challenge.ID, challenge.Nonce)
+	return &RecoveryProof{
+		ID:        challenge.ID,
+		Signature: sign(ca.pu, ca.Pu, msg),
+	}, exportKey, nil
+}
+
+// FinishRecovery verifies the RecoveryProof for the recovery started by
+// NewRecovery. If it is valid, it returns a registration with which the
+// client registers a new password, replacing the user's password file. The
+// new password file is not bound to any of the user's previous Envelopes,
+// which were sealed under the old password, nor to the old recovery envelope.
+// The recovery is consumed whether or not the proof is valid.
+func (s *Server) FinishRecovery(proof *RecoveryProof) (*pendingRegistration, error) {
+	done, err := s.permitRegistration()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	if proof == nil {
+		return nil, ErrNilMessage
+	}
+	id := s.userID(proof.ID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	nonce, exists := s.recoveries[id]
+	if !exists {
+		return nil, errors.New("no recovery in progress")
+	}
+	delete(s.recoveries, id)
+	pf, exists := s.passwordFiles[id]
+	if !exists {
+		return nil, errors.New("no such sid")
+	}
+	if !verify(pf.Pu, recoveryTranscript(proof.ID, nonce), proof.Signature) {
+		return nil, ErrInvalidSignature
+	}
+	return s.newPendingRegistration(id, true)
+}
+
+// sealRecoveryEnvelope seals the export key and the plaintext of the user's
+// envelope under recoverySecret, hardened with the Argon2Params p.
+func sealRecoveryEnvelope(recoverySecret []byte, p Argon2Params, exportKey []byte, credentials []byte) (*recoveryEnvelope, error) {
+	salt := make([]byte, recoverySaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	plaintext := append(append([]byte(nil), exportKey...), credentials...)
+	c, err := sealEnvelope(recoveryKey(recoverySecret, p, salt), recoveryAD(p), plaintext)
+	clear(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return &recoveryEnvelope{Argon2: p, Salt: salt, c: c}, nil
+}
+
+// recoveryKey derives the key a recovery envelope is sealed under from the
+// recovery secret.
+func recoveryKey(recoverySecret []byte, p Argon2Params, salt []byte) []byte {
+	return argon2IDKey(recoverySecret, salt, p.Time, p.Memory, p.Threads, 32)
+}
+
+// recoveryAD is the associated data a recovery envelope is sealed with,
+// binding its Argon2Params.
+func recoveryAD(p Argon2Params) []byte {
+	return appendLengthPrefixed([]byte("occlude recovery envelope"), p.encode())
+}
+
+// recoveryTranscript encodes the recovery challenge which the client signs.
+func recoveryTranscript(id string, nonce []byte) []byte {
+	var transcript []byte
+	for _, field := range [][]byte{
+		[]byte("occlude recovery"),
+		[]byte(id),
+		nonce,
+	} {
+		transcript = appendLengthPrefixed(transcript, field)
+	}
+	return transcript
+}
+
+// MarshalBinary encodes the RecoveryChallenge for transport.
+func (rc *RecoveryChallenge) MarshalBinary() ([]byte, error) {
+	e := newEncoder(messageRecoveryChallenge)
+	e.string(rc.ID)
+	e.bytes(rc.Nonce)
+	e.recoveryEnvelope(rc.envelope)
+	return e.b, nil
+}
+
+// UnmarshalBinary decodes a RecoveryChallenge encoded with MarshalBinary.
+func (rc *RecoveryChallenge) UnmarshalBinary(data []byte) error {
+	d := newDecoder(messageRecoveryChallenge, data)
+	decoded := RecoveryChallenge{
+		ID:       d.string("ID"),
+		Nonce:    d.bytes("Nonce"),
+		envelope: d.recoveryEnvelope(),
+	}
+	if err := d.finish(); err != nil {
+		return err
+	}
+	*rc = decoded
+	return nil
+}
+
+// MarshalBinary encodes the RecoveryProof for transport.
+func (rp *RecoveryProof) MarshalBinary() ([]byte, error) {
+	e := newEncoder(messageRecoveryProof)
+	e.string(rp.ID)
+	e.bytes(rp.Signature)
+	return e.b, nil
+}
+
+// UnmarshalBinary decodes a RecoveryProof encoded with MarshalBinary.
+func (rp *RecoveryProof) UnmarshalBinary(data []byte) error {
+	d := newDecoder(messageRecoveryProof, data)
+	decoded := RecoveryProof{
+		ID:        d.string("ID"),
+		Signature: d.bytes("Signature"),
+	}
+	if err := d.finish(); err != nil {
+		return err
+	}
+	*rp = decoded
+	return nil
+}
+
+func (e *encoder) recoveryEnvelope(env recoveryEnvelope) {
+	e.argon2Params(env.Argon2)
+	e.bytes(env.Salt)
+	e.authCiphertext(env.c)
+}
+
+func (d *decoder) recoveryEnvelope() recoveryEnvelope {
+	return recoveryEnvelope{
+		Argon2: d.argon2Params("recovery Argon2"),
+		Salt:   d.bytes("recovery salt"),
+		c:      d.authCiphertext("recovery"),
+	}
+}
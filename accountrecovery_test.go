@@ -0,0 +1,168 @@
+package occlude
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// verify that a user who registered a recovery envelope can recover their
+// export key and register a new password, with every message sent through its
+// encodings, and that the wrong recovery code is rejected.
+func TestAccountRecovery(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+	recoveryCode, err := GenerateRecoveryCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername)
+	pr, err := s.NewRegistration(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err := c.NewRegistrationWithRecovery(pr, testusername, testpassword, recoveryCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	var decodedReg Registration
+	roundTrip(t, reg, &decodedReg)
+	if err := s.Register(&decodedReg); err != nil {
+		t.Fatal(err)
+	}
+	exportKey := c.ExportKey()
+
+	sent, err := s.NewRecovery(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decodedChallenge, challenge RecoveryChallenge
+	roundTrip(t, sent, &decodedChallenge)
+	if err := challenge.FromProto(decodedChallenge.ToProto()); err != nil {
+		t.Fatal(err)
+	}
+	wrongCode, err := GenerateRecoveryCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.Recover(&challenge, wrongCode); err != ErrIncorrectRecoverySecret {
+		t.Fatal("expected ErrIncorrectRecoverySecret, got", err)
+	}
+	mistyped := "A"
+	if recoveryCode[0] == 'A' {
+		mistyped = "B"
+	}
+	if _, _, err := c.Recover(&challenge, mistyped+recoveryCode[1:]); err != ErrInvalidRecoveryCode {
+		t.Fatal("expected ErrInvalidRecoveryCode for a mistyped code, got", err)
+	}
+	sentProof, recoveredKey, err := c.Recover(&challenge, strings.ToLower(recoveryCode))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(recoveredKey, exportKey) {
+		t.Fatal("recovery did not return the registered export key")
+	}
+	var decodedProof, proof RecoveryProof
+	roundTrip(t, sentProof, &decodedProof)
+	if err := proof.FromProto(decodedProof.ToProto()); err != nil {
+		t.Fatal(err)
+	}
+
+	pr, err = s.FinishRecovery(&proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.FinishRecovery(&proof); err == nil {
+		t.Fatal("recovery proof was accepted twice")
+	}
+	newpassword := "this is a new test password"
+	reg, err = c.NewRegistration(pr, testusername, newpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register(reg); err != nil {
+		t.Fatal(err)
+	}
+	login(t, s, c, newpassword, "")
+
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err := s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.SessionKey(svrsess, testpassword); err == nil {
+		t.Fatal("old password still logs in after recovery")
+	}
+	if _, err := s.NewRecovery(testusername); err != ErrNoRecoveryEnvelope {
+		t.Fatal("expected ErrNoRecoveryEnvelope, got", err)
+	}
+}
+
+// verify that a recovery proof is bound to its challenge, and that recovery
+// cannot be used to replace a user without a valid proof.
+func TestAccountRecoveryInvalidProof(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+	recoveryCode, err := GenerateRecoveryCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername)
+	pr, err := s.NewRegistration(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err := c.NewRegistrationWithRecovery(pr, testusername, testpassword, recoveryCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register(reg); err != nil {
+		t.Fatal(err)
+	}
+
+	stale, err := s.NewRecovery(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, _, err := c.Recover(stale, recoveryCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.NewRecovery(testusername); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.FinishRecovery(proof); err != ErrInvalidSignature {
+		t.Fatal("expected ErrInvalidSignature for a proof of a stale challenge, got", err)
+	}
+	login(t, s, c, testpassword, "")
+}
+
+// verify that a recovery secret which is not a 128 bit recovery code, and so
+// could be guessed offline from the recovery envelope NewRecovery hands to
+// anyone, is rejected at registration.
+func TestAccountRecoveryWeakSecret(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername)
+	pr, err := s.NewRegistration(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"", "hunter2", "this is a test recovery secret", "AAAA-AAAA-AAAA"} {
+		if _, err := c.NewRegistrationWithRecovery(pr, testusername, testpassword, secret); err != ErrInvalidRecoveryCode {
+			t.Fatalf("registering recovery secret %q: expected ErrInvalidRecoveryCode, got %v", secret, err)
+		}
+	}
+}
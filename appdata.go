@@ -0,0 +1,245 @@
+package occlude
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// appDataChunkSize is the length of each chunk of a ChunkedEnvelope, in bytes,
+// except the last, which may be shorter. It is the maximum length of an
+// envelope ciphertext, so that every chunk is a valid authCiphertext.
+const appDataChunkSize = maxCiphertextLength
+
+// DefaultMaxAppDataSize is the largest ChunkedEnvelope, in bytes of app data,
+// that Register accepts unless configured otherwise with WithMaxAppDataSize.
+const DefaultMaxAppDataSize = 16 << 20
+
+// ErrAppDataTooLarge is returned by Register when the Registration's
+// ChunkedEnvelope holds more app data than the Server's maximum.
+var ErrAppDataTooLarge = errors.New("app data too large")
+
+// WithMaxAppDataSize configures the largest ChunkedEnvelope, in bytes of app
+// data, that Register accepts. The server stores and pays for whatever the
+// client sends, so the limit is enforced by the server, whatever the client
+// was configured with.
+func WithMaxAppDataSize(n int) ServerOption {
+	return func(s *Server) {
+		s.maxAppDataSize = n
+	}
+}
+
+// ChunkedEnvelope is application data of any length, sealed by the client
+// under its password-derived key `rw` with NewRegistrationStreaming, and
+// stored by the server with the user's password file. The data is sealed in
+// chunks of appDataChunkSize bytes, each under its own key, so that neither
+// sealing nor opening it requires holding all of the plaintext in memory. The
+// last chunk is marked as such, so that a server can neither reorder nor
+// truncate the data without OpenAppData failing.
+type ChunkedEnvelope struct {
+	Salt   []byte
+	chunks []authCiphertext
+}
+
+// NewRegistrationStreaming is NewRegistration, additionally sealing the
+// application data read from appData into a ChunkedEnvelope, which the server
+// stores with the password file. appData is read and sealed a chunk at a
+// time, so it may be larger than the client can hold in memory as plaintext.
+// If appData is empty, no ChunkedEnvelope is registered.
+func (c *Client) NewRegistrationStreaming(sinfo *pendingRegistration, username string, password string, appData io.Reader) (*Registration, error) {
+	if appData == nil {
+		return nil, errors.New("nil app data reader")
+	}
+	reg, err := c.newRegistration(sinfo, username, password, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	rw := c.rw
+	c.mu.Unlock()
+	reg.appData, err = sealAppData(rw, appData)
+	if err != nil {
+		return nil, err
+	}
+	return reg, nil
+}
+
+// AppData returns the ChunkedEnvelope the user identified by cv registered
+// with NewRegistrationStreaming, or nil if there is none. As with
+// AddEnvelope, cv must be the ClientVerification for a login which has been
+// started with NewSession but not yet finished.
+func (s *Server) AppData(cv *ClientVerification) (*ChunkedEnvelope, error) {
+	done, err := s.permitAuthentication()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	if cv == nil {
+		return nil, ErrNilMessage
+	}
+	id := s.userID(cv.ID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.authenticate(cv); err != nil {
+		return nil, err
+	}
+	return s.passwordFiles[id].appData, nil
+}
+
+// OpenAppData opens env under the `rw` derived by the Client's most recent
+// successful NewRegistration or SessionKey, writing the application data to w
+// a chunk at a time. If a chunk fails to open, OpenAppData returns an error,
+// and w has received only the data of the chunks before it.
+func (c *Client) OpenAppData(env *ChunkedEnvelope, w io.Writer) error {
+	if env == nil {
+		return ErrNilMessage
+	}
+	c.mu.Lock()
+	rw := c.rw
+	c.mu.Unlock()
+	if rw == nil {
+		return errors.New("no password-derived key, log in first")
+	}
+
+	for i, chunk := range env.chunks {
+		final := i == len(env.chunks)-1
+		plaintext, err := openEnvelope(appDataChunkKey(rw, env.Salt, uint32(i)), appDataAD(final), chunk)
+		if err != nil {
+			return fmt.Errorf("app data chunk %d: %w", i, err)
+		}
+		_, err = w.Write(plaintext)
+		clear(plaintext)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sealAppData seals the data read from r into a ChunkedEnvelope under `rw`,
+// holding at most two chunks of plaintext at a time. It returns nil if r is
+// empty.
+func sealAppData(rw []byte, r io.Reader) (*ChunkedEnvelope, error) {
+	chunk := make([]byte, appDataChunkSize)
+	next := make([]byte, appDataChunkSize)
+	defer clear(chunk)
+	defer clear(next)
+
+	n, err := readChunk(r, chunk)
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	salt := make([]byte, envelopeSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	env := &ChunkedEnvelope{Salt: salt}
+	for i := uint32(0); ; i++ {
+		// read ahead, so that the last chunk is known to be last when it
+		// is sealed.
+		var m int
+		if n == len(chunk) {
+			if m, err = readChunk(r, next); err != nil {
+				return nil, err
+			}
+		}
+		final := m == 0
+		sealed, err := sealEnvelope(appDataChunkKey(rw, salt, i), appDataAD(final), chunk[:n])
+		if err != nil {
+			return nil, err
+		}
+		env.chunks = append(env.chunks, sealed)
+		if final {
+			return env, nil
+		}
+		chunk, next, n = next, chunk, m
+	}
+}
+
+// readChunk reads from r until chunk is full or r is exhausted, returning the
+// number of bytes read.
+func readChunk(r io.Reader, chunk []byte) (int, error) {
+	n, err := io.ReadFull(r, chunk)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return n, err
+}
+
+// appDataChunkKey derives the key the chunk at index of a ChunkedEnvelope is
+// sealed under from `rw` and the ChunkedEnvelope's salt. Every chunk has its
+// own key, so that sealEnvelope's fixed IV is never reused.
+func appDataChunkKey(rw []byte, salt []byte, index uint32) []byte {
+	info := make([]byte, 4)
+	binary.BigEndian.PutUint32(info, index)
+	info = append([]byte("occlude app data chunk "), info...)
+	return deriveKey(rw, salt, info, 32)
+}
+
+// appDataAD is the associated data a chunk of a ChunkedEnvelope is sealed
+// with, binding whether it is the last chunk.
+func appDataAD(final bool) []byte {
+	ad := []byte("occlude app data")
+	if final {
+		return append(ad, " final"...)
+	}
+	return ad
+}
+
+// size returns the length of the app data sealed in the ChunkedEnvelope.
+func (env *ChunkedEnvelope) size() int {
+	n := 0
+	for _, chunk := range env.chunks {
+		n += len(chunk.Ciphertext)
+	}
+	return n
+}
+
+// validate checks that the ChunkedEnvelope has a salt of the right length and
+// at least one chunk, and the length bounds of its chunks.
+func (env *ChunkedEnvelope) validate() error {
+	if err := validateLength("app data Salt", env.Salt, envelopeSaltSize, envelopeSaltSize); err != nil {
+		return err
+	}
+	if len(env.chunks) == 0 {
+		return fmt.Errorf("%w: app data chunks", ErrMissingField)
+	}
+	for i := range env.chunks {
+		if err := env.chunks[i].validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *encoder) chunkedEnvelope(env *ChunkedEnvelope) {
+	if env == nil {
+		e.uint8(0)
+		return
+	}
+	e.uint8(1)
+	e.bytes(env.Salt)
+	e.uint32(uint32(len(env.chunks)))
+	for _, chunk := range env.chunks {
+		e.authCiphertext(chunk)
+	}
+}
+
+func (d *decoder) chunkedEnvelope() *ChunkedEnvelope {
+	switch d.uint8("app data") {
+	case 0:
+		return nil
+	case 1:
+	default:
+		d.fail("app data")
+		return nil
+	}
+	env := &ChunkedEnvelope{Salt: d.bytes("app data salt")}
+	n := d.uint32("app data chunks")
+	for i := uint32(0); i < n && d.err == nil; i++ {
+		env.chunks = append(env.chunks, d.authCiphertext("app data chunk"))
+	}
+	return env
+}
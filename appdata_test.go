@@ -0,0 +1,198 @@
+package occlude
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"runtime"
+	"testing"
+)
+
+// patternReader yields n bytes of a fixed pattern without allocating them.
+type patternReader struct {
+	off, n int
+}
+
+func (r *patternReader) Read(p []byte) (int, error) {
+	if r.off == r.n {
+		return 0, io.EOF
+	}
+	if len(p) > r.n-r.off {
+		p = p[:r.n-r.off]
+	}
+	for i := range p {
+		p[i] = byte(r.off + i)
+	}
+	r.off += len(p)
+	return len(p), nil
+}
+
+// verify that multi-megabyte app data can be registered from a reader while
+// allocating little more than its ciphertext, and read back after a login.
+func TestNewRegistrationStreaming(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+	const size = 4<<20 + 123
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername)
+	pr, err := s.NewRegistration(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	reg, err := c.NewRegistrationStreaming(pr, testusername, testpassword, &patternReader{n: size})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime.ReadMemStats(&after)
+	// the ciphertext is size bytes; buffering the plaintext as well would
+	// allocate at least double that.
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > size+size/2 {
+		t.Fatalf("allocated %d bytes to register %d bytes of app data", allocated, size)
+	}
+	if err := reg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register(reg); err != nil {
+		t.Fatal(err)
+	}
+
+	device := NewClient(testusername)
+	cv := login(t, s, device, testpassword, "")
+	env, err := s.AppData(cv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, want := sha256.New(), sha256.New()
+	if err := device.OpenAppData(env, got); err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(want, &patternReader{n: size})
+	if !bytes.Equal(got.Sum(nil), want.Sum(nil)) {
+		t.Fatal("app data did not round trip")
+	}
+
+	// the chunks can be neither truncated nor reordered.
+	truncated := &ChunkedEnvelope{Salt: env.Salt, chunks: env.chunks[:len(env.chunks)-1]}
+	if err := device.OpenAppData(truncated, io.Discard); err == nil {
+		t.Fatal("opened truncated app data")
+	}
+	reordered := &ChunkedEnvelope{Salt: env.Salt, chunks: append([]authCiphertext{env.chunks[1], env.chunks[0]}, env.chunks[2:]...)}
+	if err := device.OpenAppData(reordered, io.Discard); err == nil {
+		t.Fatal("opened reordered app data")
+	}
+}
+
+// verify that the ChunkedEnvelope survives each encoding of the Registration
+// and the password file, and that empty app data registers none.
+func TestChunkedEnvelopeEncoding(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+	data := bytes.Repeat([]byte("app data "), appDataChunkSize/4)
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername)
+	pr, err := s.NewRegistration(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err := c.NewRegistrationStreaming(pr, testusername, testpassword, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reg.appData.chunks) != 3 {
+		t.Fatal("expected 3 chunks, got", len(reg.appData.chunks))
+	}
+
+	b, err := reg.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Registration
+	if err := decoded.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	var fromProto Registration
+	if err := fromProto.FromProto(decoded.ToProto()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register(&fromProto); err != nil {
+		t.Fatal(err)
+	}
+	pf, err := s.MarshalPasswordFile(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewServer(WithArgon2Params(weakArgon2Params))
+	if err := restored.UnmarshalPasswordFile(testusername, pf); err != nil {
+		t.Fatal(err)
+	}
+
+	cv := login(t, restored, c, testpassword, "")
+	env, err := restored.AppData(cv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got bytes.Buffer
+	if err := c.OpenAppData(env, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), data) {
+		t.Fatal("app data did not round trip")
+	}
+
+	pr, err = s.NewRegistration("empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err = NewClient("empty").NewRegistrationStreaming(pr, "empty", testpassword, bytes.NewReader(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reg.appData != nil {
+		t.Fatal("registered a ChunkedEnvelope for empty app data")
+	}
+}
+
+// verify that Register rejects a ChunkedEnvelope over the Server's limit,
+// however the client constructed it, and accepts one within it.
+func TestMaxAppDataSize(t *testing.T) {
+	testpassword := "this is a test password"
+	const limit = 1 << 20
+
+	s := NewServer(WithArgon2Params(weakArgon2Params), WithMaxAppDataSize(limit))
+	for _, test := range []struct {
+		id   string
+		size int
+		err  error
+	}{
+		{"within", limit, nil},
+		{"over", limit + 1, ErrAppDataTooLarge},
+	} {
+		pr, err := s.NewRegistration(test.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reg, err := NewClient(test.id).NewRegistrationStreaming(pr, test.id, testpassword, &patternReader{n: test.size})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Register(reg); !errors.Is(err, test.err) {
+			t.Fatalf("registering %d bytes of app data: expected %v, got %v", test.size, test.err, err)
+		}
+	}
+	if _, exists := s.passwordFiles["over"]; exists {
+		t.Fatal("stored an over-limit password file")
+	}
+}
+
+// verify that AppData rejects a nil ClientVerification.
+func TestAppDataNilVerification(t *testing.T) {
+	if _, err := NewServer().AppData(nil); err != ErrNilMessage {
+		t.Fatal("expected ErrNilMessage, got", err)
+	}
+}
@@ -0,0 +1,179 @@
+package occlude
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrWeakServerParams is returned by the Client when the server asks it
+	// to harden the OPRF with Argon2 parameters below the minimum configured
+	// with WithMinArgon2Params.
+	ErrWeakServerParams = errors.New("server Argon2 parameters are below the client minimum")
+
+	// ErrWeakRegistrationParams is returned by Server.Register when a client
+	// registered with Argon2 parameters that are cheaper to compute than the
+	// server's.
+	ErrWeakRegistrationParams = errors.New("registration Argon2 parameters are below the server's")
+
+	// ErrBusy is returned by the Client when computing the OPRF would exceed
+	// the process-wide Argon2 memory ceiling set with SetMaxArgon2Memory.
+	ErrBusy = errors.New("too much Argon2 memory in use")
+)
+
+// argon2Memory tracks the Argon2 memory in use by OPRF computations across the
+// process, against the ceiling set with SetMaxArgon2Memory.
+var argon2Memory struct {
+	mu    sync.Mutex
+	max   uint64
+	inUse uint64
+}
+
+// Argon2Params are the Argon2id cost parameters used to harden the OPRF
+// output. They are bound to a password file at registration, as part of its
+// Scheme, and sent to the client with every SvrSession so that it can
+// recompute the same output.
+//
+// Argon2 is only ever computed by the client, at registration and at every
+// login: the server evaluates the OPRF on a blinded element, and stores the
+// OPRF key and the envelope rather than a hardened verifier, so its work per
+// login is a few group operations and needs no Argon2 memory, whatever the
+// parameters. A memory-constrained server can therefore choose a high memory
+// cost without paying for it.
+//
+// Moving the hardening to the client does not weaken the security model. An
+// attacker who steals a password file still pays the full Argon2 cost for
+// every guess, since rw is the hardened output of the OPRF, and one without
+// the file's OPRF key can not test guesses offline at all. The cost is
+// instead borne by clients, so the parameters must suit the weakest client
+// device; clients protect themselves from a server demanding too much with
+// SetMaxArgon2Memory, and from one demanding too little with
+// WithMinArgon2Params.
+type Argon2Params struct {
+	// Time is the number of passes over the memory.
+	Time uint32
+
+	// Memory is the size of the memory, in KiB.
+	Memory uint32
+
+	// Threads is the number of lanes the memory is split into.
+	Threads uint8
+}
+
+// DefaultArgon2Params are the Argon2Params used by a Server unless configured
+// otherwise with WithArgon2Params.
+var DefaultArgon2Params = Argon2Params{
+	Time:    argonTime,
+	Memory:  argonMemory,
+	Threads: 4,
+}
+
+// supported reports whether Argon2id can be computed with the parameters.
+func (p Argon2Params) supported() bool {
+	return p.Time > 0 && p.Threads > 0 && p.Memory >= 8*uint32(p.Threads)
+}
+
+// weakerThan reports whether the parameters cost less to compute than min.
+// The cost of a guess is determined by Time and Memory; Threads only changes
+// how the work is scheduled, so it is not compared.
+func (p Argon2Params) weakerThan(min Argon2Params) bool {
+	return p.Time < min.Time || p.Memory < min.Memory
+}
+
+// encode returns the fixed-length encoding of the parameters.
+func (p Argon2Params) encode() []byte {
+	b := make([]byte, 9)
+	binary.BigEndian.PutUint32(b[0:4], p.Time)
+	binary.BigEndian.PutUint32(b[4:8], p.Memory)
+	b[8] = p.Threads
+	return b
+}
+
+// WithArgon2Params configures the Argon2Params that new registrations are
+// bound to. Existing password files keep the parameters they were registered
+// with.
+func WithArgon2Params(p Argon2Params) ServerOption {
+	return func(s *Server) {
+		s.scheme.Argon2 = p
+	}
+}
+
+// WithMinArgon2Params configures the Client to refuse to register or log in
+// when the server asks for Argon2 parameters that are cheaper to compute than
+// min, with ErrWeakServerParams. This prevents a malicious or misconfigured
+// server from downgrading the hardening of the client's password, which
+// protects it should the server's password files later be stolen.
+func WithMinArgon2Params(min Argon2Params) ClientOption {
+	return func(c *Client) {
+		c.minArgon2 = min
+	}
+}
+
+// WithRegistrationArgon2Params configures the Client to register with the
+// Argon2Params p instead of the server's, for stronger protection of its
+// password than the server requires. The server rejects parameters which are
+// weaker than its own with ErrWeakRegistrationParams, and the parameters are
+// authenticated by the user's envelope, so they cannot later be weakened
+// without the login failing.
+func WithRegistrationArgon2Params(p Argon2Params) ClientOption {
+	return func(c *Client) {
+		c.argon2 = p
+	}
+}
+
+// checkArgon2Params returns an error if the Client should not run the OPRF
+// with the server's parameters p.
+func (c *Client) checkArgon2Params(p Argon2Params) error {
+	if !p.supported() {
+		return ErrUnsupportedScheme
+	}
+	if p.weakerThan(c.minArgon2) {
+		return ErrWeakServerParams
+	}
+	return nil
+}
+
+// memoryBytes returns the memory Argon2id uses with the parameters, in bytes.
+func (p Argon2Params) memoryBytes() uint64 {
+	return uint64(p.Memory) * 1024
+}
+
+// SetMaxArgon2Memory sets a ceiling, in bytes, on the Argon2 memory in use
+// by OPRF computations across the process. Since each computation uses the
+// Memory of its Argon2Params, the ceiling allows bytes / (Memory * 1024)
+// concurrent computations with the same parameters; a computation which
+// would exceed it fails immediately with ErrBusy rather than waiting. A
+// ceiling of zero, the default, removes the limit.
+func SetMaxArgon2Memory(bytes uint64) {
+	argon2Memory.mu.Lock()
+	defer argon2Memory.mu.Unlock()
+	argon2Memory.max = bytes
+}
+
+// Argon2MemoryInUse returns the Argon2 memory in use by OPRF computations
+// across the process, in bytes.
+func Argon2MemoryInUse() uint64 {
+	argon2Memory.mu.Lock()
+	defer argon2Memory.mu.Unlock()
+	return argon2Memory.inUse
+}
+
+// acquireArgon2Memory reserves the memory of an Argon2 computation with the
+// parameters p, or returns ErrBusy if it would exceed the ceiling.
+func acquireArgon2Memory(p Argon2Params) error {
+	argon2Memory.mu.Lock()
+	defer argon2Memory.mu.Unlock()
+	if argon2Memory.max != 0 && argon2Memory.inUse+p.memoryBytes() > argon2Memory.max {
+		return ErrBusy
+	}
+	argon2Memory.inUse += p.memoryBytes()
+	return nil
+}
+
+// releaseArgon2Memory releases memory reserved by acquireArgon2Memory.
+func releaseArgon2Memory(p Argon2Params) {
+	argon2Memory.mu.Lock()
+	defer argon2Memory.mu.Unlock()
+	argon2Memory.inUse -= p.memoryBytes()
+}
@@ -0,0 +1,265 @@
+package occlude
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"testing"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// weakArgon2Params are Argon2Params far cheaper than DefaultArgon2Params.
+var weakArgon2Params = Argon2Params{Time: 1, Memory: 64, Threads: 1}
+
+// verify that a login succeeds with the Argon2Params the password file was
+// registered with.
+func TestArgon2Params(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, sessionKey, err := s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svrsess.Argon2 != weakArgon2Params {
+		t.Fatal("server did not advertise the password file's Argon2Params")
+	}
+	clientSessionKey, _, err := c.SessionKey(svrsess, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sessionKey, clientSessionKey) {
+		t.Fatal("client and server did not compute identical session key")
+	}
+}
+
+// verify that a client with a minimum Argon2 cost refuses to register or log
+// in when the server advertises weaker parameters, without running Argon2.
+func TestMinArgon2Params(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	argonCalls := 0
+	defer func(f func([]byte, []byte, uint32, uint32, uint8, uint32) []byte) { argon2IDKey = f }(argon2IDKey)
+	idKey := argon2IDKey
+	argon2IDKey = func(password, salt []byte, time, memory uint32, threads uint8, keyLen uint32) []byte {
+		argonCalls++
+		return idKey(password, salt, time, memory, threads, keyLen)
+	}
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername, WithMinArgon2Params(DefaultArgon2Params))
+	pr, err := s.NewRegistration(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.NewRegistration(pr, testusername, testpassword); err != ErrWeakServerParams {
+		t.Fatal("expected ErrWeakServerParams, got", err)
+	}
+
+	register(t, s, NewClient(testusername), testusername, testpassword)
+	argonCalls = 0
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err := s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.SessionKey(svrsess, testpassword); err != ErrWeakServerParams {
+		t.Fatal("expected ErrWeakServerParams, got", err)
+	}
+	for _, p := range []Argon2Params{
+		{Time: DefaultArgon2Params.Time - 1, Memory: DefaultArgon2Params.Memory, Threads: 4},
+		{Time: DefaultArgon2Params.Time, Memory: DefaultArgon2Params.Memory - 1, Threads: 4},
+	} {
+		svrsess.Argon2 = p
+		if _, _, err := c.SessionKey(svrsess, testpassword); err != ErrWeakServerParams {
+			t.Fatal("expected ErrWeakServerParams, got", err)
+		}
+	}
+	if argonCalls != 0 {
+		t.Fatal("client ran Argon2 with weak server parameters")
+	}
+
+	svrsess.Argon2 = Argon2Params{Time: 1, Memory: 1, Threads: 1}
+	if _, _, err := c.SessionKey(svrsess, testpassword); err != ErrUnsupportedScheme {
+		t.Fatal("expected ErrUnsupportedScheme, got", err)
+	}
+}
+
+// verify that the server stores and honors stronger Argon2Params chosen by
+// the client, rejects weaker ones, and that substituting the stored params is
+// detected at login.
+func TestRegistrationArgon2Params(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+	strong := Argon2Params{Time: 2, Memory: 128, Threads: 1}
+
+	s := NewServer(WithArgon2Params(strong))
+	pr, err := s.NewRegistration(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err := NewClient(testusername, WithRegistrationArgon2Params(weakArgon2Params)).NewRegistration(pr, testusername, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register(reg); err != ErrWeakRegistrationParams {
+		t.Fatal("expected ErrWeakRegistrationParams, got", err)
+	}
+
+	s = NewServer(WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername, WithRegistrationArgon2Params(strong))
+	register(t, s, c, testusername, testpassword)
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err := s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svrsess.Argon2 != strong {
+		t.Fatal("server did not honor the client's Argon2Params")
+	}
+	if _, _, err := c.SessionKey(svrsess, testpassword); err != nil {
+		t.Fatal(err)
+	}
+
+	pf := s.passwordFiles[testusername]
+	pf.scheme.Argon2 = weakArgon2Params
+	s.passwordFiles[testusername] = pf
+	sess, err = c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err = s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.SessionKey(svrsess, testpassword); err == nil {
+		t.Fatal("login succeeded with substituted Argon2Params")
+	}
+}
+
+// verify that the Argon2 memory ceiling allows ceiling / per-call concurrent
+// OPRF computations, and rejects the rest with ErrBusy.
+func TestMaxArgon2Memory(t *testing.T) {
+	testpassword := "this is a test password"
+	const concurrency = 3
+	const attempts = 5
+
+	perCall := weakArgon2Params.memoryBytes()
+	SetMaxArgon2Memory(concurrency*perCall + perCall/2)
+	defer SetMaxArgon2Memory(0)
+
+	started := make(chan struct{}, attempts)
+	release := make(chan struct{})
+	defer func(f func([]byte, []byte, uint32, uint32, uint8, uint32) []byte) { argon2IDKey = f }(argon2IDKey)
+	idKey := argon2IDKey
+	argon2IDKey = func(password, salt []byte, time, memory uint32, threads uint8, keyLen uint32) []byte {
+		started <- struct{}{}
+		<-release
+		return idKey(password, salt, time, memory, threads, keyLen)
+	}
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	errs := make(chan error, attempts)
+	for i := 0; i < attempts; i++ {
+		username := fmt.Sprintf("test user %d", i)
+		pr, err := s.NewRegistration(username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			_, err := NewClient(username).NewRegistration(pr, username, testpassword)
+			errs <- err
+		}()
+	}
+
+	for i := 0; i < attempts-concurrency; i++ {
+		if err := <-errs; err != ErrBusy {
+			t.Fatal("expected ErrBusy, got", err)
+		}
+	}
+	for i := 0; i < concurrency; i++ {
+		<-started
+	}
+	if inUse := Argon2MemoryInUse(); inUse != concurrency*perCall {
+		t.Fatalf("expected %v bytes of Argon2 memory in use, got %v", concurrency*perCall, inUse)
+	}
+	close(release)
+	for i := 0; i < concurrency; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if inUse := Argon2MemoryInUse(); inUse != 0 {
+		t.Fatal("Argon2 memory was not released:", inUse)
+	}
+}
+
+// verify that the server computes no Argon2 during a login, and allocates far
+// less than the Argon2 memory cost of the user's password file.
+func TestServerLoginNoArgon2(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	// the client's Argon2 is replaced with a cheap stand-in, so that the test
+	// can use the default parameters and count calls.
+	calls := 0
+	defer func(f func([]byte, []byte, uint32, uint32, uint8, uint32) []byte) { argon2IDKey = f }(argon2IDKey)
+	argon2IDKey = func(password, salt []byte, time, memory uint32, threads uint8, keyLen uint32) []byte {
+		calls++
+		sum := sha3.Sum256(password)
+		return sum[:keyLen]
+	}
+
+	s := NewServer(WithArgon2Params(DefaultArgon2Params))
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls = 0
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	svrsess, _, err := s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime.ReadMemStats(&after)
+	if calls != 0 {
+		t.Fatal("server computed Argon2 in NewSession")
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > DefaultArgon2Params.memoryBytes()/1000 {
+		t.Fatalf("server allocated %d bytes in NewSession", allocated)
+	}
+
+	_, fk2, err := c.SessionKey(svrsess, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Fatal("expected the client to compute Argon2 once, got", calls)
+	}
+	if _, err := s.FinishSession(&ClientVerification{ID: sess.Sid, FK2: fk2}); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Fatal("server computed Argon2 in FinishSession")
+	}
+}
@@ -0,0 +1,156 @@
+package occlude
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+const (
+	// minTuneMemory and maxTuneMemory bound the Argon2 memory, in KiB, that
+	// AutoTuneArgon2 searches.
+	minTuneMemory = 8 * 1024
+	maxTuneMemory = 1024 * 1024
+
+	// maxTuneTime bounds the number of Argon2 passes that AutoTuneArgon2
+	// searches.
+	maxTuneTime = 64
+)
+
+// AutoTuneArgon2 calibrates Argon2Params which take about target to compute on
+// the current machine, for use with WithArgon2Params. It first grows the
+// memory, with a single pass, and once the memory reaches its upper bound of
+// 1 GiB grows the number of passes, binary-searching the parameter that
+// brackets the target. It returns an error if even the cheapest parameters
+// take longer than target.
+//
+// Calibration runs Argon2 repeatedly, taking a small multiple of target, and
+// should be done once at deployment rather than on every startup, since
+// parameters computed on a loaded machine will be weaker than intended.
+func AutoTuneArgon2(target time.Duration) (Argon2Params, error) {
+	if target <= 0 {
+		return Argon2Params{}, errors.New("target duration must be positive")
+	}
+	p := Argon2Params{Time: 1, Memory: minTuneMemory, Threads: DefaultArgon2Params.Threads}
+	if measureArgon2(p) > target {
+		return p, errors.New("target duration is shorter than the cheapest Argon2 parameters")
+	}
+
+	memory := searchArgon2(target, minTuneMemory, maxTuneMemory, func(m uint32) Argon2Params {
+		return Argon2Params{Time: 1, Memory: m, Threads: p.Threads}
+	})
+	if memory < maxTuneMemory {
+		return Argon2Params{Time: 1, Memory: memory, Threads: p.Threads}, nil
+	}
+	passes := searchArgon2(target, 1, maxTuneTime, func(t uint32) Argon2Params {
+		return Argon2Params{Time: t, Memory: maxTuneMemory, Threads: p.Threads}
+	})
+	return Argon2Params{Time: passes, Memory: maxTuneMemory, Threads: p.Threads}, nil
+}
+
+// ImpactReport is the resource use projected by SimulateArgon2Impact for
+// Argon2Params at a sustained login rate.
+type ImpactReport struct {
+	// Latency is the measured time to compute Argon2 once on this machine,
+	// which every login adds to the client's side of the handshake.
+	Latency time.Duration
+
+	// MemoryPerLogin is the Argon2 memory held by each login, in bytes.
+	MemoryPerLogin uint64
+
+	// Concurrent is the mean number of logins computing Argon2 at once.
+	Concurrent float64
+
+	// PeakMemory is the Argon2 memory held by Concurrent logins, rounded up
+	// to a whole login, in bytes.
+	PeakMemory uint64
+
+	// CPUCores is the number of CPU cores kept busy computing Argon2,
+	// assuming that each of its threads runs on a core of its own.
+	CPUCores float64
+}
+
+// SimulateArgon2Impact projects the aggregate cost of computing Argon2 with
+// params for loginsPerSecond logins, from the cost of a single computation
+// measured on the current machine, to estimate the impact of raising the
+// Argon2 cost before rolling it out with WithArgon2Params. Argon2 is computed
+// by the Client, so the cost falls on whatever runs it: users' own devices,
+// for which Latency and MemoryPerLogin matter, or a backend which logs users
+// in on their behalf, for which the aggregate figures do.
+//
+// The projection is advisory. Concurrent is the mean given by Little's law,
+// and bursts above the mean rate need proportionally more; the measurement is
+// only as representative as this machine and its load. SimulateArgon2Impact
+// returns the zero ImpactReport for unsupported parameters.
+func SimulateArgon2Impact(params Argon2Params, loginsPerSecond float64) ImpactReport {
+	if !params.supported() {
+		return ImpactReport{}
+	}
+	// the fastest of a few runs is the least disturbed by other load.
+	latency := measureArgon2(params)
+	for i := 0; i < 2; i++ {
+		if d := measureArgon2(params); d < latency {
+			latency = d
+		}
+	}
+	return projectArgon2Impact(params, latency, loginsPerSecond)
+}
+
+// projectArgon2Impact computes the ImpactReport for logins with params which
+// each take latency to compute Argon2, at loginsPerSecond.
+func projectArgon2Impact(params Argon2Params, latency time.Duration, loginsPerSecond float64) ImpactReport {
+	if loginsPerSecond < 0 {
+		loginsPerSecond = 0
+	}
+	concurrent := loginsPerSecond * latency.Seconds()
+	return ImpactReport{
+		Latency:        latency,
+		MemoryPerLogin: params.memoryBytes(),
+		Concurrent:     concurrent,
+		PeakMemory:     uint64(math.Ceil(concurrent)) * params.memoryBytes(),
+		CPUCores:       concurrent * float64(params.Threads),
+	}
+}
+
+// searchArgon2 finds the value in [lo, hi] for which params takes closest to
+// target to compute, assuming that the duration grows with the value. It
+// doubles the value from lo until it brackets target, then binary-searches
+// the bracket. It returns hi if even hi takes less than target.
+func searchArgon2(target time.Duration, lo uint32, hi uint32, params func(uint32) Argon2Params) uint32 {
+	below, belowDuration := lo, measureArgon2(params(lo))
+	above, aboveDuration := lo, belowDuration
+	for aboveDuration < target {
+		if above == hi {
+			return hi
+		}
+		above *= 2
+		if above > hi {
+			above = hi
+		}
+		aboveDuration = measureArgon2(params(above))
+		if aboveDuration < target {
+			below, belowDuration = above, aboveDuration
+		}
+	}
+	for above-below > 1 && above-below > below/64 {
+		mid := below + (above-below)/2
+		d := measureArgon2(params(mid))
+		if d < target {
+			below, belowDuration = mid, d
+		} else {
+			above, aboveDuration = mid, d
+		}
+	}
+	if target-belowDuration < aboveDuration-target {
+		return below
+	}
+	return above
+}
+
+// measureArgon2 returns how long Argon2id takes to compute with the
+// parameters p.
+func measureArgon2(p Argon2Params) time.Duration {
+	start := time.Now()
+	argon2IDKey([]byte("occlude argon2 calibration"), nil, p.Time, p.Memory, p.Threads, 32)
+	return time.Since(start)
+}
@@ -0,0 +1,60 @@
+package occlude
+
+import (
+	"testing"
+	"time"
+)
+
+// verify that the parameters returned by AutoTuneArgon2 take about the target
+// duration to compute. The tolerance is generous, since the test machine may
+// be loaded.
+func TestAutoTuneArgon2(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping Argon2 calibration in short mode")
+	}
+	target := 100 * time.Millisecond
+	p, err := AutoTuneArgon2(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.supported() {
+		t.Fatal("AutoTuneArgon2 returned unsupported parameters:", p)
+	}
+	measured := measureArgon2(p)
+	if measured < target/3 || measured > target*3 {
+		t.Fatalf("parameters %+v took %v, expected about %v", p, measured, target)
+	}
+
+	if _, err := AutoTuneArgon2(time.Nanosecond); err == nil {
+		t.Fatal("expected error for a target shorter than the cheapest parameters")
+	}
+}
+
+// verify that the projected resource use of Argon2 grows with both the cost
+// of the parameters and the login rate.
+func TestSimulateArgon2Impact(t *testing.T) {
+	weak := projectArgon2Impact(weakArgon2Params, time.Millisecond, 100)
+	stronger := weakArgon2Params
+	stronger.Memory *= 4
+	strong := projectArgon2Impact(stronger, 4*time.Millisecond, 100)
+	busy := projectArgon2Impact(weakArgon2Params, time.Millisecond, 10000)
+	for _, higher := range []ImpactReport{strong, busy} {
+		if higher.Concurrent <= weak.Concurrent || higher.PeakMemory <= weak.PeakMemory || higher.CPUCores <= weak.CPUCores {
+			t.Fatalf("expected %+v to project more resource use than %+v", higher, weak)
+		}
+	}
+	if strong.MemoryPerLogin <= weak.MemoryPerLogin {
+		t.Fatal("stronger parameters do not use more memory per login")
+	}
+	if busy.Concurrent != 10 || busy.PeakMemory != 10*weakArgon2Params.memoryBytes() {
+		t.Fatalf("unexpected projection at 10000 logins per second: %+v", busy)
+	}
+
+	measured := SimulateArgon2Impact(weakArgon2Params, 100)
+	if measured.Latency <= 0 || measured.MemoryPerLogin != weakArgon2Params.memoryBytes() {
+		t.Fatalf("unexpected measured projection: %+v", measured)
+	}
+	if (SimulateArgon2Impact(Argon2Params{}, 100) != ImpactReport{}) {
+		t.Fatal("projected the impact of unsupported parameters")
+	}
+}
@@ -0,0 +1,107 @@
+package occlude
+
+import (
+	"errors"
+
+	ristretto "github.com/gtank/ristretto255"
+)
+
+// Argon2Upgrade is the user's envelope re-sealed by the client under the OPRF
+// output hardened with stronger Argon2Params, in response to a SvrSession
+// with Argon2Upgrade set. The server's OPRF key and the user's credentials
+// are unchanged; only the cost of hardening the password is raised.
+type Argon2Upgrade struct {
+	ID     string
+	Argon2 Argon2Params
+	c      authCiphertext
+}
+
+// WithArgon2UpgradeOnLogin configures the Server to opportunistically
+// re-harden password files bound to Argon2Params weaker than the active
+// Scheme's, as after WithArgon2Params or SetActiveScheme raises the cost.
+// Logins against such a file still use its own parameters, but their
+// SvrSession asks the client to re-seal the envelope at the active cost,
+// which the client returns in LoginResult.Argon2Upgrade for UpgradeArgon2.
+//
+// NOTE: re-hardening changes the user's `rw`, and so their export key.
+// Envelopes, recovery envelopes and ChunkedEnvelopes are sealed under keys
+// derived from it, so files with any of them are never upgraded; their users
+// can be upgraded with NewUpgrade instead.
+func WithArgon2UpgradeOnLogin() ServerOption {
+	return func(s *Server) {
+		s.upgradeArgon2OnLogin = true
+	}
+}
+
+// shouldUpgradeArgon2 reports whether a login against pf should ask the
+// client to re-harden it. The caller must hold s.mu.
+func (s *Server) shouldUpgradeArgon2(pf pwdFile) bool {
+	return s.upgradeArgon2OnLogin && s.role != roleReplica &&
+		pf.scheme.Argon2.weakerThan(s.scheme.Argon2) &&
+		len(pf.envelopes) == 0 && pf.recovery == nil && pf.appData == nil
+}
+
+// UpgradeArgon2 replaces the envelope of the user identified by cv with the
+// re-hardened one in up, binding their password file to its Argon2Params. As
+// with AddEnvelope, cv must be the ClientVerification for a login which has
+// been started with NewSession but not yet finished.
+func (s *Server) UpgradeArgon2(cv *ClientVerification, up *Argon2Upgrade) error {
+	done, err := s.permitMutation()
+	if err != nil {
+		return err
+	}
+	defer done()
+	if cv == nil || up == nil {
+		return ErrNilMessage
+	}
+	id := s.userID(cv.ID)
+	if s.userID(up.ID) != id {
+		return errors.New("upgrade is for a different user")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.authenticate(cv); err != nil {
+		return err
+	}
+	pf := s.passwordFiles[id]
+	if !s.shouldUpgradeArgon2(pf) {
+		return errors.New("password file does not need an upgrade")
+	}
+	if !up.Argon2.supported() {
+		return ErrUnsupportedScheme
+	}
+	if up.Argon2.weakerThan(s.scheme.Argon2) {
+		return ErrWeakRegistrationParams
+	}
+	if err := up.c.validate(); err != nil {
+		return err
+	}
+	pf.c = up.c
+	pf.scheme.Argon2 = up.Argon2
+	s.setPasswordFile(id, pf)
+	return nil
+}
+
+// argon2Upgrade re-seals the plaintext credentials of the user's envelope
+// with the SvrSession's Argon2Upgrade parameters, if it has any, using the
+// blind r and password hash x of the login. It returns nil if the server asks
+// for no upgrade, or for parameters no stronger than the envelope's.
+func (c *Client) argon2Upgrade(id string, session *SvrSession, r *ristretto.Scalar, x [64]byte, credentials []byte) (*Argon2Upgrade, error) {
+	target := session.Argon2Upgrade
+	if target == nil || !session.Argon2.weakerThan(*target) {
+		return nil, nil
+	}
+	if err := c.checkArgon2Params(*target); err != nil {
+		return nil, err
+	}
+	rw, err := c.passwordRW(*target, func() []byte { return oprfB(*target, session.Beta, r, x) })
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := sealEnvelope(rw, passwordFileAD(*target), credentials)
+	clear(rw)
+	if err != nil {
+		return nil, err
+	}
+	return &Argon2Upgrade{ID: id, Argon2: *target, c: sealed}, nil
+}
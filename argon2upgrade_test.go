@@ -0,0 +1,105 @@
+package occlude
+
+import (
+	"testing"
+)
+
+// verify that a user enrolled at a low Argon2 cost is upgraded to the active,
+// higher cost after one login, and can still log in afterwards.
+func TestArgon2UpgradeOnLogin(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+	stronger := Argon2Params{Time: 2, Memory: 128, Threads: 1}
+
+	s := NewServer(WithArgon2Params(weakArgon2Params), WithArgon2UpgradeOnLogin())
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+	scheme := s.ActiveScheme()
+	scheme.Argon2 = stronger
+	s.SetActiveScheme(scheme)
+
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err := s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := c.FinishLogin(svrsess, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Argon2Upgrade == nil {
+		t.Fatal("no upgrade for a file below the active cost")
+	}
+	if err := s.UpgradeArgon2(result.Verification(), result.Argon2Upgrade); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.FinishSession(result.Verification()); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.passwordFiles[testusername].scheme.Argon2; got != stronger {
+		t.Fatal("password file not upgraded:", got)
+	}
+
+	sess, err = c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err = s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svrsess.Argon2 != stronger || svrsess.Argon2Upgrade != nil {
+		t.Fatal("login after the upgrade did not use the new cost")
+	}
+	if result, err = c.FinishLogin(svrsess, testpassword); err != nil {
+		t.Fatal(err)
+	}
+	if result.Argon2Upgrade != nil {
+		t.Fatal("upgraded an already upgraded file")
+	}
+}
+
+// verify that files are not upgraded without the option, nor when they hold
+// Envelopes sealed under the current `rw`.
+func TestArgon2UpgradeSkipped(t *testing.T) {
+	testpassword := "this is a test password"
+	stronger := Argon2Params{Time: 2, Memory: 128, Threads: 1}
+
+	for _, withEnvelope := range []bool{false, true} {
+		var opts []ServerOption
+		if withEnvelope {
+			opts = append(opts, WithArgon2UpgradeOnLogin())
+		}
+		s := NewServer(append(opts, WithArgon2Params(weakArgon2Params))...)
+		c := NewClient("user")
+		register(t, s, c, "user", testpassword)
+		cv := login(t, s, c, testpassword, "")
+		if withEnvelope {
+			env, err := c.SealEnvelope("laptop", []byte("laptop device key"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.AddEnvelope(cv, env); err != nil {
+				t.Fatal(err)
+			}
+		}
+		scheme := s.ActiveScheme()
+		scheme.Argon2 = stronger
+		s.SetActiveScheme(scheme)
+
+		sess, err := c.NewSession(testpassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		svrsess, _, err := s.NewSession(sess)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if svrsess.Argon2Upgrade != nil {
+			t.Fatal("asked for an upgrade, with envelope:", withEnvelope)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package occlude
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// ErrAuditChainBroken is returned by VerifyAuditChain when an event has been
+// modified, removed or reordered.
+var ErrAuditChainBroken = errors.New("audit hash chain is broken")
+
+// AuditEventType identifies the kind of an AuditEvent.
+type AuditEventType uint8
+
+const (
+	// AuditRegistration records a successful Register.
+	AuditRegistration AuditEventType = iota + 1
+
+	// AuditLoginSuccess records a successful FinishSession.
+	AuditLoginSuccess
+
+	// AuditLoginFailure records a failed login, for the FailureReason in
+	// its Reason.
+	AuditLoginFailure
+
+	// AuditDeregistration records a Deregister.
+	AuditDeregistration
+
+	// AuditSchemeChange records a SetActiveScheme. It has no user.
+	AuditSchemeChange
+
+	// AuditCorruptPasswordFile records a password file rejected with
+	// ErrCorruptPasswordFile when it was loaded.
+	AuditCorruptPasswordFile
+)
+
+// FailureReason is a stable, machine-readable code for the cause of a failed
+// login, recorded as the Reason of its AuditLoginFailure event so that SIEM
+// and fraud systems can key alerts off it. Reasons are only recorded in the
+// audit log: the errors returned for failed logins are unchanged, so clients
+// learn nothing more from them than before.
+//
+// The Server does not rate limit logins, so repeated failures are left to be
+// detected downstream, by counting events with the same UserHash.
+type FailureReason string
+
+const (
+	// ReasonMalformed records a login request missing a required field.
+	ReasonMalformed FailureReason = "MALFORMED"
+
+	// ReasonUnknownUser records a login for a user with no password file.
+	// On a Server configured WithUserEnumerationProtection it is recorded
+	// when the login against the user's dummy file fails verification.
+	ReasonUnknownUser FailureReason = "UNKNOWN_USER"
+
+	// ReasonLegacyUser records a login for a legacy user who must first
+	// migrate with NewLegacyRegistration.
+	ReasonLegacyUser FailureReason = "LEGACY_USER"
+
+	// ReasonReplayed records a login rejected with ErrReplayedSession.
+	ReasonReplayed FailureReason = "REPLAYED"
+
+	// ReasonNoSession records a client verification for a user with no
+	// login in progress.
+	ReasonNoSession FailureReason = "NO_SESSION"
+
+	// ReasonSessionExpired records a client verification for a login which
+	// had expired.
+	ReasonSessionExpired FailureReason = "SESSION_EXPIRED"
+
+	// ReasonVerificationFailed records a client verification which did
+	// not match, most often because the client used the wrong password.
+	ReasonVerificationFailed FailureReason = "VERIFICATION_FAILED"
+
+	// ReasonIncorrectPassword records an incorrect password given to
+	// NewLegacyRegistration.
+	ReasonIncorrectPassword FailureReason = "INCORRECT_PASSWORD"
+
+	// ReasonRejectedByHook records a login failed by the error of a
+	// WithOnAuthenticated hook.
+	ReasonRejectedByHook FailureReason = "REJECTED_BY_HOOK"
+)
+
+// AuditEvent is a single entry in the Server's audit log. It never contains
+// secrets: users are identified only by UserHash, a hash of their id, which
+// lets an operator who knows an id find its events without the log revealing
+// ids to its readers.
+//
+// AuditLoginFailure events carry the FailureReason of the failure in Reason,
+// which is empty for every other type of event.
+//
+// If the Server was configured WithAuditHashChain, Hash commits to the event
+// and to the Hash of the previous event, PrevHash, so that the log is
+// tamper-evident and can be checked with VerifyAuditChain.
+type AuditEvent struct {
+	Type     AuditEventType
+	Time     time.Time
+	UserHash []byte
+	Reason   FailureReason
+	PrevHash []byte
+	Hash     []byte
+}
+
+// AuditSink receives the Server's audit events. Record is called with the
+// Server's lock held, in the order the events occur, so it must not call back
+// into the Server, and should return quickly.
+type AuditSink interface {
+	Record(event AuditEvent)
+}
+
+// WithAuditSink configures the Server to record audit events to sink.
+func WithAuditSink(sink AuditSink) ServerOption {
+	return func(s *Server) {
+		s.auditSink = sink
+	}
+}
+
+// WithAuditHashChain configures the Server to chain its audit events into a
+// hash chain, as described by AuditEvent.
+func WithAuditHashChain() ServerOption {
+	return func(s *Server) {
+		s.auditChain = true
+	}
+}
+
+// AuditUserHash returns the UserHash that audit events identify the user id
+// with.
+func AuditUserHash(id string) []byte {
+	h := sha3.New256()
+	h.Write([]byte("occlude audit user"))
+	h.Write([]byte(id))
+	return h.Sum(nil)
+}
+
+// VerifyAuditChain checks that events, recorded by a Server configured
+// WithAuditHashChain, form an unbroken hash chain starting from the first
+// event's PrevHash.
+func VerifyAuditChain(events []AuditEvent) error {
+	for i, event := range events {
+		if i > 0 && !bytes.Equal(event.PrevHash, events[i-1].Hash) {
+			return ErrAuditChainBroken
+		}
+		if !bytes.Equal(event.Hash, auditHash(event)) {
+			return ErrAuditChainBroken
+		}
+	}
+	return nil
+}
+
+// audit records an event of type t for the user id, or for no user if id is
+// empty. The caller must hold s.mu.
+func (s *Server) audit(t AuditEventType, id string) {
+	s.recordAudit(AuditEvent{Type: t}, id)
+}
+
+// auditFailure records an AuditLoginFailure event with reason for the user
+// id, or for no user if id is empty. The caller must hold s.mu.
+func (s *Server) auditFailure(id string, reason FailureReason) {
+	s.recordAudit(AuditEvent{Type: AuditLoginFailure, Reason: reason}, id)
+}
+
+// verificationFailure is the FailureReason of a failed client verification,
+// ReasonUnknownUser if the login was against a dummy password file.
+func verificationFailure(unknown bool) FailureReason {
+	if unknown {
+		return ReasonUnknownUser
+	}
+	return ReasonVerificationFailed
+}
+
+// recordAudit timestamps, hashes and records event for the user id. The
+// caller must hold s.mu.
+func (s *Server) recordAudit(event AuditEvent, id string) {
+	if s.auditSink == nil {
+		return
+	}
+	event.Time = s.now()
+	if id != "" {
+		event.UserHash = AuditUserHash(id)
+	}
+	if s.auditChain {
+		event.PrevHash = s.auditHead
+		event.Hash = auditHash(event)
+		s.auditHead = event.Hash
+	}
+	s.auditSink.Record(event)
+}
+
+// auditHash computes the hash chaining event to its predecessor.
+func auditHash(event AuditEvent) []byte {
+	var t [8]byte
+	binary.BigEndian.PutUint64(t[:], uint64(event.Time.UnixNano()))
+	var transcript []byte
+	for _, field := range [][]byte{
+		[]byte("occlude audit event"),
+		event.PrevHash,
+		{byte(event.Type)},
+		t[:],
+		event.UserHash,
+	} {
+		transcript = appendLengthPrefixed(transcript, field)
+	}
+	// events without a Reason hash as they did before reasons were added.
+	if event.Reason != "" {
+		transcript = appendLengthPrefixed(transcript, []byte(event.Reason))
+	}
+	sum := sha3.Sum256(transcript)
+	return sum[:]
+}
@@ -0,0 +1,191 @@
+package occlude
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// auditLog is an AuditSink which records events in memory.
+type auditLog struct {
+	events []AuditEvent
+}
+
+func (l *auditLog) Record(event AuditEvent) {
+	l.events = append(l.events, event)
+}
+
+// verify the audit events recorded for a register, failed and successful
+// login, deregister and scheme change, and that their hash chain verifies
+// until it is tampered with.
+func TestAuditLog(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	log := &auditLog{}
+	s := NewServer(WithArgon2Params(weakArgon2Params), WithAuditSink(log), WithAuditHashChain())
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+	login(t, s, c, testpassword, "")
+	if _, err := s.FinishSession(&ClientVerification{ID: testusername, FK2: make([]byte, prfSize)}); err == nil {
+		t.Fatal("finished a session with an incorrect verification")
+	}
+	cv := login(t, s, c, testpassword, "")
+	if _, err := s.FinishSession(cv); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Deregister(testusername); err != nil {
+		t.Fatal(err)
+	}
+	s.SetActiveScheme(DefaultScheme)
+
+	expected := []AuditEventType{AuditRegistration, AuditLoginFailure, AuditLoginSuccess, AuditDeregistration, AuditSchemeChange}
+	if len(log.events) != len(expected) {
+		t.Fatalf("expected %v events, got %v", len(expected), len(log.events))
+	}
+	userHash := AuditUserHash(testusername)
+	for i, event := range log.events {
+		if event.Type != expected[i] {
+			t.Fatalf("event %v: expected type %v, got %v", i, expected[i], event.Type)
+		}
+		if event.Type != AuditSchemeChange && !bytes.Equal(event.UserHash, userHash) {
+			t.Fatalf("event %v: wrong user hash", i)
+		}
+		if bytes.Contains(event.UserHash, []byte(testusername)) {
+			t.Fatalf("event %v: contains the user id", i)
+		}
+	}
+	if err := VerifyAuditChain(log.events); err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := append([]AuditEvent(nil), log.events...)
+	tampered[1].Type = AuditLoginSuccess
+	if err := VerifyAuditChain(tampered); !errors.Is(err, ErrAuditChainBroken) {
+		t.Fatal("expected ErrAuditChainBroken for a modified event, got", err)
+	}
+	removed := append(append([]AuditEvent(nil), log.events[:1]...), log.events[2:]...)
+	if err := VerifyAuditChain(removed); !errors.Is(err, ErrAuditChainBroken) {
+		t.Fatal("expected ErrAuditChainBroken for a removed event, got", err)
+	}
+}
+
+// verify that a deregistered user can no longer log in, and that their id
+// can be registered again.
+func TestDeregister(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+	if err := s.Deregister(testusername); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Deregister(testusername); err == nil {
+		t.Fatal("deregistered an unregistered user")
+	}
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.NewSession(sess); err == nil {
+		t.Fatal("logged in as a deregistered user")
+	}
+	register(t, s, c, testusername, "this is a new test password")
+}
+
+// verify the FailureReason recorded for each way a login can fail, and that
+// the error returned for it does not reveal the reason.
+func TestAuditFailureReasons(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(testpassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		reason FailureReason
+		opts   []ServerOption
+		fail   func(s *Server, c *Client) error
+	}{
+		{ReasonMalformed, nil, func(s *Server, c *Client) error {
+			_, _, err := s.NewSession(&UsrSession{Sid: testusername})
+			return err
+		}},
+		{ReasonUnknownUser, nil, func(s *Server, c *Client) error {
+			sess, _ := NewClient("unknown user").NewSession(testpassword)
+			_, _, err := s.NewSession(sess)
+			return err
+		}},
+		{ReasonUnknownUser, []ServerOption{WithUserEnumerationProtection()}, func(s *Server, c *Client) error {
+			sess, _ := NewClient("unknown user").NewSession(testpassword)
+			if _, _, err := s.NewSession(sess); err != nil {
+				return err
+			}
+			_, err := s.FinishSession(&ClientVerification{ID: "unknown user", FK2: make([]byte, prfSize)})
+			return err
+		}},
+		{ReasonLegacyUser, nil, func(s *Server, c *Client) error {
+			if err := s.ImportLegacyUser("legacy user", BcryptHash(hash)); err != nil {
+				t.Fatal(err)
+			}
+			sess, _ := NewClient("legacy user").NewSession(testpassword)
+			_, _, err := s.NewSession(sess)
+			return err
+		}},
+		{ReasonIncorrectPassword, nil, func(s *Server, c *Client) error {
+			if err := s.ImportLegacyUser("legacy user", BcryptHash(hash)); err != nil {
+				t.Fatal(err)
+			}
+			_, err := s.NewLegacyRegistration("legacy user", "the wrong password")
+			return err
+		}},
+		{ReasonReplayed, []ServerOption{WithLoginSequence()}, func(s *Server, c *Client) error {
+			sess, _ := c.NewSession(testpassword)
+			sess.Sequence = 5
+			_, _, err := s.NewSession(sess)
+			return err
+		}},
+		{ReasonNoSession, nil, func(s *Server, c *Client) error {
+			_, err := s.FinishSession(&ClientVerification{ID: testusername})
+			return err
+		}},
+		{ReasonSessionExpired, []ServerOption{WithSessionTTL(time.Nanosecond)}, func(s *Server, c *Client) error {
+			cv := login(t, s, c, testpassword, "")
+			time.Sleep(time.Millisecond)
+			_, err := s.FinishSession(cv)
+			return err
+		}},
+		{ReasonVerificationFailed, nil, func(s *Server, c *Client) error {
+			login(t, s, c, testpassword, "")
+			_, err := s.FinishSession(&ClientVerification{ID: testusername, FK2: make([]byte, prfSize)})
+			return err
+		}},
+		{ReasonRejectedByHook, []ServerOption{WithOnAuthenticated(func(string, []byte) error { return errors.New("no token") })}, func(s *Server, c *Client) error {
+			_, err := s.FinishSession(login(t, s, c, testpassword, ""))
+			return err
+		}},
+	} {
+		log := &auditLog{}
+		s := NewServer(append([]ServerOption{WithArgon2Params(weakArgon2Params), WithAuditSink(log)}, test.opts...)...)
+		c := NewClient(testusername)
+		register(t, s, c, testusername, testpassword)
+		err := test.fail(s, c)
+		if err == nil {
+			t.Fatalf("%s: login did not fail", test.reason)
+		}
+		if strings.Contains(err.Error(), string(test.reason)) {
+			t.Fatalf("%s: returned error %q reveals the reason", test.reason, err)
+		}
+		last := log.events[len(log.events)-1]
+		if last.Type != AuditLoginFailure || last.Reason != test.reason {
+			t.Fatalf("%s: recorded a %v event with reason %q", test.reason, last.Type, last.Reason)
+		}
+	}
+}
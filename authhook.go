@@ -0,0 +1,36 @@
+package occlude
+
+import (
+	"fmt"
+)
+
+// WithOnAuthenticated configures the Server to call hook after each login's
+// client verification succeeds, with the user's id and the session key SK,
+// for example to mint an application token bound to SK. If hook returns an
+// error, the login fails with it: the session is not established, and the
+// failure is counted in the Server's metrics and audit log.
+//
+// hook is called with the Server's lock held, so it must not call back into
+// the Server, and should return quickly. It must not retain or modify the
+// session key.
+func WithOnAuthenticated(hook func(id string, sessionKey []byte) error) ServerOption {
+	return func(s *Server) {
+		s.onAuthenticated = hook
+	}
+}
+
+// authenticated calls the Server's OnAuthenticated hook, if it has one, for a
+// login by the user id with the session key sk which has passed client
+// verification. If the hook fails, the login is recorded as failed. The
+// caller must hold s.mu.
+func (s *Server) authenticated(id string, sk []byte) error {
+	if s.onAuthenticated == nil {
+		return nil
+	}
+	if err := s.onAuthenticated(id, sk); err != nil {
+		s.stats.loginFailures++
+		s.auditFailure(id, ReasonRejectedByHook)
+		return fmt.Errorf("authenticated hook: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package occlude
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// verify that the OnAuthenticated hook is called with the user's id and
+// session key after client verification, and that its error fails the login.
+func TestOnAuthenticated(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	var gotID string
+	var gotKey []byte
+	var hookErr error
+	s := NewServer(WithArgon2Params(weakArgon2Params), WithOnAuthenticated(func(id string, sessionKey []byte) error {
+		gotID, gotKey = id, append([]byte(nil), sessionKey...)
+		return hookErr
+	}))
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+
+	sk, err := s.FinishSession(login(t, s, c, testpassword, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotID != testusername {
+		t.Fatalf("hook called for %q, expected %q", gotID, testusername)
+	}
+	if gotKey == nil || !bytes.Equal(gotKey, sk) {
+		t.Fatal("hook was not called with the session key")
+	}
+
+	hookErr = errors.New("could not mint token")
+	if _, err := s.FinishSession(login(t, s, c, testpassword, "")); !errors.Is(err, hookErr) {
+		t.Fatal("expected the hook's error, got", err)
+	}
+	if _, err := s.FinishSession(&ClientVerification{ID: testusername}); err == nil {
+		t.Fatal("session survived a failed hook")
+	}
+}
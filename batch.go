@@ -0,0 +1,54 @@
+package occlude
+
+import (
+	ristretto "github.com/gtank/ristretto255"
+)
+
+// NewSessionBatch is NewSession for many login requests at once, such as a
+// queue of requests drained by a front end. The Server's lock is taken once
+// for the whole batch rather than once per request. The i-th SvrSession,
+// session key and error are the results of NewSession for sessions[i]; a
+// failed request does not affect the others.
+//
+// NOTE: the ristretto255 implementation offers no batched scalar
+// multiplication, so each request still costs the same group operations as a
+// call to NewSession. The saving is in lock acquisitions, which matters most
+// under contention.
+func (s *Server) NewSessionBatch(sessions []*UsrSession) ([]*SvrSession, [][]byte, []error) {
+	done, err := s.permitAuthentication()
+	if err != nil {
+		errs := make([]error, len(sessions))
+		for i := range errs {
+			errs[i] = err
+		}
+		return make([]*SvrSession, len(sessions)), make([][]byte, len(sessions)), errs
+	}
+	defer done()
+	xs := make([]*ristretto.Scalar, len(sessions))
+	for i := range xs {
+		xs[i] = randomScalar()
+	}
+	return s.newSessionBatch(sessions, xs)
+}
+
+// newSessionBatch implements NewSessionBatch using the server ephemeral keys
+// xs, as newSession does for NewSession.
+func (s *Server) newSessionBatch(sessions []*UsrSession, xs []*ristretto.Scalar) ([]*SvrSession, [][]byte, []error) {
+	svrSessions := make([]*SvrSession, len(sessions))
+	keys := make([][]byte, len(sessions))
+	errs := make([]error, len(sessions))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, session := range sessions {
+		svrSession, sess, err := s.startSessionLocked(session, xs[i], false)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		svrSessions[i] = svrSession
+		if !s.strict {
+			keys[i] = sess.sk
+		}
+	}
+	return svrSessions, keys, errs
+}
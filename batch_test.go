@@ -0,0 +1,125 @@
+package occlude
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	ristretto "github.com/gtank/ristretto255"
+)
+
+// verify that the results of NewSessionBatch match those of individual calls
+// to NewSession with the same ephemeral keys, and that a failed request does
+// not affect the rest of the batch.
+func TestNewSessionBatch(t *testing.T) {
+	testpassword := "this is a test password"
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	var clients []*Client
+	var sessions []*UsrSession
+	for i := 0; i < 3; i++ {
+		id := fmt.Sprintf("user %v", i)
+		c := NewClient(id)
+		register(t, s, c, id, testpassword)
+		sess, err := c.NewSession(testpassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, c)
+		sessions = append(sessions, sess)
+	}
+	unregistered, err := NewClient("unregistered").NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions = append(sessions, unregistered, nil)
+	xs := make([]*ristretto.Scalar, len(sessions))
+	for i := range xs {
+		xs[i] = randomScalar()
+	}
+
+	batch, keys, errs := s.newSessionBatch(sessions, xs)
+	for i, sess := range sessions {
+		individual, key, err := s.newSession(sess, xs[i])
+		if (err != nil) != (errs[i] != nil) {
+			t.Fatalf("request %v: batch error %v, individual error %v", i, errs[i], err)
+		}
+		if err != nil {
+			continue
+		}
+		if batch[i].Beta.Equal(individual.Beta) != 1 || batch[i].Xs.Equal(individual.Xs) != 1 ||
+			!bytes.Equal(batch[i].fk1, individual.fk1) || !bytes.Equal(keys[i], key) {
+			t.Fatalf("request %v: batch and individual results differ", i)
+		}
+	}
+	if errs[3] == nil || errs[4] == nil {
+		t.Fatal("batch accepted an invalid request")
+	}
+
+	for i, c := range clients {
+		clientKey, fk2, err := c.SessionKey(batch[i], testpassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		serverKey, err := s.FinishSession(&ClientVerification{ID: sessions[i].Sid, FK2: fk2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(clientKey, serverKey) || !bytes.Equal(serverKey, keys[i]) {
+			t.Fatal("batch session keys do not match")
+		}
+	}
+}
+
+// benchmarkLoginRequests registers n users and returns a Server and a login
+// request for each of them.
+func benchmarkLoginRequests(b *testing.B, n int) (*Server, []*UsrSession) {
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	var sessions []*UsrSession
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("user %v", i)
+		c := NewClient(id)
+		pr, err := s.NewRegistration(id)
+		if err != nil {
+			b.Fatal(err)
+		}
+		reg, err := c.NewRegistration(pr, id, "this is a test password")
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := s.Register(reg); err != nil {
+			b.Fatal(err)
+		}
+		sess, err := c.NewSession("this is a test password")
+		if err != nil {
+			b.Fatal(err)
+		}
+		sessions = append(sessions, sess)
+	}
+	return s, sessions
+}
+
+func BenchmarkNewSessionSequential(b *testing.B) {
+	s, sessions := benchmarkLoginRequests(b, 64)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, sess := range sessions {
+			if _, _, err := s.NewSession(sess); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkNewSessionBatch(b *testing.B) {
+	s, sessions := benchmarkLoginRequests(b, 64)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _, errs := s.NewSessionBatch(sessions)
+		for _, err := range errs {
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
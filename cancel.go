@@ -0,0 +1,64 @@
+package occlude
+
+import (
+	"crypto/subtle"
+	"errors"
+)
+
+// cancelTokenSize is the length of the token which authenticates a
+// RegistrationCancellation, in bytes.
+const cancelTokenSize = 32
+
+// ErrInvalidCancellation is returned by Server.CancelRegistration when the
+// RegistrationCancellation's token does not match the registration in
+// progress.
+var ErrInvalidCancellation = errors.New("invalid registration cancellation")
+
+// RegistrationCancellation is a request from the client to abandon the
+// registration for ID which it started with NewRegistration. Token is the
+// secret returned to the client with the registration, so that only the
+// client which started a registration can cancel it.
+type RegistrationCancellation struct {
+	ID    string
+	Token []byte
+}
+
+// CancelRegistration returns the RegistrationCancellation for the
+// registration sinfo started for the Client, to send to the server instead of
+// a Registration.
+func (c *Client) CancelRegistration(sinfo *pendingRegistration) (*RegistrationCancellation, error) {
+	if sinfo == nil {
+		return nil, ErrNilMessage
+	}
+	return &RegistrationCancellation{
+		ID:    c.Sid,
+		Token: append([]byte(nil), sinfo.cancelToken...),
+	}, nil
+}
+
+// CancelRegistration immediately removes the state of the registration in
+// progress for the user in the RegistrationCancellation, rather than leaving
+// it until it expires, so that a subsequent Register for it fails. The user's
+// existing password file, if any, is unaffected.
+func (s *Server) CancelRegistration(cancel *RegistrationCancellation) error {
+	done, err := s.permitRegistration()
+	if err != nil {
+		return err
+	}
+	defer done()
+	if cancel == nil {
+		return ErrNilMessage
+	}
+	id := s.userID(cancel.ID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pr, exists := s.pendingRegistrations[id]
+	if !exists {
+		return errors.New("no pending registration")
+	}
+	if subtle.ConstantTimeCompare(pr.cancelToken, cancel.Token) != 1 {
+		return ErrInvalidCancellation
+	}
+	delete(s.pendingRegistrations, id)
+	return nil
+}
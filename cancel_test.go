@@ -0,0 +1,45 @@
+package occlude
+
+import (
+	"errors"
+	"testing"
+)
+
+// verify that only the initiating client can cancel a registration, and that
+// a cancelled registration can no longer be completed.
+func TestCancelRegistration(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername)
+	pr, err := s.NewRegistration(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err := c.NewRegistration(pr, testusername, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forged := &RegistrationCancellation{ID: testusername, Token: make([]byte, cancelTokenSize)}
+	if err := s.CancelRegistration(forged); !errors.Is(err, ErrInvalidCancellation) {
+		t.Fatal("expected ErrInvalidCancellation, got", err)
+	}
+	cancel, err := c.CancelRegistration(pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CancelRegistration(cancel); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := s.pendingRegistrations[testusername]; exists {
+		t.Fatal("cancelled registration is still pending")
+	}
+	if err := s.Register(reg); err == nil {
+		t.Fatal("registered a cancelled registration")
+	}
+	if err := s.CancelRegistration(cancel); err == nil {
+		t.Fatal("cancelled a registration twice")
+	}
+}
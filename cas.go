@@ -0,0 +1,86 @@
+package occlude
+
+import (
+	"bytes"
+	"errors"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// ErrConcurrentModification is returned when a password file is to be
+// replaced, but has been modified since it was read, so that replacing it
+// would silently discard the other modification. The caller may read the file
+// again and retry.
+var ErrConcurrentModification = errors.New("password file was modified concurrently")
+
+// fileDigest returns a digest of the password file of the user id, or nil if
+// they have none. The login sequence is left out, since logins advance it
+// without modifying the user's credentials. The caller must hold s.mu.
+func (s *Server) fileDigest(id string) []byte {
+	pf, exists := s.passwordFiles[id]
+	if !exists {
+		return nil
+	}
+	return passwordFileDigest(id, pf)
+}
+
+// passwordFileDigest returns the digest fileDigest returns for pf.
+func passwordFileDigest(id string, pf pwdFile) []byte {
+	pf.sequence = 0
+	sum := sha3.Sum256(encodePasswordFile(id, pf))
+	return sum[:]
+}
+
+// compareAndSwap stores pf as the password file of the user id if their
+// current file has the digest expected, or if expected is nil and they have
+// none, and returns ErrConcurrentModification otherwise. The caller must hold
+// s.mu.
+func (s *Server) compareAndSwap(id string, expected []byte, pf pwdFile) error {
+	if !bytes.Equal(s.fileDigest(id), expected) {
+		return ErrConcurrentModification
+	}
+	s.setPasswordFile(id, pf)
+	return nil
+}
+
+// CompareAndSwapPasswordFile is UnmarshalPasswordFile, storing data as the
+// password file of the user id only if their current file is the one encoded
+// in expected, as returned by an earlier MarshalPasswordFile, or if expected
+// is nil and they have none. Otherwise it returns ErrConcurrentModification,
+// so that two read-modify-write updates to the same file can not race and
+// lose one of them. Registrations started by NewUpgrade and FinishRecovery are
+// completed by Register in the same way, against the file they were started
+// for.
+//
+// NOTE: every modification other than a login advancing the file's sequence
+// number counts, including the re-peppering of the file by a login after the
+// Server's pepper epoch changes.
+func (s *Server) CompareAndSwapPasswordFile(id string, expected []byte, data []byte) error {
+	fileID, pf, err := s.decodePasswordFile(data)
+	if err != nil {
+		return err
+	}
+	id = s.userID(id)
+	if s.userID(fileID) != id {
+		return ErrPasswordFileMismatch
+	}
+	var digest []byte
+	if expected != nil {
+		expectedID, expectedFile, err := s.decodePasswordFile(expected)
+		if err != nil {
+			return err
+		}
+		if s.userID(expectedID) != id {
+			return ErrPasswordFileMismatch
+		}
+		digest = passwordFileDigest(id, expectedFile)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.compareAndSwap(id, digest, pf); err != nil {
+		return err
+	}
+	delete(s.legacyUsers, id)
+	return nil
+}
@@ -0,0 +1,83 @@
+package occlude
+
+import (
+	"testing"
+)
+
+// verify that of two updates to the same password file read at the same time,
+// one wins and the other fails with ErrConcurrentModification, and that a
+// password change loses to a modification made after it was started.
+func TestCompareAndSwapPasswordFile(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+	expected, err := s.MarshalPasswordFile(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each admin prepares a replacement file from its own registration.
+	var updates [][]byte
+	for _, password := range []string{"first new password", "second new password"} {
+		other := NewServer(WithArgon2Params(weakArgon2Params))
+		register(t, other, NewClient(testusername), testusername, password)
+		update, err := other.MarshalPasswordFile(testusername)
+		if err != nil {
+			t.Fatal(err)
+		}
+		updates = append(updates, update)
+	}
+	errs := make(chan error, len(updates))
+	for _, update := range updates {
+		go func(update []byte) {
+			errs <- s.CompareAndSwapPasswordFile(testusername, expected, update)
+		}(update)
+	}
+	var won, lost int
+	for range updates {
+		switch err := <-errs; err {
+		case nil:
+			won++
+		case ErrConcurrentModification:
+			lost++
+		default:
+			t.Fatal(err)
+		}
+	}
+	if won != 1 || lost != 1 {
+		t.Fatalf("expected one update to win and one to lose, %d won and %d lost", won, lost)
+	}
+	if err := s.CompareAndSwapPasswordFile("new user", nil, updates[0]); err != ErrPasswordFileMismatch {
+		t.Fatal("expected ErrPasswordFileMismatch, got", err)
+	}
+
+	// a password change started before an envelope is added can not then
+	// replace the file, which would lose the envelope.
+	s = NewServer(WithArgon2Params(weakArgon2Params))
+	register(t, s, c, testusername, testpassword)
+	cv := login(t, s, c, testpassword, "")
+	pr, err := s.NewUpgrade(cv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := c.SealEnvelope("label", []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddEnvelope(cv, env); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.FinishSession(cv); err != nil {
+		t.Fatal(err)
+	}
+	reg, err := c.NewRegistration(pr, testusername, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register(reg); err != ErrConcurrentModification {
+		t.Fatal("expected ErrConcurrentModification, got", err)
+	}
+}
@@ -0,0 +1,45 @@
+package occlude
+
+// NewSessionWithChannelBinding is NewSessionWithEnvelope for a login bound to
+// the channel it runs over, identified by binding, such as the keying material
+// exported from the TLS connection (RFC 5705 or RFC 8446 section 7.5) under a
+// label agreed with the server. binding is folded into the shared secret of
+// the key exchange, so the login succeeds only if the server supplies the same
+// value to its NewSessionWithChannelBinding. A login relayed by a machine in
+// the middle, which terminates one TLS connection and opens another, fails
+// with ErrAuthenticationFailed, since the two connections export different
+// keying material.
+//
+// An empty binding leaves the login unbound.
+func (c *Client) NewSessionWithChannelBinding(password string, label string, binding []byte) (*UsrSession, error) {
+	session, err := c.NewSessionWithEnvelope(password, label)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	session.channelBinding = append([]byte(nil), binding...)
+	return session, nil
+}
+
+// NewSessionWithChannelBinding is NewSession for a login bound to the channel
+// the UsrSession was received over, identified by binding, which must be the
+// value the client supplied to its NewSessionWithChannelBinding. A retried
+// UsrSession is answered as a retry only if it is bound to the same channel.
+func (s *Server) NewSessionWithChannelBinding(session *UsrSession, binding []byte) (*SvrSession, []byte, error) {
+	if session == nil {
+		return nil, nil, ErrNilMessage
+	}
+	bound := *session
+	bound.channelBinding = append([]byte(nil), binding...)
+	return s.NewSession(&bound)
+}
+
+// bindChannel folds the channel binding of a login into the shared secret K.
+// If binding is empty, K is returned unchanged.
+func bindChannel(K []byte, binding []byte) []byte {
+	if len(binding) == 0 {
+		return K
+	}
+	return deriveKey(K, nil, appendLengthPrefixed([]byte("occlude channel binding"), binding), len(K))
+}
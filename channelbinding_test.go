@@ -0,0 +1,56 @@
+package occlude
+
+import (
+	"bytes"
+	"testing"
+)
+
+// verify that a login bound to a channel succeeds only if the client and the
+// server supply the same channel binding, as a relayed login over a different
+// TLS connection would not.
+func TestChannelBinding(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+
+	channel := bytes.Repeat([]byte{1}, 32)
+	relayed := bytes.Repeat([]byte{2}, 32)
+	for _, test := range []struct {
+		client, server []byte
+		err            error
+	}{
+		{channel, channel, nil},
+		{nil, nil, nil},
+		{channel, relayed, ErrAuthenticationFailed},
+		{channel, nil, ErrAuthenticationFailed},
+		{nil, channel, ErrAuthenticationFailed},
+	} {
+		sess, err := c.NewSessionWithChannelBinding(testpassword, "", test.client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the binding is supplied locally by each side, and is not sent.
+		b, err := sess.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var received UsrSession
+		if err := received.UnmarshalBinary(b); err != nil {
+			t.Fatal(err)
+		}
+		svrsess, serverKey, err := s.NewSessionWithChannelBinding(&received, test.server)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clientKey, _, err := c.SessionKey(svrsess, testpassword)
+		if err != test.err {
+			t.Fatalf("client binding %x, server binding %x: expected %v, got %v", test.client, test.server, test.err, err)
+		}
+		if err == nil && !bytes.Equal(clientKey, serverKey) {
+			t.Fatal("session keys differ")
+		}
+	}
+}
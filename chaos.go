@@ -0,0 +1,75 @@
+package occlude
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+)
+
+// ErrInjectedStoreFailure is returned by the operations of a Server
+// configured WithFailureInjection in place of a failure of its password file
+// store.
+var ErrInjectedStoreFailure = errors.New("injected password file store failure")
+
+// FailureInjection configures the rates, each the probability from 0 to 1
+// with which every operation of the Server fails, at which a Server
+// configured WithFailureInjection injects each kind of failure. Seed seeds the
+// choice of which operations fail, so that a run can be reproduced.
+type FailureInjection struct {
+	// StoreRate is the rate of failures of the password file store, which
+	// return ErrInjectedStoreFailure.
+	StoreRate float64
+
+	// RandomnessRate is the rate of failures of the source of randomness,
+	// which return ErrWeakRandomness.
+	RandomnessRate float64
+
+	// TimeoutRate is the rate of simulated timeouts, which return
+	// context.DeadlineExceeded.
+	TimeoutRate float64
+
+	Seed int64
+}
+
+// WithFailureInjection configures the Server for chaos and resilience
+// testing, to fail its operations at random as f configures, before they do
+// any work, with the errors the real failures return. It lets integration
+// tests exercise the error handling of their callers on paths which are
+// otherwise hard to reach. Operations which are not failed run as normal.
+//
+// NOTE: a Server configured WithFailureInjection fails logins and
+// registrations on purpose. It must never be used in production: no Server
+// injects failures unless configured to with this option.
+func WithFailureInjection(f FailureInjection) ServerOption {
+	return func(s *Server) {
+		s.failures = &failureInjector{config: f, rand: rand.New(rand.NewSource(f.Seed))}
+	}
+}
+
+// failureInjector chooses the operations of a Server to fail.
+type failureInjector struct {
+	config FailureInjection
+	rand   *rand.Rand
+}
+
+// inject returns the failure to inject into an operation, or nil if it should
+// run as normal, as it always does if fi is nil. The caller must hold the
+// Server's mu.
+func (fi *failureInjector) inject() error {
+	if fi == nil {
+		return nil
+	}
+	for _, failure := range []struct {
+		rate float64
+		err  error
+	}{
+		{fi.config.StoreRate, ErrInjectedStoreFailure},
+		{fi.config.RandomnessRate, ErrWeakRandomness},
+		{fi.config.TimeoutRate, context.DeadlineExceeded},
+	} {
+		if failure.rate > 0 && fi.rand.Float64() < failure.rate {
+			return failure.err
+		}
+	}
+	return nil
+}
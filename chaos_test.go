@@ -0,0 +1,48 @@
+package occlude
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// verify that a Server injecting each kind of failure at a rate of 100% fails
+// its registrations and logins with the error of that failure, and that one
+// injecting none fails neither.
+func TestFailureInjection(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := s.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		config FailureInjection
+		err    error
+	}{
+		{FailureInjection{}, nil},
+		{FailureInjection{StoreRate: 1}, ErrInjectedStoreFailure},
+		{FailureInjection{RandomnessRate: 1}, ErrWeakRandomness},
+		{FailureInjection{TimeoutRate: 1}, context.DeadlineExceeded},
+	} {
+		s := NewServer(WithArgon2Params(weakArgon2Params), WithFailureInjection(test.config))
+		if err := s.RestoreSnapshot(snapshot); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.NewRegistration("another user"); !errors.Is(err, test.err) {
+			t.Fatalf("%+v: NewRegistration: expected %v, got %v", test.config, test.err, err)
+		}
+		if _, _, err := s.NewSession(sess); !errors.Is(err, test.err) {
+			t.Fatalf("%+v: NewSession: expected %v, got %v", test.config, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package occlude
+
+import (
+	"time"
+)
+
+// ClockPolicy determines how a Server enforces its time-based checks, such as
+// the session and registration TTLs, while its clock appears untrustworthy.
+type ClockPolicy uint8
+
+const (
+	// ClockFailClosed keeps enforcing time-based checks while the clock
+	// appears untrustworthy, so that logins and registrations may be
+	// rejected as expired. It is the default.
+	ClockFailClosed ClockPolicy = iota
+
+	// ClockFailOpen suspends time-based rejections while the clock appears
+	// untrustworthy: logins and registrations do not expire, and Sweep does
+	// not remove them, until the clock is plausible again.
+	ClockFailOpen
+)
+
+var (
+	// minPlausibleTime and maxPlausibleTime bound the times a Server's clock
+	// is expected to read. A clock outside of them, for example one reset to
+	// the Unix epoch, is untrustworthy.
+	minPlausibleTime = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	maxPlausibleTime = time.Date(2100, time.January, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// maxClockRegression is how far a Server's clock may go backwards between two
+// checks before it is considered untrustworthy.
+const maxClockRegression = time.Hour
+
+// WithClockWarning configures a function which the Server calls, with the
+// time its clock reads, whenever a clock check finds the clock implausible:
+// outside of the years 2020 to 2100, or more than an hour behind the previous
+// check. Every such check is also counted in the
+// occlude_clock_warnings_total metric. The clock is checked in NewServer, in
+// every Sweep, and by CheckClock. The function is called with the Server
+// locked, and must not call back into it.
+func WithClockWarning(warn func(now time.Time)) ServerOption {
+	return func(s *Server) {
+		s.clockWarning = warn
+	}
+}
+
+// WithClockPolicy configures how the Server enforces its time-based checks
+// while its clock appears untrustworthy. The default is ClockFailClosed.
+func WithClockPolicy(p ClockPolicy) ServerOption {
+	return func(s *Server) {
+		s.clockPolicy = p
+	}
+}
+
+// CheckClock checks that the Server's clock is plausibly current, warning as
+// configured with WithClockWarning if not, and reports whether it is.
+func (s *Server) CheckClock() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.checkClock()
+}
+
+// checkClock implements CheckClock. The caller must hold s.mu.
+func (s *Server) checkClock() bool {
+	now := s.now()
+	plausible := !now.Before(minPlausibleTime) && !now.After(maxPlausibleTime) &&
+		(s.lastClockCheck.IsZero() || now.After(s.lastClockCheck.Add(-maxClockRegression)))
+	s.lastClockCheck = now
+	s.clockUntrusted = !plausible
+	if !plausible {
+		s.stats.clockWarnings++
+		if s.clockWarning != nil {
+			s.clockWarning(now)
+		}
+	}
+	return plausible
+}
+
+// enforceTime reports whether time-based rejections should be made, which is
+// unless the clock is untrustworthy and the policy is ClockFailOpen. The
+// caller must hold s.mu.
+func (s *Server) enforceTime() bool {
+	return !s.clockUntrusted || s.clockPolicy != ClockFailOpen
+}
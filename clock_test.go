@@ -0,0 +1,72 @@
+package occlude
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// verify that a clock set to an implausible time fires the clock warning,
+// and that ClockFailOpen then suspends expiry while ClockFailClosed does not.
+func TestClockWarning(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	for _, policy := range []ClockPolicy{ClockFailClosed, ClockFailOpen} {
+		var warnings []time.Time
+		clock := &testClock{t: time.Unix(1700000000, 0)}
+		s := NewServer(
+			WithArgon2Params(weakArgon2Params),
+			withClock(clock.now),
+			WithSessionTTL(time.Minute),
+			WithClockWarning(func(now time.Time) { warnings = append(warnings, now) }),
+			WithClockPolicy(policy),
+		)
+		if len(warnings) != 0 {
+			t.Fatal("clock warning fired for a plausible clock")
+		}
+		c := NewClient(testusername)
+		register(t, s, c, testusername, testpassword)
+		cv := login(t, s, c, testpassword, "")
+
+		// the clock jumps far into the future.
+		clock.t = time.Date(2200, time.January, 1, 0, 0, 0, 0, time.UTC)
+		s.Sweep()
+		if len(warnings) != 1 || !warnings[0].Equal(clock.t) {
+			t.Fatalf("clock warning did not fire for an implausible clock: %v", warnings)
+		}
+		var buf bytes.Buffer
+		if err := s.WriteMetrics(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(buf.String(), "occlude_clock_warnings_total 1\n") {
+			t.Fatalf("clock warning was not counted:\n%s", buf.String())
+		}
+
+		// a login which the broken clock has "expired" is only rejected when
+		// failing closed.
+		_, err := s.FinishSession(cv)
+		if policy == ClockFailClosed && err == nil {
+			t.Fatal("ClockFailClosed did not enforce the session TTL")
+		}
+		if policy == ClockFailOpen && err != nil {
+			t.Fatal("ClockFailOpen enforced the session TTL:", err)
+		}
+
+		// a clock reset to the Unix epoch, or which goes backwards, is also
+		// implausible.
+		clock.t = time.Unix(0, 0)
+		if s.CheckClock() {
+			t.Fatal("clock reset to the epoch was not reported")
+		}
+		clock.t = time.Unix(1700000000, 0)
+		if !s.CheckClock() {
+			t.Fatal("plausible clock reported as implausible")
+		}
+		clock.t = clock.t.Add(-2 * maxClockRegression)
+		if s.CheckClock() {
+			t.Fatal("clock going backwards was not reported")
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package occlude
+
+// WithContext binds the Server to a deployment context, such as the
+// application name and environment. The context is mixed into the session
+// key derivation and the signed session transcript, so that a login can only
+// succeed between a Server and a Client configured with the same context, set
+// with WithClientContext. Password files registered under one context can not
+// be logged in to under another. The empty context, the default, leaves the
+// protocol unchanged.
+func WithContext(ctx []byte) ServerOption {
+	return func(s *Server) {
+		s.context = append([]byte(nil), ctx...)
+	}
+}
+
+// WithClientContext binds the Client to a deployment context. The context is
+// mixed into the OPRF output, and so into the keys sealing the user's
+// envelopes and the export key, as well as into the session key derivation
+// and the signed session transcript. It must match the context the Server was
+// configured with using WithContext.
+func WithClientContext(ctx []byte) ClientOption {
+	return func(c *Client) {
+		c.context = append([]byte(nil), ctx...)
+	}
+}
+
+// bindContext derives a key from key which is bound to the deployment context
+// ctx, using HKDF with the context and the key's label as the info. If ctx is
+// empty, key is returned unchanged, so that deployments without a context
+// derive the same keys as before contexts were introduced.
+func bindContext(ctx []byte, label string, key []byte) []byte {
+	if len(ctx) == 0 {
+		return key
+	}
+	info := appendLengthPrefixed([]byte("occlude context "+label), ctx)
+	return deriveKey(key, nil, info, len(key))
+}
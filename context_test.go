@@ -0,0 +1,89 @@
+package occlude
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// verify that a Server and a Client configured with the same context can log
+// in, and that a mismatch on either side causes authentication to fail.
+func TestContext(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+	production := []byte("example app production")
+	staging := []byte("example app staging")
+
+	s := NewServer(WithArgon2Params(weakArgon2Params), WithContext(production))
+	c := NewClient(testusername, WithClientContext(production))
+	register(t, s, c, testusername, testpassword)
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err := s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientKey, fk2, err := c.SessionKey(svrsess, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverKey, err := s.FinishSession(&ClientVerification{ID: testusername, FK2: fk2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(clientKey, serverKey) {
+		t.Fatal("matching contexts derived different session keys")
+	}
+
+	// a client from another environment can't log in...
+	c2 := NewClient(testusername, WithClientContext(staging))
+	sess, err = c2.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err = s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c2.SessionKey(svrsess, testpassword); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatal("expected ErrAuthenticationFailed, got", err)
+	}
+
+	// ...nor a client of this environment to a server of another.
+	encoded, err := s.MarshalPasswordFile(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2 := NewServer(WithContext(staging))
+	if err := s2.UnmarshalPasswordFile(testusername, encoded); err != nil {
+		t.Fatal(err)
+	}
+	sess, err = c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err = s2.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.SessionKey(svrsess, testpassword); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatal("expected ErrAuthenticationFailed, got", err)
+	}
+}
+
+// verify that bindContext derives distinct keys for distinct contexts and
+// labels, and leaves keys unchanged without a context.
+func TestBindContext(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	if !bytes.Equal(bindContext(nil, "K", key), key) {
+		t.Fatal("empty context changed the key")
+	}
+	a := bindContext([]byte("production"), "K", key)
+	b := bindContext([]byte("staging"), "K", key)
+	rw := bindContext([]byte("production"), "rw", key)
+	if bytes.Equal(a, key) || bytes.Equal(a, b) || bytes.Equal(a, rw) {
+		t.Fatal("contexts or labels derived the same key")
+	}
+}
@@ -1,8 +1,13 @@
 package occlude
 
 import (
+	"bytes"
 	"crypto/rand"
+	"crypto/sha512"
+	"errors"
+	"hash"
 	"io"
+	"sync"
 
 	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/blake2b"
@@ -15,44 +20,106 @@ import (
 const (
 	argonTime   = 3
 	argonMemory = 1e5
+
+	// scalarSize and elementSize are the lengths of the canonical encodings of
+	// ristretto scalars and elements.
+	scalarSize  = 32
+	elementSize = 32
+)
+
+// TranscriptHash identifies the hash function used to compute the shared
+// secret K from the key exchange transcript. It is chosen independently of the
+// keyed Blake2B prf which expands K into the session and confirmation keys.
+type TranscriptHash uint8
+
+const (
+	// TranscriptSHA3_256 hashes the transcript with SHA3-256. It is the
+	// original, and default, transcript hash.
+	TranscriptSHA3_256 TranscriptHash = 1
+
+	// TranscriptSHA3_512 hashes the transcript with SHA3-512.
+	TranscriptSHA3_512 TranscriptHash = 2
+
+	// TranscriptSHA512 hashes the transcript with SHA-512.
+	TranscriptSHA512 TranscriptHash = 3
+
+	// DefaultTranscriptHash is the TranscriptHash used by a Server unless
+	// configured otherwise with WithTranscriptHash.
+	DefaultTranscriptHash = TranscriptSHA3_256
 )
 
+// ErrUnsupportedTranscriptHash is returned when a SvrSession requests a
+// TranscriptHash that is not known to this implementation.
+var ErrUnsupportedTranscriptHash = errors.New("unsupported transcript hash")
+
+// new returns a new instance of the hash function h, or nil if h is not
+// supported.
+func (h TranscriptHash) new() hash.Hash {
+	switch h {
+	case TranscriptSHA3_256:
+		return sha3.New256()
+	case TranscriptSHA3_512:
+		return sha3.New512()
+	case TranscriptSHA512:
+		return sha512.New()
+	}
+	return nil
+}
+
+// supported reports whether h is a TranscriptHash known to this
+// implementation.
+func (h TranscriptHash) supported() bool {
+	return h.new() != nil
+}
+
+// ErrWeakRandomness is returned when a freshly generated secret scalar is
+// zero, which happens only if the source of randomness is broken.
+var ErrWeakRandomness = errors.New("random scalar is zero, the source of randomness is broken")
+
+// entropy is the source of randomness for randomScalar. It is a variable so
+// that tests can inject a broken source.
+var entropy io.Reader = rand.Reader
+
 // Compute and return a random ristretto scalar (←R Zq).
 func randomScalar() *ristretto.Scalar {
 	b := make([]byte, 64)
-	_, err := rand.Read(b)
+	_, err := io.ReadFull(entropy, b)
 	if err != nil {
 		panic("could not get entropy")
 	}
 	return new(ristretto.Scalar).FromUniformBytes(b)
 }
 
+// argon2IDKey computes Argon2id. It is a variable so that tests can observe
+// when the expensive OPRF hardening runs.
+var argon2IDKey = argon2.IDKey
+
 // Compute the oprf output H(x, (H'(x))^k), where H' is a uniformly random
 // unique mapping of arbitrary length data to an element of the curve group. The
 // output is wrapped with Argon2ID to make dictionary attacks in the case of a
-// compromised server more costly. See the OPAQUE protocol paper for more
+// compromised server more costly, with the cost set by p. See the OPAQUE protocol paper for more
 // information about the design of this OPRF.
-func oprfA(x []byte, k *ristretto.Scalar) []byte {
+func oprfA(p Argon2Params, x []byte, k *ristretto.Scalar) []byte {
 	hprimex := new(ristretto.Element).FromUniformBytes(x)  // H'(x)
 	hprimex.ScalarMult(k, hprimex)                         // H'(x)^k
 	hash := sha3.Sum512(append(x, hprimex.Encode(nil)...)) // H(x, (H'(x)^k))
-	output := argon2.IDKey(hash[:], nil, argonTime, argonMemory, 4, 32)
+	output := argon2IDKey(hash[:], nil, p.Time, p.Memory, p.Threads, 32)
 	return output
 }
 
 // Compute the oprf output H(x, (H'(x))^k) given the input
 // β = a^k = ((H'(pw))^r)^k, r, and password.
-func oprfB(B *ristretto.Element, r *ristretto.Scalar, x [64]byte) []byte {
+func oprfB(p Argon2Params, B *ristretto.Element, r *ristretto.Scalar, x [64]byte) []byte {
 	rinv := new(ristretto.Scalar).Invert(r)
 	// B^{1/r} = (a^k)^{1/r} = (((H'(x))^r)^k)^{1/r}) = (H'(x)^k)
 	betarinv := new(ristretto.Element).ScalarMult(rinv, B)     // B^{1/r}
 	hash := sha3.Sum512(append(x[:], betarinv.Encode(nil)...)) // H(x, (H'(x))^k)
-	output := argon2.IDKey(hash[:], nil, argonTime, argonMemory, 4, 32)
+	output := argon2IDKey(hash[:], nil, p.Time, p.Memory, p.Threads, 32)
 	return output
 }
 
 // prf is a pseudorandom function, implemented with keyed Blake2B
-func prf(k [32]byte, x []byte) []byte {
+func prf(k []byte, x []byte) []byte {
 	b, err := blake2b.New256(k[:])
 	if err != nil {
 		panic(err)
@@ -64,43 +131,217 @@ func prf(k [32]byte, x []byte) []byte {
 	return b.Sum(nil)
 }
 
-// derive a separate authentication and cipher key using HKDF and the given
-// input key `x`.
-func deriveHKDFKeys(x []byte) (authKey []byte, cipherKey []byte) {
-	hkdf := hkdf.New(sha3.New512, x, nil, nil)
-	cipherKey = make([]byte, 32)
-	authKey = make([]byte, 32)
-	_, err := io.ReadFull(hkdf, cipherKey)
-	if err != nil {
-		panic("could not derive HKDF key material")
+// supported reports whether v is a key derivation Version known to this
+// implementation.
+func (v Version) supported() bool {
+	return v == Version1 || v == Version2 || v == Version3
+}
+
+// deriveSessionKeys derives the session key SK and the server and client
+// confirmation keys fk1 and fk2 from the shared secret K, according to the key
+// derivation Version v. From Version2, the Version and the TranscriptHash h
+// used to compute K are bound into each derived key.
+func deriveSessionKeys(v Version, h TranscriptHash, K []byte) (SK []byte, fk1 []byte, fk2 []byte, err error) {
+	if !v.supported() {
+		return nil, nil, nil, ErrUnsupportedVersion
 	}
-	_, err = io.ReadFull(hkdf, authKey)
-	if err != nil {
-		panic("could not derive HKDF key material")
+	label := func(i byte) []byte {
+		if v == Version1 {
+			return []byte{i}
+		}
+		return []byte{i, byte(v), byte(h)}
 	}
-	return
+	return prf(K, label(0)), prf(K, label(1)), prf(K, label(2)), nil
+}
+
+// keySpec names a key derived by deriveKeys: the HKDF info it is expanded
+// with, which must differ from that of every other key derived from the same
+// input, and its length in bytes.
+type keySpec struct {
+	info   []byte
+	length int
+}
+
+// deriveKeys derives a key for each of specs from the input key material ikm
+// and salt, which may be nil, with HKDF-SHA3-512. ikm is extracted once, and
+// each key expanded with its own info, so that a key depends only on ikm, salt
+// and its own spec, and not on which other keys are derived with it, or in
+// what order. It panics if two specs have the same info.
+func deriveKeys(ikm []byte, salt []byte, specs []keySpec) [][]byte {
+	prk := hkdf.Extract(sha3.New512, ikm, salt)
+	defer clear(prk)
+	keys := make([][]byte, len(specs))
+	for i, spec := range specs {
+		for _, prev := range specs[:i] {
+			if bytes.Equal(prev.info, spec.info) {
+				panic("duplicate HKDF info")
+			}
+		}
+		keys[i] = make([]byte, spec.length)
+		if _, err := io.ReadFull(hkdf.Expand(sha3.New512, prk, spec.info), keys[i]); err != nil {
+			panic("could not derive HKDF key material")
+		}
+	}
+	return keys
+}
+
+// deriveKey derives the single key of length bytes expanded with info, as
+// deriveKeys does.
+func deriveKey(ikm []byte, salt []byte, info []byte, length int) []byte {
+	return deriveKeys(ikm, salt, []keySpec{{info, length}})[0]
+}
+
+// deriveExportKey derives the export key from the OPRF output `rw`. The export
+// key is known only to the client, is stable for as long as the password and
+// the server's OPRF key are unchanged, and is independent of the keys used to
+// seal the envelope.
+func deriveExportKey(rw []byte) []byte {
+	return deriveKey(rw, nil, []byte("occlude export key"), 32)
 }
 
 // Perform the key exchange. Compute the shared secret using ECDH with the
-// provided static and ephemeral keys.
-func keServer(ps *ristretto.Scalar, xs *ristretto.Scalar, Pu *ristretto.Element, Xu *ristretto.Element) [32]byte {
+// provided static and ephemeral keys, hashed with the TranscriptHash h.
+func keServer(h TranscriptHash, ps *ristretto.Scalar, xs *ristretto.Scalar, Pu *ristretto.Element, Xu *ristretto.Element) []byte {
 	xsPu := new(ristretto.Element).ScalarMult(xs, Pu)
 	psXu := new(ristretto.Element).ScalarMult(ps, Xu)
 	xsXu := new(ristretto.Element).ScalarMult(xs, Xu)
 	sharedSecret := append(xsPu.Encode(nil), psXu.Encode(nil)...)
 	sharedSecret = append(sharedSecret, xsXu.Encode(nil)...)
-	return sha3.Sum256(sharedSecret)
+	return transcriptSum(h, sharedSecret)
 }
 
 // Perform the key exchange. Compute the shared secret using ECDH with the
-// provided static and ephemeral keys.
-func keUser(pu *ristretto.Scalar, xu *ristretto.Scalar, Ps *ristretto.Element, Xs *ristretto.Element) [32]byte {
+// provided static and ephemeral keys, hashed with the TranscriptHash h.
+func keUser(h TranscriptHash, pu *ristretto.Scalar, xu *ristretto.Scalar, Ps *ristretto.Element, Xs *ristretto.Element) []byte {
+	return keUserWithEphemeral(h, pu, xu, Ps, Xs, new(ristretto.Element).ScalarMult(xu, Xs))
+}
+
+// keUserWithEphemeral is keUser, with the ephemeral product xuXs = xu·Xs
+// already computed. Unlike the other products, it does not depend on the
+// credentials from the envelope, so the client can compute it while the OPRF
+// output is hardened.
+func keUserWithEphemeral(h TranscriptHash, pu *ristretto.Scalar, xu *ristretto.Scalar, Ps *ristretto.Element, Xs *ristretto.Element, xuXs *ristretto.Element) []byte {
 	puXs := new(ristretto.Element).ScalarMult(pu, Xs)
 	xuPs := new(ristretto.Element).ScalarMult(xu, Ps)
-	xuXs := new(ristretto.Element).ScalarMult(xu, Xs)
 	sharedSecret := append(puXs.Encode(nil), xuPs.Encode(nil)...)
 	sharedSecret = append(sharedSecret, xuXs.Encode(nil)...)
-	return sha3.Sum256(sharedSecret)
+	return transcriptSum(h, sharedSecret)
+}
+
+// keScratch holds the element, shared secret buffer and hash which
+// keServerScratch and keUserScratch reuse from one call to the next, so that
+// a server running many key exchanges does not allocate them for each. A
+// keScratch must not be used by concurrent calls. The shared secret is erased
+// from it after every call.
+type keScratch struct {
+	product ristretto.Element
+	secret  [3 * elementSize]byte
+	h       TranscriptHash
+	hh      hash.Hash
+}
+
+// keScratchPool holds the keScratch of the server's key exchanges.
+var keScratchPool = sync.Pool{New: func() interface{} { return new(keScratch) }}
+
+// keServerScratch is keServer, computing the shared secret in sc and
+// appending its hash to dst.
+func keServerScratch(sc *keScratch, dst []byte, h TranscriptHash, ps *ristretto.Scalar, xs *ristretto.Scalar, Pu *ristretto.Element, Xu *ristretto.Element) []byte {
+	b := sc.product.ScalarMult(xs, Pu).Encode(sc.secret[:0])
+	b = sc.product.ScalarMult(ps, Xu).Encode(b)
+	sc.product.ScalarMult(xs, Xu).Encode(b)
+	return sc.sum(h, dst)
+}
+
+// keUserScratch is keUserWithEphemeral, computing the shared secret in sc and
+// appending its hash to dst.
+func keUserScratch(sc *keScratch, dst []byte, h TranscriptHash, pu *ristretto.Scalar, xu *ristretto.Scalar, Ps *ristretto.Element, Xs *ristretto.Element, xuXs *ristretto.Element) []byte {
+	b := sc.product.ScalarMult(pu, Xs).Encode(sc.secret[:0])
+	b = sc.product.ScalarMult(xu, Ps).Encode(b)
+	xuXs.Encode(b)
+	return sc.sum(h, dst)
+}
+
+// sum appends the hash with h of the shared secret in sc to dst, and erases
+// the shared secret. h must be supported.
+func (sc *keScratch) sum(h TranscriptHash, dst []byte) []byte {
+	if sc.hh == nil || sc.h != h {
+		sc.h, sc.hh = h, h.new()
+	}
+	sc.hh.Write(sc.secret[:])
+	dst = sc.hh.Sum(dst)
+	sc.hh.Reset()
+	clear(sc.secret[:])
+	sc.product.Zero()
+	return dst
+}
+
+// transcriptSum hashes the key exchange transcript with h. h must be
+// supported.
+func transcriptSum(h TranscriptHash, transcript []byte) []byte {
+	hh := h.new()
+	hh.Write(transcript)
+	return hh.Sum(nil)
+}
+
+// sign produces a Schnorr signature (R, s) over msg using the private scalar
+// priv with corresponding public element pub.
+func sign(priv *ristretto.Scalar, pub *ristretto.Element, msg []byte) []byte {
+	k := randomScalar()
+	R := new(ristretto.Element).ScalarBaseMult(k)
+	e := signatureChallenge(R, pub, msg)
+	s := new(ristretto.Scalar).Multiply(e, priv)
+	s.Add(s, k)
+	return s.Encode(R.Encode(nil))
+}
+
+// verify reports whether sig is a valid Schnorr signature over msg by the
+// holder of the private scalar corresponding to pub.
+func verify(pub *ristretto.Element, msg []byte, sig []byte) bool {
+	if len(sig) != elementSize+scalarSize {
+		return false
+	}
+	R, err := group.element(sig[:elementSize])
+	if err != nil {
+		return false
+	}
+	s, err := group.scalar(sig[elementSize:])
+	if err != nil {
+		return false
+	}
+	e := signatureChallenge(R, pub, msg)
+	// sG = R + e*pub
+	sG := new(ristretto.Element).ScalarBaseMult(s)
+	expected := new(ristretto.Element).ScalarMult(e, pub)
+	expected.Add(expected, R)
+	return sG.Equal(expected) == 1
+}
+
+// signatureChallenge computes the Schnorr challenge e = H(R, pub, msg).
+func signatureChallenge(R *ristretto.Element, pub *ristretto.Element, msg []byte) *ristretto.Scalar {
+	h := sha3.New512()
+	h.Write([]byte("occlude signature"))
+	h.Write(R.Encode(nil))
+	h.Write(pub.Encode(nil))
+	h.Write(msg)
+	return new(ristretto.Scalar).FromUniformBytes(h.Sum(nil))
+}
+
+// isIdentity reports whether e is the identity element of the group.
+func isIdentity(e *ristretto.Element) bool {
+	return e.Equal(new(ristretto.Element).Zero()) == 1
+}
+
+// checkRandomness returns ErrWeakRandomness if any of the freshly generated
+// secret scalars is zero. A zero scalar has the identity as its public
+// element, so the check covers the elements derived from them too.
+func checkRandomness(scalars ...*ristretto.Scalar) error {
+	zero := new(ristretto.Scalar).Zero()
+	for _, k := range scalars {
+		if k.Equal(zero) == 1 {
+			return ErrWeakRandomness
+		}
+	}
+	return nil
 }
 
 func clear(x []byte) {
@@ -1,13 +1,17 @@
 package occlude
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"math/big"
 	"testing"
 	"time"
 
 	ristretto "github.com/gtank/ristretto255"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/sha3"
 )
 
 func timingAnalysis(a func(), b func(), n int) error {
@@ -93,3 +97,328 @@ func TestRistrettoTiming(t *testing.T) {
 	t.Log(timingAnalysis(f2, f3, 10000))
 	t.Log(timingAnalysis(f3, f4, 10000))
 }
+
+// verify that Schnorr signatures verify only for the signed message and key.
+func TestSignVerify(t *testing.T) {
+	priv := randomScalar()
+	pub := new(ristretto.Element).ScalarBaseMult(priv)
+	msg := []byte("this is a test message")
+	sig := sign(priv, pub, msg)
+	if !verify(pub, msg, sig) {
+		t.Fatal("valid signature did not verify")
+	}
+	if verify(pub, []byte("this is another message"), sig) {
+		t.Fatal("signature verified for a different message")
+	}
+	otherPub := new(ristretto.Element).ScalarBaseMult(randomScalar())
+	if verify(otherPub, msg, sig) {
+		t.Fatal("signature verified under a different key")
+	}
+	sig[len(sig)-1] ^= 0x01
+	if verify(pub, msg, sig) {
+		t.Fatal("tampered signature verified")
+	}
+	if verify(pub, msg, sig[:10]) {
+		t.Fatal("truncated signature verified")
+	}
+}
+
+// verify that each Version derives distinct keys from the same shared secret.
+func TestDeriveSessionKeysVersion(t *testing.T) {
+	K := []byte("this is a test shared secret")
+	sk1, fk11, fk21, err := deriveSessionKeys(Version1, DefaultTranscriptHash, K)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk2, fk12, fk22, err := deriveSessionKeys(Version2, DefaultTranscriptHash, K)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(sk1, sk2) || bytes.Equal(fk11, fk12) || bytes.Equal(fk21, fk22) {
+		t.Fatal("different versions derived the same key")
+	}
+	sk3, _, _, err := deriveSessionKeys(Version2, TranscriptSHA512, K)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(sk2, sk3) {
+		t.Fatal("different transcript hashes derived the same key")
+	}
+	if _, _, _, err := deriveSessionKeys(0, DefaultTranscriptHash, K); err != ErrUnsupportedVersion {
+		t.Fatal("expected ErrUnsupportedVersion, got", err)
+	}
+}
+
+// Verify that SessionKey fails uniformly, whether the envelope MAC fails
+// because the server tampered with it or the password is wrong, or the MAC
+// succeeds and the fk1 check fails. Before SessionKey was restructured, a
+// failed MAC returned before the key exchange, and took about half as long as
+// a failed fk1 check with cheap Argon2 parameters. It now runs the key
+// exchange with placeholder keys and reports both failures with
+// ErrAuthenticationFailed, and the paths are within measurement noise.
+func TestSessionKeyFailureTiming(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err := s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	badMAC := *svrsess
+	badMAC.c.Tag = append([]byte(nil), svrsess.c.Tag...)
+	badMAC.c.Tag[0] ^= 0xff
+	badFK1 := *svrsess
+	badFK1.fk1 = append([]byte(nil), svrsess.fk1...)
+	badFK1.fk1[0] ^= 0xff
+
+	failures := map[string]func(){
+		"bad MAC": func() {
+			if _, _, err := c.SessionKey(&badMAC, testpassword); err != ErrAuthenticationFailed {
+				t.Fatal("expected ErrAuthenticationFailed for a bad MAC, got", err)
+			}
+		},
+		"bad fk1": func() {
+			if _, _, err := c.SessionKey(&badFK1, testpassword); err != ErrAuthenticationFailed {
+				t.Fatal("expected ErrAuthenticationFailed for a bad fk1, got", err)
+			}
+		},
+		"bad password": func() {
+			if _, _, err := c.SessionKey(svrsess, "this is the wrong password"); err != ErrAuthenticationFailed {
+				t.Fatal("expected ErrAuthenticationFailed for a bad password, got", err)
+			}
+		},
+	}
+	for _, pair := range [][2]string{
+		{"bad MAC", "bad fk1"},
+		{"bad password", "bad fk1"},
+		{"bad MAC", "bad password"},
+	} {
+		t.Log(pair[0], "vs", pair[1], timingAnalysis(failures[pair[0]], failures[pair[1]], 200))
+	}
+}
+
+// verify that each side of the key exchange only computes the expected shared
+// secret when it passes its own static and ephemeral keys in their intended
+// positions, by systematically swapping its scalar and element arguments. This
+// guards against refactorings which, for example, use Xu where Xs is meant.
+//
+// Each side is checked against a reference transcript computed directly from
+// the products of the private scalars, rather than against the other side:
+// complementary mistakes on both sides, such as the server swapping its
+// scalars and the client its elements, still agree with each other but
+// compute a different, weaker, combination of the keys.
+func TestKeyExchangeArgumentPermutations(t *testing.T) {
+	ps, xs, pu, xu := randomScalar(), randomScalar(), randomScalar(), randomScalar()
+	Ps := new(ristretto.Element).ScalarBaseMult(ps)
+	Xs := new(ristretto.Element).ScalarBaseMult(xs)
+	Pu := new(ristretto.Element).ScalarBaseMult(pu)
+	Xu := new(ristretto.Element).ScalarBaseMult(xu)
+
+	product := func(a, b *ristretto.Scalar) []byte {
+		ab := new(ristretto.Scalar).Multiply(a, b)
+		return new(ristretto.Element).ScalarBaseMult(ab).Encode(nil)
+	}
+	var reference []byte
+	for _, pair := range [][2]*ristretto.Scalar{{xs, pu}, {ps, xu}, {xs, xu}} {
+		reference = append(reference, product(pair[0], pair[1])...)
+	}
+	expected := transcriptSum(DefaultTranscriptHash, reference)
+
+	type args struct {
+		name              string
+		static, ephemeral *ristretto.Scalar
+		public1, public2  *ristretto.Element
+	}
+	ke := map[string]func(args) []byte{
+		"server": func(a args) []byte {
+			return keServer(DefaultTranscriptHash, a.static, a.ephemeral, a.public1, a.public2)
+		},
+		"user": func(a args) []byte {
+			return keUser(DefaultTranscriptHash, a.static, a.ephemeral, a.public1, a.public2)
+		},
+	}
+	permutations := map[string][]args{
+		"server": {
+			{"correct", ps, xs, Pu, Xu},
+			{"swapped scalars", xs, ps, Pu, Xu},
+			{"swapped elements", ps, xs, Xu, Pu},
+			{"swapped both", xs, ps, Xu, Pu},
+			{"own static", ps, xs, Ps, Xu},
+			{"own ephemeral", ps, xs, Pu, Xs},
+			{"peer's ephemeral twice", ps, xs, Xu, Xu},
+		},
+		"user": {
+			{"correct", pu, xu, Ps, Xs},
+			{"swapped scalars", xu, pu, Ps, Xs},
+			{"swapped elements", pu, xu, Xs, Ps},
+			{"swapped both", xu, pu, Xs, Ps},
+			{"own static", pu, xu, Pu, Xs},
+			{"own ephemeral", pu, xu, Ps, Xu},
+			{"peer's ephemeral twice", pu, xu, Xs, Xs},
+		},
+	}
+	for side, perms := range permutations {
+		for _, a := range perms {
+			agreed := bytes.Equal(ke[side](a), expected)
+			if agreed != (a.name == "correct") {
+				t.Fatalf("%v with %v arguments: agreed = %v", side, a.name, agreed)
+			}
+		}
+	}
+}
+
+// verify that deriveKeys is deterministic, derives distinct keys for distinct
+// infos, derives each key independently of the others and of their order, and
+// matches the single HKDF expansion keys were derived with before it.
+func TestDeriveKeys(t *testing.T) {
+	ikm := []byte("this is test input key material")
+	salt := []byte("this is a test salt")
+	specs := []keySpec{
+		{[]byte("cipher"), 32},
+		{[]byte("auth"), 32},
+		{[]byte("iv"), 16},
+	}
+	keys := deriveKeys(ikm, salt, specs)
+	again := deriveKeys(ikm, salt, specs)
+	reversed := deriveKeys(ikm, salt, []keySpec{specs[2], specs[1], specs[0]})
+	for i, key := range keys {
+		if len(key) != specs[i].length {
+			t.Fatalf("key %q has length %d, expected %d", specs[i].info, len(key), specs[i].length)
+		}
+		if !bytes.Equal(key, again[i]) {
+			t.Fatalf("key %q is not stable", specs[i].info)
+		}
+		if !bytes.Equal(key, reversed[len(keys)-1-i]) {
+			t.Fatalf("key %q depends on derivation order", specs[i].info)
+		}
+		if !bytes.Equal(key, deriveKey(ikm, salt, specs[i].info, specs[i].length)) {
+			t.Fatalf("key %q depends on the other keys derived", specs[i].info)
+		}
+		for j := range keys[:i] {
+			if bytes.Equal(key[:16], keys[j][:16]) {
+				t.Fatalf("keys %q and %q are not distinct", specs[i].info, specs[j].info)
+			}
+		}
+	}
+	if bytes.Equal(keys[0], deriveKey(ikm, nil, specs[0].info, specs[0].length)) {
+		t.Fatal("key does not depend on the salt")
+	}
+
+	kdf := hkdf.New(sha3.New512, ikm, salt, specs[0].info)
+	want := make([]byte, specs[0].length)
+	if _, err := io.ReadFull(kdf, want); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(keys[0], want) {
+		t.Fatal("deriveKeys does not match HKDF")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("derived two keys with the same info")
+		}
+	}()
+	deriveKeys(ikm, salt, []keySpec{specs[0], specs[0]})
+}
+
+// zeroReader is a broken source of randomness, which only returns zeros.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// verify that registrations and logins are refused with ErrWeakRandomness
+// when the source of randomness returns only zeros.
+func TestWeakRandomness(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+	pr, err := s.NewRegistration("pending user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(r io.Reader) { entropy = r }(entropy)
+	entropy = zeroReader{}
+	if _, err := s.NewRegistration("new user"); err != ErrWeakRandomness {
+		t.Fatal("expected ErrWeakRandomness from Server.NewRegistration, got", err)
+	}
+	if _, err := NewClient("pending user").NewRegistration(pr, "pending user", testpassword); err != ErrWeakRandomness {
+		t.Fatal("expected ErrWeakRandomness from Client.NewRegistration, got", err)
+	}
+	if _, err := c.NewSession(testpassword); err != ErrWeakRandomness {
+		t.Fatal("expected ErrWeakRandomness from Client.NewSession, got", err)
+	}
+	if _, _, err := s.NewSession(sess); err != ErrWeakRandomness {
+		t.Fatal("expected ErrWeakRandomness from Server.NewSession, got", err)
+	}
+}
+
+// verify that the scratch-reusing key exchange computes the same shared
+// secrets as keServer and keUser, for every TranscriptHash, whatever the
+// scratch was last used for.
+func TestKeyExchangeScratch(t *testing.T) {
+	sc := new(keScratch)
+	for i := 0; i < 3; i++ {
+		for _, h := range []TranscriptHash{TranscriptSHA3_256, TranscriptSHA3_512, TranscriptSHA512} {
+			ps, xs, pu, xu := randomScalar(), randomScalar(), randomScalar(), randomScalar()
+			Ps := new(ristretto.Element).ScalarBaseMult(ps)
+			Xs := new(ristretto.Element).ScalarBaseMult(xs)
+			Pu := new(ristretto.Element).ScalarBaseMult(pu)
+			Xu := new(ristretto.Element).ScalarBaseMult(xu)
+			if !bytes.Equal(keServerScratch(sc, nil, h, ps, xs, Pu, Xu), keServer(h, ps, xs, Pu, Xu)) {
+				t.Fatal("keServerScratch differs from keServer for", h)
+			}
+			xuXs := new(ristretto.Element).ScalarMult(xu, Xs)
+			if !bytes.Equal(keUserScratch(sc, nil, h, pu, xu, Ps, Xs, xuXs), keUser(h, pu, xu, Ps, Xs)) {
+				t.Fatal("keUserScratch differs from keUser for", h)
+			}
+			if sc.secret != [3 * elementSize]byte{} {
+				t.Fatal("shared secret left in the scratch")
+			}
+		}
+	}
+}
+
+// BenchmarkKeServer and BenchmarkKeServerScratch compare the allocations of
+// the server's key exchange with and without reusing a keScratch.
+func BenchmarkKeServer(b *testing.B) {
+	ps, xs := randomScalar(), randomScalar()
+	Pu := new(ristretto.Element).ScalarBaseMult(randomScalar())
+	Xu := new(ristretto.Element).ScalarBaseMult(randomScalar())
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		keServer(DefaultTranscriptHash, ps, xs, Pu, Xu)
+	}
+}
+
+func BenchmarkKeServerScratch(b *testing.B) {
+	ps, xs := randomScalar(), randomScalar()
+	Pu := new(ristretto.Element).ScalarBaseMult(randomScalar())
+	Xu := new(ristretto.Element).ScalarBaseMult(randomScalar())
+	sc := new(keScratch)
+	dst := make([]byte, 0, 64)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		keServerScratch(sc, dst[:0], DefaultTranscriptHash, ps, xs, Pu, Xu)
+	}
+}
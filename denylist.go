@@ -0,0 +1,52 @@
+package occlude
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// ErrCompromisedPassword is returned by Server.Register when the client's
+// password hash prefix is on the server's PasswordDenylist.
+var ErrCompromisedPassword = errors.New("password is known to be compromised")
+
+// PasswordDenylist checks password hash prefixes, as computed by
+// PasswordHashPrefix, against a corpus of known-compromised passwords.
+type PasswordDenylist interface {
+	// Compromised reports whether prefix should be refused.
+	Compromised(prefix string) bool
+}
+
+// PasswordHashPrefix returns the first n hex characters of the uppercase
+// SHA-1 hash of password, the k-anonymized form used by breach corpora such
+// as Have I Been Pwned.
+//
+// NOTE: the prefix reveals a small amount of information about the password
+// to the server. Shorter prefixes reveal less, but match more passwords.
+func PasswordHashPrefix(password string, n int) string {
+	sum := sha1.Sum([]byte(password))
+	encoded := strings.ToUpper(hex.EncodeToString(sum[:]))
+	if n > len(encoded) {
+		n = len(encoded)
+	}
+	return encoded[:n]
+}
+
+// WithPasswordPrefix configures the Client to include the first n characters
+// of its PasswordHashPrefix in each Registration, for servers which check it
+// against a PasswordDenylist.
+func WithPasswordPrefix(n int) ClientOption {
+	return func(c *Client) {
+		c.passwordPrefixLength = n
+	}
+}
+
+// WithPasswordDenylist configures the Server to reject, with
+// ErrCompromisedPassword, registrations whose password hash prefix is on d.
+// Registrations which do not include a prefix are also rejected.
+func WithPasswordDenylist(d PasswordDenylist) ServerOption {
+	return func(s *Server) {
+		s.denylist = d
+	}
+}
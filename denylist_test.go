@@ -0,0 +1,61 @@
+package occlude
+
+import (
+	"errors"
+	"testing"
+)
+
+// testDenylist is a PasswordDenylist backed by a set of prefixes.
+type testDenylist map[string]bool
+
+func (d testDenylist) Compromised(prefix string) bool {
+	return d[prefix]
+}
+
+// verify that PasswordHashPrefix matches the SHA-1 prefixes used by breach
+// corpora.
+func TestPasswordHashPrefix(t *testing.T) {
+	// SHA-1("password") = 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+	if prefix := PasswordHashPrefix("password", 5); prefix != "5BAA6" {
+		t.Fatal("unexpected prefix", prefix)
+	}
+	if prefix := PasswordHashPrefix("password", 100); len(prefix) != 40 {
+		t.Fatal("prefix longer than the hash", prefix)
+	}
+}
+
+// verify that a denylisted password prefix blocks registration, while other
+// passwords can still register.
+func TestPasswordDenylist(t *testing.T) {
+	testusername := "this is a test username"
+	denylist := testDenylist{PasswordHashPrefix("password", 5): true}
+
+	s := NewServer(WithPasswordDenylist(denylist))
+	c := NewClient(testusername, WithPasswordPrefix(5))
+	pr, err := s.NewRegistration(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err := c.NewRegistration(pr, testusername, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register(reg); err != ErrCompromisedPassword {
+		t.Fatal("expected ErrCompromisedPassword, got", err)
+	}
+
+	// a client which doesn't send a prefix can't register either.
+	pr, err = s.NewRegistration(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err = NewClient(testusername).NewRegistration(pr, testusername, "this is a test password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register(reg); !errors.Is(err, ErrMissingField) {
+		t.Fatal("expected ErrMissingField, got", err)
+	}
+
+	register(t, s, c, testusername, "this is a test password")
+}
@@ -0,0 +1,539 @@
+package occlude
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	ristretto "github.com/gtank/ristretto255"
+)
+
+// encodingVersion is the version of the binary encoding produced by the
+// MarshalBinary methods of the protocol messages.
+const encodingVersion = 1
+
+// messageType tags each binary-encoded message with its type, so that a
+// message of one type can never be decoded as another.
+type messageType uint8
+
+const (
+	messageUsrSession messageType = iota + 1
+	messageSvrSession
+	messageRegistration
+	messageClientVerification
+	messageEnvelope
+	messagePasswordFile
+	messageSealedPasswordFile
+	messageSnapshot
+	messageEnrollment
+	messageRuntimeState
+	messageClientHello
+	messageServerHello
+	messageClientFinish
+	messageRecoveryChallenge
+	messageRecoveryProof
+)
+
+var (
+	// ErrWrongMessageType is returned by the UnmarshalBinary methods when the
+	// data encodes a different type of message.
+	ErrWrongMessageType = errors.New("wrong message type")
+
+	// ErrMalformedMessage is returned by the UnmarshalBinary methods when the
+	// data is not a valid encoding of the message.
+	ErrMalformedMessage = errors.New("malformed message")
+)
+
+// encoder builds the binary encoding of a message. Variable-length fields are
+// prefixed with their length, and group elements and scalars are written in
+// their fixed-length canonical encodings.
+type encoder struct {
+	b []byte
+}
+
+func newEncoder(t messageType) *encoder {
+	return &encoder{b: []byte{byte(t), encodingVersion}}
+}
+
+func (e *encoder) uint8(v uint8) {
+	e.b = append(e.b, v)
+}
+
+func (e *encoder) uint32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	e.b = append(e.b, b[:]...)
+}
+
+func (e *encoder) uint64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	e.b = append(e.b, b[:]...)
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.b = appendLengthPrefixed(e.b, b)
+}
+
+func (e *encoder) string(s string) {
+	e.bytes([]byte(s))
+}
+
+func (e *encoder) element(el *ristretto.Element) {
+	e.b = el.Encode(e.b)
+}
+
+func (e *encoder) scalar(sc *ristretto.Scalar) {
+	e.b = sc.Encode(e.b)
+}
+
+// decoder parses the binary encoding of a message written by an encoder. The
+// first error encountered is retained, and all later reads return zero values.
+type decoder struct {
+	b   []byte
+	err error
+
+	// strict additionally rejects group elements which are the identity.
+	strict bool
+}
+
+func newDecoder(t messageType, data []byte) *decoder {
+	d := &decoder{b: data}
+	if len(data) < 2 {
+		d.err = ErrMalformedMessage
+		return d
+	}
+	if messageType(data[0]) != t {
+		d.err = ErrWrongMessageType
+		return d
+	}
+	if data[1] != encodingVersion {
+		d.err = fmt.Errorf("%w: unsupported encoding version %v", ErrMalformedMessage, data[1])
+		return d
+	}
+	d.b = data[2:]
+	return d
+}
+
+func (d *decoder) fail(field string) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: %s", ErrMalformedMessage, field)
+	}
+}
+
+func (d *decoder) next(field string, n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.b) < n {
+		d.fail(field)
+		return nil
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b
+}
+
+func (d *decoder) uint8(field string) uint8 {
+	b := d.next(field, 1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *decoder) uint32(field string) uint32 {
+	b := d.next(field, 4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (d *decoder) uint64(field string) uint64 {
+	b := d.next(field, 8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+func (d *decoder) bytes(field string) []byte {
+	length := d.next(field, 4)
+	if length == nil {
+		return nil
+	}
+	n := binary.BigEndian.Uint32(length)
+	if uint64(n) > uint64(len(d.b)) {
+		d.fail(field)
+		return nil
+	}
+	b := d.next(field, int(n))
+	if len(b) == 0 {
+		return nil
+	}
+	return append([]byte(nil), b...)
+}
+
+func (d *decoder) string(field string) string {
+	return string(d.bytes(field))
+}
+
+func (d *decoder) element(field string) *ristretto.Element {
+	b := d.next(field, elementSize)
+	if b == nil {
+		return nil
+	}
+	el, err := group.element(b)
+	if err != nil {
+		d.fail(field)
+		return nil
+	}
+	if d.strict {
+		if err := checkNonIdentity(field, el); err != nil {
+			d.err = err
+			return nil
+		}
+	}
+	return el
+}
+
+func (d *decoder) scalar(field string) *ristretto.Scalar {
+	b := d.next(field, scalarSize)
+	if b == nil {
+		return nil
+	}
+	sc, err := group.scalar(b)
+	if err != nil {
+		d.fail(field)
+		return nil
+	}
+	return sc
+}
+
+// finish returns the first decoding error, or ErrMalformedMessage if there is
+// trailing data after the message.
+func (d *decoder) finish() error {
+	if d.err == nil && len(d.b) != 0 {
+		d.fail("trailing data")
+	}
+	return d.err
+}
+
+// optionalElement encodes el, which may be nil, preceded by a presence flag.
+func (e *encoder) optionalElement(el *ristretto.Element) {
+	if el == nil {
+		e.uint8(0)
+		return
+	}
+	e.uint8(1)
+	e.element(el)
+}
+
+func (d *decoder) optionalElement(field string) *ristretto.Element {
+	switch d.uint8(field) {
+	case 0:
+		return nil
+	case 1:
+		return d.element(field)
+	}
+	d.fail(field)
+	return nil
+}
+
+// boolean encodes b as a single byte flag.
+func (e *encoder) boolean(b bool) {
+	if b {
+		e.uint8(1)
+	} else {
+		e.uint8(0)
+	}
+}
+
+func (d *decoder) boolean(field string) bool {
+	switch d.uint8(field) {
+	case 0:
+		return false
+	case 1:
+		return true
+	}
+	d.fail(field)
+	return false
+}
+
+func (e *encoder) authCiphertext(a authCiphertext) {
+	e.bytes(a.Tag)
+	e.bytes(a.Ciphertext)
+	e.bytes(a.Salt)
+}
+
+func (d *decoder) authCiphertext(field string) authCiphertext {
+	return authCiphertext{
+		Tag:        d.bytes(field + " tag"),
+		Ciphertext: d.bytes(field + " ciphertext"),
+		Salt:       d.bytes(field + " salt"),
+	}
+}
+
+func (e *encoder) argon2Params(p Argon2Params) {
+	e.b = append(e.b, p.encode()...)
+}
+
+func (d *decoder) argon2Params(field string) Argon2Params {
+	b := d.next(field, 9)
+	if b == nil {
+		return Argon2Params{}
+	}
+	return Argon2Params{
+		Time:    binary.BigEndian.Uint32(b[0:4]),
+		Memory:  binary.BigEndian.Uint32(b[4:8]),
+		Threads: b[8],
+	}
+}
+
+func (e *encoder) envelope(env *Envelope) {
+	e.string(env.Label)
+	e.bytes(env.Salt)
+	e.boolean(env.ExportKeyed)
+	e.authCiphertext(env.c)
+}
+
+func (d *decoder) envelope() *Envelope {
+	return &Envelope{
+		Label:       d.string("Envelope label"),
+		Salt:        d.bytes("Envelope salt"),
+		ExportKeyed: d.boolean("Envelope ExportKeyed"),
+		c:           d.authCiphertext("Envelope"),
+	}
+}
+
+// MarshalBinary encodes the UsrSession for transport.
+func (u *UsrSession) MarshalBinary() ([]byte, error) {
+	if u.Alpha == nil || u.Xu == nil {
+		return nil, ErrMissingField
+	}
+	e := newEncoder(messageUsrSession)
+	e.element(u.Alpha)
+	e.element(u.Xu)
+	e.string(u.Sid)
+	e.string(u.Envelope)
+	e.uint64(u.Sequence)
+	return e.b, nil
+}
+
+// UnmarshalBinary decodes a UsrSession encoded with MarshalBinary.
+func (u *UsrSession) UnmarshalBinary(data []byte) error {
+	return u.unmarshalBinary(data, false)
+}
+
+// UnmarshalBinaryStrict is UnmarshalBinary, additionally rejecting group
+// elements which are the identity with ErrIdentityElement.
+func (u *UsrSession) UnmarshalBinaryStrict(data []byte) error {
+	return u.unmarshalBinary(data, true)
+}
+
+func (u *UsrSession) unmarshalBinary(data []byte, strict bool) error {
+	d := newDecoder(messageUsrSession, data)
+	d.strict = strict
+	decoded := UsrSession{
+		Alpha:    d.element("Alpha"),
+		Xu:       d.element("Xu"),
+		Sid:      d.string("Sid"),
+		Envelope: d.string("Envelope"),
+		Sequence: d.uint64("Sequence"),
+	}
+	if err := d.finish(); err != nil {
+		return err
+	}
+	*u = decoded
+	return nil
+}
+
+// MarshalBinary encodes the SvrSession for transport.
+func (v *SvrSession) MarshalBinary() ([]byte, error) {
+	if v.Beta == nil || v.Xs == nil {
+		return nil, ErrMissingField
+	}
+	e := newEncoder(messageSvrSession)
+	e.uint8(uint8(v.Version))
+	e.uint8(uint8(v.TranscriptHash))
+	e.argon2Params(v.Argon2)
+	e.element(v.Beta)
+	e.element(v.Xs)
+	e.bytes(v.fk1)
+	e.authCiphertext(v.c)
+	if v.Envelope != nil {
+		e.uint8(1)
+		e.envelope(v.Envelope)
+	} else {
+		e.uint8(0)
+	}
+	e.bytes(v.Signature)
+	e.optionalElement(v.Xu)
+	if v.Argon2Upgrade != nil {
+		e.uint8(1)
+		e.argon2Params(*v.Argon2Upgrade)
+	} else {
+		e.uint8(0)
+	}
+	e.uint64(v.Sequence)
+	e.uint64(v.SessionEpoch)
+	e.optionalElement(v.OPRFKey)
+	e.bytes(v.OPRFProof)
+	return e.b, nil
+}
+
+// UnmarshalBinary decodes a SvrSession encoded with MarshalBinary.
+func (v *SvrSession) UnmarshalBinary(data []byte) error {
+	return v.unmarshalBinary(data, false)
+}
+
+// UnmarshalBinaryStrict is UnmarshalBinary, additionally rejecting group
+// elements which are the identity with ErrIdentityElement.
+func (v *SvrSession) UnmarshalBinaryStrict(data []byte) error {
+	return v.unmarshalBinary(data, true)
+}
+
+func (v *SvrSession) unmarshalBinary(data []byte, strict bool) error {
+	d := newDecoder(messageSvrSession, data)
+	d.strict = strict
+	decoded := SvrSession{
+		Version:        Version(d.uint8("Version")),
+		TranscriptHash: TranscriptHash(d.uint8("TranscriptHash")),
+		Argon2:         d.argon2Params("Argon2"),
+		Beta:           d.element("Beta"),
+		Xs:             d.element("Xs"),
+		fk1:            d.bytes("fk1"),
+		c:              d.authCiphertext("c"),
+	}
+	switch d.uint8("Envelope") {
+	case 0:
+	case 1:
+		decoded.Envelope = d.envelope()
+	default:
+		d.fail("Envelope")
+	}
+	decoded.Signature = d.bytes("Signature")
+	decoded.Xu = d.optionalElement("Xu")
+	switch d.uint8("Argon2Upgrade") {
+	case 0:
+	case 1:
+		upgrade := d.argon2Params("Argon2Upgrade")
+		decoded.Argon2Upgrade = &upgrade
+	default:
+		d.fail("Argon2Upgrade")
+	}
+	decoded.Sequence = d.uint64("Sequence")
+	decoded.SessionEpoch = d.uint64("SessionEpoch")
+	decoded.OPRFKey = d.optionalElement("OPRFKey")
+	decoded.OPRFProof = d.bytes("OPRFProof")
+	if err := d.finish(); err != nil {
+		return err
+	}
+	*v = decoded
+	return nil
+}
+
+// MarshalBinary encodes the Registration for transport.
+func (r *Registration) MarshalBinary() ([]byte, error) {
+	if r.Pu == nil {
+		return nil, ErrMissingField
+	}
+	e := newEncoder(messageRegistration)
+	e.string(r.ID)
+	e.authCiphertext(r.aci)
+	e.element(r.Pu)
+	e.argon2Params(r.Argon2)
+	e.string(r.PasswordPrefix)
+	e.string(r.IdempotencyKey)
+	e.optionalElement(r.SecondFactor)
+	e.uint8(r.StrengthScore)
+	if r.recovery != nil {
+		e.uint8(1)
+		e.recoveryEnvelope(*r.recovery)
+	} else {
+		e.uint8(0)
+	}
+	e.chunkedEnvelope(r.appData)
+	return e.b, nil
+}
+
+// UnmarshalBinary decodes a Registration encoded with MarshalBinary.
+func (r *Registration) UnmarshalBinary(data []byte) error {
+	return r.unmarshalBinary(data, false)
+}
+
+// UnmarshalBinaryStrict is UnmarshalBinary, additionally rejecting group
+// elements which are the identity with ErrIdentityElement.
+func (r *Registration) UnmarshalBinaryStrict(data []byte) error {
+	return r.unmarshalBinary(data, true)
+}
+
+func (r *Registration) unmarshalBinary(data []byte, strict bool) error {
+	d := newDecoder(messageRegistration, data)
+	d.strict = strict
+	decoded := Registration{
+		ID:             d.string("ID"),
+		aci:            d.authCiphertext("aci"),
+		Pu:             d.element("Pu"),
+		Argon2:         d.argon2Params("Argon2"),
+		PasswordPrefix: d.string("PasswordPrefix"),
+		IdempotencyKey: d.string("IdempotencyKey"),
+		SecondFactor:   d.optionalElement("SecondFactor"),
+		StrengthScore:  d.uint8("StrengthScore"),
+	}
+	switch d.uint8("recovery") {
+	case 0:
+	case 1:
+		recovery := d.recoveryEnvelope()
+		decoded.recovery = &recovery
+	default:
+		d.fail("recovery")
+	}
+	decoded.appData = d.chunkedEnvelope()
+	if err := d.finish(); err != nil {
+		return err
+	}
+	*r = decoded
+	return nil
+}
+
+// MarshalBinary encodes the ClientVerification for transport.
+func (cv *ClientVerification) MarshalBinary() ([]byte, error) {
+	e := newEncoder(messageClientVerification)
+	e.string(cv.ID)
+	e.bytes(cv.FK2)
+	return e.b, nil
+}
+
+// UnmarshalBinary decodes a ClientVerification encoded with MarshalBinary.
+func (cv *ClientVerification) UnmarshalBinary(data []byte) error {
+	d := newDecoder(messageClientVerification, data)
+	decoded := ClientVerification{
+		ID:  d.string("ID"),
+		FK2: d.bytes("FK2"),
+	}
+	if err := d.finish(); err != nil {
+		return err
+	}
+	*cv = decoded
+	return nil
+}
+
+// MarshalBinary encodes the Envelope for transport.
+func (env *Envelope) MarshalBinary() ([]byte, error) {
+	e := newEncoder(messageEnvelope)
+	e.envelope(env)
+	return e.b, nil
+}
+
+// UnmarshalBinary decodes an Envelope encoded with MarshalBinary.
+func (env *Envelope) UnmarshalBinary(data []byte) error {
+	d := newDecoder(messageEnvelope, data)
+	decoded := d.envelope()
+	if err := d.finish(); err != nil {
+		return err
+	}
+	*env = *decoded
+	return nil
+}
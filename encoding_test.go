@@ -0,0 +1,202 @@
+package occlude
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"strings"
+	"testing"
+
+	ristretto "github.com/gtank/ristretto255"
+)
+
+// roundTrip encodes m and decodes the result into out.
+func roundTrip(t *testing.T, m encoding.BinaryMarshaler, out encoding.BinaryUnmarshaler) {
+	t.Helper()
+	data, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := out.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// verify that a full registration and login succeeds with every message sent
+// through its binary encoding.
+func TestBinaryEncodingHandshake(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer()
+	c := NewClient(testusername)
+	pr, err := s.NewRegistration(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err := c.NewRegistration(pr, testusername, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg.IdempotencyKey = "registration attempt"
+	var decodedReg Registration
+	roundTrip(t, reg, &decodedReg)
+	if decodedReg.IdempotencyKey != reg.IdempotencyKey {
+		t.Fatal("IdempotencyKey did not survive encoding")
+	}
+	if err := s.Register(&decodedReg); err != nil {
+		t.Fatal(err)
+	}
+
+	cv := login(t, s, c, testpassword, "")
+	env, err := c.SealEnvelope("laptop", []byte("laptop device key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decodedEnv Envelope
+	roundTrip(t, env, &decodedEnv)
+	if err := s.AddEnvelope(cv, &decodedEnv); err != nil {
+		t.Fatal(err)
+	}
+
+	sess, err := c.NewSessionWithEnvelope(testpassword, "laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decodedSess UsrSession
+	roundTrip(t, sess, &decodedSess)
+	svrsess, sessionKey, err := s.NewSession(&decodedSess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decodedSvrsess SvrSession
+	roundTrip(t, svrsess, &decodedSvrsess)
+	clientSessionKey, fk2, err := c.SessionKey(&decodedSvrsess, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sessionKey, clientSessionKey) {
+		t.Fatal("client and server did not compute identical session key")
+	}
+	if !bytes.Equal(c.EnvelopeData(), []byte("laptop device key")) {
+		t.Fatal("envelope did not survive encoding")
+	}
+
+	var decodedCV ClientVerification
+	roundTrip(t, &ClientVerification{ID: testusername, FK2: fk2}, &decodedCV)
+	if _, err := s.FinishSession(&decodedCV); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// verify that a message of one type can't be decoded as another, and that
+// malformed encodings are rejected.
+func TestBinaryEncodingWrongType(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer()
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err := s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrData, err := svrsess.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	usrData, err := sess.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := new(UsrSession).UnmarshalBinary(svrData); err != ErrWrongMessageType {
+		t.Fatal("expected ErrWrongMessageType, got", err)
+	}
+	if err := new(SvrSession).UnmarshalBinary(usrData); err != ErrWrongMessageType {
+		t.Fatal("expected ErrWrongMessageType, got", err)
+	}
+	if err := new(Registration).UnmarshalBinary(usrData); err != ErrWrongMessageType {
+		t.Fatal("expected ErrWrongMessageType, got", err)
+	}
+	if err := new(ClientVerification).UnmarshalBinary(svrData); err != ErrWrongMessageType {
+		t.Fatal("expected ErrWrongMessageType, got", err)
+	}
+
+	for _, malformed := range [][]byte{
+		nil,
+		usrData[:len(usrData)-1],
+		append(append([]byte(nil), usrData...), 0),
+		append([]byte{usrData[0], encodingVersion + 1}, usrData[2:]...),
+	} {
+		if err := new(UsrSession).UnmarshalBinary(malformed); !errors.Is(err, ErrMalformedMessage) {
+			t.Fatal("expected ErrMalformedMessage, got", err)
+		}
+	}
+
+	// an invalid encoding of either element is rejected rather than
+	// decoded, with an error naming it.
+	for i, field := range []string{"Alpha", "Xu"} {
+		badElement := append([]byte(nil), usrData...)
+		for j := 2 + i*elementSize; j < 2+(i+1)*elementSize; j++ {
+			badElement[j] = 0xff
+		}
+		err := new(UsrSession).UnmarshalBinary(badElement)
+		if !errors.Is(err, ErrMalformedMessage) || !strings.Contains(err.Error(), field) {
+			t.Fatalf("expected ErrMalformedMessage for %s, got %v", field, err)
+		}
+	}
+}
+
+// identityMessages returns a UsrSession, SvrSession and Registration whose
+// group elements are the identity.
+func identityMessages() (*UsrSession, *SvrSession, *Registration) {
+	identity := new(ristretto.Element).Zero()
+	return &UsrSession{Alpha: identity, Xu: identity, Sid: "this is a test username"},
+		&SvrSession{Version: Version1, Beta: identity, Xs: identity},
+		&Registration{ID: "this is a test username", Pu: identity}
+}
+
+// verify that lenient decoding accepts identity elements, while strict
+// decoding rejects them with ErrIdentityElement.
+func TestStrictDecoding(t *testing.T) {
+	usr, svr, reg := identityMessages()
+	for _, m := range []struct {
+		msg     encoding.BinaryMarshaler
+		lenient encoding.BinaryUnmarshaler
+		strict  func([]byte) error
+	}{
+		{usr, new(UsrSession), new(UsrSession).UnmarshalBinaryStrict},
+		{svr, new(SvrSession), new(SvrSession).UnmarshalBinaryStrict},
+		{reg, new(Registration), new(Registration).UnmarshalBinaryStrict},
+	} {
+		data, err := m.msg.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.lenient.UnmarshalBinary(data); err != nil {
+			t.Fatal("lenient decoding rejected the identity:", err)
+		}
+		if err := m.strict(data); !errors.Is(err, ErrIdentityElement) {
+			t.Fatal("expected ErrIdentityElement, got", err)
+		}
+	}
+
+	// strict decoding accepts legitimate messages.
+	sess, err := NewClient("this is a test username").NewSession("this is a test password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := sess.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := new(UsrSession).UnmarshalBinaryStrict(data); err != nil {
+		t.Fatal(err)
+	}
+}
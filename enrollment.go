@@ -0,0 +1,49 @@
+package occlude
+
+// EnrollmentBlob encodes the public parameters a client needs to register and
+// log in to the Server, its public key, deployment context and active Scheme,
+// as a compact, versioned blob suitable for transfer in a QR code when
+// provisioning a device. The blob holds no secrets, but it is also not
+// authenticated: it must be transferred over a channel the device trusts, as
+// it decides which server the device trusts.
+func (s *Server) EnrollmentBlob() ([]byte, error) {
+	scheme := s.ActiveScheme()
+	e := newEncoder(messageEnrollment)
+	e.element(s.identity.pub)
+	e.bytes(s.context)
+	e.uint8(uint8(scheme.Version))
+	e.uint8(uint8(scheme.TranscriptHash))
+	e.argon2Params(scheme.Argon2)
+	return e.b, nil
+}
+
+// ConfigureFromBlob configures the Client from a blob produced by
+// Server.EnrollmentBlob, as with WithServerKey, WithClientContext and
+// WithPinnedScheme. It must be called before the Client is used, and
+// replaces any of those options it was constructed with.
+//
+// NOTE: the NOTE on WithPinnedScheme applies: after the server's active
+// Scheme changes, devices must be provisioned with a new blob.
+func (c *Client) ConfigureFromBlob(blob []byte) error {
+	d := newDecoder(messageEnrollment, blob)
+	pub := d.element("server key")
+	ctx := d.bytes("context")
+	scheme := Scheme{
+		Version:        Version(d.uint8("Version")),
+		TranscriptHash: TranscriptHash(d.uint8("TranscriptHash")),
+		Argon2:         d.argon2Params("Argon2"),
+	}
+	if err := d.finish(); err != nil {
+		return err
+	}
+	if err := checkNonIdentity("server key", pub); err != nil {
+		return err
+	}
+	if !scheme.supported() {
+		return ErrUnsupportedScheme
+	}
+	WithServerKey(pub)(c)
+	WithClientContext(ctx)(c)
+	WithPinnedScheme(scheme)(c)
+	return nil
+}
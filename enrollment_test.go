@@ -0,0 +1,48 @@
+package occlude
+
+import (
+	"testing"
+)
+
+// verify that a Client configured from a Server's EnrollmentBlob can register
+// and log in, and is pinned to that Server.
+func TestEnrollmentBlob(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer(WithArgon2Params(weakArgon2Params), WithContext([]byte("test app")))
+	blob, err := s.EnrollmentBlob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewClient(testusername)
+	if err := c.ConfigureFromBlob(blob); err != nil {
+		t.Fatal(err)
+	}
+	register(t, s, c, testusername, testpassword)
+	if _, err := s.FinishSession(login(t, s, c, testpassword, "")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CheckHello(s.Hello()); err != nil {
+		t.Fatal(err)
+	}
+
+	// a server with another identity is rejected by the configured Client.
+	other := NewServer(WithArgon2Params(weakArgon2Params), WithContext([]byte("test app")))
+	register(t, other, NewClient(testusername), testusername, testpassword)
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err := other.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.SessionKey(svrsess, testpassword); err == nil {
+		t.Fatal("logged in to a server other than the enrolled one")
+	}
+
+	if err := NewClient(testusername).ConfigureFromBlob(blob[:len(blob)-1]); err == nil {
+		t.Fatal("configured from a truncated blob")
+	}
+}
@@ -0,0 +1,123 @@
+package occlude
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"sort"
+
+	ristretto "github.com/gtank/ristretto255"
+)
+
+// enumerationKeySize is the length of the secret dummy password files are
+// derived from, in bytes.
+const enumerationKeySize = 32
+
+const (
+	// dummySequences bounds the login sequence number of a dummy password
+	// file, and dummySessionEpochs its session epoch, so that both are
+	// plausible for a registered user.
+	dummySequences     = 1 << 10
+	dummySessionEpochs = 4
+)
+
+// WithUserEnumerationProtection configures the Server to answer a login
+// request for an unknown user with a SvrSession computed from a dummy
+// password file, rather than failing with an error. The dummy file is derived
+// deterministically from the user id and a secret generated by the Server, so
+// that repeated requests for the same unknown user receive consistent
+// responses, and it is derived for every request, whether or not the user
+// exists, so that both cases do the same work. The login then fails at the
+// client, exactly as it would with an incorrect password. The dummy file is
+// bound to one of the Schemes registered users' files are bound to, chosen in
+// proportion to their number, and has a login sequence number and session
+// epoch of its own, so that the SvrSession for an unknown user is
+// indistinguishable from one for a registered user. On a Server configured
+// WithMasterSecret, the dummy file's keys are derived from the master secret
+// at login, like those of a new registration, so that the login does the
+// same derivations.
+//
+// NOTE: a request for a named Envelope still reveals whether the user has an
+// Envelope with that label, and legacy users imported with ImportLegacyUser
+// are still reported with ErrLegacyUser. The sequence number of a registered
+// user advances with each login, while that of an unknown user never does,
+// and the Scheme chosen for an unknown user may change as users register.
+func WithUserEnumerationProtection() ServerOption {
+	return func(s *Server) {
+		s.enumerationKey = make([]byte, enumerationKeySize)
+		if _, err := rand.Read(s.enumerationKey); err != nil {
+			panic("could not get entropy")
+		}
+	}
+}
+
+// dummyPasswordFile derives the dummy password file for the user id. The
+// caller must hold s.mu.
+func (s *Server) dummyPasswordFile(id string) pwdFile {
+	info := func(field string) []byte {
+		return append([]byte("occlude dummy password file "+field+" "), id...)
+	}
+	m := deriveKeys(s.enumerationKey, nil, []keySpec{
+		{info("ks"), 64},
+		{info("ps"), 64},
+		{info("pu"), 64},
+		{info("tag"), macSize},
+		{info("ciphertext"), credentialsSize},
+		{info("salt"), envelopeSaltSize},
+		{info("scheme"), 8},
+		{info("sequence"), 8},
+		{info("session epoch"), 8},
+		{info("key nonce"), keyNonceSize},
+	})
+	ps := new(ristretto.Scalar).FromUniformBytes(m[1])
+	pu := new(ristretto.Scalar).FromUniformBytes(m[2])
+	pf := pwdFile{
+		ks: new(ristretto.Scalar).FromUniformBytes(m[0]),
+		ps: ps,
+		Ps: new(ristretto.Element).ScalarBaseMult(ps),
+		Pu: new(ristretto.Element).ScalarBaseMult(pu),
+		c: authCiphertext{
+			Tag:        m[3],
+			Ciphertext: m[4],
+			Salt:       m[5],
+		},
+		scheme:       s.dummyScheme(binary.BigEndian.Uint64(m[6])),
+		pepperEpoch:  s.pepperEpoch,
+		sequence:     binary.BigEndian.Uint64(m[7]) % dummySequences,
+		sessionEpoch: binary.BigEndian.Uint64(m[8]) % dummySessionEpochs,
+	}
+	if s.masterSecret != nil {
+		// As for a new registration, the keys are derived from the master
+		// secret at login, and are not peppered.
+		pf.ks, pf.ps, pf.keyNonce, pf.pepperEpoch = nil, nil, m[9], 0
+	}
+	return pf
+}
+
+// dummyScheme chooses the Scheme of a dummy password file with v from the
+// Schemes registered users' files are bound to, each in proportion to the
+// number of files bound to it, or returns the active Scheme if there are no
+// registered users. The caller must hold s.mu.
+func (s *Server) dummyScheme(v uint64) Scheme {
+	schemes := make([]Scheme, 0, len(s.schemes))
+	total := 0
+	for scheme, n := range s.schemes {
+		schemes = append(schemes, scheme)
+		total += n
+	}
+	if total == 0 {
+		return s.scheme
+	}
+	// The Schemes are sorted, so that the choice for each v is stable.
+	sort.Slice(schemes, func(i, j int) bool {
+		return bytes.Compare(schemes[i].encode(), schemes[j].encode()) < 0
+	})
+	v %= uint64(total)
+	for _, scheme := range schemes {
+		if v < uint64(s.schemes[scheme]) {
+			return scheme
+		}
+		v -= uint64(s.schemes[scheme])
+	}
+	return s.scheme
+}
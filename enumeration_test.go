@@ -0,0 +1,186 @@
+package occlude
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	ristretto "github.com/gtank/ristretto255"
+)
+
+// verify that with user enumeration protection, a login for an unknown user
+// receives a consistent response and fails at the client like an incorrect
+// password.
+func TestUserEnumerationProtection(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer(WithArgon2Params(weakArgon2Params), WithUserEnumerationProtection())
+	register(t, s, NewClient(testusername), testusername, testpassword)
+
+	c := NewClient("unknown user")
+	var responses []*SvrSession
+	for i := 0; i < 2; i++ {
+		sess, err := c.NewSession(testpassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		svrsess, _, err := s.NewSession(sess)
+		if err != nil {
+			t.Fatal("login for an unknown user failed:", err)
+		}
+		if _, _, err := c.SessionKey(svrsess, testpassword); !errors.Is(err, ErrAuthenticationFailed) {
+			t.Fatal("expected ErrAuthenticationFailed, got", err)
+		}
+		responses = append(responses, svrsess)
+	}
+	if !bytes.Equal(responses[0].c.Ciphertext, responses[1].c.Ciphertext) {
+		t.Fatal("responses for the same unknown user differ")
+	}
+	if len(responses[0].c.Ciphertext) != len(s.passwordFiles[testusername].c.Ciphertext) {
+		t.Fatal("dummy envelope has a different length from a real one")
+	}
+
+	sess, err := NewClient("another unknown user").NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(other.c.Ciphertext, responses[0].c.Ciphertext) {
+		t.Fatal("different unknown users received the same response")
+	}
+	if _, err := s.FinishSession(&ClientVerification{ID: "unknown user", FK2: make([]byte, prfSize)}); err == nil {
+		t.Fatal("finished a login for an unknown user")
+	}
+}
+
+// compare the time NewSession takes for an existing and an unknown user with
+// user enumeration protection, on a Server with random keys and on one which
+// derives them from a master secret.
+func TestUserEnumerationTiming(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	masterSecret := bytes.Repeat([]byte{0x42}, minMasterSecretSize)
+	for name, opts := range map[string][]ServerOption{
+		"random keys":   nil,
+		"master secret": {WithMasterSecret(masterSecret)},
+	} {
+		s := NewServer(append([]ServerOption{WithArgon2Params(weakArgon2Params), WithUserEnumerationProtection()}, opts...)...)
+		register(t, s, NewClient(testusername), testusername, testpassword)
+		existing, err := NewClient(testusername).NewSession(testpassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unknown, err := NewClient("unknown user").NewSession(testpassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		newSession := func(sess *UsrSession) func() {
+			return func() {
+				// Each login has a fresh Xu, so that it is not answered as a
+				// retry of the last, and does the whole of the work.
+				fresh := *sess
+				fresh.Xu = new(ristretto.Element).ScalarBaseMult(randomScalar())
+				if _, _, err := s.NewSession(&fresh); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		t.Log(name, "existing vs unknown user", timingAnalysis(newSession(existing), newSession(unknown), 200))
+	}
+}
+
+// verify that on a Server configured WithMasterSecret, the dummy password file
+// of an unknown user has its keys derived from the master secret at login, like
+// a registered user's, and the login fails at the client.
+func TestUserEnumerationMasterSecret(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer(WithArgon2Params(weakArgon2Params), WithUserEnumerationProtection(),
+		WithMasterSecret(bytes.Repeat([]byte{0x42}, minMasterSecretSize)),
+		WithPeppers(1, map[uint32][]byte{1: []byte("this is a test pepper")}))
+	register(t, s, NewClient(testusername), testusername, testpassword)
+
+	s.mu.Lock()
+	dummy := s.dummyPasswordFile("unknown user")
+	s.mu.Unlock()
+	registered := s.passwordFiles[testusername]
+	if dummy.keyNonce == nil || dummy.ks != nil || dummy.pepperEpoch != registered.pepperEpoch {
+		t.Fatal("dummy password file is not keyed like a registered user's")
+	}
+
+	c := NewClient("unknown user")
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err := s.NewSession(sess)
+	if err != nil {
+		t.Fatal("login for an unknown user failed:", err)
+	}
+	if _, _, err := c.SessionKey(svrsess, testpassword); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatal("expected ErrAuthenticationFailed, got", err)
+	}
+}
+
+// verify that with user enumeration protection, the SvrSession for an unknown
+// user is bound to the Scheme of the registered users, rather than the active
+// Scheme, and carries a plausible sequence number and session epoch.
+func TestUserEnumerationResponseFields(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer(WithArgon2Params(weakArgon2Params), WithUserEnumerationProtection(),
+		WithLoginSequence(), WithSessionEpochs(), WithArgon2UpgradeOnLogin())
+	register(t, s, NewClient(testusername), testusername, testpassword)
+	s.SetActiveScheme(Scheme{
+		Version:        DefaultVersion,
+		TranscriptHash: DefaultTranscriptHash,
+		Argon2:         Argon2Params{Time: 2, Memory: 64, Threads: 1},
+	})
+
+	respond := func(c *Client) *SvrSession {
+		sess, err := c.NewSession(testpassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		svrsess, _, err := s.NewSession(sess)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return svrsess
+	}
+	existing := respond(NewClient(testusername))
+	s.mu.Lock()
+	dummy := s.dummyPasswordFile("unknown user")
+	s.mu.Unlock()
+	unknown := respond(NewClient("unknown user", WithLastSequence(dummy.sequence)))
+	if unknown.Version != existing.Version || unknown.TranscriptHash != existing.TranscriptHash || unknown.Argon2 != existing.Argon2 {
+		t.Fatal("unknown user's response is bound to a different Scheme")
+	}
+	if (unknown.Argon2Upgrade == nil) != (existing.Argon2Upgrade == nil) {
+		t.Fatal("only one of the responses asks for an Argon2 upgrade")
+	}
+	if unknown.Sequence != dummy.sequence+1 || unknown.SessionEpoch != dummy.sessionEpoch {
+		t.Fatal("unknown user's response does not carry the dummy file's sequence number and epoch")
+	}
+
+	sequences := make(map[uint64]bool)
+	epochs := make(map[uint64]bool)
+	s.mu.Lock()
+	for i := 0; i < 32; i++ {
+		pf := s.dummyPasswordFile(fmt.Sprint("unknown user ", i))
+		sequences[pf.sequence] = true
+		epochs[pf.sessionEpoch] = true
+	}
+	s.mu.Unlock()
+	if len(sequences) == 1 || len(epochs) == 1 {
+		t.Fatal("every unknown user has the same sequence number or session epoch")
+	}
+}
@@ -0,0 +1,207 @@
+package occlude
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+)
+
+// envelopeSaltSize is the length of the random salt each Envelope is sealed
+// with, in bytes.
+const envelopeSaltSize = 32
+
+// Envelope is a named piece of application data, such as a wrapped per-device
+// key, sealed by the client under its password-derived key `rw`. A user may
+// have many Envelopes, all sealed under the same `rw`, and the server returns
+// the one named by UsrSession.Envelope at login. The server can store but
+// never open an Envelope.
+//
+// An Envelope with ExportKeyed set is sealed with SealWithExportKey instead,
+// under a key derived from the export key alone.
+type Envelope struct {
+	Label       string
+	Salt        []byte
+	ExportKeyed bool
+	c           authCiphertext
+}
+
+// SealEnvelope seals data into an Envelope named label, under the `rw`
+// derived by the Client's most recent successful NewRegistration or
+// SessionKey. Every Envelope is sealed with a fresh random salt, so an
+// Envelope may be replaced without reusing a key.
+func (c *Client) SealEnvelope(label string, data []byte) (*Envelope, error) {
+	c.mu.Lock()
+	rw := c.rw
+	c.mu.Unlock()
+	if rw == nil {
+		return nil, errors.New("no password-derived key, log in first")
+	}
+
+	salt := make([]byte, envelopeSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	sealed, err := sealEnvelope(envelopeKey(rw, label, salt), nil, data)
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{Label: label, Salt: salt, c: sealed}, nil
+}
+
+// SealWithExportKey seals data into an Envelope named label under a key
+// derived only from exportKey, as returned by Client.ExportKey. Unlike
+// SealEnvelope, the key does not depend on `rw`, so the Client never derives
+// it, or holds the data, during a registration or login: SessionKey delivers
+// the Envelope still sealed, from SealedEnvelope, and only a holder of the
+// export key can open it with OpenWithExportKey. The export key is never sent
+// to the server.
+func SealWithExportKey(exportKey []byte, label string, data []byte) (*Envelope, error) {
+	if len(exportKey) == 0 {
+		return nil, errors.New("empty export key")
+	}
+	salt := make([]byte, envelopeSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	sealed, err := sealEnvelope(exportEnvelopeKey(exportKey, label, salt), nil, data)
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{Label: label, Salt: salt, ExportKeyed: true, c: sealed}, nil
+}
+
+// OpenWithExportKey opens an Envelope sealed with SealWithExportKey.
+func OpenWithExportKey(exportKey []byte, env *Envelope) ([]byte, error) {
+	if env == nil {
+		return nil, ErrNilMessage
+	}
+	if !env.ExportKeyed {
+		return nil, errors.New("envelope is not sealed with the export key")
+	}
+	return openEnvelope(exportEnvelopeKey(exportKey, env.Label, env.Salt), nil, env.c)
+}
+
+// SealedEnvelope returns the Envelope returned by the server for the Client's
+// most recent successful SessionKey, if it was sealed with SealWithExportKey,
+// or nil otherwise.
+func (c *Client) SealedEnvelope() *Envelope {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sealedEnvelope
+}
+
+// EnvelopeData returns the data in the Envelope returned by the server for
+// the Client's most recent successful SessionKey, or nil if there was none.
+func (c *Client) EnvelopeData() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]byte(nil), c.envelopeData...)
+}
+
+// openLabeledEnvelope opens the Envelope env under `rw`.
+func openLabeledEnvelope(rw []byte, env *Envelope) ([]byte, error) {
+	return openEnvelope(envelopeKey(rw, env.Label, env.Salt), nil, env.c)
+}
+
+// envelopeKey derives the key an Envelope named label is sealed under from
+// `rw` and the Envelope's salt.
+func envelopeKey(rw []byte, label string, salt []byte) []byte {
+	return deriveKey(rw, salt, append([]byte("occlude envelope "), label...), 32)
+}
+
+// exportEnvelopeKey derives the key an Envelope named label is sealed under by
+// SealWithExportKey from the export key and the Envelope's salt.
+func exportEnvelopeKey(exportKey []byte, label string, salt []byte) []byte {
+	return deriveKey(exportKey, salt, append([]byte("occlude export envelope "), label...), 32)
+}
+
+// AddEnvelope stores env for the user identified by cv, replacing any
+// Envelope with the same label. cv must be the ClientVerification for a login
+// which has been started with NewSession but not yet finished, so AddEnvelope
+// must be called before FinishSession.
+func (s *Server) AddEnvelope(cv *ClientVerification, env *Envelope) error {
+	done, err := s.permitMutation()
+	if err != nil {
+		return err
+	}
+	defer done()
+	if cv == nil || env == nil {
+		return ErrNilMessage
+	}
+	if err := env.validate(); err != nil {
+		return err
+	}
+	id := s.userID(cv.ID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.authenticate(cv); err != nil {
+		return err
+	}
+	pf := s.passwordFiles[id]
+	pf.envelopes = copyEnvelopes(pf.envelopes)
+	pf.envelopes[env.Label] = *env
+	s.setPasswordFile(id, pf)
+	return nil
+}
+
+// RemoveEnvelope removes the Envelope named label for the user identified by
+// cv. As with AddEnvelope, it must be called before FinishSession.
+func (s *Server) RemoveEnvelope(cv *ClientVerification, label string) error {
+	done, err := s.permitMutation()
+	if err != nil {
+		return err
+	}
+	defer done()
+	if cv == nil {
+		return ErrNilMessage
+	}
+	id := s.userID(cv.ID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.authenticate(cv); err != nil {
+		return err
+	}
+	pf := s.passwordFiles[id]
+	if _, exists := pf.envelopes[label]; !exists {
+		return errors.New("no such envelope")
+	}
+	pf.envelopes = copyEnvelopes(pf.envelopes)
+	delete(pf.envelopes, label)
+	s.setPasswordFile(id, pf)
+	return nil
+}
+
+// copyEnvelopes returns a copy of envelopes, so that a password file's
+// Envelopes can be changed without modifying the stored file.
+func copyEnvelopes(envelopes map[string]Envelope) map[string]Envelope {
+	c := make(map[string]Envelope, len(envelopes)+1)
+	for label, env := range envelopes {
+		c[label] = env
+	}
+	return c
+}
+
+// validate checks the lengths of the Envelope's salt and sealed data.
+func (env *Envelope) validate() error {
+	if err := validateLength("Envelope Salt", env.Salt, envelopeSaltSize, envelopeSaltSize); err != nil {
+		return err
+	}
+	return env.c.validate()
+}
+
+// authenticate checks cv against the login in progress for its user, without
+// finishing the session. The caller must hold s.mu.
+func (s *Server) authenticate(cv *ClientVerification) error {
+	id := s.userID(cv.ID)
+	sess, exists := s.sessions[id]
+	if !exists || s.sessionExpired(sess, s.now()) {
+		return errors.New("no session in progress")
+	}
+	if subtle.ConstantTimeCompare(sess.fk2, cv.FK2) != 1 {
+		return errors.New("client verification failed")
+	}
+	if _, exists := s.passwordFiles[id]; !exists {
+		return errors.New("no such sid")
+	}
+	return nil
+}
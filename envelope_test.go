@@ -0,0 +1,207 @@
+package occlude
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// verify that two labeled envelopes can be stored and each retrieved at login,
+// and that envelopes can be removed.
+func TestEnvelopes(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+	laptopData := []byte("laptop device key")
+	phoneData := []byte("phone device key")
+
+	s := NewServer()
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+
+	cv := login(t, s, c, testpassword, "")
+	for label, data := range map[string][]byte{"laptop": laptopData, "phone": phoneData} {
+		env, err := c.SealEnvelope(label, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.AddEnvelope(cv, env); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.FinishSession(cv); err != nil {
+		t.Fatal(err)
+	}
+
+	// envelopes can only be changed by an authenticated session.
+	env, err := c.SealEnvelope("laptop", []byte("attacker data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddEnvelope(cv, env); err == nil {
+		t.Fatal("AddEnvelope succeeded without a session")
+	}
+
+	// a fresh client, e.g. on the device, can retrieve each envelope.
+	device := NewClient(testusername)
+	login(t, s, device, testpassword, "laptop")
+	if !bytes.Equal(device.EnvelopeData(), laptopData) {
+		t.Fatal("retrieved wrong laptop envelope", device.EnvelopeData())
+	}
+	cv = login(t, s, device, testpassword, "phone")
+	if !bytes.Equal(device.EnvelopeData(), phoneData) {
+		t.Fatal("retrieved wrong phone envelope", device.EnvelopeData())
+	}
+
+	if err := s.RemoveEnvelope(cv, "laptop"); err != nil {
+		t.Fatal(err)
+	}
+	login(t, s, device, testpassword, "laptop")
+	if device.EnvelopeData() != nil {
+		t.Fatal("retrieved a removed envelope")
+	}
+}
+
+// verify that an Envelope sealed with the export key is delivered still sealed
+// by a login, and can be opened only with the export key.
+func TestExportKeyedEnvelope(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+	appData := []byte("application secret")
+
+	s := NewServer()
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+
+	cv := login(t, s, c, testpassword, "")
+	exportKey := c.ExportKey()
+	env, err := SealWithExportKey(exportKey, "vault", appData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddEnvelope(cv, env); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.FinishSession(cv); err != nil {
+		t.Fatal(err)
+	}
+
+	device := NewClient(testusername)
+	login(t, s, device, testpassword, "vault")
+	if device.EnvelopeData() != nil {
+		t.Fatal("login opened an export-keyed envelope")
+	}
+	sealed := device.SealedEnvelope()
+	if sealed == nil {
+		t.Fatal("login did not deliver the export-keyed envelope")
+	}
+	if bytes.Contains(sealed.c.Ciphertext, appData) {
+		t.Fatal("envelope holds the plaintext")
+	}
+	if _, err := openLabeledEnvelope(device.rw, sealed); err == nil {
+		t.Fatal("envelope opened under rw")
+	}
+	if _, err := OpenWithExportKey(make([]byte, len(exportKey)), sealed); err == nil {
+		t.Fatal("envelope opened under the wrong export key")
+	}
+	data, err := OpenWithExportKey(device.ExportKey(), sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, appData) {
+		t.Fatal("export-keyed envelope did not round trip", data)
+	}
+}
+
+// verify that the envelope keys derived during one user's login can not open
+// another user's envelope, even for the same password, nor a re-registration
+// of the same user, nor that user's labeled Envelopes.
+func TestLeakedEnvelopeKeys(t *testing.T) {
+	testpassword := "this is a test password"
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	alice, bob := NewClient("alice"), NewClient("bob")
+	register(t, s, alice, "alice", testpassword)
+	register(t, s, bob, "bob", testpassword)
+	cv := login(t, s, alice, testpassword, "")
+	env, err := alice.SealEnvelope("laptop", []byte("laptop device key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddEnvelope(cv, env); err != nil {
+		t.Fatal(err)
+	}
+
+	// the leaked rw of alice's login opens her envelope...
+	leaked := alice.rw
+	ad := passwordFileAD(weakArgon2Params)
+	if _, err := openEnvelope(leaked, ad, s.passwordFiles["alice"].c); err != nil {
+		t.Fatal(err)
+	}
+	// ...but not bob's, nor her labeled Envelope without its salt.
+	if _, err := openEnvelope(leaked, ad, s.passwordFiles["bob"].c); err == nil {
+		t.Fatal("leaked keys opened another user's envelope")
+	}
+	if _, err := openEnvelope(leaked, nil, env.c); err == nil {
+		t.Fatal("leaked keys opened a labeled Envelope")
+	}
+
+	// a re-registration with the same password is sealed under new keys.
+	if err := s.Deregister("alice"); err != nil {
+		t.Fatal(err)
+	}
+	register(t, s, NewClient("alice"), "alice", testpassword)
+	if _, err := openEnvelope(leaked, ad, s.passwordFiles["alice"].c); err == nil {
+		t.Fatal("leaked keys opened the envelope of a re-registration")
+	}
+}
+
+// verify that AddEnvelope and RemoveEnvelope reject nil and malformed
+// messages, and that RemoveEnvelope replaces the stored password file rather
+// than modifying it.
+func TestEnvelopeMalformed(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer()
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+
+	cv := login(t, s, c, testpassword, "")
+	env, err := c.SealEnvelope("laptop", []byte("laptop device key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddEnvelope(nil, env); err != ErrNilMessage {
+		t.Fatal("expected ErrNilMessage, got", err)
+	}
+	if err := s.AddEnvelope(cv, nil); err != ErrNilMessage {
+		t.Fatal("expected ErrNilMessage, got", err)
+	}
+	if err := s.RemoveEnvelope(nil, "laptop"); err != ErrNilMessage {
+		t.Fatal("expected ErrNilMessage, got", err)
+	}
+	short := *env
+	short.Salt = short.Salt[1:]
+	if err := s.AddEnvelope(cv, &short); !errors.Is(err, ErrInvalidLength) {
+		t.Fatal("expected ErrInvalidLength for a short salt, got", err)
+	}
+	short = *env
+	short.c.Tag = short.c.Tag[1:]
+	if err := s.AddEnvelope(cv, &short); !errors.Is(err, ErrInvalidLength) {
+		t.Fatal("expected ErrInvalidLength for a short tag, got", err)
+	}
+
+	if err := s.AddEnvelope(cv, env); err != nil {
+		t.Fatal(err)
+	}
+	before := s.passwordFiles[testusername]
+	if err := s.RemoveEnvelope(cv, "laptop"); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := before.envelopes["laptop"]; !exists {
+		t.Fatal("RemoveEnvelope modified the stored password file in place")
+	}
+	if _, exists := s.passwordFiles[testusername].envelopes["laptop"]; exists {
+		t.Fatal("RemoveEnvelope did not remove the envelope")
+	}
+}
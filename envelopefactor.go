@@ -0,0 +1,44 @@
+package occlude
+
+import (
+	"golang.org/x/crypto/sha3"
+)
+
+// WithEnvelopeFactor configures the Client to split the user's envelope
+// across their password and a second factor with the high-entropy secret,
+// such as a secret held by a hardware token. The OPRF output `rw` of the
+// password is combined with a key derived from the secret before it seals or
+// opens the envelope, so that the envelope, and every key derived from `rw`,
+// including the export key, can be recovered only with both. A login with the
+// password alone, or the secret with the wrong password, fails with
+// ErrAuthenticationFailed. So too does an offline attack on a stolen password
+// file which has guessed the password: unlike WithSecondFactor, which the
+// server enforces in the key exchange, the envelope factor protects the
+// envelope itself.
+//
+// The user must be registered, and every login made, with the same secret. A
+// Client configured with both WithSecondFactor and WithEnvelopeFactor may use
+// the same secret for each, since their keys are derived under different
+// labels. The recovery envelope of NewRegistrationWithRecovery is sealed under
+// the recovery secret alone, so that a user who has lost the factor can still
+// recover their account.
+func WithEnvelopeFactor(secret []byte) ClientOption {
+	return func(c *Client) {
+		h := sha3.New512()
+		h.Write([]byte("occlude envelope factor"))
+		h.Write(secret)
+		c.envelopeFactor = h.Sum(nil)
+	}
+}
+
+// passwordRW is oprf for an OPRF output of the user's password, combining it
+// with the Client's envelope factor, if it has one.
+func (c *Client) passwordRW(p Argon2Params, f func() []byte) ([]byte, error) {
+	rw, err := c.oprf(p, f)
+	if err != nil || c.envelopeFactor == nil {
+		return rw, err
+	}
+	bound := deriveKey(append(append([]byte(nil), rw...), c.envelopeFactor...), nil, []byte("occlude envelope factor"), len(rw))
+	clear(rw)
+	return bound, nil
+}
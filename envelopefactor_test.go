@@ -0,0 +1,59 @@
+package occlude
+
+import (
+	"errors"
+	"testing"
+)
+
+// verify that a user registered with an envelope factor can log in only with
+// both their password and the factor's secret.
+func TestEnvelopeFactor(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+	secret := []byte("this is a test hardware token secret")
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername, WithEnvelopeFactor(secret))
+	register(t, s, c, testusername, testpassword)
+	cv := login(t, s, NewClient(testusername, WithEnvelopeFactor(secret)), testpassword, "")
+	if _, err := s.FinishSession(cv); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		c        *Client
+		password string
+	}{
+		{NewClient(testusername), testpassword},
+		{NewClient(testusername, WithEnvelopeFactor([]byte("this is another secret"))), testpassword},
+		{NewClient(testusername, WithEnvelopeFactor(secret)), "this is the wrong password"},
+	} {
+		sess, err := test.c.NewSession(test.password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		svrsess, _, err := s.NewSession(sess)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := test.c.SessionKey(svrsess, test.password); !errors.Is(err, ErrAuthenticationFailed) {
+			t.Fatal("expected ErrAuthenticationFailed, got", err)
+		}
+	}
+
+	// a client with an envelope factor can't log in to a user registered
+	// without one either.
+	register(t, s, NewClient("another user"), "another user", testpassword)
+	c = NewClient("another user", WithEnvelopeFactor(secret))
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err := s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.SessionKey(svrsess, testpassword); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatal("expected ErrAuthenticationFailed, got", err)
+	}
+}
@@ -0,0 +1,36 @@
+package occlude
+
+import (
+	"encoding/hex"
+	"errors"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// CredentialFingerprint returns a stable fingerprint of the password file of
+// the user id, for support tooling to check that a credential is the same as
+// before, for example after it was exported with MarshalPasswordFile and
+// restored. It is a hash of the user id and the file's public keys `Ps` and
+// `Pu` only, which are random and independent of the password, so it reveals
+// nothing that would help an offline attack. It changes whenever the user
+// registers again, including after NewUpgrade or a recovery.
+func (s *Server) CredentialFingerprint(id string) (string, error) {
+	id = s.userID(id)
+	s.mu.Lock()
+	pf, exists := s.passwordFiles[id]
+	s.mu.Unlock()
+	if !exists {
+		return "", errors.New("no such sid")
+	}
+	var b []byte
+	for _, field := range [][]byte{
+		[]byte("occlude credential fingerprint"),
+		[]byte(id),
+		pf.Ps.Encode(nil),
+		pf.Pu.Encode(nil),
+	} {
+		b = appendLengthPrefixed(b, field)
+	}
+	sum := sha3.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,52 @@
+package occlude
+
+import (
+	"testing"
+)
+
+// verify that a user's credential fingerprint survives export and import of
+// their password file, and changes when their keys are rotated by registering
+// again.
+func TestCredentialFingerprint(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+	fingerprint, err := s.CredentialFingerprint(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := s.MarshalPasswordFile(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewServer(WithArgon2Params(weakArgon2Params))
+	if err := restored.UnmarshalPasswordFile(testusername, data); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := restored.CredentialFingerprint(testusername); err != nil || got != fingerprint {
+		t.Fatal("fingerprint changed across export and import", got, err)
+	}
+
+	cv := login(t, s, c, testpassword, "")
+	pr, err := s.NewUpgrade(cv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err := c.NewRegistration(pr, testusername, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register(reg); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.CredentialFingerprint(testusername); err != nil || got == fingerprint {
+		t.Fatal("fingerprint unchanged after rotating the keys", got, err)
+	}
+	if _, err := s.CredentialFingerprint("nobody"); err == nil {
+		t.Fatal("fingerprinted an unregistered user")
+	}
+}
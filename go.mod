@@ -5,4 +5,6 @@ go 1.16
 require (
 	github.com/gtank/ristretto255 v0.1.2
 	golang.org/x/crypto v0.0.0-20210513164829-c07d793c2f9a
+	golang.org/x/text v0.3.3
+	google.golang.org/protobuf v1.28.1
 )
@@ -0,0 +1,63 @@
+package occlude
+
+import (
+	"errors"
+
+	ristretto "github.com/gtank/ristretto255"
+	"golang.org/x/crypto/sha3"
+)
+
+// groupCodec decodes the canonical encodings of ristretto255 group elements
+// and scalars. Parsing code decodes through the package's group rather than
+// calling ristretto directly, so that tests can substitute a groupCodec which
+// fails on demand to exercise error paths that valid encodings never reach.
+type groupCodec interface {
+	element(b []byte) (*ristretto.Element, error)
+	scalar(b []byte) (*ristretto.Scalar, error)
+}
+
+// ristrettoCodec is the groupCodec backed by the ristretto255 package.
+type ristrettoCodec struct{}
+
+func (ristrettoCodec) element(b []byte) (*ristretto.Element, error) {
+	e := new(ristretto.Element)
+	if err := e.Decode(b); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// scalar checks the length of b itself, since ristretto.Scalar.Decode panics
+// on input of the wrong length.
+func (ristrettoCodec) scalar(b []byte) (*ristretto.Scalar, error) {
+	if len(b) != scalarSize {
+		return nil, errors.New("invalid scalar length")
+	}
+	s := new(ristretto.Scalar)
+	if err := s.Decode(b); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// group is the groupCodec used for all parsing.
+var group groupCodec = ristrettoCodec{}
+
+// groupMarker is a digest of the ristretto255 operations the protocol relies
+// on, applied to a fixed test vector: reducing a uniform string to a scalar,
+// mapping one to an element, scalar multiplication, and the canonical
+// encodings of the results. Builds against ristretto255 versions which agree
+// on all of them compute the same marker, which is exchanged in the Hello.
+var groupMarker = computeGroupMarker()
+
+func computeGroupMarker() []byte {
+	vector := sha3.Sum512([]byte("occlude group marker"))
+	sc := new(ristretto.Scalar).FromUniformBytes(vector[:])
+	el := new(ristretto.Element).FromUniformBytes(vector[:])
+	h := sha3.New256()
+	h.Write(sc.Encode(nil))
+	h.Write(el.Encode(nil))
+	h.Write(new(ristretto.Element).ScalarBaseMult(sc).Encode(nil))
+	h.Write(new(ristretto.Element).ScalarMult(sc, el).Encode(nil))
+	return h.Sum(nil)
+}
@@ -0,0 +1,131 @@
+package occlude
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	ristretto "github.com/gtank/ristretto255"
+)
+
+// failingCodec is a groupCodec which fails the decode with index fail, and
+// otherwise decodes with ristrettoCodec.
+type failingCodec struct {
+	fail  int
+	calls int
+}
+
+var errForcedDecode = errors.New("forced decode failure")
+
+func (f *failingCodec) next() bool {
+	f.calls++
+	return f.calls-1 == f.fail
+}
+
+func (f *failingCodec) element(b []byte) (*ristretto.Element, error) {
+	if f.next() {
+		return nil, errForcedDecode
+	}
+	return ristrettoCodec{}.element(b)
+}
+
+func (f *failingCodec) scalar(b []byte) (*ristretto.Scalar, error) {
+	if f.next() {
+		return nil, errForcedDecode
+	}
+	return ristrettoCodec{}.scalar(b)
+}
+
+// decodeFailures calls decode once with each of its first n group decodes
+// forced to fail in turn, and returns the errors.
+func decodeFailures(n int, decode func() error) []error {
+	defer func(codec groupCodec) { group = codec }(group)
+	var errs []error
+	for i := 0; i < n; i++ {
+		group = &failingCodec{fail: i}
+		errs = append(errs, decode())
+	}
+	return errs
+}
+
+// verify that each decode in ciphertextData.UnmarshalJSON, including those
+// after an earlier successful decode, returns its error.
+func TestCiphertextDataDecodeFailures(t *testing.T) {
+	pu := randomScalar()
+	data, err := json.Marshal(&ciphertextData{
+		pu: pu,
+		Pu: new(ristretto.Element).ScalarBaseMult(pu),
+		Ps: new(ristretto.Element).ScalarBaseMult(randomScalar()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, err := range decodeFailures(3, func() error {
+		return json.Unmarshal(data, new(ciphertextData))
+	}) {
+		if !errors.Is(err, errForcedDecode) {
+			t.Fatalf("decode %v: expected the forced failure, got %v", i, err)
+		}
+	}
+	var ca ciphertextData
+	if err := json.Unmarshal(data, &ca); err != nil {
+		t.Fatal(err)
+	}
+	if ca.pu.Equal(pu) != 1 {
+		t.Fatal("ciphertextData did not survive encoding")
+	}
+}
+
+// verify that a decode failure of each group element in the binary and
+// protobuf encodings is reported as ErrMalformedMessage.
+func TestMessageDecodeFailures(t *testing.T) {
+	sess, err := NewClient("this is a test username").NewSession("this is a test password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := sess.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pb := sess.ToProto()
+	for _, decode := range []func() error{
+		func() error { return new(UsrSession).UnmarshalBinary(data) },
+		func() error { return new(UsrSession).FromProto(pb) },
+	} {
+		for i, err := range decodeFailures(2, decode) {
+			if !errors.Is(err, ErrMalformedMessage) {
+				t.Fatalf("decode %v: expected ErrMalformedMessage, got %v", i, err)
+			}
+		}
+	}
+}
+
+// verify that decode failures in identity keys and signatures are reported.
+func TestKeyDecodeFailures(t *testing.T) {
+	key := GenerateIdentityKey()
+	data, err := key.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range decodeFailures(1, func() error { return new(IdentityKey).UnmarshalBinary(data) }) {
+		if !errors.Is(err, errForcedDecode) {
+			t.Fatal("expected the forced failure, got", err)
+		}
+	}
+
+	msg := []byte("this is a test message")
+	sig := sign(key.priv, key.pub, msg)
+	for i, err := range decodeFailures(2, func() error {
+		if verify(key.pub, msg, sig) {
+			return nil
+		}
+		return errForcedDecode
+	}) {
+		if err == nil {
+			t.Fatalf("decode %v: signature verified despite a decode failure", i)
+		}
+	}
+	if !verify(key.pub, msg, sig) {
+		t.Fatal("valid signature did not verify")
+	}
+}
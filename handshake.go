@@ -0,0 +1,145 @@
+package occlude
+
+// The messages of a login are combined into exactly one message per
+// direction, for transports which prefer them to several: the client sends a
+// ClientHello, the server replies with a ServerHello, and the client finishes
+// with a ClientFinish. Each wraps the existing messages of its round, in their
+// binary encodings, so that the combined handshake runs the same protocol.
+
+// ClientHello is everything the client sends in the first round of a login:
+// its UsrSession, and optionally its Hello, for the server to check with
+// CheckHello before the login.
+type ClientHello struct {
+	Hello   *Hello
+	Session *UsrSession
+}
+
+// ServerHello is everything the server sends in reply to a ClientHello: its
+// SvrSession, and optionally its Hello, for the client to check with
+// CheckHello before the OPRF.
+type ServerHello struct {
+	Hello   *Hello
+	Session *SvrSession
+}
+
+// ClientFinish is the client's final message of a login, its
+// ClientVerification.
+type ClientFinish struct {
+	Verification *ClientVerification
+}
+
+func (e *encoder) hello(h *Hello) {
+	if h == nil {
+		e.uint8(0)
+		return
+	}
+	e.uint8(1)
+	e.bytes(h.Fingerprint)
+	e.bytes(h.GroupMarker)
+}
+
+func (d *decoder) hello() *Hello {
+	switch d.uint8("Hello") {
+	case 0:
+		return nil
+	case 1:
+	default:
+		d.fail("Hello")
+		return nil
+	}
+	return &Hello{
+		Fingerprint: d.bytes("Hello Fingerprint"),
+		GroupMarker: d.bytes("Hello GroupMarker"),
+	}
+}
+
+// MarshalBinary encodes the ClientHello for transport.
+func (h *ClientHello) MarshalBinary() ([]byte, error) {
+	if h.Session == nil {
+		return nil, ErrMissingField
+	}
+	session, err := h.Session.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	e := newEncoder(messageClientHello)
+	e.hello(h.Hello)
+	e.bytes(session)
+	return e.b, nil
+}
+
+// UnmarshalBinary decodes a ClientHello encoded with MarshalBinary.
+func (h *ClientHello) UnmarshalBinary(data []byte) error {
+	d := newDecoder(messageClientHello, data)
+	hello := d.hello()
+	session := d.bytes("Session")
+	if err := d.finish(); err != nil {
+		return err
+	}
+	decoded := ClientHello{Hello: hello, Session: new(UsrSession)}
+	if err := decoded.Session.UnmarshalBinary(session); err != nil {
+		return err
+	}
+	*h = decoded
+	return nil
+}
+
+// MarshalBinary encodes the ServerHello for transport.
+func (h *ServerHello) MarshalBinary() ([]byte, error) {
+	if h.Session == nil {
+		return nil, ErrMissingField
+	}
+	session, err := h.Session.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	e := newEncoder(messageServerHello)
+	e.hello(h.Hello)
+	e.bytes(session)
+	return e.b, nil
+}
+
+// UnmarshalBinary decodes a ServerHello encoded with MarshalBinary.
+func (h *ServerHello) UnmarshalBinary(data []byte) error {
+	d := newDecoder(messageServerHello, data)
+	hello := d.hello()
+	session := d.bytes("Session")
+	if err := d.finish(); err != nil {
+		return err
+	}
+	decoded := ServerHello{Hello: hello, Session: new(SvrSession)}
+	if err := decoded.Session.UnmarshalBinary(session); err != nil {
+		return err
+	}
+	*h = decoded
+	return nil
+}
+
+// MarshalBinary encodes the ClientFinish for transport.
+func (f *ClientFinish) MarshalBinary() ([]byte, error) {
+	if f.Verification == nil {
+		return nil, ErrMissingField
+	}
+	verification, err := f.Verification.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	e := newEncoder(messageClientFinish)
+	e.bytes(verification)
+	return e.b, nil
+}
+
+// UnmarshalBinary decodes a ClientFinish encoded with MarshalBinary.
+func (f *ClientFinish) UnmarshalBinary(data []byte) error {
+	d := newDecoder(messageClientFinish, data)
+	verification := d.bytes("Verification")
+	if err := d.finish(); err != nil {
+		return err
+	}
+	decoded := ClientFinish{Verification: new(ClientVerification)}
+	if err := decoded.Verification.UnmarshalBinary(verification); err != nil {
+		return err
+	}
+	*f = decoded
+	return nil
+}
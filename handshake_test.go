@@ -0,0 +1,92 @@
+package occlude
+
+import (
+	"bytes"
+	"testing"
+)
+
+// verify that a login whose messages are sent as a ClientHello, a ServerHello
+// and a ClientFinish, one blob per direction, succeeds, and that the blobs of
+// one round can't be decoded as those of another.
+func TestCombinedHandshake(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	pinned := DefaultScheme
+	pinned.Argon2 = weakArgon2Params
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername, WithServerKey(s.identity.PublicKey()), WithPinnedScheme(pinned))
+	register(t, s, c, testusername, testpassword)
+
+	// client: round one.
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientHello, err := (&ClientHello{Hello: c.Hello(), Session: sess}).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// server: reply.
+	var ch ClientHello
+	if err := ch.UnmarshalBinary(clientHello); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CheckHello(ch.Hello); err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err := s.NewSession(ch.Session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverHello, err := (&ServerHello{Hello: s.Hello(), Session: svrsess}).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// client: finish.
+	var sh ServerHello
+	if err := sh.UnmarshalBinary(serverHello); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CheckHello(sh.Hello); err != nil {
+		t.Fatal(err)
+	}
+	result, err := c.FinishLogin(sh.Session, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientFinish, err := (&ClientFinish{Verification: result.Verification()}).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// server: verify.
+	var cf ClientFinish
+	if err := cf.UnmarshalBinary(clientFinish); err != nil {
+		t.Fatal(err)
+	}
+	serverKey, err := s.FinishSession(cf.Verification)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(serverKey, result.SessionKey) {
+		t.Fatal("session keys differ")
+	}
+
+	if err := new(ServerHello).UnmarshalBinary(clientHello); err != ErrWrongMessageType {
+		t.Fatal("expected ErrWrongMessageType, got", err)
+	}
+	if err := new(ClientFinish).UnmarshalBinary(serverHello); err != ErrWrongMessageType {
+		t.Fatal("expected ErrWrongMessageType, got", err)
+	}
+	// the Hello is optional.
+	b, err := (&ClientHello{Session: sess}).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.UnmarshalBinary(b); err != nil || ch.Hello != nil {
+		t.Fatal("ClientHello without a Hello did not round trip:", err)
+	}
+}
@@ -0,0 +1,100 @@
+package occlude
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+
+	"golang.org/x/crypto/sha3"
+)
+
+var (
+	// ErrConfigMismatch is returned by CheckHello when the peer's
+	// configuration fingerprint differs from the local one.
+	ErrConfigMismatch = errors.New("protocol configuration mismatch")
+
+	// ErrRistrettoVersionSkew is returned by CheckHello when the peer was
+	// built against a version of the ristretto255 package which computes or
+	// encodes group elements and scalars differently from the local one, so
+	// that no handshake between them can succeed.
+	ErrRistrettoVersionSkew = errors.New("ristretto255 version skew")
+)
+
+// Hello is an optional message exchanged by the client and the server before
+// a registration or login, advertising a fingerprint of the sender's
+// protocol configuration. Comparing the fingerprints with CheckHello turns a
+// misconfiguration, such as a mismatched deployment context or Scheme, into
+// an early ErrConfigMismatch, instead of an authentication failure after the
+// expensive OPRF. GroupMarker is a digest of the sender's ristretto255
+// implementation applied to a fixed test vector, which turns a peer built
+// against an incompatible version of the package into an early
+// ErrRistrettoVersionSkew. A Hello carries no secrets and commits to nothing.
+type Hello struct {
+	Fingerprint []byte
+	GroupMarker []byte
+}
+
+// ConfigFingerprint returns the fingerprint of a protocol configuration with
+// the deployment context ctx and the Scheme scheme.
+func ConfigFingerprint(ctx []byte, scheme Scheme) []byte {
+	var b []byte
+	for _, field := range [][]byte{
+		[]byte("occlude config"),
+		ctx,
+		{byte(scheme.Version), byte(scheme.TranscriptHash)},
+		scheme.Argon2.encode(),
+	} {
+		b = appendLengthPrefixed(b, field)
+	}
+	sum := sha3.Sum256(b)
+	return sum[:]
+}
+
+// Hello returns the Server's Hello, fingerprinting its deployment context and
+// active Scheme.
+//
+// NOTE: logins use the Scheme the user's password file is bound to, which may
+// differ from the active Scheme, so a matching Hello does not guarantee that
+// a login will succeed after SetActiveScheme.
+func (s *Server) Hello() *Hello {
+	return &Hello{Fingerprint: ConfigFingerprint(s.context, s.ActiveScheme()), GroupMarker: groupMarker}
+}
+
+// CheckHello returns ErrConfigMismatch if the client's Hello does not match
+// the Server's.
+func (s *Server) CheckHello(h *Hello) error {
+	return checkHello(s.Hello(), h)
+}
+
+// Hello returns the Client's Hello, fingerprinting its deployment context and
+// the Scheme it was pinned to WithPinnedScheme, or the DefaultScheme if it
+// was not.
+func (c *Client) Hello() *Hello {
+	scheme := DefaultScheme
+	if c.pinnedScheme != nil {
+		scheme = *c.pinnedScheme
+	}
+	return &Hello{Fingerprint: ConfigFingerprint(c.context, scheme), GroupMarker: groupMarker}
+}
+
+// CheckHello returns ErrConfigMismatch if the server's Hello does not match
+// the Client's.
+func (c *Client) CheckHello(h *Hello) error {
+	return checkHello(c.Hello(), h)
+}
+
+// checkHello compares the local Hello with the peer's. A peer's Hello without
+// a GroupMarker, from a version of occlude which predates it, is compared by
+// its Fingerprint alone.
+func checkHello(local *Hello, peer *Hello) error {
+	if peer == nil {
+		return ErrNilMessage
+	}
+	if peer.GroupMarker != nil && !bytes.Equal(local.GroupMarker, peer.GroupMarker) {
+		return ErrRistrettoVersionSkew
+	}
+	if subtle.ConstantTimeCompare(local.Fingerprint, peer.Fingerprint) != 1 {
+		return ErrConfigMismatch
+	}
+	return nil
+}
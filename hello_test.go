@@ -0,0 +1,77 @@
+package occlude
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// verify that clients and servers with the same configuration agree at hello,
+// and that each kind of mismatch aborts there.
+func TestHello(t *testing.T) {
+	pinned := DefaultScheme
+	pinned.Argon2 = weakArgon2Params
+	for _, test := range []struct {
+		name     string
+		server   []ServerOption
+		client   []ClientOption
+		mismatch bool
+	}{
+		{name: "defaults"},
+		{
+			name:   "same context and scheme",
+			server: []ServerOption{WithContext([]byte("app")), WithArgon2Params(weakArgon2Params)},
+			client: []ClientOption{WithClientContext([]byte("app")), WithPinnedScheme(pinned)},
+		},
+		{
+			name:     "context",
+			server:   []ServerOption{WithContext([]byte("app"))},
+			client:   []ClientOption{WithClientContext([]byte("other app"))},
+			mismatch: true,
+		},
+		{
+			name:     "transcript hash",
+			server:   []ServerOption{WithTranscriptHash(TranscriptSHA512)},
+			mismatch: true,
+		},
+		{
+			name:     "argon2",
+			server:   []ServerOption{WithArgon2Params(weakArgon2Params)},
+			mismatch: true,
+		},
+	} {
+		s := NewServer(test.server...)
+		c := NewClient("user", test.client...)
+		serverErr, clientErr := s.CheckHello(c.Hello()), c.CheckHello(s.Hello())
+		if !test.mismatch && (serverErr != nil || clientErr != nil) {
+			t.Fatal(test.name, serverErr, clientErr)
+		}
+		if test.mismatch && (!errors.Is(serverErr, ErrConfigMismatch) || !errors.Is(clientErr, ErrConfigMismatch)) {
+			t.Fatal(test.name, "expected ErrConfigMismatch, got", serverErr, clientErr)
+		}
+	}
+}
+
+// verify that the group marker is stable for the pinned ristretto255 v0.1.2,
+// and that a peer whose marker differs is rejected with
+// ErrRistrettoVersionSkew.
+func TestGroupMarker(t *testing.T) {
+	const want = "d5c7ecb80c9aa5165011aa26cf117c959612cb96bd165d989a063c72c6b1c420"
+	if got := hex.EncodeToString(groupMarker); got != want {
+		t.Fatalf("group marker changed: got %s, want %s", got, want)
+	}
+
+	s := NewServer()
+	c := NewClient("user")
+	skewed := c.Hello()
+	skewed.GroupMarker = append([]byte(nil), skewed.GroupMarker...)
+	skewed.GroupMarker[0] ^= 1
+	if err := s.CheckHello(skewed); err != ErrRistrettoVersionSkew {
+		t.Fatal("expected ErrRistrettoVersionSkew, got", err)
+	}
+	legacy := c.Hello()
+	legacy.GroupMarker = nil
+	if err := s.CheckHello(legacy); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,83 @@
+package occlude
+
+import (
+	"errors"
+
+	ristretto "github.com/gtank/ristretto255"
+)
+
+// IdentityKey is a long-term server keypair. Unlike the per-user `ps`/`Ps`
+// generated at registration, a single IdentityKey identifies the server as a
+// whole, and its public key can be published for clients to pin.
+//
+// NOTE: the private part of an IdentityKey must be stored as carefully as any
+// other long-term server secret. Anyone who holds it can impersonate the server
+// to clients which pin its public key.
+type IdentityKey struct {
+	priv *ristretto.Scalar
+	pub  *ristretto.Element
+}
+
+// GenerateIdentityKey creates a new random IdentityKey.
+func GenerateIdentityKey() *IdentityKey {
+	priv := randomScalar()
+	return &IdentityKey{
+		priv: priv,
+		pub:  new(ristretto.Element).ScalarBaseMult(priv),
+	}
+}
+
+// PublicKey returns a copy of the public part of the IdentityKey.
+func (k *IdentityKey) PublicKey() *ristretto.Element {
+	pub := new(ristretto.Element)
+	if err := pub.Decode(k.pub.Encode(nil)); err != nil {
+		panic("could not copy identity public key")
+	}
+	return pub
+}
+
+// MarshalBinary exports the private part of the IdentityKey. The output is
+// secret and must be stored confidentially.
+func (k *IdentityKey) MarshalBinary() ([]byte, error) {
+	if k.priv == nil {
+		return nil, errors.New("identity key is not initialized")
+	}
+	return k.priv.Encode(nil), nil
+}
+
+// UnmarshalBinary imports an IdentityKey previously exported with
+// MarshalBinary, recomputing its public key.
+func (k *IdentityKey) UnmarshalBinary(data []byte) error {
+	if len(data) != scalarSize {
+		return errors.New("invalid identity key length")
+	}
+	priv, err := group.scalar(data)
+	if err != nil {
+		return err
+	}
+	if priv.Equal(new(ristretto.Scalar).Zero()) == 1 {
+		return errors.New("identity key is zero")
+	}
+	k.priv = priv
+	k.pub = new(ristretto.Element).ScalarBaseMult(priv)
+	return nil
+}
+
+// WithIdentityKey configures the Server to use the provided long-term
+// IdentityKey, rather than generating a new one in NewServer.
+func WithIdentityKey(k *IdentityKey) ServerOption {
+	return func(s *Server) {
+		s.identity = k
+	}
+}
+
+// PublicKey returns the public part of the Server's long-term IdentityKey.
+func (s *Server) PublicKey() *ristretto.Element {
+	return s.identity.PublicKey()
+}
+
+// IdentityKey returns the Server's long-term IdentityKey, so that it can be
+// exported with MarshalBinary and reloaded across restarts.
+func (s *Server) IdentityKey() *IdentityKey {
+	return s.identity
+}
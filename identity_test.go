@@ -0,0 +1,55 @@
+package occlude
+
+import (
+	"bytes"
+	"testing"
+)
+
+// verify that NewServer generates a distinct identity key per server unless one
+// is provided.
+func TestIdentityKeyGeneration(t *testing.T) {
+	s1 := NewServer()
+	s2 := NewServer()
+	if s1.PublicKey().Equal(s2.PublicKey()) == 1 {
+		t.Fatal("two servers generated the same identity key")
+	}
+
+	k := GenerateIdentityKey()
+	s3 := NewServer(WithIdentityKey(k))
+	if s3.PublicKey().Equal(k.PublicKey()) != 1 {
+		t.Fatal("server did not use the provided identity key")
+	}
+}
+
+// verify that an identity key survives an export and import, as it would across
+// a server restart.
+func TestIdentityKeyExportImport(t *testing.T) {
+	s := NewServer()
+	exported, err := s.IdentityKey().MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var k IdentityKey
+	if err := k.UnmarshalBinary(exported); err != nil {
+		t.Fatal(err)
+	}
+	restarted := NewServer(WithIdentityKey(&k))
+	if restarted.PublicKey().Equal(s.PublicKey()) != 1 {
+		t.Fatal("identity public key changed across export and import")
+	}
+	reexported, err := restarted.IdentityKey().MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(exported, reexported) {
+		t.Fatal("identity private key changed across export and import")
+	}
+
+	if err := k.UnmarshalBinary(make([]byte, 32)); err == nil {
+		t.Fatal("imported a zero identity key")
+	}
+	if err := k.UnmarshalBinary([]byte{1, 2, 3}); err == nil {
+		t.Fatal("imported a truncated identity key")
+	}
+}
@@ -0,0 +1,59 @@
+package occlude
+
+import (
+	"fmt"
+	"sort"
+)
+
+// VerifyStoreIntegrity checks every password file held by the Server, without
+// attempting any logins, and returns an error for each one which is corrupt,
+// in user id order. It is intended as a health check after a migration or a
+// restore from backup. Each error wraps ErrCorruptPasswordFile,
+// ErrMasterSecretMismatch or ErrUnknownPepperEpoch, and names the user id and the corrupt field, but
+// never any key material.
+func (s *Server) VerifyStoreIntegrity() []error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, 0, len(s.passwordFiles))
+	for id := range s.passwordFiles {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var errs []error
+	for _, id := range ids {
+		if err := s.verifyPasswordFile(id, s.passwordFiles[id]); err != nil {
+			errs = append(errs, fmt.Errorf("password file %q: %w", id, err))
+		}
+	}
+	return errs
+}
+
+// verifyPasswordFile checks the password file pf of the user id as validate
+// does, and additionally that its keys are derived from the Server's master
+// secret, if they are derived from one, that it is bound to a supported
+// Scheme, that its pepper epoch is known to the Server, and that each of its
+// Envelopes is filed under its own label and has a well-formed salt. The
+// caller must hold s.mu.
+func (s *Server) verifyPasswordFile(id string, pf pwdFile) error {
+	if err := pf.validate(); err != nil {
+		return err
+	}
+	if err := s.checkFileKeys(id, pf); err != nil {
+		return err
+	}
+	if !pf.scheme.supported() {
+		return fmt.Errorf("%w: scheme", ErrCorruptPasswordFile)
+	}
+	if pf.pepperEpoch != 0 {
+		if _, exists := s.peppers[pf.pepperEpoch]; !exists {
+			return fmt.Errorf("%w: %v", ErrUnknownPepperEpoch, pf.pepperEpoch)
+		}
+	}
+	for label, env := range pf.envelopes {
+		if env.Label != label || len(env.Salt) != envelopeSaltSize {
+			return fmt.Errorf("%w: Envelope %q", ErrCorruptPasswordFile, label)
+		}
+	}
+	return nil
+}
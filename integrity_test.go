@@ -0,0 +1,39 @@
+package occlude
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// verify that VerifyStoreIntegrity reports a single corrupted password file
+// among healthy ones, without revealing its key material.
+func TestVerifyStoreIntegrity(t *testing.T) {
+	testpassword := "this is a test password"
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	for _, username := range []string{"alice", "bob", "carol"} {
+		register(t, s, NewClient(username), username, testpassword)
+	}
+	if errs := s.VerifyStoreIntegrity(); len(errs) != 0 {
+		t.Fatal("healthy store reported as corrupt:", errs)
+	}
+
+	pf := s.passwordFiles["bob"]
+	pf.c.Tag = pf.c.Tag[:macSize/2]
+	s.passwordFiles["bob"] = pf
+
+	errs := s.VerifyStoreIntegrity()
+	if len(errs) != 1 {
+		t.Fatalf("expected one corrupt password file, got %v", errs)
+	}
+	if !errors.Is(errs[0], ErrCorruptPasswordFile) || !strings.Contains(errs[0].Error(), `"bob"`) {
+		t.Fatal("corrupt password file was not identified:", errs[0])
+	}
+	for _, secret := range [][]byte{pf.ks.Encode(nil), pf.ps.Encode(nil), pf.c.Tag} {
+		if strings.Contains(errs[0].Error(), string(secret)) || strings.Contains(errs[0].Error(), hex.EncodeToString(secret)) {
+			t.Fatal("error reveals key material:", errs[0])
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package occlude
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrLegacyUser is returned by Server.NewSession for a user imported with
+// ImportLegacyUser who has not yet been upgraded. The client should upgrade
+// them with NewLegacyRegistration.
+var ErrLegacyUser = errors.New("user has a legacy password hash and must be upgraded")
+
+// LegacyVerifier checks a password against a user's password hash from a
+// traditional password database, so that deployments migrating to occlude
+// can import their users without knowing their passwords. Hashes in other
+// formats, such as scrypt, can be imported by implementing LegacyVerifier.
+type LegacyVerifier interface {
+	VerifyPassword(password string) bool
+}
+
+// BcryptHash is a LegacyVerifier for a password hash in the bcrypt encoding.
+type BcryptHash []byte
+
+// VerifyPassword reports whether password matches the bcrypt hash.
+func (h BcryptHash) VerifyPassword(password string) bool {
+	return bcrypt.CompareHashAndPassword(h, []byte(password)) == nil
+}
+
+// ImportLegacyUser imports the user id from a traditional password database,
+// keeping their legacy password hash until they next log in. The user cannot
+// log in with NewSession until they have been upgraded with
+// NewLegacyRegistration, and their id cannot be registered by anyone else.
+func (s *Server) ImportLegacyUser(id string, verifier LegacyVerifier) error {
+	done, err := s.permitRegistration()
+	if err != nil {
+		return err
+	}
+	defer done()
+	id = s.userID(id)
+	if verifier == nil {
+		return errors.New("nil legacy verifier")
+	}
+	if err := s.checkUsername(id); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.passwordFiles[id]; exists {
+		return errors.New("user already registered")
+	}
+	s.legacyUsers[id] = verifier
+	return nil
+}
+
+// IsLegacyUser reports whether the user id was imported with ImportLegacyUser
+// and has not yet been upgraded.
+func (s *Server) IsLegacyUser(id string) bool {
+	id = s.userID(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, exists := s.legacyUsers[id]
+	return exists
+}
+
+// NewLegacyRegistration upgrades a legacy user on their first login. It checks
+// password against the user's legacy hash and, if it matches, starts a
+// registration for the client to complete with NewRegistration and the same
+// password. The legacy hash is deleted when Register succeeds, after which
+// the user logs in with NewSession as usual.
+//
+// NOTE: unlike the rest of the protocol, this step sends the plaintext
+// password to the server, as verifying a traditional password hash requires
+// it. It must be executed over a secure, authenticated and confidential
+// medium such as TLS, and happens at most once per user. Each call is an
+// online guess at the password, so deployments should call
+// NewLegacyRegistrationFrom with a registration Throttle instead.
+func (s *Server) NewLegacyRegistration(id string, password string) (*pendingRegistration, error) {
+	return s.NewLegacyRegistrationFrom("", id, password)
+}
+
+// NewLegacyRegistrationFrom is NewLegacyRegistration for a request from
+// source, such as the client's IP address, which is checked against the
+// Server's registration Throttle before the password is.
+func (s *Server) NewLegacyRegistrationFrom(source string, id string, password string) (*pendingRegistration, error) {
+	done, err := s.permitRegistration()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	if err := s.throttleRegistration(source); err != nil {
+		return nil, err
+	}
+	id = s.userID(id)
+	s.mu.Lock()
+	verifier, exists := s.legacyUsers[id]
+	s.mu.Unlock()
+	if !exists {
+		return nil, errors.New("no such legacy user")
+	}
+
+	// A legacy hash is deliberately slow to verify, so it is verified
+	// without holding s.mu, which would stall every other request.
+	valid := verifier.VerifyPassword(password)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !valid {
+		s.stats.loginFailures++
+		s.auditFailure(id, ReasonIncorrectPassword)
+		return nil, ErrIncorrectPassword
+	}
+	// the user may have been upgraded while the hash was verified.
+	if _, exists := s.legacyUsers[id]; !exists {
+		return nil, errors.New("no such legacy user")
+	}
+	pr, err := s.newPendingRegistration(id, false)
+	if err != nil {
+		return nil, err
+	}
+	pending := s.pendingRegistrations[id]
+	pending.legacy = true
+	s.pendingRegistrations[id] = pending
+	return pr, nil
+}
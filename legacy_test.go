@@ -0,0 +1,120 @@
+package occlude
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// verify that a user imported with a legacy bcrypt hash is upgraded to an
+// OPAQUE password file on their next login, after which the legacy hash is
+// gone.
+func TestLegacyUpgrade(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(testpassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	if err := s.ImportLegacyUser(testusername, BcryptHash(hash)); err != nil {
+		t.Fatal(err)
+	}
+	if !s.IsLegacyUser(testusername) {
+		t.Fatal("imported user is not a legacy user")
+	}
+
+	c := NewClient(testusername)
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.NewSession(sess); err != ErrLegacyUser {
+		t.Fatal("expected ErrLegacyUser, got", err)
+	}
+	pr, err := s.NewRegistration(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err := NewClient(testusername).NewRegistration(pr, testusername, "an impostor's password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register(reg); err == nil {
+		t.Fatal("legacy user was registered without their legacy password")
+	}
+	if _, err := s.NewLegacyRegistration(testusername, "the wrong password"); err != ErrIncorrectPassword {
+		t.Fatal("expected ErrIncorrectPassword, got", err)
+	}
+
+	pr, err = s.NewLegacyRegistration(testusername, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err = c.NewRegistration(pr, testusername, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register(reg); err != nil {
+		t.Fatal(err)
+	}
+	if s.IsLegacyUser(testusername) {
+		t.Fatal("legacy hash was not deleted after the upgrade")
+	}
+	if _, err := s.NewLegacyRegistration(testusername, testpassword); err == nil {
+		t.Fatal("upgraded user can still use their legacy hash")
+	}
+	login(t, s, c, testpassword, "")
+}
+
+// blockingVerifier is a LegacyVerifier which blocks until released, standing
+// in for a slow password hash.
+type blockingVerifier struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (v blockingVerifier) VerifyPassword(password string) bool {
+	select {
+	case v.started <- struct{}{}:
+	default:
+	}
+	<-v.release
+	return false
+}
+
+// verify that a legacy hash is verified without blocking the rest of the
+// Server, and that legacy upgrades are checked against the registration
+// Throttle.
+func TestLegacyRegistrationConcurrency(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	clock := &testClock{t: time.Unix(0, 0)}
+	s := NewServer(WithArgon2Params(weakArgon2Params), WithRegistrationThrottle(newWindowThrottle(2, time.Minute, clock.now)))
+	verifier := blockingVerifier{started: make(chan struct{}, 1), release: make(chan struct{})}
+	if err := s.ImportLegacyUser(testusername, verifier); err != nil {
+		t.Fatal(err)
+	}
+	failed := make(chan error)
+	go func() {
+		_, err := s.NewLegacyRegistrationFrom("guesser", testusername, "a guess")
+		failed <- err
+	}()
+	<-verifier.started
+	// the Server still serves logins while the hash is verified.
+	register(t, s, NewClient("another user"), "another user", testpassword)
+	close(verifier.release)
+	if err := <-failed; err != ErrIncorrectPassword {
+		t.Fatal("expected ErrIncorrectPassword, got", err)
+	}
+
+	if _, err := s.NewLegacyRegistrationFrom("guesser", testusername, "another guess"); err != ErrIncorrectPassword {
+		t.Fatal("expected ErrIncorrectPassword, got", err)
+	}
+	if _, err := s.NewLegacyRegistrationFrom("guesser", testusername, "a third guess"); err != ErrRateLimited {
+		t.Fatal("expected ErrRateLimited, got", err)
+	}
+}
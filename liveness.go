@@ -0,0 +1,141 @@
+package occlude
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"errors"
+
+	ristretto "github.com/gtank/ristretto255"
+	"golang.org/x/crypto/sha3"
+)
+
+// livenessNonceSize is the length of the nonce in a LivenessChallenge, in
+// bytes.
+const livenessNonceSize = 32
+
+var (
+	// ErrNoLivenessKey is returned by Client.NewLivenessChallenge when the
+	// Client has not registered or logged in, and so can not verify a
+	// LivenessProof.
+	ErrNoLivenessKey = errors.New("no liveness key: register or log in first")
+
+	// ErrInvalidLivenessProof is returned by Client.VerifyLiveness when the
+	// proof was not produced by a server holding the user's password file.
+	ErrInvalidLivenessProof = errors.New("invalid liveness proof")
+)
+
+// LivenessChallenge is a client's request for the server to prove that it is
+// reachable and holds the password file of the user ID, without running the
+// key exchange or establishing a session. Nonce is chosen fresh by the client
+// for each challenge.
+type LivenessChallenge struct {
+	ID    string
+	Nonce []byte
+}
+
+// NewLivenessChallenge creates a LivenessChallenge for the Client's user.
+// The Client must have completed a NewRegistration or SessionKey, from which
+// it retains the key needed to verify the server's proof; the password is not
+// needed again.
+func (c *Client) NewLivenessChallenge() (*LivenessChallenge, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.livenessKey == nil {
+		return nil, ErrNoLivenessKey
+	}
+	nonce := make([]byte, livenessNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	c.livenessNonce = nonce
+	return &LivenessChallenge{ID: c.Sid, Nonce: nonce}, nil
+}
+
+// ProveLiveness answers a LivenessChallenge with a MAC over it, keyed by the
+// static Diffie-Hellman secret between the server's `ps` and the user's `Pu`
+// from their password file. Only the server holding the file, and the client
+// holding `pu`, can compute it.
+//
+// The proof does not create an oracle for the rest of the protocol: its key
+// is derived for this purpose alone and is independent of the OPRF key, the
+// envelope and every session key, and the MACed transcript is domain
+// separated, so a proof reveals nothing about the password and can not be
+// replayed as any other protocol message. If the Server was created
+// WithUserEnumerationProtection, a challenge for an unknown user is answered
+// from the user's dummy password file, so that the response does not reveal
+// whether the user exists.
+func (s *Server) ProveLiveness(challenge *LivenessChallenge) ([]byte, error) {
+	done, err := s.permitAuthentication()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	if challenge == nil {
+		return nil, ErrNilMessage
+	}
+	if len(challenge.Nonce) != livenessNonceSize {
+		return nil, errors.New("invalid liveness nonce length")
+	}
+	id := s.userID(challenge.ID)
+	s.mu.Lock()
+	pf, exists := s.passwordFiles[id]
+	if !exists && s.enumerationKey != nil {
+		pf, exists = s.dummyPasswordFile(id), true
+	}
+	s.mu.Unlock()
+	if !exists {
+		return nil, errors.New("no such sid")
+	}
+	_, ps, err := s.fileKeys(id, pf)
+	if err != nil {
+		return nil, err
+	}
+	key := livenessKey(s.context, new(ristretto.Element).ScalarMult(ps, pf.Pu))
+	return livenessProof(key, challenge), nil
+}
+
+// VerifyLiveness checks the server's proof for the Client's most recent
+// LivenessChallenge, returning ErrInvalidLivenessProof if it was not produced
+// by a server holding the user's password file.
+func (c *Client) VerifyLiveness(challenge *LivenessChallenge, proof []byte) error {
+	if challenge == nil {
+		return ErrNilMessage
+	}
+	c.mu.Lock()
+	key, nonce := c.livenessKey, c.livenessNonce
+	c.livenessNonce = nil
+	c.mu.Unlock()
+	if key == nil {
+		return ErrNoLivenessKey
+	}
+	if nonce == nil || challenge.ID != c.Sid || !hmac.Equal(nonce, challenge.Nonce) {
+		return errors.New("no such liveness challenge in progress")
+	}
+	if !hmac.Equal(livenessProof(key, challenge), proof) {
+		return ErrInvalidLivenessProof
+	}
+	return nil
+}
+
+// livenessKey derives the key liveness proofs are MACed with from the static
+// Diffie-Hellman secret dh = ps·Pu = pu·Ps, bound to the deployment context
+// ctx.
+func livenessKey(ctx []byte, dh *ristretto.Element) []byte {
+	key := deriveKey(dh.Encode(nil), nil, []byte("occlude liveness key"), 32)
+	return bindContext(ctx, "liveness", key)
+}
+
+// livenessProof computes the MAC over challenge under key.
+func livenessProof(key []byte, challenge *LivenessChallenge) []byte {
+	var transcript []byte
+	for _, field := range [][]byte{
+		[]byte("occlude liveness"),
+		[]byte(challenge.ID),
+		challenge.Nonce,
+	} {
+		transcript = appendLengthPrefixed(transcript, field)
+	}
+	mac := hmac.New(sha3.New256, key)
+	mac.Write(transcript)
+	return mac.Sum(nil)
+}
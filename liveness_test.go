@@ -0,0 +1,62 @@
+package occlude
+
+import (
+	"errors"
+	"testing"
+)
+
+// verify that a client which has logged in can verify the server's liveness
+// proof without its password, and that a server without the user's password
+// file, or a replayed proof, is rejected.
+func TestLiveness(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername)
+	if _, err := c.NewLivenessChallenge(); !errors.Is(err, ErrNoLivenessKey) {
+		t.Fatalf("expected ErrNoLivenessKey before login, got %v", err)
+	}
+	register(t, s, c, testusername, testpassword)
+
+	// a fresh client learns the liveness key by logging in.
+	c = NewClient(testusername)
+	login(t, s, c, testpassword, "")
+
+	challenge, err := c.NewLivenessChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := s.ProveLiveness(challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.VerifyLiveness(challenge, proof); err != nil {
+		t.Fatal(err)
+	}
+
+	// the proof answers only the challenge it was made for.
+	challenge, err = c.NewLivenessChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.VerifyLiveness(challenge, proof); !errors.Is(err, ErrInvalidLivenessProof) {
+		t.Fatalf("expected ErrInvalidLivenessProof for a replayed proof, got %v", err)
+	}
+
+	// a server holding a different password file for the user can't
+	// produce a valid proof.
+	impostor := NewServer(WithArgon2Params(weakArgon2Params))
+	register(t, impostor, NewClient(testusername), testusername, testpassword)
+	challenge, err = c.NewLivenessChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err = impostor.ProveLiveness(challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.VerifyLiveness(challenge, proof); !errors.Is(err, ErrInvalidLivenessProof) {
+		t.Fatalf("expected ErrInvalidLivenessProof from an impostor, got %v", err)
+	}
+}
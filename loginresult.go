@@ -0,0 +1,80 @@
+package occlude
+
+import (
+	"time"
+
+	ristretto "github.com/gtank/ristretto255"
+)
+
+// LoginResult holds the outputs of a successful login, as returned by
+// Client.FinishLogin.
+type LoginResult struct {
+	// ID is the user id the login was made for.
+	ID string
+
+	// SessionKey is the session key SK, shared with the server.
+	SessionKey []byte
+
+	// ClientConfirmation is the fk2 the client sends to the server, in a
+	// ClientVerification, to prove that it derived the same session key.
+	ClientConfirmation []byte
+
+	// ExportKey is the user's export key, as returned by Client.ExportKey.
+	ExportKey []byte
+
+	// ServerStaticKey is the server's static public key `Ps` for the user,
+	// from their envelope.
+	ServerStaticKey *ristretto.Element
+
+	// Scheme is the Scheme the user's password file is bound to.
+	Scheme Scheme
+
+	// EnvelopeData is the data in the requested Envelope, and SealedEnvelope
+	// the requested Envelope itself if it was sealed with SealWithExportKey.
+	// Both are nil if no Envelope was requested, or none was found.
+	EnvelopeData   []byte
+	SealedEnvelope *Envelope
+
+	// RawSecret is the shared secret K the session key is derived from, if
+	// the Client was configured WithClientAllowRawSecret, or nil otherwise.
+	RawSecret []byte
+
+	// Argon2Upgrade is the user's envelope re-hardened with the stronger
+	// Argon2Params the server asked for, to send to Server.UpgradeArgon2, or
+	// nil if it asked for none.
+	Argon2Upgrade *Argon2Upgrade
+
+	// IssuedAt is when the session key was established, by the Client's
+	// clock.
+	IssuedAt time.Time
+
+	// SessionEpoch is the user's session epoch the session key is bound to,
+	// for a Server configured WithSessionEpochs.
+	SessionEpoch uint64
+}
+
+// Expired reports whether, at now, the session key is older than maxAge.
+// occlude does not itself expire session keys: applications which want them
+// to expire check Expired, or set the expiry of a Transport keyed by the
+// session key with SetExpiry.
+func (r *LoginResult) Expired(now time.Time, maxAge time.Duration) bool {
+	return now.After(r.IssuedAt.Add(maxAge))
+}
+
+// Verification returns the ClientVerification to send to the server to
+// complete the login.
+func (r *LoginResult) Verification() *ClientVerification {
+	return &ClientVerification{ID: r.ID, FK2: append([]byte(nil), r.ClientConfirmation...)}
+}
+
+// SessionKey completes the client's half of the login as FinishLogin does,
+// returning only the session key SK and the fk2 to send to the server in a
+// ClientVerification, in that order. It is kept for compatibility: new code
+// should prefer FinishLogin, whose LoginResult labels each of the outputs.
+func (c *Client) SessionKey(session *SvrSession, password string) ([]byte, []byte, error) {
+	result, err := c.FinishLogin(session, password)
+	if err != nil {
+		return nil, nil, err
+	}
+	return result.SessionKey, result.ClientConfirmation, nil
+}
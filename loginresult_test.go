@@ -0,0 +1,60 @@
+package occlude
+
+import (
+	"bytes"
+	"testing"
+)
+
+// verify that FinishLogin's LoginResult carries the outputs of the login, and
+// that its Verification completes the login at the server.
+func TestFinishLogin(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer(WithArgon2Params(weakArgon2Params), WithStrictVerification())
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+	cv := login(t, s, c, testpassword, "")
+	env, err := c.SealEnvelope("laptop", []byte("laptop device key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddEnvelope(cv, env); err != nil {
+		t.Fatal(err)
+	}
+
+	sess, err := c.NewSessionWithEnvelope(testpassword, "laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err := s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := c.FinishLogin(svrsess, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ID != testusername {
+		t.Fatal("wrong ID", result.ID)
+	}
+	if !bytes.Equal(result.ExportKey, c.ExportKey()) {
+		t.Fatal("wrong ExportKey")
+	}
+	if result.ServerStaticKey.Equal(s.passwordFiles[testusername].Ps) != 1 {
+		t.Fatal("wrong ServerStaticKey")
+	}
+	if result.Scheme != s.ActiveScheme() {
+		t.Fatal("wrong Scheme", result.Scheme)
+	}
+	if !bytes.Equal(result.EnvelopeData, []byte("laptop device key")) || result.SealedEnvelope != nil {
+		t.Fatal("wrong Envelope", result.EnvelopeData)
+	}
+	serverKey, err := s.FinishSession(result.Verification())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(serverKey, result.SessionKey) {
+		t.Fatal("client and server did not compute identical session key")
+	}
+}
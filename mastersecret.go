@@ -0,0 +1,94 @@
+package occlude
+
+import (
+	"errors"
+	"fmt"
+
+	ristretto "github.com/gtank/ristretto255"
+)
+
+// minMasterSecretSize is the minimum length of a master secret, in bytes.
+const minMasterSecretSize = 32
+
+// keyNonceSize is the length of the nonce each registration's keys are
+// derived with from the master secret, in bytes.
+const keyNonceSize = 32
+
+var (
+	// ErrWeakMasterSecret is returned by NewRegistration when the Server's
+	// master secret is shorter than 32 bytes.
+	ErrWeakMasterSecret = errors.New("master secret is too short")
+
+	// ErrMasterSecretMismatch is returned when a password file whose keys
+	// are derived from a master secret is loaded by a Server which does not
+	// hold that master secret.
+	ErrMasterSecretMismatch = errors.New("password file keys were derived from another master secret")
+)
+
+// WithMasterSecret configures the Server to derive each user's OPRF key `ks`
+// and private key `ps` from secret, the user id and a random nonce drawn for
+// each registration, rather than generating them at random in
+// NewRegistration. The secret must be at least 32 bytes. Only the nonce is
+// stored in the user's password file, and the keys are recomputed from it
+// whenever they are needed, so that the master secret is the only long-term
+// secret the Server holds.
+//
+// NOTE: this is a tradeoff. Anyone who obtains the master secret and the
+// password files holds the keys of every user, and can mount an offline
+// dictionary attack against any of them, or impersonate the server to them:
+// it must be protected at least as well as the whole password file store.
+// Conversely, password files stolen without it hold no keys, so peppers
+// configured WithPeppers are not applied to them. The nonce makes the keys of
+// each registration unrelated to those of any other, even of the same user,
+// so that NewRegistration, which hands the OPRF key of the registration to
+// the client, reveals nothing about the keys of an existing password file.
+func WithMasterSecret(secret []byte) ServerOption {
+	return func(s *Server) {
+		s.masterSecret = append([]byte(nil), secret...)
+	}
+}
+
+// userKeys derives the OPRF key ks and private key ps of a registration of the
+// user id with nonce from the master secret.
+func userKeys(masterSecret []byte, id string, nonce []byte) (ks *ristretto.Scalar, ps *ristretto.Scalar, err error) {
+	if len(masterSecret) < minMasterSecretSize {
+		return nil, nil, ErrWeakMasterSecret
+	}
+	keys := deriveKeys(masterSecret, nonce, []keySpec{
+		{append([]byte("occlude user oprf key "), id...), 64},
+		{append([]byte("occlude user private key "), id...), 64},
+	})
+	ks = new(ristretto.Scalar).FromUniformBytes(keys[0])
+	ps = new(ristretto.Scalar).FromUniformBytes(keys[1])
+	return ks, ps, nil
+}
+
+// fileKeys returns the stored OPRF key ks and private key ps of the password
+// file pf of the user id, recomputing them from the master secret if the file
+// has a key nonce.
+func (s *Server) fileKeys(id string, pf pwdFile) (ks *ristretto.Scalar, ps *ristretto.Scalar, err error) {
+	if pf.keyNonce == nil {
+		return pf.ks, pf.ps, nil
+	}
+	if s.masterSecret == nil {
+		return nil, nil, ErrMasterSecretMismatch
+	}
+	return userKeys(s.masterSecret, id, pf.keyNonce)
+}
+
+// checkFileKeys checks that the keys of the password file pf of the user id,
+// if they are derived from a master secret, are derived from the Server's:
+// that its public key Ps matches the recomputed private key.
+func (s *Server) checkFileKeys(id string, pf pwdFile) error {
+	if pf.keyNonce == nil {
+		return nil
+	}
+	_, ps, err := s.fileKeys(id, pf)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrMasterSecretMismatch, err)
+	}
+	if pf.Ps.Equal(new(ristretto.Element).ScalarBaseMult(ps)) != 1 {
+		return ErrMasterSecretMismatch
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package occlude
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	ristretto "github.com/gtank/ristretto255"
+)
+
+// verify that the keys derived from a master secret are reproducible for the
+// same secret, id and nonce, and differ between ids, nonces and secrets; that
+// password files store only the nonce, from which the keys are recomputed at
+// login; and that a file can only be loaded under the same master secret.
+func TestMasterSecret(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+	secret := []byte("this is a test master secret of 32+ bytes")
+	nonce := []byte("this is a test key nonce")
+
+	ks, ps, err := userKeys(secret, testusername, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks2, ps2, err := userKeys(secret, testusername, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ks.Equal(ks2) != 1 || ps.Equal(ps2) != 1 {
+		t.Fatal("derived keys are not reproducible")
+	}
+	if ks.Equal(ps) == 1 {
+		t.Fatal("ks and ps are equal")
+	}
+	other, _, err := userKeys(secret, "another user", nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Equal(ks) == 1 {
+		t.Fatal("two users derived the same key")
+	}
+	other, _, err = userKeys(secret, testusername, []byte("this is another key nonce"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Equal(ks) == 1 {
+		t.Fatal("two nonces derived the same key")
+	}
+	other, _, err = userKeys([]byte("this is another master secret of 32+ bytes"), testusername, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Equal(ks) == 1 {
+		t.Fatal("two master secrets derived the same key")
+	}
+
+	s := NewServer(WithArgon2Params(weakArgon2Params), WithMasterSecret(secret))
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+	pf := s.passwordFiles[testusername]
+	if len(pf.keyNonce) != keyNonceSize || pf.ks != nil || pf.ps != nil {
+		t.Fatal("password file holds keys, or no key nonce")
+	}
+	_, ps, err = userKeys(secret, testusername, pf.keyNonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pf.Ps.Equal(new(ristretto.Element).ScalarBaseMult(ps)) != 1 {
+		t.Fatal("registration did not use the derived keys")
+	}
+	login(t, s, c, testpassword, "")
+
+	data, err := s.MarshalPasswordFile(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, ps.Encode(nil)) {
+		t.Fatal("encoded password file holds the private key")
+	}
+	restored := NewServer(WithArgon2Params(weakArgon2Params), WithMasterSecret(secret))
+	if err := restored.UnmarshalPasswordFile(testusername, data); err != nil {
+		t.Fatal(err)
+	}
+	login(t, restored, c, testpassword, "")
+	for _, s := range []*Server{
+		NewServer(WithMasterSecret([]byte("this is another master secret of 32+ bytes"))),
+		NewServer(),
+	} {
+		if err := s.UnmarshalPasswordFile(testusername, data); !errors.Is(err, ErrMasterSecretMismatch) {
+			t.Fatalf("expected ErrMasterSecretMismatch, got %v", err)
+		}
+	}
+
+	s = NewServer(WithMasterSecret(secret[:minMasterSecretSize-1]))
+	if _, err := s.NewRegistration(testusername); !errors.Is(err, ErrWeakMasterSecret) {
+		t.Fatalf("expected ErrWeakMasterSecret, got %v", err)
+	}
+}
+
+// verify that NewRegistration for a registered user never reveals the OPRF
+// key of their password file, which with their envelope would allow an
+// offline dictionary attack.
+func TestMasterSecretNewRegistration(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+	secret := []byte("this is a test master secret of 32+ bytes")
+
+	for _, opts := range [][]ServerOption{
+		{WithMasterSecret(secret)},
+		{WithMasterSecret(secret), WithPeppers(1, map[uint32][]byte{1: []byte("this is a test pepper of 32+ bytes")})},
+	} {
+		s := NewServer(append(opts, WithArgon2Params(weakArgon2Params))...)
+		register(t, s, NewClient(testusername), testusername, testpassword)
+		pf := s.passwordFiles[testusername]
+		ks, _, err := s.fileKeys(testusername, pf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored, err := s.oprfKey(ks, pf.pepperEpoch, testusername)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			pr, err := s.NewRegistration(testusername)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pr.ks.Equal(stored) == 1 || pr.ks.Equal(ks) == 1 {
+				t.Fatal("NewRegistration revealed the stored OPRF key")
+			}
+		}
+	}
+}
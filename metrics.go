@@ -0,0 +1,53 @@
+package occlude
+
+import (
+	"fmt"
+	"io"
+)
+
+// serverStats are the cumulative counters a Server exports with WriteMetrics.
+type serverStats struct {
+	loginSuccesses      uint64
+	loginFailures       uint64
+	rateLimitRejections uint64
+	lateRegistrations   uint64
+
+	// corruptPasswordFiles counts corrupt password files rejected on load.
+	corruptPasswordFiles uint64
+
+	// clockWarnings counts clock checks which found the clock implausible.
+	clockWarnings uint64
+}
+
+// metric is a single metric in the Prometheus text exposition format.
+type metric struct {
+	name  string
+	kind  string
+	help  string
+	value uint64
+}
+
+// WriteMetrics writes the Server's metrics to w in the Prometheus text
+// exposition format, suitable for serving from a /metrics handler. The metrics
+// are aggregates only, and never identify individual users.
+func (s *Server) WriteMetrics(w io.Writer) error {
+	s.mu.Lock()
+	metrics := []metric{
+		{"occlude_registered_users", "gauge", "Number of registered users.", uint64(len(s.passwordFiles))},
+		{"occlude_pending_registrations", "gauge", "Number of registrations awaiting Register.", uint64(len(s.pendingRegistrations))},
+		{"occlude_login_successes_total", "counter", "Number of logins whose client verification succeeded.", s.stats.loginSuccesses},
+		{"occlude_login_failures_total", "counter", "Number of logins which failed.", s.stats.loginFailures},
+		{"occlude_rate_limit_rejections_total", "counter", "Number of requests rejected by rate limiting.", s.stats.rateLimitRejections},
+		{"occlude_late_registrations_total", "counter", "Number of registrations accepted within the grace period after their TTL.", s.stats.lateRegistrations},
+		{"occlude_corrupt_password_files_total", "counter", "Number of password files rejected as corrupt when loaded.", s.stats.corruptPasswordFiles},
+		{"occlude_clock_warnings_total", "counter", "Number of clock checks which found the clock implausible.", s.stats.clockWarnings},
+	}
+	s.mu.Unlock()
+
+	for _, m := range metrics {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", m.name, m.help, m.name, m.kind, m.name, m.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package occlude
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// parseMetrics parses Prometheus text exposition output into a map of metric
+// values, failing the test on any malformed line.
+func parseMetrics(t *testing.T, output string) map[string]float64 {
+	t.Helper()
+	metrics := make(map[string]float64)
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "# HELP ") || strings.HasPrefix(line, "# TYPE ") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			t.Fatal("malformed metric line:", line)
+		}
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			t.Fatal("malformed metric value:", line)
+		}
+		metrics[fields[0]] = value
+	}
+	return metrics
+}
+
+// verify that WriteMetrics produces parseable output with the expected
+// metrics, and no user identifiers.
+func TestWriteMetrics(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer(WithStrictVerification())
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+	if _, err := s.NewRegistration("another user"); err != nil {
+		t.Fatal(err)
+	}
+	cv := login(t, s, c, testpassword, "")
+	if _, err := s.FinishSession(cv); err != nil {
+		t.Fatal(err)
+	}
+	unregistered, err := NewClient("unregistered").NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.NewSession(unregistered); err == nil {
+		t.Fatal("login succeeded for an unregistered user")
+	}
+
+	var buf bytes.Buffer
+	if err := s.WriteMetrics(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), testusername) || strings.Contains(buf.String(), "another user") {
+		t.Fatal("metrics contain a user id")
+	}
+	metrics := parseMetrics(t, buf.String())
+	for name, expected := range map[string]float64{
+		"occlude_registered_users":             1,
+		"occlude_pending_registrations":        1,
+		"occlude_login_successes_total":        1,
+		"occlude_login_failures_total":         1,
+		"occlude_rate_limit_rejections_total":  0,
+		"occlude_late_registrations_total":     0,
+		"occlude_corrupt_password_files_total": 0,
+	} {
+		value, exists := metrics[name]
+		if !exists {
+			t.Fatal("missing metric", name)
+		}
+		if value != expected {
+			t.Fatalf("expected %v = %v, got %v", name, expected, value)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+// Package occludepb contains protocol buffer definitions of the occlude
+// protocol messages, for interoperability with systems standardized on
+// protobuf. Convert to and from the occlude types with their ToProto and
+// FromProto methods.
+package occludepb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative occlude.proto
@@ -29,15 +29,69 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/subtle"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"hash"
+	"sync"
+	"time"
 
 	"golang.org/x/crypto/sha3"
 
 	ristretto "github.com/gtank/ristretto255"
 )
 
+var (
+	// ErrInvalidSignature is returned by the Client when it pins a server
+	// public key and the SvrSession is not signed by it.
+	ErrInvalidSignature = errors.New("invalid server signature on session")
+
+	// ErrUnsupportedVersion is returned when a SvrSession requests a key
+	// derivation Version that is not known to this implementation.
+	ErrUnsupportedVersion = errors.New("unsupported protocol version")
+
+	// ErrNilMessage is returned when a protocol method is passed a nil
+	// message, or a message with a nil group element.
+	ErrNilMessage = errors.New("nil message")
+
+	// ErrAuthenticationFailed is returned by Client.SessionKey when the
+	// server could not be authenticated: either the password is incorrect,
+	// or the SvrSession was not produced from the user's password file.
+	ErrAuthenticationFailed = errors.New("server authentication failed")
+
+	// ErrServerAuthFailed is an alias of ErrAuthenticationFailed.
+	ErrServerAuthFailed = ErrAuthenticationFailed
+)
+
+// Version identifies the scheme used to derive the session key SK and the
+// confirmation keys fk1 and fk2 from the shared secret. Applications which
+// store data under, or derive long-lived secrets from, SK should record the
+// Version alongside it so they can detect when the derivation has changed.
+type Version uint8
+
+const (
+	// Version1 is the original derivation, which separates SK, fk1 and fk2
+	// with single-byte labels.
+	Version1 Version = 1
+
+	// Version2 additionally binds the Version into each derived key.
+	Version2 Version = 2
+
+	// Version3 is Version2, additionally proving to the client that Beta
+	// was computed with the user's OPRF key, with the SvrSession's
+	// OPRFProof. The proof binds Beta to the SvrSession's OPRFKey, which the
+	// server chooses, so a client should pin the key committed to at
+	// registration WithOPRFKey.
+	Version3 Version = 3
+
+	// DefaultVersion is the Version used by a Server unless configured
+	// otherwise with WithVersion.
+	DefaultVersion = Version1
+)
+
 // TODO:
 // - Think more about session identifiers and potential attacks here.
 // - Add support for an extra round to mutually authenticate server and client.
@@ -54,18 +108,61 @@ type (
 	// server public key, private key pair and random scalar `ks` can be used in
 	// the registration process.
 	pendingRegistration struct {
-		ks *ristretto.Scalar
-		Ps *ristretto.Element
-		ps *ristretto.Scalar
+		ks     *ristretto.Scalar
+		Ps     *ristretto.Element
+		ps     *ristretto.Scalar
+		scheme Scheme
+
+		// replace is set when the registration was started by
+		// FinishRecovery or NewUpgrade, and may replace the user's existing
+		// password file, as long as it still has the digest expected.
+		replace  bool
+		expected []byte
+
+		// legacy is set when the registration was started by
+		// NewLegacyRegistration, and replaces the user's legacy password hash.
+		legacy bool
+
+		// created is when the registration was started.
+		created time.Time
+
+		// pepperEpoch is the pepper epoch the registration is sealed under.
+		// ks is the stored key, without the pepper.
+		pepperEpoch uint32
+
+		// cancelToken authenticates a RegistrationCancellation for the
+		// registration. It is also set in the copy returned to the client.
+		cancelToken []byte
+
+		// keyNonce is the nonce ks and ps were derived with from the
+		// Server's master secret, if it is configured WithMasterSecret.
+		keyNonce []byte
 	}
 
 	// Registration is a request from the Client to register a new username. The
 	// username is specified by Username, and the client supplies some
-	// authCiphertext as well as their public key.
+	// authCiphertext as well as their public key. PasswordPrefix is the
+	// optional PasswordHashPrefix of the password, for servers which check it
+	// against a PasswordDenylist. IdempotencyKey is an optional token chosen by
+	// the client: resubmitting a Registration that has already succeeded with
+	// the same IdempotencyKey succeeds again, so a client whose Register
+	// response was lost can safely retry. Argon2 are the parameters the client
+	// hardened the OPRF output with, which are authenticated by the
+	// authCiphertext. SecondFactor is the optional verifier of the client's
+	// second factor, set WithSecondFactor. StrengthScore is the client's own
+	// estimate of the password's strength, set WithStrengthEstimator, for
+	// servers configured WithMinStrengthScore.
 	Registration struct {
-		ID  string
-		aci authCiphertext
-		Pu  *ristretto.Element
+		ID             string
+		aci            authCiphertext
+		Pu             *ristretto.Element
+		Argon2         Argon2Params
+		PasswordPrefix string
+		IdempotencyKey string
+		SecondFactor   *ristretto.Element
+		StrengthScore  uint8
+		recovery       *recoveryEnvelope
+		appData        *ChunkedEnvelope
 	}
 
 	// pwdFile is the data stored by the server used to authenticate new user
@@ -78,42 +175,162 @@ type (
 	// hash and not exposed to anyone except for the server. `occlude` uses
 	// Argon2id to derive the OPRF key, so in practice dictionary attacks will be
 	// very costly.
+	//
+	// ks and ps are nil if the file has a key nonce, and are instead derived
+	// from the Server's master secret whenever they are needed.
 	pwdFile struct {
 		ks *ristretto.Scalar
 		ps *ristretto.Scalar
 		Ps *ristretto.Element
 		Pu *ristretto.Element
 		c  authCiphertext
+
+		// scheme is the Scheme the file was bound to at registration.
+		scheme Scheme
+
+		// idempotencyKey is the IdempotencyKey of the Registration that
+		// created the file, if any.
+		idempotencyKey string
+
+		// recovery is the user's recovery envelope, if they registered one.
+		recovery *recoveryEnvelope
+
+		// appData is the user's ChunkedEnvelope, if they registered one with
+		// NewRegistrationStreaming.
+		appData *ChunkedEnvelope
+
+		// secondFactor is the verifier of the user's second factor, if they
+		// registered one.
+		secondFactor *ristretto.Element
+
+		// envelopes are the user's application data Envelopes, by label.
+		envelopes map[string]Envelope
+
+		// pepperEpoch is the epoch of the pepper mixed into ks, or 0 if
+		// none is.
+		pepperEpoch uint32
+
+		// sequence is the login sequence number of the last finished login,
+		// for a Server configured WithLoginSequence.
+		sequence uint64
+
+		// sessionEpoch is the number of times the user has changed their
+		// password, for a Server configured WithSessionEpochs.
+		sessionEpoch uint64
+
+		// keyNonce is the nonce ks and ps are derived with from the
+		// Server's master secret, or nil if they were generated at random.
+		keyNonce []byte
 	}
 
 	// UsrSession is sent by a client who wants to log in and create a session to
-	// the Server.
+	// the Server. Envelope optionally names the Envelope to return with the
+	// SvrSession. Sequence is the login sequence number the client last saw,
+	// for a Server configured WithLoginSequence.
 	UsrSession struct {
-		Alpha *ristretto.Element
-		Xu    *ristretto.Element
-		Sid   string
+		Alpha    *ristretto.Element
+		Xu       *ristretto.Element
+		Sid      string
+		Envelope string
+		Sequence uint64
+
+		// channelBinding is the channel binding each side supplied locally
+		// for the login, with NewSessionWithChannelBinding. It is never
+		// encoded.
+		channelBinding []byte
 	}
 
 	// SvrSession is the server's response to the session initiation by the Client.
+	// Version is the key derivation Version the session key is derived with,
+	// and TranscriptHash the hash used to compute the shared secret. Argon2
+	// are the parameters the client must harden the OPRF output with. Envelope
+	// is the Envelope requested by the UsrSession, if it exists.
+	// Signature is a signature by the server's IdentityKey over the UsrSession
+	// and the rest of the SvrSession. Xu echoes the client's ephemeral key
+	// from the UsrSession being responded to, so that the client can reject
+	// a stale or misrouted response before running the OPRF. Argon2Upgrade,
+	// if set, asks the client to re-harden the user's envelope with stronger
+	// Argon2Params, for a Server configured WithArgon2UpgradeOnLogin.
+	// Sequence is the login sequence number the login takes once finished,
+	// for a Server configured WithLoginSequence. SessionEpoch is the user's
+	// session epoch, for a Server configured WithSessionEpochs. From
+	// Version3, OPRFKey commits to the user's OPRF key, and OPRFProof proves
+	// that Beta was computed from the client's Alpha with it, so that the
+	// client can reject a forged Beta before running Argon2.
 	SvrSession struct {
-		Beta *ristretto.Element
-		Xs   *ristretto.Element
-		fk1  []byte
-		c    authCiphertext
+		Version        Version
+		TranscriptHash TranscriptHash
+		Argon2         Argon2Params
+		Beta           *ristretto.Element
+		Xs             *ristretto.Element
+		fk1            []byte
+		c              authCiphertext
+		Envelope       *Envelope
+		Signature      []byte
+		Xu             *ristretto.Element
+		Argon2Upgrade  *Argon2Params
+		Sequence       uint64
+		SessionEpoch   uint64
+		OPRFKey        *ristretto.Element
+		OPRFProof      []byte
 	}
 
+	// ClientVerification is sent by the client after a successful SessionKey to
+	// prove to the Server that it derived the same shared secret.
 	ClientVerification struct {
 		ID  string
 		FK2 []byte
 	}
 
+	// serverSession is the state the server keeps for a login which has been
+	// initiated with NewSession but not yet verified with FinishSession.
+	serverSession struct {
+		sk      []byte
+		fk2     []byte
+		created time.Time
+
+		// request and response are the UsrSession the login was started
+		// with and the SvrSession sent in reply, which is sent again if the
+		// client retries the same request.
+		request  *UsrSession
+		response *SvrSession
+
+		// rawSecret is the shared secret K, kept only if the Server was
+		// configured WithAllowRawSecret.
+		rawSecret []byte
+
+		// sequence is the login sequence number the user's password file
+		// takes once the login is finished.
+		sequence uint64
+
+		// unknown is set if the login is against the dummy password file
+		// of a user with no password file.
+		unknown bool
+	}
+
+	// passwordCheck is the state the server keeps for a password check which
+	// has been started with NewPasswordCheck but not yet completed.
+	passwordCheck struct {
+		alpha   *ristretto.Element
+		beta    *ristretto.Element
+		nonce   []byte
+		created time.Time
+
+		// unknown is set if the check runs against a dummy password file,
+		// whose public key Pu the proof is checked with.
+		unknown bool
+		Pu      *ristretto.Element
+	}
+
 	// authCiphertext is a simple struct which encodes an arbitrary-length
 	// ciphertext with its associated MAC tag. In OPAQUE, we require a stronger
 	// assumption than what is given by traditional AEAD modes ("key committal"),
-	// so we use AES-CTR with an HMAC-SHA3 MAC.
+	// so we use AES-CTR with an HMAC-SHA3 MAC. Salt is the random salt its
+	// keys were derived with.
 	authCiphertext struct {
 		Tag        []byte
 		Ciphertext []byte
+		Salt       []byte
 	}
 
 	// ciphertextData is the structure of the plaintext that is encrypted to
@@ -126,181 +343,1321 @@ type (
 
 	// Server is the server in the OPAQUE protocol.
 	Server struct {
+		mu                   sync.Mutex
 		passwordFiles        map[string]pwdFile
 		pendingRegistrations map[string]pendingRegistration
+		sessions             map[string]serverSession
+		passwordChecks       map[string]passwordCheck
+		recoveries           map[string][]byte
+		legacyUsers          map[string]LegacyVerifier
+
+		// identity is the server's long-term keypair, shared by all users.
+		identity *IdentityKey
+
+		// context is the deployment context bound into the protocol, set
+		// with WithContext.
+		context []byte
+
+		// normalizeUsernames is set by WithUsernameNormalization, and
+		// usernamePolicy by WithUsernamePolicy.
+		normalizeUsernames bool
+		usernamePolicy     UsernamePolicy
+
+		// stats are the counters exported by WriteMetrics.
+		stats serverStats
+
+		// enumerationKey, if set, is the secret dummy password files for
+		// unknown users are derived from.
+		enumerationKey []byte
+
+		// schemes counts the password files bound to each Scheme, so that
+		// dummy password files can be bound to the Schemes in use.
+		schemes map[Scheme]int
+
+		// pseudonymKey is the secret per-realm pseudonyms are derived from.
+		pseudonymKey []byte
+
+		// peppers are the Server's peppers by epoch, and pepperEpoch the
+		// epoch new registrations are sealed under.
+		peppers     map[uint32][]byte
+		pepperEpoch uint32
+
+		// masterSecret, if set, is the secret each user's keys are derived
+		// from.
+		masterSecret []byte
+
+		// storeKey, if set, is the key encoded password files are encrypted
+		// under.
+		storeKey []byte
+
+		// allowRawSecret releases the shared secret K from
+		// FinishSessionRaw.
+		allowRawSecret bool
+
+		// upgradeArgon2OnLogin asks clients to re-harden envelopes bound to
+		// Argon2Params weaker than the active Scheme's.
+		upgradeArgon2OnLogin bool
+
+		// loginSequence enforces login sequence numbers in each UsrSession.
+		loginSequence bool
+
+		// sessionEpochs binds each session key to the user's session epoch.
+		sessionEpochs bool
+
+		// maxAppDataSize is the largest ChunkedEnvelope Register accepts.
+		maxAppDataSize int
+
+		// minStrengthScore is the lowest Registration.StrengthScore
+		// accepted by Register.
+		minStrengthScore uint8
+
+		// clockWarning, if set, is called when the clock is found
+		// implausible, and clockPolicy determines whether time-based checks
+		// are then enforced. clockUntrusted is the result of the most recent
+		// check, made at lastClockCheck.
+		clockWarning   func(now time.Time)
+		clockPolicy    ClockPolicy
+		clockUntrusted bool
+		lastClockCheck time.Time
+
+		// registrationThrottle, if set, limits registrations per source.
+		registrationThrottle Throttle
+
+		// auditSink receives the Server's audit events. If auditChain is
+		// set they are hash chained, and auditHead is the Hash of the last
+		// event.
+		auditSink  AuditSink
+		auditChain bool
+		auditHead  []byte
+
+		// now returns the current time, and sessionTTL is how long a login
+		// may remain unfinished.
+		now        func() time.Time
+		sessionTTL time.Duration
+
+		// closing is set by Shutdown, after which new operations are refused.
+		// inflight is the number of operations in progress, and idle, if set,
+		// is closed by the last of them to finish once Shutdown is waiting.
+		closing  bool
+		inflight int
+		idle     chan struct{}
+
+		// sweepInterval, if set, is how often the background sweeper calls
+		// Sweep. stopSweeper stops it, and sweeperDone is closed when it has
+		// exited.
+		sweepInterval time.Duration
+		stopSweeper   chan struct{}
+		sweeperDone   chan struct{}
+
+		// registrationTTL is how long a registration may remain unfinished,
+		// and registrationGrace how much longer Register still accepts it.
+		registrationTTL   time.Duration
+		registrationGrace time.Duration
+
+		// strict withholds the session key from NewSession until the client
+		// has been verified by FinishSession.
+		strict bool
+
+		// scheme is the Scheme new registrations are bound to.
+		scheme Scheme
+
+		// denylist, if set, is consulted for each Registration's
+		// PasswordPrefix.
+		denylist PasswordDenylist
+
+		// role is the set of operations the Server performs.
+		role serverRole
+
+		// timingObserver, if set, receives the timing of each login step.
+		timingObserver TimingObserver
+
+		// onAuthenticated, if set, is called after each successful client
+		// verification.
+		onAuthenticated func(id string, sessionKey []byte) error
+
+		// failures, if set, injects failures into the Server's operations,
+		// configured WithFailureInjection.
+		failures *failureInjector
 	}
 
+	// ServerOption configures optional behavior of a Server.
+	ServerOption func(*Server)
+
 	// Client is the client in the OPAQUE protocol.
+	//
+	// A Client is safe for concurrent use by multiple goroutines, but it holds
+	// the state of a single login at a time: each call to NewSession replaces
+	// the state of any previous one, so SessionKey only succeeds for the
+	// response to the most recent NewSession. Concurrent logins should each
+	// use their own Client.
 	Client struct {
 		Sid string
-		xu  *ristretto.Scalar
-		r   *ristretto.Scalar
+
+		mu      sync.Mutex
+		xu      *ristretto.Scalar
+		r       *ristretto.Scalar
+		session *UsrSession
+
+		// rw, exportKey and envelopeData are the password-derived key, the
+		// export key and the requested Envelope's data from the most recent
+		// successful registration or login. sealedEnvelope is the requested
+		// Envelope instead, if it was sealed with SealWithExportKey.
+		rw             []byte
+		exportKey      []byte
+		envelopeData   []byte
+		sealedEnvelope *Envelope
+
+		// onOPRFStart and onOPRFEnd, if set, are called before and after the
+		// client computes the Argon2-hardened OPRF output.
+		onOPRFStart func()
+		onOPRFEnd   func()
+
+		// passwordPrefixLength is the length of the PasswordHashPrefix to
+		// include in each Registration, or zero to include none.
+		passwordPrefixLength int
+
+		// serverKey, if set, is the pinned public key of the server. The
+		// client rejects any SvrSession not signed by it before spending any
+		// work on the OPRF.
+		serverKey *ristretto.Element
+
+		// oprfKey is the commitment to the user's OPRF key learned from
+		// the most recent Version3 registration or login. pinnedOPRFKey,
+		// if set, is the commitment the client requires every SvrSession
+		// to prove Beta against, and requireOPRFProof is set if it
+		// requires a proof at all.
+		oprfKey          *ristretto.Element
+		pinnedOPRFKey    *ristretto.Element
+		requireOPRFProof bool
+
+		// minArgon2 is the cheapest Argon2Params the Client will accept from
+		// the server.
+		minArgon2 Argon2Params
+
+		// argon2, if set, are the Argon2Params the Client registers with in
+		// place of the server's.
+		argon2 Argon2Params
+
+		// context is the deployment context bound into the protocol, set
+		// with WithClientContext.
+		context []byte
+
+		// pinnedScheme, if set, is the Scheme the Client requires every
+		// login to use.
+		pinnedScheme *Scheme
+
+		// secondFactor, if set, is the private scalar of the Client's second
+		// factor, set WithSecondFactor.
+		secondFactor *ristretto.Scalar
+
+		// envelopeFactor, if set, is the key derived from the secret of the
+		// factor the Client's envelope is split across, set
+		// WithEnvelopeFactor.
+		envelopeFactor []byte
+
+		// livenessKey is the key which verifies the server's liveness
+		// proofs, from the most recent successful registration or login, and
+		// livenessNonce the nonce of the LivenessChallenge in progress.
+		livenessKey   []byte
+		livenessNonce []byte
+
+		// rwCacheTTL, if set, is how long rw may be reused by SessionKey,
+		// and rwCache records what it was computed for. now returns the
+		// current time.
+		rwCacheTTL time.Duration
+		rwCache    *rwCacheEntry
+		now        func() time.Time
+
+		// passwordPolicy, if set, decides which passwords may be
+		// registered.
+		passwordPolicy PasswordPolicy
+
+		// strengthEstimator, if set, computes the StrengthScore of each
+		// Registration.
+		strengthEstimator func(password string) uint8
+
+		// selfCheck opens each envelope sealed by NewRegistration before
+		// returning it.
+		selfCheck bool
+
+		// allowRawSecret releases the shared secret K in each LoginResult.
+		allowRawSecret bool
+
+		// sequence is the login sequence number last seen by the Client, for
+		// servers configured WithLoginSequence.
+		sequence uint64
 	}
+
+	// ClientOption configures optional behavior of a Client.
+	ClientOption func(*Client)
 )
 
+// WithServerKey pins the server's long-term public key, as returned by
+// Server.PublicKey. The Client will then reject, before running the expensive
+// OPRF, any SvrSession that is not signed by the corresponding IdentityKey.
+func WithServerKey(pub *ristretto.Element) ClientOption {
+	return func(c *Client) {
+		c.serverKey = pub
+	}
+}
+
+// WithOPRFCallbacks configures callbacks which the Client calls immediately
+// before and after it computes the Argon2-hardened OPRF output during
+// NewRegistration and SessionKey. Argon2 dominates the cost of both, so the
+// callbacks can be used, for example, to display a progress indicator. Either
+// callback may be nil.
+func WithOPRFCallbacks(onStart func(), onEnd func()) ClientOption {
+	return func(c *Client) {
+		c.onOPRFStart = onStart
+		c.onOPRFEnd = onEnd
+	}
+}
+
 // NewClient creates a new OPAQUE client using the provided id.
-func NewClient(id string) *Client {
-	return &Client{
+func NewClient(id string, opts ...ClientOption) *Client {
+	c := &Client{
 		Sid: id,
+		now: time.Now,
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// hashPassword returns H(password). It is recomputed at each step of a
+// registration or login, rather than cached, so that the Client never holds
+// the password, nor anything it could be checked against, between the steps.
+// Only a Client configured WithRWCache keeps the hash, for the TTL of its
+// cache.
+//
+// Every key derived from the password is derived from this fixed length hash,
+// never from the password itself, so that passwords of any length keep all of
+// their entropy even if a KDF which truncates its input, as bcrypt does at 72
+// bytes, is used in place of Argon2. Only the legacy verifiers of
+// LegacyVerifier and the PasswordHashPrefix see the password as it was typed.
+func hashPassword(password string) [64]byte {
+	b := []byte(password)
+	defer clear(b)
+	return sha3.Sum512(b)
+}
+
+// oprf computes the OPRF output with f, hardened with the Argon2Params p,
+// calling the Client's OPRF callbacks around it. It returns ErrBusy, without
+// calling f, if the Argon2 memory ceiling would be exceeded.
+func (c *Client) oprf(p Argon2Params, f func() []byte) ([]byte, error) {
+	if err := acquireArgon2Memory(p); err != nil {
+		return nil, err
+	}
+	defer releaseArgon2Memory(p)
+	if c.onOPRFStart != nil {
+		c.onOPRFStart()
+	}
+	if c.onOPRFEnd != nil {
+		defer c.onOPRFEnd()
+	}
+	return bindContext(c.context, "rw", f()), nil
+}
+
+// Close erases the secret state held by the Client, including its export key
+// and the ephemeral keys of any login in progress. The Client may still be
+// used afterwards, but must start a new login.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.rw)
+	clear(c.exportKey)
+	clear(c.envelopeData)
+	clear(c.livenessKey)
+	c.rw = nil
+	c.rwCache = nil
+	c.exportKey = nil
+	c.envelopeData = nil
+	c.sealedEnvelope = nil
+	c.livenessKey = nil
+	c.livenessNonce = nil
+	c.xu = nil
+	c.r = nil
+	c.session = nil
+	return nil
 }
 
 // NewSession creates a new UsrSession using the provided password.
 func (c *Client) NewSession(password string) (*UsrSession, error) {
+	return c.NewSessionWithEnvelope(password, "")
+}
+
+// NewSessionWithEnvelope creates a new UsrSession using the provided password,
+// which also requests the Envelope named label. Its data is available from
+// EnvelopeData after a successful SessionKey.
+func (c *Client) NewSessionWithEnvelope(password string, label string) (*UsrSession, error) {
 	xu := randomScalar()
 	Xu := new(ristretto.Element).ScalarBaseMult(xu)
 
-	x := sha3.Sum512([]byte(password))
+	x := hashPassword(password)
 	Alpha := new(ristretto.Element).FromUniformBytes(x[:])
 	r := randomScalar()
+	if err := checkRandomness(xu, r); err != nil {
+		return nil, err
+	}
 	Alpha.ScalarMult(r, Alpha)
 
+	session := &UsrSession{
+		Alpha:    Alpha,
+		Xu:       Xu,
+		Sid:      c.Sid,
+		Envelope: label,
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	session.Sequence = c.sequence
 	c.xu = xu
 	c.r = r
+	c.session = session
 
-	return &UsrSession{
-		Alpha: Alpha,
-		Xu:    Xu,
-		Sid:   c.Sid,
-	}, nil
+	return session, nil
+}
+
+// WithStrictVerification configures the Server to withhold the session key
+// from NewSession. The key is only returned by FinishSession once the client
+// has proven that it derived the same shared secret, so the server never acts
+// on an unauthenticated session.
+func WithStrictVerification() ServerOption {
+	return func(s *Server) {
+		s.strict = true
+	}
+}
+
+// WithVersion configures the key derivation Version that new registrations
+// are bound to. Clients follow the Version advertised in the SvrSession.
+func WithVersion(v Version) ServerOption {
+	return func(s *Server) {
+		s.scheme.Version = v
+	}
+}
+
+// WithTranscriptHash configures the TranscriptHash that new registrations are
+// bound to. Clients follow the TranscriptHash advertised in the SvrSession.
+func WithTranscriptHash(h TranscriptHash) ServerOption {
+	return func(s *Server) {
+		s.scheme.TranscriptHash = h
+	}
 }
 
 // NewServer creates a new server.
-func NewServer() *Server {
-	return &Server{
+func NewServer(opts ...ServerOption) *Server {
+	s := &Server{
 		passwordFiles:        make(map[string]pwdFile),
 		pendingRegistrations: make(map[string]pendingRegistration),
+		sessions:             make(map[string]serverSession),
+		passwordChecks:       make(map[string]passwordCheck),
+		recoveries:           make(map[string][]byte),
+		legacyUsers:          make(map[string]LegacyVerifier),
+		schemes:              make(map[Scheme]int),
+		scheme:               DefaultScheme,
+		now:                  time.Now,
+		sessionTTL:           DefaultSessionTTL,
+		registrationTTL:      DefaultRegistrationTTL,
+		registrationGrace:    DefaultRegistrationGrace,
+		maxAppDataSize:       DefaultMaxAppDataSize,
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	if s.identity == nil {
+		s.identity = GenerateIdentityKey()
+	}
+	s.checkClock()
+	if s.sweepInterval > 0 {
+		s.startSweeper()
+	}
+	return s
 }
 
 // Register a new user with the server. NOTE: this step of the
 // protocol should be executed over a secure, authenticated and
 // confidential medium such as TLS.
 func (s *Server) NewRegistration(sid string) (*pendingRegistration, error) {
-	ks := randomScalar()
-	ps := randomScalar()
+	return s.NewRegistrationFrom("", sid)
+}
+
+// NewRegistrationFrom is NewRegistration for a request from source, such as
+// the client's IP address, which is checked against the Server's registration
+// Throttle.
+func (s *Server) NewRegistrationFrom(source string, sid string) (*pendingRegistration, error) {
+	done, err := s.permitRegistration()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	if err := s.throttleRegistration(source); err != nil {
+		return nil, err
+	}
+	id := s.userID(sid)
+	if err := s.checkUsername(id); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.newPendingRegistration(id, false)
+}
+
+// newPendingRegistration starts a registration for sid bound to the Server's
+// active Scheme. If replace is set, the registration may replace the user's
+// existing password file. The caller must hold s.mu.
+func (s *Server) newPendingRegistration(sid string, replace bool) (*pendingRegistration, error) {
+	if !s.scheme.supported() {
+		return nil, ErrUnsupportedScheme
+	}
+	ks, ps := randomScalar(), randomScalar()
+	pepperEpoch := s.pepperEpoch
+	var keyNonce []byte
+	if s.masterSecret != nil {
+		keyNonce = make([]byte, keyNonceSize)
+		if _, err := rand.Read(keyNonce); err != nil {
+			return nil, err
+		}
+		var err error
+		if ks, ps, err = userKeys(s.masterSecret, sid, keyNonce); err != nil {
+			return nil, err
+		}
+		pepperEpoch = 0
+	}
+	if err := checkRandomness(ks, ps); err != nil {
+		return nil, err
+	}
+	oprfKey, err := s.oprfKey(ks, pepperEpoch, sid)
+	if err != nil {
+		return nil, err
+	}
+	cancelToken := make([]byte, cancelTokenSize)
+	if _, err := rand.Read(cancelToken); err != nil {
+		return nil, err
+	}
 	Ps := new(ristretto.Element).ScalarBaseMult(ps)
+	var expected []byte
+	if replace {
+		expected = s.fileDigest(sid)
+	}
 	s.pendingRegistrations[sid] = pendingRegistration{
-		ks: ks,
-		Ps: Ps,
-		ps: ps,
+		ks:          ks,
+		Ps:          Ps,
+		ps:          ps,
+		scheme:      s.scheme,
+		replace:     replace,
+		expected:    expected,
+		created:     s.now(),
+		pepperEpoch: pepperEpoch,
+		cancelToken: cancelToken,
+		keyNonce:    keyNonce,
 	}
-	return &pendingRegistration{ks: ks, Ps: Ps}, nil
+	return &pendingRegistration{ks: oprfKey, Ps: Ps, scheme: s.scheme, cancelToken: cancelToken}, nil
 }
 
 // Register creates a new registration in the server using the
 // provided details.
 func (s *Server) Register(reg *Registration) error {
-	pendingRegistration, exists := s.pendingRegistrations[reg.ID]
+	return s.RegisterFrom("", reg)
+}
+
+// RegisterFrom is Register for a request from source, which is checked
+// against the Server's registration Throttle.
+func (s *Server) RegisterFrom(source string, reg *Registration) error {
+	done, err := s.permitRegistration()
+	if err != nil {
+		return err
+	}
+	defer done()
+	if err := s.throttleRegistration(source); err != nil {
+		return err
+	}
+	if reg == nil || reg.Pu == nil {
+		return ErrNilMessage
+	}
+	id := s.userID(reg.ID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if pf, exists := s.passwordFiles[id]; exists && reg.IdempotencyKey != "" &&
+		subtle.ConstantTimeCompare([]byte(pf.idempotencyKey), []byte(reg.IdempotencyKey)) == 1 {
+		return nil
+	}
+	pendingRegistration, exists := s.pendingRegistrations[id]
 	if !exists {
 		return errors.New("no pending registration")
 	}
-	defer delete(s.pendingRegistrations, reg.ID)
-	if _, exists = s.passwordFiles[reg.ID]; exists {
+	defer delete(s.pendingRegistrations, id)
+	age := s.now().Sub(pendingRegistration.created)
+	if s.registrationExpired(pendingRegistration, s.now()) {
+		return errors.New("registration expired")
+	}
+	if _, exists = s.passwordFiles[id]; exists && !pendingRegistration.replace {
+		return errors.New("user already registered")
+	}
+	if _, exists = s.legacyUsers[id]; exists && !pendingRegistration.legacy {
 		return errors.New("user already registered")
 	}
+	if !reg.Argon2.supported() {
+		return ErrUnsupportedScheme
+	}
+	if reg.Argon2.weakerThan(pendingRegistration.scheme.Argon2) {
+		return ErrWeakRegistrationParams
+	}
+	if reg.StrengthScore < s.minStrengthScore {
+		return ErrPasswordTooWeak
+	}
+	if reg.appData != nil && reg.appData.size() > s.maxAppDataSize {
+		return ErrAppDataTooLarge
+	}
+	if s.denylist != nil {
+		if reg.PasswordPrefix == "" {
+			return fmt.Errorf("%w: PasswordPrefix", ErrMissingField)
+		}
+		if s.denylist.Compromised(reg.PasswordPrefix) {
+			return ErrCompromisedPassword
+		}
+	}
 	pf := pwdFile{
-		ks: pendingRegistration.ks,
-		ps: pendingRegistration.ps,
-		Ps: pendingRegistration.Ps,
-		Pu: reg.Pu,
-		c:  reg.aci,
+		ks:     pendingRegistration.ks,
+		ps:     pendingRegistration.ps,
+		Ps:     pendingRegistration.Ps,
+		Pu:     reg.Pu,
+		c:      reg.aci,
+		scheme: pendingRegistration.scheme,
+
+		idempotencyKey: reg.IdempotencyKey,
+		recovery:       reg.recovery,
+		appData:        reg.appData,
+		secondFactor:   reg.SecondFactor,
+		pepperEpoch:    pendingRegistration.pepperEpoch,
+		keyNonce:       pendingRegistration.keyNonce,
+	}
+	pf.scheme.Argon2 = reg.Argon2
+	if pf.keyNonce != nil {
+		pf.ks, pf.ps = nil, nil
 	}
-	s.passwordFiles[reg.ID] = pf
+	if err := pf.validate(); err != nil {
+		return err
+	}
+	if pendingRegistration.replace {
+		pf.sessionEpoch = s.passwordFiles[id].sessionEpoch
+		if s.sessionEpochs {
+			pf.sessionEpoch++
+		}
+		if err := s.compareAndSwap(id, pendingRegistration.expected, pf); err != nil {
+			return err
+		}
+	} else {
+		s.setPasswordFile(id, pf)
+	}
+	delete(s.legacyUsers, id)
+	if age > s.registrationTTL {
+		s.stats.lateRegistrations++
+	}
+	s.audit(AuditRegistration, id)
+	return nil
+}
+
+// Deregister deletes the user id, together with any registration, login,
+// password check or recovery in progress for them. The id may then be
+// registered again.
+func (s *Server) Deregister(id string) error {
+	done, err := s.permitRegistration()
+	if err != nil {
+		return err
+	}
+	defer done()
+	id = s.userID(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, registered := s.passwordFiles[id]
+	_, legacy := s.legacyUsers[id]
+	if !registered && !legacy {
+		return errors.New("no such sid")
+	}
+	s.deletePasswordFile(id)
+	delete(s.legacyUsers, id)
+	delete(s.pendingRegistrations, id)
+	delete(s.sessions, id)
+	delete(s.passwordChecks, id)
+	delete(s.recoveries, id)
+	s.audit(AuditDeregistration, id)
 	return nil
 }
 
 func (c *Client) NewRegistration(sinfo *pendingRegistration, username string, password string) (*Registration, error) {
+	return c.newRegistration(sinfo, username, password, nil)
+}
+
+// newRegistration implements NewRegistration, additionally sealing a
+// recovery envelope under recoverySecret if it is set.
+func (c *Client) newRegistration(sinfo *pendingRegistration, username string, password string, recoverySecret []byte) (*Registration, error) {
+	if err := c.CheckPassword(password); err != nil {
+		return nil, err
+	}
 	pu := randomScalar()
+	if err := checkRandomness(pu); err != nil {
+		return nil, err
+	}
 	Pu := new(ristretto.Element).ScalarBaseMult(pu)
 
-	x := sha3.Sum512([]byte(password))
-	rw := oprfA(x[:], sinfo.ks)
-
-	// Use AES-CTR with HMAC and a separate HMAC key as a wrapping function, since
-	// key-committing property is desired.
-	hmacKey, cipherKey := deriveHKDFKeys(rw)
-
-	block, err := aes.NewCipher(cipherKey)
+	params := sinfo.scheme.Argon2
+	if c.argon2 != (Argon2Params{}) {
+		params = c.argon2
+	}
+	if err := c.checkArgon2Params(params); err != nil {
+		return nil, err
+	}
+	x := hashPassword(password)
+	rw, err := c.passwordRW(params, func() []byte { return oprfA(params, x[:], sinfo.ks) })
 	if err != nil {
 		return nil, err
 	}
-	iv := make([]byte, block.BlockSize())
-	ctr := cipher.NewCTR(block, iv)
-	authHmac := hmac.New(sha3.New256, hmacKey)
 
 	//	c←AuthEncrw(pu,Pu,Ps);
 	toencrypt, err := json.Marshal(&ciphertextData{pu: pu, Pu: Pu, Ps: sinfo.Ps})
 	if err != nil {
 		return nil, err
 	}
+	aci, err := sealEnvelope(rw, passwordFileAD(params), toencrypt)
+	if err != nil {
+		return nil, err
+	}
+	if c.selfCheck {
+		if err := selfCheckEnvelope(rw, params, aci, toencrypt, Pu); err != nil {
+			return nil, err
+		}
+	}
 
-	ctext := make([]byte, len(toencrypt))
-	ctr.XORKeyStream(ctext, toencrypt)
-	tag := authHmac.Sum(ctext)
+	exportKey := deriveExportKey(rw)
+	c.mu.Lock()
+	c.rw = rw
+	c.exportKey = exportKey
+	c.livenessKey = livenessKey(c.context, new(ristretto.Element).ScalarMult(pu, sinfo.Ps))
+	if sinfo.scheme.Version >= Version3 {
+		c.oprfKey = new(ristretto.Element).ScalarBaseMult(sinfo.ks)
+	}
+	c.mu.Unlock()
 
-	aci := authCiphertext{
-		Tag:        tag,
-		Ciphertext: ctext,
+	reg := &Registration{
+		ID:     username,
+		aci:    aci,
+		Pu:     Pu,
+		Argon2: params,
+	}
+	if c.secondFactor != nil {
+		reg.SecondFactor = new(ristretto.Element).ScalarBaseMult(c.secondFactor)
 	}
+	if recoverySecret != nil {
+		reg.recovery, err = sealRecoveryEnvelope(recoverySecret, params, exportKey, toencrypt)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.passwordPrefixLength > 0 {
+		reg.PasswordPrefix = PasswordHashPrefix(password, c.passwordPrefixLength)
+	}
+	if c.strengthEstimator != nil {
+		reg.StrengthScore = c.strengthEstimator(password)
+	}
+	return reg, nil
+}
 
-	return &Registration{
-		username,
-		aci,
-		Pu,
-	}, nil
+// ExportKey returns the export key derived by the Client's most recent
+// successful NewRegistration or SessionKey, or nil if there was none. The
+// export key is a secret known only to the client, derived from the password
+// and stable across logins, which applications can use to protect data that
+// must never be readable by the server.
+func (c *Client) ExportKey() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]byte(nil), c.exportKey...)
 }
 
+// NewSession responds to a client's login request. It returns the SvrSession
+// to send to the client along with the session key SK. If the Server was
+// created WithStrictVerification, SK is nil and must instead be obtained from
+// FinishSession.
 func (s *Server) NewSession(session *UsrSession) (*SvrSession, []byte, error) {
-	pf, exist := s.passwordFiles[session.Sid]
-	if !exist {
-		return nil, nil, errors.New("no such sid")
+	done, err := s.permitAuthentication()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer done()
+	return s.newSession(session, randomScalar())
+}
+
+// newSession implements NewSession using the server ephemeral key xs. It is
+// unexported so that only tests can supply a fixed xs, to reconstruct a
+// captured session exactly when debugging a failed login.
+func (s *Server) newSession(session *UsrSession, xs *ristretto.Scalar) (*SvrSession, []byte, error) {
+	svrSession, sess, err := s.startSession(session, xs)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.strict {
+		return svrSession, nil, nil
 	}
+	return svrSession, sess.sk, nil
+}
+
+// startSession runs the server's half of the key exchange for session with
+// the ephemeral key xs, and records the resulting serverSession for
+// FinishSession.
+func (s *Server) startSession(session *UsrSession, xs *ristretto.Scalar) (*SvrSession, serverSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.startSessionLocked(session, xs, false)
+}
+
+// startSessionLocked implements startSession. If probe is set, the login is
+// a probe, started by NewProbeSession, which is neither recorded for
+// FinishSession nor has any other effect on the Server. The caller must hold
+// s.mu.
+func (s *Server) startSessionLocked(session *UsrSession, xs *ristretto.Scalar, probe bool) (*SvrSession, serverSession, error) {
+	if session == nil || session.Alpha == nil || session.Xu == nil {
+		id := ""
+		if session != nil {
+			id = s.userID(session.Sid)
+		}
+		if !probe {
+			s.auditFailure(id, ReasonMalformed)
+		}
+		return nil, serverSession{}, ErrNilMessage
+	}
+	if err := checkRandomness(xs); err != nil {
+		return nil, serverSession{}, err
+	}
+	timer := s.startTimer(LoginStepNewSession)
+	id := s.userID(session.Sid)
+	if prev, exists := s.sessions[id]; exists && !probe && prev.retriedBy(session) && !s.sessionExpired(prev, s.now()) {
+		return prev.response, prev, nil
+	}
+	pf, unknown, err := s.loginPasswordFile(id, probe)
+	if err != nil {
+		return nil, serverSession{}, err
+	}
+	if !pf.scheme.supported() {
+		return nil, serverSession{}, ErrUnsupportedScheme
+	}
+	if err := s.checkSequence(session, pf); err != nil {
+		if !probe {
+			s.auditFailure(id, ReasonReplayed)
+		}
+		return nil, serverSession{}, err
+	}
+
+	storedKs, ps, err := s.fileKeys(id, pf)
+	if err != nil {
+		return nil, serverSession{}, err
+	}
+	ks, err := s.oprfKey(storedKs, pf.pepperEpoch, id)
+	if err != nil {
+		return nil, serverSession{}, err
+	}
+	_, stored := s.passwordFiles[id]
+	s.repepper(id, pf, ks, stored && !probe)
+
+	timer.lap(phaseStore)
+
+	beta := new(ristretto.Element).ScalarMult(ks, session.Alpha)
+	timer.lap(phaseOPRF)
 
-	xs := randomScalar()
 	Xs := new(ristretto.Element).ScalarBaseMult(xs)
-	beta := new(ristretto.Element).ScalarMult(pf.ks, session.Alpha)
 
-	K := keServer(pf.ps, xs, pf.Pu, session.Xu)
-	SK := prf(K, []byte{0})
-	fk1 := prf(K, []byte{1})
+	sc := keScratchPool.Get().(*keScratch)
+	K := bindContext(s.context, "K", keServerScratch(sc, nil, pf.scheme.TranscriptHash, ps, xs, pf.Pu, session.Xu))
+	keScratchPool.Put(sc)
+	if pf.secondFactor != nil {
+		K = bindSecondFactor(K, new(ristretto.Element).ScalarMult(xs, pf.secondFactor))
+	}
+	K = bindChannel(K, session.channelBinding)
+	var epoch uint64
+	if s.sessionEpochs {
+		epoch = pf.sessionEpoch
+	}
+	K = bindSessionEpoch(K, epoch)
+	SK, fk1, fk2, err := deriveSessionKeys(pf.scheme.Version, pf.scheme.TranscriptHash, K)
+	if err != nil {
+		return nil, serverSession{}, err
+	}
+	svrSession := &SvrSession{
+		Version:        pf.scheme.Version,
+		TranscriptHash: pf.scheme.TranscriptHash,
+		Argon2:         pf.scheme.Argon2,
+		Beta:           beta,
+		Xs:             Xs,
+		c:              pf.c,
+		fk1:            fk1,
+		Xu:             session.Xu,
+		SessionEpoch:   epoch,
+	}
+	if pf.scheme.Version >= Version3 {
+		svrSession.OPRFKey, svrSession.OPRFProof = proveOPRF(ks, session.Alpha, beta)
+	}
+	if s.loginSequence {
+		svrSession.Sequence = pf.sequence + 1
+	}
+	if env, exists := pf.envelopes[session.Envelope]; exists && session.Envelope != "" {
+		svrSession.Envelope = &env
+	}
+	if _, stored := s.passwordFiles[id]; (stored || unknown) && !probe && s.shouldUpgradeArgon2(pf) {
+		target := s.scheme.Argon2
+		svrSession.Argon2Upgrade = &target
+	}
+	svrSession.Signature = sign(s.identity.priv, s.identity.pub, sessionTranscript(s.context, session, svrSession))
+	sess := serverSession{sk: SK, fk2: fk2, created: s.now(), request: session, response: svrSession, sequence: svrSession.Sequence, unknown: unknown}
+	if s.allowRawSecret {
+		sess.rawSecret = K
+	}
+	if probe {
+		return svrSession, sess, nil
+	}
+	s.sessions[id] = sess
+	timer.lap(phaseAKE)
+	s.observeTiming(timer)
+	return svrSession, sess, nil
+}
+
+// loginPasswordFile returns the password file a login for the user id runs
+// against: the user's own or, on a Server configured
+// WithUserEnumerationProtection, a dummy file for a user who has none, in
+// which case unknown is set. A login for a user with no password file is
+// counted and audited as a failure, unless it is a probe. The caller must
+// hold s.mu.
+func (s *Server) loginPasswordFile(id string, probe bool) (pf pwdFile, unknown bool, err error) {
+	pf, exist := s.passwordFiles[id]
+	_, legacy := s.legacyUsers[id]
+	if s.enumerationKey != nil {
+		// The dummy file is derived whether or not the user exists, so that
+		// both cases do the same work.
+		dummy := s.dummyPasswordFile(id)
+		if !exist && !legacy {
+			return dummy, true, nil
+		}
+	}
+	if exist {
+		return pf, false, nil
+	}
+	if !probe {
+		s.stats.loginFailures++
+		if legacy {
+			s.auditFailure(id, ReasonLegacyUser)
+		} else {
+			s.auditFailure(id, ReasonUnknownUser)
+		}
+	}
+	if legacy {
+		return pwdFile{}, false, ErrLegacyUser
+	}
+	return pwdFile{}, false, errors.New("no such sid")
+}
+
+// FinishSession verifies the client's proof that it derived the same shared
+// secret as the server for the session started by NewSession, and returns the
+// session key SK.
+func (s *Server) FinishSession(cv *ClientVerification) ([]byte, error) {
+	done, err := s.permitAuthentication()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	sess, err := s.finishSession(cv)
+	if err != nil {
+		return nil, err
+	}
+	return sess.sk, nil
+}
+
+// finishSession implements FinishSession, returning the finished
+// serverSession.
+func (s *Server) finishSession(cv *ClientVerification) (serverSession, error) {
+	if cv == nil {
+		return serverSession{}, ErrNilMessage
+	}
+	id := s.userID(cv.ID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	timer := s.startTimer(LoginStepFinishSession)
+	sess, exists := s.sessions[id]
+	if !exists {
+		s.auditFailure(id, ReasonNoSession)
+		return serverSession{}, errors.New("no session in progress")
+	}
+	delete(s.sessions, id)
+	if err := s.completeSession(id, sess, cv.FK2, timer); err != nil {
+		return serverSession{}, err
+	}
+	return sess, nil
+}
 
-	return &SvrSession{Beta: beta, Xs: Xs, c: pf.c, fk1: fk1}, SK, nil
+// completeSession finishes the login sess of the user id, which the caller has
+// removed from the Server's session state, if fk2 matches the client's: it
+// calls the WithOnAuthenticated hook, advances the login sequence number, and
+// records the outcome in the Server's metrics, audit log and timing. The
+// caller must hold s.mu.
+func (s *Server) completeSession(id string, sess serverSession, fk2 []byte, timer *loginTimer) error {
+	timer.lap(phaseStore)
+	if err := s.checkVerification(id, sess, fk2, false); err != nil {
+		return err
+	}
+	if err := s.authenticated(id, sess.sk); err != nil {
+		return err
+	}
+	timer.lap(phaseAKE)
+	s.advanceSequence(id, sess.sequence)
+	timer.lap(phaseStore)
+	s.stats.loginSuccesses++
+	s.audit(AuditLoginSuccess, id)
+	s.observeTiming(timer)
+	return nil
+}
+
+// checkVerification checks that the login sess of the user id has not
+// expired, and that fk2 matches the client's. A failure is counted and
+// audited, unless the login is a probe. The caller must hold s.mu.
+func (s *Server) checkVerification(id string, sess serverSession, fk2 []byte, probe bool) error {
+	if s.sessionExpired(sess, s.now()) {
+		if !probe {
+			s.stats.loginFailures++
+			s.auditFailure(id, ReasonSessionExpired)
+		}
+		return errors.New("session expired")
+	}
+	if subtle.ConstantTimeCompare(sess.fk2, fk2) != 1 {
+		if !probe {
+			s.stats.loginFailures++
+			s.auditFailure(id, verificationFailure(sess.unknown))
+		}
+		return errors.New("client verification failed")
+	}
+	return nil
+}
+
+// overlapKeyExchange is whether SessionKey computes the ephemeral products of
+// the key exchange concurrently with the OPRF. It is a variable so that
+// benchmarks can compare both.
+var overlapKeyExchange = true
+
+// ephemeralProducts are the client's Diffie-Hellman products with the
+// server's ephemeral key Xs that do not depend on the envelope: xu·Xs, and
+// t·Xs with the second factor t, if the Client has one.
+type ephemeralProducts struct {
+	xuXs         *ristretto.Element
+	secondFactor *ristretto.Element
+}
+
+func (c *Client) ephemeralProducts(xu *ristretto.Scalar, Xs *ristretto.Element) ephemeralProducts {
+	p := ephemeralProducts{xuXs: new(ristretto.Element).ScalarMult(xu, Xs)}
+	if c.secondFactor != nil {
+		p.secondFactor = new(ristretto.Element).ScalarMult(c.secondFactor, Xs)
+	}
+	return p
 }
 
-func (c *Client) SessionKey(session *SvrSession, password string) ([]byte, []byte, error) {
-	x := sha3.Sum512([]byte(password))
-	rw := oprfB(session.Beta, c.r, x)
+// FinishLogin completes the client's half of the login for the SvrSession
+// sent in response to the Client's most recent NewSession, authenticating the
+// server, and returns the outputs of the login as a LoginResult. The
+// ClientConfirmation must be sent to the server, for example with
+// LoginResult.Verification, for it to authenticate the client.
+func (c *Client) FinishLogin(session *SvrSession, password string) (*LoginResult, error) {
+	if session == nil || session.Beta == nil || session.Xs == nil {
+		return nil, ErrNilMessage
+	}
+	if err := session.checkWellFormed(); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	xu, r, usrSession := c.xu, c.r, c.session
+	c.mu.Unlock()
+	if usrSession == nil {
+		return nil, errors.New("no session in progress")
+	}
+	if err := checkFresh(usrSession, session); err != nil {
+		return nil, err
+	}
+
+	if c.serverKey != nil && !verify(c.serverKey, sessionTranscript(c.context, usrSession, session), session.Signature) {
+		return nil, ErrInvalidSignature
+	}
+	if err := c.checkPinnedScheme(session); err != nil {
+		return nil, err
+	}
+	if !session.Version.supported() {
+		return nil, ErrUnsupportedVersion
+	}
+	if !session.TranscriptHash.supported() {
+		return nil, ErrUnsupportedTranscriptHash
+	}
+	if err := c.checkArgon2Params(session.Argon2); err != nil {
+		return nil, err
+	}
+	if err := c.verifyOPRF(session, usrSession.Alpha); err != nil {
+		return nil, err
+	}
+
+	// The products with Xs which do not depend on the envelope are computed
+	// while the OPRF output is hardened. The channel is buffered, so that the
+	// goroutine never blocks if the OPRF fails.
+	products := make(chan ephemeralProducts, 1)
+	if overlapKeyExchange {
+		go func() { products <- c.ephemeralProducts(xu, session.Xs) }()
+	} else {
+		products <- c.ephemeralProducts(xu, session.Xs)
+	}
+
+	x := hashPassword(password)
+	rw, cached := c.cachedRW(x, session.Argon2)
+	var caData []byte
+	var err error
+	if cached {
+		caData, err = openEnvelope(rw, passwordFileAD(session.Argon2), session.c)
+		cached = err == nil
+	}
+	if !cached {
+		rw, err = c.passwordRW(session.Argon2, func() []byte { return oprfB(session.Argon2, session.Beta, r, x) })
+		if err != nil {
+			return nil, err
+		}
+		caData, err = openEnvelope(rw, passwordFileAD(session.Argon2), session.c)
+	}
+	ephemeral := <-products
+
+	// A failed MAC, whether from a wrong password or a tampered envelope,
+	// does not return early: the key exchange runs with placeholder keys, so
+	// that it takes as long as, and is indistinguishable from, a failed fk1
+	// check.
+	var ca ciphertextData
+	if err == nil {
+		err = json.Unmarshal(caData, &ca)
+	}
+	opened := err == nil
+	if !opened {
+		ca = ciphertextData{pu: xu, Ps: session.Xs}
+	} else if err := ca.checkPu(); err != nil {
+		// The envelope opened, so the password was correct, and failing
+		// early reveals nothing about it.
+		return nil, err
+	}
 
-	hmacKey, cipherKey := deriveHKDFKeys(rw)
+	K := bindContext(c.context, "K", keUserWithEphemeral(session.TranscriptHash, ca.pu, xu, ca.Ps, session.Xs, ephemeral.xuXs))
+	if c.secondFactor != nil {
+		K = bindSecondFactor(K, ephemeral.secondFactor)
+	}
+	K = bindChannel(K, usrSession.channelBinding)
+	K = bindSessionEpoch(K, session.SessionEpoch)
+	SK, fk1, fk2, err := deriveSessionKeys(session.Version, session.TranscriptHash, K)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(fk1, session.fk1) != 1 || !opened {
+		return nil, ErrAuthenticationFailed
+	}
+	var envelopeData []byte
+	var sealedEnvelope *Envelope
+	if session.Envelope != nil && session.Envelope.ExportKeyed {
+		sealedEnvelope = session.Envelope
+	} else if session.Envelope != nil {
+		envelopeData, err = openLabeledEnvelope(rw, session.Envelope)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	upgrade, err := c.argon2Upgrade(usrSession.Sid, session, r, x, caData)
+	if err != nil {
+		return nil, err
+	}
+
+	var rawSecret []byte
+	if c.allowRawSecret {
+		rawSecret = K
+	}
+	exportKey := deriveExportKey(rw)
+	c.mu.Lock()
+	c.rw = rw
+	c.exportKey = exportKey
+	c.envelopeData = envelopeData
+	c.sealedEnvelope = sealedEnvelope
+	if session.OPRFKey != nil {
+		c.oprfKey = session.OPRFKey
+	}
+	if !cached && c.rwCacheTTL > 0 {
+		c.rwCache = &rwCacheEntry{passwordHash: x, argon2: session.Argon2, created: c.now()}
+	}
+	c.livenessKey = livenessKey(c.context, new(ristretto.Element).ScalarMult(ca.pu, ca.Ps))
+	if session.Sequence != 0 {
+		c.sequence = session.Sequence
+	}
+	c.mu.Unlock()
+	return &LoginResult{
+		ID:                 usrSession.Sid,
+		SessionKey:         SK,
+		ClientConfirmation: fk2,
+		ExportKey:          append([]byte(nil), exportKey...),
+		ServerStaticKey:    ca.Ps,
+		Scheme:             Scheme{Version: session.Version, TranscriptHash: session.TranscriptHash, Argon2: session.Argon2},
+		EnvelopeData:       append([]byte(nil), envelopeData...),
+		SealedEnvelope:     sealedEnvelope,
+		RawSecret:          rawSecret,
+		Argon2Upgrade:      upgrade,
+		IssuedAt:           c.now(),
+		SessionEpoch:       session.SessionEpoch,
+	}, nil
+}
+
+// sealEnvelope encrypts and authenticates plaintext under keys derived from the
+// OPRF output `rw`. AES-CTR with HMAC and a separate HMAC key is used as the
+// wrapping function, since the key-committing property is desired. The tag
+// also authenticates the associated data ad, which is not encrypted.
+//
+// The keys are derived from `rw` and a random salt stored with the
+// ciphertext, with a fixed IV, since the user's envelope must be opened at
+// every login from the password alone: there is no per-login contribution to
+// them. A leaked pair of keys therefore opens, and forges, the envelope they
+// were derived for, at every login, until the user re-registers. It opens
+// nothing else: the salt makes the keys of every sealing unrelated, even
+// under the same `rw`, and the session keys are derived from the key
+// exchange, not from `rw`. The fixed IV is safe because each key seals a
+// single plaintext.
+func sealEnvelope(rw []byte, ad []byte, plaintext []byte) (authCiphertext, error) {
+	salt := make([]byte, envelopeSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return authCiphertext{}, err
+	}
+	hmacKey, cipherKey := envelopeKeys(rw, salt)
 	block, err := aes.NewCipher(cipherKey)
 	if err != nil {
-		return nil, nil, err
+		return authCiphertext{}, err
 	}
 	iv := make([]byte, block.BlockSize())
 	ctr := cipher.NewCTR(block, iv)
 	authHmac := hmac.New(sha3.New256, hmacKey)
 
-	if subtle.ConstantTimeCompare(authHmac.Sum(session.c.Ciphertext), session.c.Tag) != 1 {
-		return nil, nil, errors.New("invalid hmac tag on server-sent c")
-	}
+	ctext := make([]byte, len(plaintext))
+	ctr.XORKeyStream(ctext, plaintext)
+	tag := envelopeTag(authHmac, ad, ctext)
 
-	var ca ciphertextData
-	caData := make([]byte, len(session.c.Ciphertext))
-	ctr.XORKeyStream(caData, session.c.Ciphertext)
-	err = json.Unmarshal(caData, &ca)
+	return authCiphertext{
+		Tag:        tag,
+		Ciphertext: ctext,
+		Salt:       salt,
+	}, nil
+}
+
+// openEnvelope authenticates and decrypts an envelope sealed with sealEnvelope
+// under the same `rw` and associated data ad.
+func openEnvelope(rw []byte, ad []byte, c authCiphertext) ([]byte, error) {
+	hmacKey, cipherKey := envelopeKeys(rw, c.Salt)
+	block, err := aes.NewCipher(cipherKey)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
+	iv := make([]byte, block.BlockSize())
+	ctr := cipher.NewCTR(block, iv)
+	authHmac := hmac.New(sha3.New256, hmacKey)
 
-	K := keUser(ca.pu, c.xu, ca.Ps, session.Xs)
-	SK := prf(K, []byte{0})
-	fk1 := prf(K, []byte{1})
-	if subtle.ConstantTimeCompare(fk1, session.fk1) != 1 {
-		return nil, nil, errors.New("server authentication failed")
+	if subtle.ConstantTimeCompare(envelopeTag(authHmac, ad, c.Ciphertext), c.Tag) != 1 {
+		return nil, errors.New("invalid hmac tag on server-sent c")
+	}
+
+	plaintext := make([]byte, len(c.Ciphertext))
+	ctr.XORKeyStream(plaintext, c.Ciphertext)
+	return plaintext, nil
+}
+
+// envelopeKeys derives the authentication and cipher keys of an envelope
+// sealed with salt from `rw`.
+func envelopeKeys(rw []byte, salt []byte) (authKey []byte, cipherKey []byte) {
+	keys := deriveKeys(rw, salt, []keySpec{
+		{[]byte("occlude envelope auth key"), 32},
+		{[]byte("occlude envelope cipher key"), 32},
+	})
+	return keys[0], keys[1]
+}
+
+// envelopeTag computes the tag over the associated data and ciphertext of an
+// envelope. The associated data is length-prefixed so that bytes cannot be
+// moved between it and the ciphertext.
+func envelopeTag(mac hash.Hash, ad []byte, ciphertext []byte) []byte {
+	mac.Write(appendLengthPrefixed(nil, ad))
+	mac.Write(ciphertext)
+	return mac.Sum(nil)
+}
+
+// passwordFileAD is the associated data the user's envelope is sealed with. It
+// binds the Argon2Params the client registered with, so that a password file
+// whose parameters have been substituted fails to open at login.
+func passwordFileAD(p Argon2Params) []byte {
+	return appendLengthPrefixed([]byte("occlude password file"), p.encode())
+}
+
+// sessionTranscript encodes the deployment context, the client's login request
+// and the server's response, excluding the server's signature, for signing by
+// the server.
+func sessionTranscript(context []byte, u *UsrSession, v *SvrSession) []byte {
+	var transcript []byte
+	for _, field := range [][]byte{
+		[]byte("occlude session"),
+		context,
+		{byte(v.Version), byte(v.TranscriptHash)},
+		v.Argon2.encode(),
+		u.Alpha.Encode(nil),
+		u.Xu.Encode(nil),
+		[]byte(u.Sid),
+		[]byte(u.Envelope),
+		v.Beta.Encode(nil),
+		v.Xs.Encode(nil),
+		v.fk1,
+		v.c.Tag,
+		v.c.Ciphertext,
+	} {
+		transcript = appendLengthPrefixed(transcript, field)
+	}
+	if v.Envelope != nil {
+		for _, field := range [][]byte{
+			[]byte(v.Envelope.Label),
+			v.Envelope.Salt,
+			v.Envelope.c.Tag,
+			v.Envelope.c.Ciphertext,
+		} {
+			transcript = appendLengthPrefixed(transcript, field)
+		}
+		if v.Envelope.ExportKeyed {
+			transcript = appendLengthPrefixed(transcript, []byte("export keyed"))
+		}
+	}
+	if u.Sequence != 0 || v.Sequence != 0 {
+		var sequences [16]byte
+		binary.BigEndian.PutUint64(sequences[:8], u.Sequence)
+		binary.BigEndian.PutUint64(sequences[8:], v.Sequence)
+		transcript = appendLengthPrefixed(transcript, []byte("sequence"))
+		transcript = appendLengthPrefixed(transcript, sequences[:])
+	}
+	if v.Argon2Upgrade != nil {
+		transcript = appendLengthPrefixed(transcript, []byte("argon2 upgrade"))
+		transcript = appendLengthPrefixed(transcript, v.Argon2Upgrade.encode())
+	}
+	if v.SessionEpoch != 0 {
+		var epoch [8]byte
+		binary.BigEndian.PutUint64(epoch[:], v.SessionEpoch)
+		transcript = appendLengthPrefixed(transcript, []byte("session epoch"))
+		transcript = appendLengthPrefixed(transcript, epoch[:])
 	}
-	fk2 := prf(K, []byte{2})
-	return SK, fk2, nil
+	if v.OPRFKey != nil {
+		transcript = appendLengthPrefixed(transcript, []byte("oprf proof"))
+		transcript = appendLengthPrefixed(transcript, v.OPRFKey.Encode(nil))
+		transcript = appendLengthPrefixed(transcript, v.OPRFProof)
+	}
+	return transcript
+}
+
+// appendLengthPrefixed appends field to b, prefixed with its length, so that
+// a sequence of fields has an unambiguous encoding.
+func appendLengthPrefixed(b []byte, field []byte) []byte {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(field)))
+	b = append(b, length[:]...)
+	return append(b, field...)
 }
 
 func (c *ciphertextData) MarshalJSON() ([]byte, error) {
@@ -325,16 +1682,24 @@ func (c *ciphertextData) UnmarshalJSON(data []byte) error {
 	if err := json.Unmarshal(data, &encoded); err != nil {
 		return err
 	}
-	return func() error {
-		c.Pu = new(ristretto.Element)
-		if err := c.Pu.Decode(encoded.Pu); err != nil {
-			return err
-		}
-		c.pu = new(ristretto.Scalar)
-		if err := c.pu.Decode(encoded.Puscalar); err != nil {
-			return err
-		}
-		c.Ps = new(ristretto.Element)
-		return c.Ps.Decode(encoded.Ps)
-	}()
+	Pu, err := group.element(encoded.Pu)
+	if err != nil {
+		return err
+	}
+	if err := checkNonIdentity("Pu", Pu); err != nil {
+		return err
+	}
+	pu, err := group.scalar(encoded.Puscalar)
+	if err != nil {
+		return err
+	}
+	Ps, err := group.element(encoded.Ps)
+	if err != nil {
+		return err
+	}
+	if err := checkNonIdentity("Ps", Ps); err != nil {
+		return err
+	}
+	c.pu, c.Pu, c.Ps = pu, Pu, Ps
+	return nil
 }
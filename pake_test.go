@@ -2,7 +2,11 @@ package occlude
 
 import (
 	"bytes"
+	"strings"
+	"sync"
 	"testing"
+
+	"golang.org/x/crypto/sha3"
 )
 
 // verify that a username can be registered.
@@ -80,3 +84,680 @@ func TestLogin(t *testing.T) {
 	}
 
 }
+
+// register registers username with password on s using c, failing the test on
+// any error.
+func register(t *testing.T, s *Server, c *Client, username string, password string) {
+	t.Helper()
+	pr, err := s.NewRegistration(username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err := c.NewRegistration(pr, username, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register(reg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// login runs a full login for c against s, requesting the Envelope named
+// label, and returns the client's verification.
+func login(t *testing.T, s *Server, c *Client, password string, label string) *ClientVerification {
+	t.Helper()
+	sess, err := c.NewSessionWithEnvelope(password, label)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err := s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, fk2, err := c.SessionKey(svrsess, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &ClientVerification{ID: sess.Sid, FK2: fk2}
+}
+
+// verify that resubmitting a successful Registration with the same
+// IdempotencyKey succeeds, while a different or missing key is still rejected.
+func TestRegisterIdempotencyKey(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername)
+	pr, err := s.NewRegistration(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err := c.NewRegistration(pr, testusername, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg.IdempotencyKey = "first attempt"
+	if err := s.Register(reg); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register(reg); err != nil {
+		t.Fatal("retry with the same IdempotencyKey failed:", err)
+	}
+	login(t, s, c, testpassword, "")
+
+	pr, err = s.NewRegistration(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewClient(testusername).NewRegistration(pr, testusername, "another password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"second attempt", ""} {
+		other.IdempotencyKey = key
+		if err := s.Register(other); err == nil {
+			t.Fatalf("registration with IdempotencyKey %q replaced the existing user", key)
+		}
+	}
+	login(t, s, c, testpassword, "")
+}
+
+// verify that in strict mode the server only releases the session key after
+// the client has been verified.
+func TestStrictVerification(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer(WithStrictVerification())
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, sessionKey, err := s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sessionKey != nil {
+		t.Fatal("strict server returned a session key before client verification")
+	}
+	clientSessionKey, fk2, err := c.SessionKey(svrsess, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a bad verification must not release the key, and consumes the session.
+	badfk2 := append([]byte(nil), fk2...)
+	badfk2[0] ^= 0xff
+	if _, err := s.FinishSession(&ClientVerification{ID: testusername, FK2: badfk2}); err == nil {
+		t.Fatal("FinishSession accepted an invalid client verification")
+	}
+	if _, err := s.FinishSession(&ClientVerification{ID: testusername, FK2: fk2}); err == nil {
+		t.Fatal("FinishSession succeeded after the session was consumed")
+	}
+
+	sess, err = c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err = s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientSessionKey, fk2, err = c.SessionKey(svrsess, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionKey, err = s.FinishSession(&ClientVerification{ID: testusername, FK2: fk2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sessionKey, clientSessionKey) {
+		t.Fatal("client and server did not compute identical session key")
+	}
+}
+
+// verify that a client pinning the server's key rejects SvrSessions that are
+// unsigned or signed by another key, without running Argon2.
+func TestPinnedServerKey(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer()
+	register(t, s, NewClient(testusername), testusername, testpassword)
+
+	argonCalls := 0
+	defer func(f func([]byte, []byte, uint32, uint32, uint8, uint32) []byte) { argon2IDKey = f }(argon2IDKey)
+	idKey := argon2IDKey
+	argon2IDKey = func(password, salt []byte, time, memory uint32, threads uint8, keyLen uint32) []byte {
+		argonCalls++
+		return idKey(password, salt, time, memory, threads, keyLen)
+	}
+
+	impostor := NewServer()
+	c := NewClient(testusername, WithServerKey(s.PublicKey()))
+	for _, tamper := range []func(*SvrSession){
+		func(svrsess *SvrSession) { svrsess.Signature = nil },
+		func(svrsess *SvrSession) { svrsess.Signature[0] ^= 0xff },
+		func(svrsess *SvrSession) {
+			svrsess.Signature = sign(impostor.identity.priv, impostor.identity.pub, sessionTranscript(impostor.context, c.session, svrsess))
+		},
+	} {
+		sess, err := c.NewSession(testpassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		svrsess, _, err := s.NewSession(sess)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tamper(svrsess)
+		if _, _, err := c.SessionKey(svrsess, testpassword); err != ErrInvalidSignature {
+			t.Fatal("expected ErrInvalidSignature, got", err)
+		}
+	}
+	if argonCalls != 0 {
+		t.Fatal("client ran Argon2 before rejecting the server signature")
+	}
+
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, sessionKey, err := s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientSessionKey, _, err := c.SessionKey(svrsess, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sessionKey, clientSessionKey) {
+		t.Fatal("client and server did not compute identical session key")
+	}
+}
+
+// verify that the session's version tag reflects the server's configured
+// version, and that the client rejects unknown versions.
+func TestSessionVersion(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	for _, v := range []Version{Version1, Version2, Version3} {
+		s := NewServer(WithVersion(v))
+		c := NewClient(testusername)
+		register(t, s, c, testusername, testpassword)
+
+		sess, err := c.NewSession(testpassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		svrsess, sessionKey, err := s.NewSession(sess)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if svrsess.Version != v {
+			t.Fatalf("expected version %v, got %v", v, svrsess.Version)
+		}
+		clientSessionKey, _, err := c.SessionKey(svrsess, testpassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(sessionKey, clientSessionKey) {
+			t.Fatal("client and server did not compute identical session key")
+		}
+
+		svrsess.Version = 0xff
+		if _, _, err := c.SessionKey(svrsess, testpassword); err != ErrUnsupportedVersion {
+			t.Fatal("expected ErrUnsupportedVersion, got", err)
+		}
+	}
+
+	s := NewServer(WithVersion(0xff))
+	if _, err := s.NewRegistration(testusername); err != ErrUnsupportedScheme {
+		t.Fatal("expected ErrUnsupportedScheme, got", err)
+	}
+}
+
+// verify that a Client can be shared across goroutines. Run with -race.
+func TestClientConcurrentUse(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer()
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sess, err := c.NewSession(testpassword)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			svrsess, _, err := s.NewSession(sess)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			// interleaved logins may replace each other's state, so only
+			// the absence of races is asserted here.
+			c.SessionKey(svrsess, testpassword)
+		}()
+	}
+	wg.Wait()
+
+	// the client is still usable for a login once the others have finished.
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, sessionKey, err := s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientSessionKey, _, err := c.SessionKey(svrsess, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sessionKey, clientSessionKey) {
+		t.Fatal("client and server did not compute identical session key")
+	}
+}
+
+// verify that the password hash is H(password), differs between passwords,
+// and that the Client keeps no login state after Close.
+func TestPasswordHash(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	x := hashPassword(testpassword)
+	if x != sha3.Sum512([]byte(testpassword)) {
+		t.Fatal("incorrect password hash")
+	}
+	if hashPassword("this is another password") == x {
+		t.Fatal("two passwords have the same hash")
+	}
+
+	c := NewClient(testusername)
+	if _, err := c.NewSession(testpassword); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.SessionKey(&SvrSession{}, testpassword); err == nil {
+		t.Fatal("SessionKey succeeded after Close")
+	}
+}
+
+// BenchmarkHashPassword measures the cost of hashing the password at each step
+// of the handshake: NewSession and SessionKey each hash it once, which is
+// negligible next to the Argon2 hardening of the OPRF output.
+func BenchmarkHashPassword(b *testing.B) {
+	password := "this is a test password"
+	for i := 0; i < b.N; i++ {
+		hashPassword(password)
+		hashPassword(password)
+	}
+}
+
+// benchmarkSessionKey measures SessionKey, with the ephemeral products of the
+// key exchange computed concurrently with the OPRF if overlap is set. The
+// Client has a second factor, so that there are two such products.
+func benchmarkSessionKey(b *testing.B, overlap bool) {
+	defer func(old bool) { overlapKeyExchange = old }(overlapKeyExchange)
+	overlapKeyExchange = overlap
+
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername, WithSecondFactor([]byte("this is a test second factor")))
+	pr, err := s.NewRegistration(testusername)
+	if err != nil {
+		b.Fatal(err)
+	}
+	reg, err := c.NewRegistration(pr, testusername, testpassword)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := s.Register(reg); err != nil {
+		b.Fatal(err)
+	}
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		b.Fatal(err)
+	}
+	svrsess, _, err := s.NewSession(sess)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := c.SessionKey(svrsess, testpassword); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSessionKeySequential(b *testing.B) {
+	benchmarkSessionKey(b, false)
+}
+
+func BenchmarkSessionKeyOverlapped(b *testing.B) {
+	benchmarkSessionKey(b, true)
+}
+
+// verify that the OPRF callbacks fire around the Argon2 computation during
+// both registration and login.
+func TestOPRFCallbacks(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	var events []string
+	defer func(f func([]byte, []byte, uint32, uint32, uint8, uint32) []byte) { argon2IDKey = f }(argon2IDKey)
+	idKey := argon2IDKey
+	argon2IDKey = func(password, salt []byte, time, memory uint32, threads uint8, keyLen uint32) []byte {
+		events = append(events, "argon2")
+		return idKey(password, salt, time, memory, threads, keyLen)
+	}
+
+	s := NewServer()
+	c := NewClient(testusername, WithOPRFCallbacks(
+		func() { events = append(events, "start") },
+		func() { events = append(events, "end") },
+	))
+	register(t, s, c, testusername, testpassword)
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err := s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.SessionKey(svrsess, testpassword); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"start", "argon2", "end", "start", "argon2", "end"}
+	if len(events) != len(expected) {
+		t.Fatalf("expected events %v, got %v", expected, events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Fatalf("expected events %v, got %v", expected, events)
+		}
+	}
+}
+
+// verify that injecting a fixed server ephemeral reproduces a captured
+// SvrSession and session key exactly.
+func TestReplaySession(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer()
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	xs := randomScalar()
+	captured, capturedKey, err := s.newSession(sess, xs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed, replayedKey, err := s.newSession(sess, xs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if captured.Beta.Equal(replayed.Beta) != 1 || captured.Xs.Equal(replayed.Xs) != 1 {
+		t.Fatal("replayed session has different group elements")
+	}
+	if !bytes.Equal(captured.fk1, replayed.fk1) || !bytes.Equal(capturedKey, replayedKey) {
+		t.Fatal("replayed session derived different keys")
+	}
+
+	// the replayed session is a valid response to the captured request.
+	clientSessionKey, _, err := c.SessionKey(replayed, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(clientSessionKey, capturedKey) {
+		t.Fatal("client and server did not compute identical session key")
+	}
+}
+
+// verify that client and server agree under each transcript hash, and that the
+// client rejects an unknown one.
+func TestTranscriptHash(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	for _, h := range []TranscriptHash{TranscriptSHA3_512, TranscriptSHA512} {
+		s := NewServer(WithTranscriptHash(h), WithVersion(Version2))
+		c := NewClient(testusername)
+		register(t, s, c, testusername, testpassword)
+		sess, err := c.NewSession(testpassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		svrsess, sessionKey, err := s.NewSession(sess)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if svrsess.TranscriptHash != h {
+			t.Fatalf("expected transcript hash %v, got %v", h, svrsess.TranscriptHash)
+		}
+		clientSessionKey, _, err := c.SessionKey(svrsess, testpassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(sessionKey, clientSessionKey) {
+			t.Fatal("client and server did not compute identical session key")
+		}
+
+		svrsess.TranscriptHash = 0xff
+		if _, _, err := c.SessionKey(svrsess, testpassword); err != ErrUnsupportedTranscriptHash {
+			t.Fatal("expected ErrUnsupportedTranscriptHash, got", err)
+		}
+	}
+}
+
+// verify that nil messages, and messages with nil elements, are rejected with
+// ErrNilMessage rather than panicking.
+func TestNilMessages(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer()
+	c := NewClient(testusername)
+	if err := s.Register(nil); err != ErrNilMessage {
+		t.Fatal("expected ErrNilMessage, got", err)
+	}
+	if err := s.Register(&Registration{ID: testusername}); err != ErrNilMessage {
+		t.Fatal("expected ErrNilMessage, got", err)
+	}
+	register(t, s, c, testusername, testpassword)
+
+	if _, _, err := s.NewSession(nil); err != ErrNilMessage {
+		t.Fatal("expected ErrNilMessage, got", err)
+	}
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []UsrSession{
+		{Sid: testusername, Xu: sess.Xu},
+		{Sid: testusername, Alpha: sess.Alpha},
+	} {
+		if _, _, err := s.NewSession(&bad); err != ErrNilMessage {
+			t.Fatal("expected ErrNilMessage, got", err)
+		}
+	}
+
+	svrsess, _, err := s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.SessionKey(nil, testpassword); err != ErrNilMessage {
+		t.Fatal("expected ErrNilMessage, got", err)
+	}
+	for _, bad := range []SvrSession{
+		{Version: svrsess.Version, Xs: svrsess.Xs},
+		{Version: svrsess.Version, Beta: svrsess.Beta},
+	} {
+		if _, _, err := c.SessionKey(&bad, testpassword); err != ErrNilMessage {
+			t.Fatal("expected ErrNilMessage, got", err)
+		}
+	}
+	if _, err := s.FinishSession(nil); err != ErrNilMessage {
+		t.Fatal("expected ErrNilMessage, got", err)
+	}
+}
+
+// verify that a login against a password file bound to an unknown scheme fails
+// closed, rather than falling back to the server's defaults.
+func TestUnsupportedScheme(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer()
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+
+	for _, scheme := range []Scheme{
+		{Version: 0xff, TranscriptHash: DefaultTranscriptHash},
+		{Version: DefaultVersion, TranscriptHash: 0xff},
+	} {
+		pf := s.passwordFiles[testusername]
+		pf.scheme = scheme
+		s.passwordFiles[testusername] = pf
+
+		sess, err := c.NewSession(testpassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := s.NewSession(sess); err != ErrUnsupportedScheme {
+			t.Fatal("expected ErrUnsupportedScheme, got", err)
+		}
+	}
+}
+
+// verify that distinct passwords under the same OPRF key derive distinct
+// envelope keys, and that an envelope sealed under one password never opens
+// under another.
+func TestDistinctPasswordsDistinctKeys(t *testing.T) {
+	passwords := []string{
+		"this is a test password",
+		"this is a test password ",
+		"This is a test password",
+		"",
+		"a",
+		"b",
+		"correct horse battery staple",
+		"correct horse battery stapler",
+	}
+
+	ks := randomScalar()
+	rws := make([][]byte, len(passwords))
+	seen := make(map[string]bool)
+	for i, password := range passwords {
+		x := sha3.Sum512([]byte(password))
+		rws[i] = oprfA(DefaultArgon2Params, x[:], ks)
+		authKey, cipherKey := envelopeKeys(rws[i], make([]byte, envelopeSaltSize))
+		for _, key := range [][]byte{authKey, cipherKey} {
+			if seen[string(key)] {
+				t.Fatalf("password %q derived a duplicate envelope key", password)
+			}
+			seen[string(key)] = true
+		}
+	}
+
+	plaintext := []byte("this is a test envelope")
+	for i := range passwords {
+		env, err := sealEnvelope(rws[i], nil, plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j := range passwords {
+			_, err := openEnvelope(rws[j], nil, env)
+			if i == j && err != nil {
+				t.Fatal(err)
+			}
+			if i != j && err == nil {
+				t.Fatalf("envelope for %q opened with %q", passwords[i], passwords[j])
+			}
+		}
+	}
+}
+
+// verify that sealing the same plaintext twice under the same `rw` never
+// repeats a keystream, so that the keys of one sealing are of no use against
+// another, and that the salt is authenticated.
+func TestEnvelopeSalt(t *testing.T) {
+	rw := make([]byte, 64)
+	plaintext := bytes.Repeat([]byte{0}, 64)
+	first, err := sealEnvelope(rw, nil, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := sealEnvelope(rw, nil, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the plaintext is zero, so each ciphertext is its keystream.
+	if bytes.Equal(first.Ciphertext, second.Ciphertext) {
+		t.Fatal("two sealings under the same rw reused a keystream")
+	}
+
+	tampered := first
+	tampered.Salt = second.Salt
+	if _, err := openEnvelope(rw, nil, tampered); err == nil {
+		t.Fatal("opened an envelope with a substituted salt")
+	}
+	tampered.Salt = nil
+	if _, err := openEnvelope(rw, nil, tampered); err == nil {
+		t.Fatal("opened an envelope with its salt removed")
+	}
+}
+
+// verify that long passwords which differ only after their 72nd byte, where
+// bcrypt would truncate them, are distinct credentials.
+func TestLongPasswordsNotTruncated(t *testing.T) {
+	testusername := "this is a test username"
+	prefix := strings.Repeat("long password ", 6)
+	password, other := prefix+"one", prefix+"two"
+	if len(prefix) <= 72 {
+		t.Fatal("passwords do not differ past byte 72")
+	}
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername)
+	register(t, s, c, testusername, password)
+	if hashPassword(password) == hashPassword(other) {
+		t.Fatal("long passwords hashed to the same value")
+	}
+
+	sess, err := c.NewSession(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err := s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.SessionKey(svrsess, other); err != ErrAuthenticationFailed {
+		t.Fatal("expected ErrAuthenticationFailed logging in with the other password, got", err)
+	}
+	login(t, s, c, password, "")
+}
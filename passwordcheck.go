@@ -0,0 +1,214 @@
+package occlude
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	ristretto "github.com/gtank/ristretto255"
+)
+
+// passwordCheckNonceSize is the length of the server's password check
+// challenge, in bytes.
+const passwordCheckNonceSize = 32
+
+// ErrIncorrectPassword is returned by Client.VerifyPassword when the password
+// does not open the user's envelope.
+var ErrIncorrectPassword = errors.New("incorrect password")
+
+type (
+	// PasswordChallenge is the server's response to a password check. It holds
+	// the OPRF evaluation and envelope the client needs to confirm its
+	// password, and a fresh Nonce for the client to prove it to the server.
+	// Argon2 are the parameters the client must harden the OPRF output with.
+	PasswordChallenge struct {
+		Argon2 Argon2Params
+		Beta   *ristretto.Element
+		Nonce  []byte
+		c      authCiphertext
+	}
+
+	// PasswordProof is the client's proof, in response to a
+	// PasswordChallenge, that it knows the user's password. It is a
+	// signature over the challenge by the client's private key `pu`, which
+	// can only be recovered from the envelope using the password.
+	PasswordProof struct {
+		ID        string
+		Signature []byte
+	}
+)
+
+// NewPasswordCheck starts a password check for the user identified by the
+// UsrSession, as created by Client.NewSession. Unlike NewSession, it does not
+// run the key exchange or establish a session key: it is intended for flows
+// such as "confirm your password before changing your email", which only need
+// to confirm that the user knows their password. The UsrSession's Xu is
+// ignored.
+//
+// A password check is subject to the same protections as a login: on a
+// Server configured WithUserEnumerationProtection, a check for an unknown
+// user runs against the user's dummy password file, and fails only at the
+// client. Failed checks are counted and audited as failed logins, and a check
+// must be answered with CheckPasswordProof within the session TTL.
+func (s *Server) NewPasswordCheck(req *UsrSession) (*PasswordChallenge, error) {
+	done, err := s.permitAuthentication()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	if req == nil || req.Alpha == nil {
+		return nil, ErrNilMessage
+	}
+	if err := checkNonIdentity("Alpha", req.Alpha); err != nil {
+		return nil, err
+	}
+	id := s.userID(req.Sid)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pf, unknown, err := s.loginPasswordFile(id, false)
+	if err != nil {
+		return nil, err
+	}
+	if !pf.scheme.supported() {
+		return nil, ErrUnsupportedScheme
+	}
+
+	nonce := make([]byte, passwordCheckNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	storedKs, _, err := s.fileKeys(id, pf)
+	if err != nil {
+		return nil, err
+	}
+	ks, err := s.oprfKey(storedKs, pf.pepperEpoch, id)
+	if err != nil {
+		return nil, err
+	}
+	beta := new(ristretto.Element).ScalarMult(ks, req.Alpha)
+	check := passwordCheck{alpha: req.Alpha, beta: beta, nonce: nonce, created: s.now(), unknown: unknown}
+	if unknown {
+		check.Pu = pf.Pu
+	}
+	s.passwordChecks[id] = check
+
+	return &PasswordChallenge{Argon2: pf.scheme.Argon2, Beta: beta, Nonce: nonce, c: pf.c}, nil
+}
+
+// CheckPasswordProof reports whether the PasswordProof answers the password
+// check started for its user by NewPasswordCheck. The check is consumed
+// whether or not the proof is valid.
+func (s *Server) CheckPasswordProof(proof *PasswordProof) (bool, error) {
+	done, err := s.permitAuthentication()
+	if err != nil {
+		return false, err
+	}
+	defer done()
+	if proof == nil {
+		return false, ErrNilMessage
+	}
+	id := s.userID(proof.ID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	check, exists := s.passwordChecks[id]
+	if !exists {
+		s.auditFailure(id, ReasonNoSession)
+		return false, errors.New("no password check in progress")
+	}
+	delete(s.passwordChecks, id)
+	if s.passwordCheckExpired(check, s.now()) {
+		s.stats.loginFailures++
+		s.auditFailure(id, ReasonSessionExpired)
+		return false, errors.New("password check expired")
+	}
+	Pu := check.Pu
+	if !check.unknown {
+		pf, exists := s.passwordFiles[id]
+		if !exists {
+			s.stats.loginFailures++
+			s.auditFailure(id, ReasonUnknownUser)
+			return false, errors.New("no such sid")
+		}
+		Pu = pf.Pu
+	}
+	msg := passwordCheckTranscript(proof.ID, check.nonce, check.alpha, check.beta)
+	if !verify(Pu, msg, proof.Signature) {
+		s.stats.loginFailures++
+		s.auditFailure(id, verificationFailure(check.unknown))
+		return false, nil
+	}
+	return true, nil
+}
+
+// passwordCheckExpired reports whether check has outlived the session TTL at
+// now. The caller must hold s.mu.
+func (s *Server) passwordCheckExpired(check passwordCheck, now time.Time) bool {
+	return s.enforceTime() && now.Sub(check.created) > s.sessionTTL
+}
+
+// VerifyPassword checks the password against the PasswordChallenge returned
+// by the server for the Client's most recent NewSession. It runs the OPRF and
+// the envelope MAC check, but not the key exchange. If the password is
+// correct it returns a PasswordProof for the server, and otherwise
+// ErrIncorrectPassword.
+func (c *Client) VerifyPassword(challenge *PasswordChallenge, password string) (*PasswordProof, error) {
+	if challenge == nil || challenge.Beta == nil {
+		return nil, ErrNilMessage
+	}
+	if err := checkNonIdentity("Beta", challenge.Beta); err != nil {
+		return nil, err
+	}
+	if len(challenge.Nonce) != passwordCheckNonceSize {
+		return nil, fmt.Errorf("%w: Nonce", ErrMalformedMessage)
+	}
+	if err := challenge.c.validate(); err != nil {
+		return nil, fmt.Errorf("%w: c", ErrMalformedMessage)
+	}
+	c.mu.Lock()
+	r, usrSession := c.r, c.session
+	c.mu.Unlock()
+	if usrSession == nil {
+		return nil, errors.New("no session in progress")
+	}
+
+	if err := c.checkArgon2Params(challenge.Argon2); err != nil {
+		return nil, err
+	}
+
+	x := hashPassword(password)
+	rw, err := c.passwordRW(challenge.Argon2, func() []byte { return oprfB(challenge.Argon2, challenge.Beta, r, x) })
+	if err != nil {
+		return nil, err
+	}
+	caData, err := openEnvelope(rw, passwordFileAD(challenge.Argon2), challenge.c)
+	if err != nil {
+		return nil, ErrIncorrectPassword
+	}
+	var ca ciphertextData
+	if err := json.Unmarshal(caData, &ca); err != nil {
+		return nil, err
+	}
+
+	msg := passwordCheckTranscript(usrSession.Sid, challenge.Nonce, usrSession.Alpha, challenge.Beta)
+	return &PasswordProof{
+		ID:        usrSession.Sid,
+		Signature: sign(ca.pu, ca.Pu, msg),
+	}, nil
+}
+
+// passwordCheckTranscript encodes the password check which the client signs.
+func passwordCheckTranscript(id string, nonce []byte, alpha *ristretto.Element, beta *ristretto.Element) []byte {
+	var transcript []byte
+	for _, field := range [][]byte{
+		[]byte("occlude password check"),
+		[]byte(id),
+		nonce,
+		alpha.Encode(nil),
+		beta.Encode(nil),
+	} {
+		transcript = appendLengthPrefixed(transcript, field)
+	}
+	return transcript
+}
@@ -0,0 +1,219 @@
+package occlude
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	ristretto "github.com/gtank/ristretto255"
+)
+
+// verify that a password check succeeds with the correct password and fails
+// with an incorrect one, on both the client and the server.
+func TestVerifyPassword(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer()
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+
+	req, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge, err := s.NewPasswordCheck(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := c.VerifyPassword(challenge, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := s.CheckPasswordProof(proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("server rejected a valid password proof")
+	}
+	if _, err := s.CheckPasswordProof(proof); err == nil {
+		t.Fatal("password proof was accepted twice")
+	}
+	if len(s.sessions) != 0 {
+		t.Fatal("password check created a login session")
+	}
+
+	req, err = c.NewSession("this is the wrong password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge, err = s.NewPasswordCheck(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.VerifyPassword(challenge, "this is the wrong password"); err != ErrIncorrectPassword {
+		t.Fatal("expected ErrIncorrectPassword, got", err)
+	}
+
+	// a proof for a previous challenge does not answer a new one.
+	ok, err = s.CheckPasswordProof(proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("server accepted a stale password proof")
+	}
+}
+
+// verify that a password check rejects nil and malformed messages with an
+// error rather than a panic, on both the client and the server.
+func TestPasswordCheckMalformed(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer()
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+
+	req, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge, err := s.NewPasswordCheck(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity := new(ristretto.Element).Zero()
+	tests := []struct {
+		name     string
+		check    func() error
+		expected error
+	}{
+		{"nil UsrSession", func() error { _, err := s.NewPasswordCheck(nil); return err }, ErrNilMessage},
+		{"nil Alpha", func() error { u := *req; u.Alpha = nil; _, err := s.NewPasswordCheck(&u); return err }, ErrNilMessage},
+		{"identity Alpha", func() error { u := *req; u.Alpha = identity; _, err := s.NewPasswordCheck(&u); return err }, ErrIdentityElement},
+		{"nil PasswordProof", func() error { _, err := s.CheckPasswordProof(nil); return err }, ErrNilMessage},
+		{"nil PasswordChallenge", func() error { _, err := c.VerifyPassword(nil, testpassword); return err }, ErrNilMessage},
+		{"nil Beta", func() error { v := *challenge; v.Beta = nil; _, err := c.VerifyPassword(&v, testpassword); return err }, ErrNilMessage},
+		{"identity Beta", func() error {
+			v := *challenge
+			v.Beta = identity
+			_, err := c.VerifyPassword(&v, testpassword)
+			return err
+		}, ErrIdentityElement},
+		{"short Nonce", func() error {
+			v := *challenge
+			v.Nonce = v.Nonce[1:]
+			_, err := c.VerifyPassword(&v, testpassword)
+			return err
+		}, ErrMalformedMessage},
+		{"short Tag", func() error {
+			v := *challenge
+			v.c.Tag = v.c.Tag[1:]
+			_, err := c.VerifyPassword(&v, testpassword)
+			return err
+		}, ErrMalformedMessage},
+	}
+	for _, test := range tests {
+		if err := test.check(); !errors.Is(err, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, err)
+		}
+	}
+
+	// the challenge is still answerable after the malformed requests.
+	proof, err := c.VerifyPassword(challenge, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := s.CheckPasswordProof(proof); err != nil || !ok {
+		t.Fatal("server rejected a valid password proof:", err)
+	}
+}
+
+// verify that with user enumeration protection, a password check for an
+// unknown user is answered like one for a registered user, and fails at the
+// client like an incorrect password, and that the failed proof is audited.
+func TestPasswordCheckEnumerationProtection(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	log := &auditLog{}
+	s := NewServer(WithArgon2Params(weakArgon2Params), WithUserEnumerationProtection(), WithAuditSink(log))
+	register(t, s, NewClient(testusername), testusername, testpassword)
+
+	c := NewClient("unknown user")
+	req, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge, err := s.NewPasswordCheck(req)
+	if err != nil {
+		t.Fatal("password check for an unknown user failed:", err)
+	}
+	if challenge.Argon2 != s.passwordFiles[testusername].scheme.Argon2 {
+		t.Fatal("challenge for an unknown user has different Argon2 parameters")
+	}
+	if len(challenge.c.Ciphertext) != len(s.passwordFiles[testusername].c.Ciphertext) {
+		t.Fatal("dummy envelope has a different length from a real one")
+	}
+	if _, err := c.VerifyPassword(challenge, testpassword); err != ErrIncorrectPassword {
+		t.Fatal("expected ErrIncorrectPassword, got", err)
+	}
+
+	ok, err := s.CheckPasswordProof(&PasswordProof{ID: "unknown user", Signature: make([]byte, 64)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("server accepted a proof for an unknown user")
+	}
+	last := log.events[len(log.events)-1]
+	if last.Type != AuditLoginFailure || last.Reason != ReasonUnknownUser {
+		t.Fatal("failed password check was not audited:", last)
+	}
+}
+
+// verify that an unanswered password check expires after the session TTL,
+// and is removed by Sweep.
+func TestPasswordCheckExpiry(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	clock := &testClock{t: time.Unix(1700000000, 0)}
+	s := NewServer(withClock(clock.now), WithSessionTTL(time.Minute))
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+
+	req, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge, err := s.NewPasswordCheck(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := c.VerifyPassword(challenge, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Sweep()
+	if _, exists := s.passwordChecks[testusername]; !exists {
+		t.Fatal("sweep removed a live password check")
+	}
+
+	clock.t = clock.t.Add(2 * time.Minute)
+	if ok, err := s.CheckPasswordProof(proof); err == nil || ok {
+		t.Fatal("accepted a proof for an expired password check")
+	}
+
+	if _, err := s.NewPasswordCheck(req); err != nil {
+		t.Fatal(err)
+	}
+	clock.t = clock.t.Add(2 * time.Minute)
+	s.Sweep()
+	if len(s.passwordChecks) != 0 {
+		t.Fatal("sweep did not remove an abandoned password check")
+	}
+}
@@ -0,0 +1,287 @@
+package occlude
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+
+	ristretto "github.com/gtank/ristretto255"
+)
+
+var (
+	// ErrPasswordFileMismatch is returned by UnmarshalPasswordFile when the
+	// data encodes the password file of a different user.
+	ErrPasswordFileMismatch = errors.New("password file belongs to a different user")
+
+	// ErrCorruptPasswordFile is returned when a password file is loaded, for
+	// example by UnmarshalPasswordFile, or registered, and is structurally
+	// invalid, for example because it was corrupted in storage.
+	ErrCorruptPasswordFile = errors.New("corrupt password file")
+)
+
+// credentialsSize is the length of the JSON-encoded ciphertextData sealed in
+// the user's envelope: three base64-encoded 32 byte values.
+var credentialsSize = len(`{"pu":"","Pu":"","Ps":""}`) + 3*base64.StdEncoding.EncodedLen(scalarSize)
+
+// EstimatedPasswordFileSize returns the size in bytes of the encoding produced
+// by MarshalPasswordFile for a user with a single Envelope holding appDataLen
+// bytes of application data, or with no Envelope if appDataLen is zero, and
+// with no second factor, recovery envelope or ChunkedEnvelope. The lengths of
+// the user id, the IdempotencyKey and the Envelope's label, if any, add to the
+// size. A Server configured WithMasterSecret stores a 32 byte key nonce in
+// place of the 64 bytes of the keys ks and ps. It is intended for capacity
+// planning of external stores.
+func EstimatedPasswordFileSize(appDataLen int) int {
+	const lengthPrefix = 4
+	size := 2 + // message type and encoding version
+		lengthPrefix + // user id
+		lengthPrefix + // key nonce
+		2*scalarSize + // ks, ps
+		2*elementSize + // Ps, Pu
+		lengthPrefix + macSize + lengthPrefix + credentialsSize + lengthPrefix + envelopeSaltSize + // c
+		2 + // Version, TranscriptHash
+		len(Argon2Params{}.encode()) +
+		lengthPrefix + // IdempotencyKey
+		1 + // second factor flag
+		4 + // pepper epoch
+		8 + // login sequence
+		8 + // session epoch
+		1 + // recovery envelope flag
+		1 + // ChunkedEnvelope flag
+		4 // Envelope count
+	if appDataLen > 0 {
+		size += lengthPrefix + // label
+			lengthPrefix + envelopeSaltSize +
+			1 + // ExportKeyed
+			lengthPrefix + macSize + lengthPrefix + appDataLen + lengthPrefix + envelopeSaltSize
+	}
+	return size
+}
+
+// MarshalPasswordFile encodes the password file of the user id, so that it can
+// be persisted in an external store and later loaded with
+// UnmarshalPasswordFile. The encoding is versioned, and includes the user id
+// so that a file can not be loaded for another user.
+//
+// NOTE: the encoding contains the server's OPRF key and private key for the
+// user, and is password-equivalent: anyone who obtains it can mount an offline
+// dictionary attack against the user's password, or impersonate the server to
+// them. It must be stored with the same care as the password itself. If the
+// Server is configured WithPeppers, the encoding is only password-equivalent
+// together with the pepper of the file's epoch, and if it is configured
+// WithMasterSecret, the encoding holds no keys, and is only
+// password-equivalent together with the master secret. If the Server is configured
+// WithStoreKey, the encoding is encrypted under the store key.
+func (s *Server) MarshalPasswordFile(id string) ([]byte, error) {
+	id = s.userID(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pf, exists := s.passwordFiles[id]
+	if !exists {
+		return nil, errors.New("no such sid")
+	}
+	return s.sealPasswordFile(encodePasswordFile(id, pf))
+}
+
+// encodePasswordFile encodes the password file pf of the user id.
+func encodePasswordFile(id string, pf pwdFile) []byte {
+	e := newEncoder(messagePasswordFile)
+	e.string(id)
+	e.bytes(pf.keyNonce)
+	if pf.keyNonce == nil {
+		e.scalar(pf.ks)
+		e.scalar(pf.ps)
+	}
+	e.element(pf.Ps)
+	e.element(pf.Pu)
+	e.authCiphertext(pf.c)
+	e.uint8(uint8(pf.scheme.Version))
+	e.uint8(uint8(pf.scheme.TranscriptHash))
+	e.argon2Params(pf.scheme.Argon2)
+	e.string(pf.idempotencyKey)
+	e.optionalElement(pf.secondFactor)
+	e.uint32(pf.pepperEpoch)
+	e.uint64(pf.sequence)
+	e.uint64(pf.sessionEpoch)
+	if pf.recovery != nil {
+		e.uint8(1)
+		e.recoveryEnvelope(*pf.recovery)
+	} else {
+		e.uint8(0)
+	}
+	e.chunkedEnvelope(pf.appData)
+
+	// Envelopes are encoded in label order, so that the encoding of a password
+	// file is deterministic.
+	labels := make([]string, 0, len(pf.envelopes))
+	for label := range pf.envelopes {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	e.uint32(uint32(len(labels)))
+	for _, label := range labels {
+		env := pf.envelopes[label]
+		e.envelope(&env)
+	}
+	return e.b
+}
+
+// UnmarshalPasswordFile decodes a password file encoded with
+// MarshalPasswordFile, and stores it as the password file of the user id,
+// replacing any existing file. It returns ErrPasswordFileMismatch if data is
+// the password file of a different user. If the Server is configured
+// WithStoreKey, data must be encrypted under the store key.
+func (s *Server) UnmarshalPasswordFile(id string, data []byte) error {
+	fileID, pf, err := s.decodePasswordFile(data)
+	if err != nil {
+		return err
+	}
+	id = s.userID(id)
+	if s.userID(fileID) != id {
+		return ErrPasswordFileMismatch
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setPasswordFile(id, pf)
+	delete(s.legacyUsers, id)
+	return nil
+}
+
+// decodePasswordFile decrypts, if the Server is configured WithStoreKey,
+// decodes and validates a password file encoded with MarshalPasswordFile,
+// returning the user id it was encoded for. A corrupt file is counted and
+// audited. The caller must not hold s.mu.
+func (s *Server) decodePasswordFile(data []byte) (string, pwdFile, error) {
+	data, err := s.openPasswordFile(data)
+	if err != nil {
+		return "", pwdFile{}, err
+	}
+	d := newDecoder(messagePasswordFile, data)
+	fileID := d.string("ID")
+	keyNonce := d.bytes("keyNonce")
+	var ks, ps *ristretto.Scalar
+	if keyNonce == nil {
+		ks, ps = d.scalar("ks"), d.scalar("ps")
+	}
+	pf := pwdFile{
+		ks: ks,
+		ps: ps,
+		Ps: d.element("Ps"),
+		Pu: d.element("Pu"),
+		c:  d.authCiphertext("c"),
+		scheme: Scheme{
+			Version:        Version(d.uint8("Version")),
+			TranscriptHash: TranscriptHash(d.uint8("TranscriptHash")),
+			Argon2:         d.argon2Params("Argon2"),
+		},
+		idempotencyKey: d.string("idempotencyKey"),
+		secondFactor:   d.optionalElement("secondFactor"),
+		pepperEpoch:    d.uint32("pepperEpoch"),
+		sequence:       d.uint64("sequence"),
+		sessionEpoch:   d.uint64("sessionEpoch"),
+		keyNonce:       keyNonce,
+		envelopes:      make(map[string]Envelope),
+	}
+	switch d.uint8("recovery") {
+	case 0:
+	case 1:
+		recovery := d.recoveryEnvelope()
+		pf.recovery = &recovery
+	default:
+		d.fail("recovery")
+	}
+	pf.appData = d.chunkedEnvelope()
+	n := d.uint32("envelopes")
+	for i := uint32(0); i < n && d.err == nil; i++ {
+		env := d.envelope()
+		pf.envelopes[env.Label] = *env
+	}
+	if err := d.finish(); err != nil {
+		return "", pwdFile{}, err
+	}
+	if err := pf.validate(); err != nil {
+		s.mu.Lock()
+		s.stats.corruptPasswordFiles++
+		s.audit(AuditCorruptPasswordFile, s.userID(fileID))
+		s.mu.Unlock()
+		return "", pwdFile{}, err
+	}
+	if err := s.checkFileKeys(s.userID(fileID), pf); err != nil {
+		return "", pwdFile{}, err
+	}
+	return fileID, pf, nil
+}
+
+// validate checks that the password file is structurally valid: that its keys,
+// or its key nonce, are set and not trivial, that its public key Ps matches its
+// private key ps, and that its envelopes have well-formed tags. It returns
+// ErrCorruptPasswordFile if not. Keys derived from a master secret are checked
+// by Server.checkFileKeys instead.
+func (pf *pwdFile) validate() error {
+	if pf.Pu == nil || isIdentity(pf.Pu) {
+		return fmt.Errorf("%w: Pu", ErrCorruptPasswordFile)
+	}
+	if pf.keyNonce != nil {
+		if len(pf.keyNonce) != keyNonceSize || pf.ks != nil || pf.ps != nil {
+			return fmt.Errorf("%w: keyNonce", ErrCorruptPasswordFile)
+		}
+		if pf.Ps == nil || isIdentity(pf.Ps) {
+			return fmt.Errorf("%w: Ps", ErrCorruptPasswordFile)
+		}
+	} else {
+		zero := new(ristretto.Scalar).Zero()
+		if pf.ks == nil || pf.ks.Equal(zero) == 1 {
+			return fmt.Errorf("%w: ks", ErrCorruptPasswordFile)
+		}
+		if pf.ps == nil || pf.ps.Equal(zero) == 1 {
+			return fmt.Errorf("%w: ps", ErrCorruptPasswordFile)
+		}
+		if pf.Ps == nil || pf.Ps.Equal(new(ristretto.Element).ScalarBaseMult(pf.ps)) != 1 {
+			return fmt.Errorf("%w: Ps", ErrCorruptPasswordFile)
+		}
+	}
+	if err := pf.c.validate(); err != nil {
+		return fmt.Errorf("%w: c: %v", ErrCorruptPasswordFile, err)
+	}
+	if pf.recovery != nil {
+		if err := pf.recovery.c.validate(); err != nil {
+			return fmt.Errorf("%w: recovery: %v", ErrCorruptPasswordFile, err)
+		}
+	}
+	if pf.appData != nil {
+		if err := pf.appData.validate(); err != nil {
+			return fmt.Errorf("%w: app data: %v", ErrCorruptPasswordFile, err)
+		}
+	}
+	for label, env := range pf.envelopes {
+		if len(env.c.Tag) != macSize {
+			return fmt.Errorf("%w: Envelope %q", ErrCorruptPasswordFile, label)
+		}
+	}
+	return nil
+}
+
+// setPasswordFile stores pf as the password file of the user id, replacing any
+// existing file. The caller must hold s.mu.
+func (s *Server) setPasswordFile(id string, pf pwdFile) {
+	s.deletePasswordFile(id)
+	s.passwordFiles[id] = pf
+	s.schemes[pf.scheme]++
+}
+
+// deletePasswordFile deletes the password file of the user id, if any. The
+// caller must hold s.mu.
+func (s *Server) deletePasswordFile(id string) {
+	pf, exists := s.passwordFiles[id]
+	if !exists {
+		return
+	}
+	delete(s.passwordFiles, id)
+	if s.schemes[pf.scheme] <= 1 {
+		delete(s.schemes, pf.scheme)
+	} else {
+		s.schemes[pf.scheme]--
+	}
+}
@@ -0,0 +1,183 @@
+package occlude
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	ristretto "github.com/gtank/ristretto255"
+)
+
+// verify that a password file marshaled from one server, persisted, and
+// unmarshaled into another, can still be logged in with, including its
+// Envelopes.
+func TestPasswordFileRoundTrip(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+	data := []byte("laptop device key")
+
+	s := NewServer()
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+	cv := login(t, s, c, testpassword, "")
+	env, err := c.SealEnvelope("laptop", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddEnvelope(cv, env); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.FinishSession(cv); err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := s.MarshalPasswordFile(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := s.MarshalPasswordFile(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded, again) {
+		t.Fatal("password file encoding is not deterministic")
+	}
+	store := map[string][]byte{testusername: encoded}
+
+	loaded := NewServer()
+	if err := loaded.UnmarshalPasswordFile(testusername, store[testusername]); err != nil {
+		t.Fatal(err)
+	}
+	cv = login(t, loaded, c, testpassword, "laptop")
+	if _, err := loaded.FinishSession(cv); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(c.EnvelopeData(), data) {
+		t.Fatal("envelope was not preserved")
+	}
+}
+
+// verify that UnmarshalPasswordFile rejects a file for another user, and
+// malformed files.
+func TestUnmarshalPasswordFileErrors(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer()
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+	encoded, err := s.MarshalPasswordFile(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.MarshalPasswordFile("another user"); err == nil {
+		t.Fatal("marshaled a password file for an unregistered user")
+	}
+
+	loaded := NewServer()
+	if err := loaded.UnmarshalPasswordFile("another user", encoded); !errors.Is(err, ErrPasswordFileMismatch) {
+		t.Fatal("expected ErrPasswordFileMismatch, got", err)
+	}
+	if err := loaded.UnmarshalPasswordFile(testusername, encoded[:len(encoded)-1]); !errors.Is(err, ErrMalformedMessage) {
+		t.Fatal("expected ErrMalformedMessage, got", err)
+	}
+	if err := loaded.UnmarshalPasswordFile(testusername, append(encoded, 0)); !errors.Is(err, ErrMalformedMessage) {
+		t.Fatal("expected ErrMalformedMessage, got", err)
+	}
+	if len(loaded.passwordFiles) != 0 {
+		t.Fatal("stored a rejected password file")
+	}
+}
+
+// verify that EstimatedPasswordFileSize matches the size of an actual encoded
+// password file, with and without an Envelope.
+func TestEstimatedPasswordFileSize(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+	label := "laptop"
+	data := bytes.Repeat([]byte{1}, 100)
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+	encoded, err := s.MarshalPasswordFile(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := EstimatedPasswordFileSize(0) + len(testusername); len(encoded) != expected {
+		t.Fatalf("estimated %v bytes, got %v", expected, len(encoded))
+	}
+
+	cv := login(t, s, c, testpassword, "")
+	env, err := c.SealEnvelope(label, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddEnvelope(cv, env); err != nil {
+		t.Fatal(err)
+	}
+	encoded, err = s.MarshalPasswordFile(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := EstimatedPasswordFileSize(len(data)) + len(testusername) + len(label); len(encoded) != expected {
+		t.Fatalf("estimated %v bytes, got %v", expected, len(encoded))
+	}
+}
+
+// verify that a corrupted password file is rejected with
+// ErrCorruptPasswordFile when it is loaded, counted and audited, and leaves
+// the user's existing password file in place.
+func TestCorruptPasswordFile(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	log := &auditLog{}
+	s := NewServer(WithArgon2Params(weakArgon2Params), WithAuditSink(log))
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+	encoded, err := s.MarshalPasswordFile(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// replace Ps, which follows the id, the empty key nonce, ks and ps, with
+	// another valid element.
+	corrupted := append([]byte(nil), encoded...)
+	offset := 2 + 4 + len(testusername) + 4 + 2*scalarSize
+	other := new(ristretto.Element).ScalarBaseMult(randomScalar()).Encode(nil)
+	copy(corrupted[offset:], other)
+	if err := NewServer().UnmarshalPasswordFile(testusername, corrupted); !errors.Is(err, ErrCorruptPasswordFile) {
+		t.Fatal("expected ErrCorruptPasswordFile, got", err)
+	}
+
+	for _, corrupt := range []func(pf *pwdFile){
+		func(pf *pwdFile) { pf.c.Tag = pf.c.Tag[:macSize/2] },
+		func(pf *pwdFile) { pf.ks = new(ristretto.Scalar).Zero() },
+		func(pf *pwdFile) { pf.Pu = new(ristretto.Element).Zero() },
+	} {
+		pf := s.passwordFiles[testusername]
+		corrupt(&pf)
+		if err := s.UnmarshalPasswordFile(testusername, encodePasswordFile(testusername, pf)); !errors.Is(err, ErrCorruptPasswordFile) {
+			t.Fatal("expected ErrCorruptPasswordFile, got", err)
+		}
+	}
+	login(t, s, c, testpassword, "")
+
+	var buf bytes.Buffer
+	if err := s.WriteMetrics(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if corrupt := parseMetrics(t, buf.String())["occlude_corrupt_password_files_total"]; corrupt != 3 {
+		t.Fatal("expected 3 corrupt password files, got", corrupt)
+	}
+	audited := 0
+	for _, event := range log.events {
+		if event.Type == AuditCorruptPasswordFile {
+			audited++
+		}
+	}
+	if audited != 3 {
+		t.Fatal("expected 3 corrupt password file audit events, got", audited)
+	}
+}
@@ -0,0 +1,52 @@
+package occlude
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// ErrWeakPassword is returned, wrapped with the reason, when a password is
+// refused by the Client's PasswordPolicy.
+var ErrWeakPassword = errors.New("password rejected by policy")
+
+// PasswordPolicy decides which passwords a Client may register.
+type PasswordPolicy interface {
+	// Check returns nil if password is acceptable, or an error wrapping
+	// ErrWeakPassword describing why not.
+	Check(password string) error
+}
+
+// WithPasswordPolicy configures the Client to refuse, in NewRegistration,
+// passwords not accepted by p. The same check is available without
+// registering from CheckPassword.
+func WithPasswordPolicy(p PasswordPolicy) ClientOption {
+	return func(c *Client) {
+		c.passwordPolicy = p
+	}
+}
+
+// CheckPassword checks password against the Client's PasswordPolicy, if it has
+// one, returning an error wrapping ErrWeakPassword if it is refused. It does
+// no key derivation or network interaction, so that a user interface can call
+// it as the user types.
+func (c *Client) CheckPassword(password string) error {
+	if c.passwordPolicy == nil {
+		return nil
+	}
+	return c.passwordPolicy.Check(password)
+}
+
+// MinLengthPolicy is a PasswordPolicy which refuses passwords of fewer than
+// MinLength characters.
+type MinLengthPolicy struct {
+	MinLength int
+}
+
+// Check refuses password if it has fewer than p.MinLength characters.
+func (p MinLengthPolicy) Check(password string) error {
+	if n := utf8.RuneCountInString(password); n < p.MinLength {
+		return fmt.Errorf("%w: must be at least %v characters", ErrWeakPassword, p.MinLength)
+	}
+	return nil
+}
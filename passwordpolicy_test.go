@@ -0,0 +1,64 @@
+package occlude
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// digitPolicy is a sample PasswordPolicy requiring a digit.
+type digitPolicy struct{}
+
+func (digitPolicy) Check(password string) error {
+	if !strings.ContainsAny(password, "0123456789") {
+		return fmt.Errorf("%w: must contain a digit", ErrWeakPassword)
+	}
+	return nil
+}
+
+// verify that CheckPassword applies the Client's PasswordPolicy, and that
+// NewRegistration refuses passwords it rejects.
+func TestCheckPassword(t *testing.T) {
+	testusername := "this is a test username"
+
+	if err := NewClient(testusername).CheckPassword(""); err != nil {
+		t.Fatal("Client without a policy rejected a password:", err)
+	}
+
+	c := NewClient(testusername, WithPasswordPolicy(MinLengthPolicy{MinLength: 12}))
+	for password, ok := range map[string]bool{
+		"short":                false,
+		"ünïcödé":              false,
+		"long enough password": true,
+		"ünïcödé pässwörd":     true,
+	} {
+		err := c.CheckPassword(password)
+		if ok && err != nil {
+			t.Fatalf("%q was rejected: %v", password, err)
+		}
+		if !ok && !errors.Is(err, ErrWeakPassword) {
+			t.Fatalf("expected ErrWeakPassword for %q, got %v", password, err)
+		}
+	}
+
+	c = NewClient(testusername, WithPasswordPolicy(digitPolicy{}))
+	if err := c.CheckPassword("no digits here"); !errors.Is(err, ErrWeakPassword) {
+		t.Fatal("expected ErrWeakPassword, got", err)
+	}
+	if err := c.CheckPassword("1 digit here"); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	pr, err := s.NewRegistration(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.NewRegistration(pr, testusername, "no digits here"); !errors.Is(err, ErrWeakPassword) {
+		t.Fatal("NewRegistration accepted a weak password:", err)
+	}
+	if _, err := c.NewRegistration(pr, testusername, "1 digit here"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,163 @@
+package occlude
+
+import (
+	"crypto/subtle"
+	"errors"
+	"sync"
+	"time"
+)
+
+// PendingSession is the server's half of a login started with
+// NewPendingSession or VerifyAndEstablish. It holds the session key SK
+// together with the fk2 the client is expected to send in its
+// ClientVerification, so that the caller can complete the login itself, for
+// example on another machine, without the Server's session state.
+type PendingSession struct {
+	id       string
+	sk       []byte
+	fk2      []byte
+	created  time.Time
+	response *SvrSession
+	sequence uint64
+	unknown  bool
+
+	// probe is set if the login was started by NewProbeSession.
+	probe bool
+
+	// server is the Server which started the login, and finished is set
+	// once Finish has been called.
+	server   *Server
+	mu       sync.Mutex
+	finished bool
+}
+
+// NewPendingSession is NewSession, returning a PendingSession in place of the
+// session key. The login is also recorded for FinishSession as usual, so
+// either may be used to complete it.
+func (s *Server) NewPendingSession(session *UsrSession) (*SvrSession, *PendingSession, error) {
+	done, err := s.permitAuthentication()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer done()
+	svrSession, sess, err := s.startSession(session, randomScalar())
+	if err != nil {
+		return nil, nil, err
+	}
+	return svrSession, &PendingSession{
+		id:       session.Sid,
+		sk:       sess.sk,
+		fk2:      sess.fk2,
+		created:  sess.created,
+		response: svrSession,
+		server:   s,
+		sequence: sess.sequence,
+		unknown:  sess.unknown,
+	}, nil
+}
+
+// VerifyAndEstablish starts a login for session, returning a PendingSession
+// which holds the whole of its state: the SvrSession to send to the client,
+// from Response, and the client's expected verification, checked by Finish.
+// It is NewPendingSession for callers which hold on to the PendingSession
+// until the client's ClientVerification arrives.
+func (s *Server) VerifyAndEstablish(session *UsrSession) (*PendingSession, error) {
+	_, pending, err := s.NewPendingSession(session)
+	return pending, err
+}
+
+// Response returns the SvrSession to send to the client.
+func (p *PendingSession) Response() *SvrSession {
+	return p.response
+}
+
+// ExpectedFK2 returns the fk2 the client is expected to send in its
+// ClientVerification.
+func (p *PendingSession) ExpectedFK2() []byte {
+	return append([]byte(nil), p.fk2...)
+}
+
+// Verify checks the client's ClientVerification against the PendingSession
+// and, if it matches and the login has not outlived the session TTL, returns
+// the session key SK. The outcome is counted in the Server's metrics and
+// audit log, unless the login is a probe, but the login is left in the
+// Server's session state, and the PendingSession may be verified again.
+func (p *PendingSession) Verify(cv *ClientVerification) ([]byte, error) {
+	if cv == nil {
+		return nil, ErrNilMessage
+	}
+	s := p.server
+	id := s.userID(p.id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkVerification(id, p.session(), p.verificationFK2(cv), p.probe); err != nil {
+		return nil, err
+	}
+	if !p.probe {
+		s.stats.loginSuccesses++
+		s.audit(AuditLoginSuccess, id)
+	}
+	return append([]byte(nil), p.sk...), nil
+}
+
+// session returns the serverSession of the PendingSession's login.
+func (p *PendingSession) session() serverSession {
+	return serverSession{sk: p.sk, fk2: p.fk2, created: p.created, response: p.response, sequence: p.sequence, unknown: p.unknown}
+}
+
+// verificationFK2 returns the fk2 of cv, or nil, which matches no login, if cv
+// is for another user.
+func (p *PendingSession) verificationFK2(cv *ClientVerification) []byte {
+	if cv.ID != p.id {
+		return nil
+	}
+	return cv.FK2
+}
+
+// Finish completes the login as FinishSession does: it verifies the client's
+// ClientVerification and, if it matches, returns the session key SK. Unlike
+// Verify, the PendingSession is consumed, whether or not verification
+// succeeds, the login is removed from the Server's session state, and, once
+// verified, the WithOnAuthenticated hook is called and the login sequence
+// number advanced, exactly as by FinishSession. Finish fails if
+// the login is no longer in the Server's session state, because it has been
+// finished with FinishSession, swept, or replaced by a newer login.
+func (p *PendingSession) Finish(cv *ClientVerification) ([]byte, error) {
+	s := p.server
+	done, err := s.permitAuthentication()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	if cv == nil {
+		return nil, ErrNilMessage
+	}
+	p.mu.Lock()
+	finished := p.finished
+	p.finished = true
+	p.mu.Unlock()
+	if finished {
+		return nil, errors.New("session already finished")
+	}
+	if p.probe {
+		return p.Verify(cv)
+	}
+
+	id := s.userID(p.id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	timer := s.startTimer(LoginStepFinishSession)
+	// The login can only be finished while the Server's record is still this
+	// login: not once it has been finished with FinishSession, nor replaced
+	// by a newer login for the same user.
+	sess, exists := s.sessions[id]
+	if !exists || subtle.ConstantTimeCompare(sess.fk2, p.fk2) != 1 {
+		s.auditFailure(id, ReasonNoSession)
+		return nil, errors.New("no session in progress")
+	}
+	delete(s.sessions, id)
+	if err := s.completeSession(id, sess, p.verificationFK2(cv), timer); err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), p.sk...), nil
+}
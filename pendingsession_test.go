@@ -0,0 +1,189 @@
+package occlude
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// verify that the fk2 expected by a PendingSession matches the client's, and
+// that Verify releases the session key only for the matching
+// ClientVerification.
+func TestPendingSession(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer(WithArgon2Params(weakArgon2Params), WithStrictVerification())
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, pending, err := s.NewPendingSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientKey, fk2, err := c.SessionKey(svrsess, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pending.ExpectedFK2(), fk2) {
+		t.Fatal("expected fk2 does not match the client's fk2")
+	}
+
+	bad := append([]byte(nil), fk2...)
+	bad[0] ^= 0xff
+	if _, err := pending.Verify(&ClientVerification{ID: testusername, FK2: bad}); err == nil {
+		t.Fatal("PendingSession accepted an incorrect fk2")
+	}
+	if _, err := pending.Verify(&ClientVerification{ID: "another user", FK2: fk2}); err == nil {
+		t.Fatal("PendingSession accepted a verification for another user")
+	}
+	serverKey, err := pending.Verify(&ClientVerification{ID: testusername, FK2: fk2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(serverKey, clientKey) {
+		t.Fatal("client and server did not compute identical session key")
+	}
+}
+
+// verify that a full handshake can be driven through VerifyAndEstablish and
+// the PendingSession, that a failed verification consumes it, and that a
+// finished login is removed from the Server.
+func TestVerifyAndEstablish(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer(WithArgon2Params(weakArgon2Params), WithStrictVerification())
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, err := s.VerifyAndEstablish(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientKey, fk2, err := c.SessionKey(pending.Response(), testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverKey, err := pending.Finish(&ClientVerification{ID: testusername, FK2: fk2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(serverKey, clientKey) {
+		t.Fatal("client and server did not compute identical session key")
+	}
+	if _, exists := s.sessions[testusername]; exists {
+		t.Fatal("Finish did not remove the login from the Server")
+	}
+	if _, err := pending.Finish(&ClientVerification{ID: testusername, FK2: fk2}); err == nil {
+		t.Fatal("PendingSession was finished twice")
+	}
+
+	// a failed verification consumes the PendingSession.
+	sess, err = c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, err = s.VerifyAndEstablish(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, fk2, err = c.SessionKey(pending.Response(), testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad := append([]byte(nil), fk2...)
+	bad[0] ^= 0xff
+	if _, err := pending.Finish(&ClientVerification{ID: testusername, FK2: bad}); err == nil {
+		t.Fatal("PendingSession accepted an incorrect fk2")
+	}
+	if _, err := pending.Finish(&ClientVerification{ID: testusername, FK2: fk2}); err == nil {
+		t.Fatal("PendingSession could be finished after a failed verification")
+	}
+	if s.stats.loginSuccesses != 1 || s.stats.loginFailures != 1 {
+		t.Fatalf("logins were not counted: %+v", s.stats)
+	}
+}
+
+// verify that a PendingSession can not be finished once its login has been
+// finished with FinishSession.
+func TestPendingSessionFinishedBySession(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer(WithArgon2Params(weakArgon2Params), WithLoginSequence())
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, pending, err := s.NewPendingSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, fk2, err := c.SessionKey(svrsess, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cv := &ClientVerification{ID: testusername, FK2: fk2}
+	if _, err := s.FinishSession(cv); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pending.Finish(cv); err == nil {
+		t.Fatal("PendingSession was finished after FinishSession")
+	}
+	if s.stats.loginSuccesses != 1 || s.passwordFiles[testusername].sequence != 1 {
+		t.Fatalf("login was finished twice: %+v, sequence %v", s.stats, s.passwordFiles[testusername].sequence)
+	}
+}
+
+// verify that Verify refuses a login which has outlived the session TTL, and
+// audits both its failures and its successes.
+func TestPendingSessionVerifyExpiry(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	clock := &testClock{t: time.Unix(1700000000, 0)}
+	log := &auditLog{}
+	s := NewServer(WithArgon2Params(weakArgon2Params), withClock(clock.now), WithSessionTTL(time.Minute), WithAuditSink(log))
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, pending, err := s.NewPendingSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, fk2, err := c.SessionKey(svrsess, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cv := &ClientVerification{ID: testusername, FK2: fk2}
+	if _, err := pending.Verify(cv); err != nil {
+		t.Fatal(err)
+	}
+	if last := log.events[len(log.events)-1]; last.Type != AuditLoginSuccess {
+		t.Fatal("successful Verify was not audited:", last)
+	}
+
+	clock.t = clock.t.Add(2 * time.Minute)
+	if _, err := pending.Verify(cv); err == nil {
+		t.Fatal("Verify accepted an expired login")
+	}
+	if last := log.events[len(log.events)-1]; last.Type != AuditLoginFailure || last.Reason != ReasonSessionExpired {
+		t.Fatal("expired Verify was not audited:", last)
+	}
+}
@@ -0,0 +1,85 @@
+package occlude
+
+import (
+	"errors"
+
+	ristretto "github.com/gtank/ristretto255"
+)
+
+// ErrUnknownPepperEpoch is returned when a password file was sealed under a
+// pepper epoch the Server no longer holds.
+var ErrUnknownPepperEpoch = errors.New("unknown pepper epoch")
+
+// WithPeppers configures the Server with a set of secret peppers, by epoch,
+// and the current epoch, which must be in peppers. The pepper of a password
+// file's epoch is mixed into the user's OPRF key, so that a stolen password
+// file can not be used for a dictionary attack without the pepper, which
+// should be held apart from the password files, for example in an HSM.
+//
+// New registrations are sealed under the current epoch. To rotate the pepper,
+// add a new epoch and make it current while keeping the old ones: a user whose
+// password file is sealed under an older epoch still logs in, and their file
+// is upgraded to the current epoch as they do. An old pepper can be dropped
+// once no password files remain under its epoch. Epoch 0 is reserved for
+// password files registered without a pepper, which are upgraded in the same
+// way.
+func WithPeppers(current uint32, peppers map[uint32][]byte) ServerOption {
+	return func(s *Server) {
+		if current == 0 {
+			panic("occlude: pepper epoch 0 is reserved")
+		}
+		if len(peppers[current]) == 0 {
+			panic("occlude: no pepper for the current epoch")
+		}
+		s.pepperEpoch = current
+		s.peppers = make(map[uint32][]byte, len(peppers))
+		for epoch, pepper := range peppers {
+			s.peppers[epoch] = append([]byte(nil), pepper...)
+		}
+	}
+}
+
+// pepperScalar derives the scalar the pepper of epoch multiplies the OPRF key
+// of the user id by, or returns nil for epoch 0.
+func (s *Server) pepperScalar(epoch uint32, id string) (*ristretto.Scalar, error) {
+	if epoch == 0 {
+		return nil, nil
+	}
+	pepper, exists := s.peppers[epoch]
+	if !exists {
+		return nil, ErrUnknownPepperEpoch
+	}
+	b := deriveKey(pepper, nil, append([]byte("occlude pepper "), id...), 64)
+	return new(ristretto.Scalar).FromUniformBytes(b), nil
+}
+
+// oprfKey returns the OPRF key of the user id under the pepper epoch: the
+// stored key ks multiplied by the epoch's pepper scalar.
+func (s *Server) oprfKey(ks *ristretto.Scalar, epoch uint32, id string) (*ristretto.Scalar, error) {
+	p, err := s.pepperScalar(epoch, id)
+	if err != nil || p == nil {
+		return ks, err
+	}
+	return new(ristretto.Scalar).Multiply(ks, p), nil
+}
+
+// repepper upgrades the password file pf of the user id, whose OPRF key is ks,
+// to the current pepper epoch, if it is sealed under another and store is
+// set. Its stored key is replaced with one which, under the current pepper,
+// gives the same OPRF key, so that the user's envelopes remain valid. A
+// replica leaves the file to be repeppered by its primary, and a file whose
+// keys are derived from the master secret is never peppered. The new key is
+// computed whether or not the file is upgraded, so that a login does the same
+// work for every file, including a dummy one. The caller must hold s.mu.
+func (s *Server) repepper(id string, pf pwdFile, ks *ristretto.Scalar, store bool) {
+	k := ks
+	if p, _ := s.pepperScalar(s.pepperEpoch, id); p != nil {
+		k = new(ristretto.Scalar).Multiply(ks, new(ristretto.Scalar).Invert(p))
+	}
+	if !store || pf.pepperEpoch == s.pepperEpoch || s.role == roleReplica || pf.keyNonce != nil {
+		return
+	}
+	pf.ks = k
+	pf.pepperEpoch = s.pepperEpoch
+	s.setPasswordFile(id, pf)
+}
@@ -0,0 +1,90 @@
+package occlude
+
+import (
+	"errors"
+	"testing"
+)
+
+// verify that a user whose password file is sealed under an old pepper epoch
+// still logs in after the server advances to a new epoch, and is upgraded to
+// it, so that the old pepper can be dropped.
+func TestPepperRotation(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+	pepper1 := []byte("this is the first test pepper")
+	pepper2 := []byte("this is the second test pepper")
+
+	s := NewServer(WithArgon2Params(weakArgon2Params), WithPeppers(1, map[uint32][]byte{1: pepper1}))
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+	login(t, s, c, testpassword, "")
+	file, err := s.MarshalPasswordFile(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the pepper is needed to log in.
+	unpeppered := NewServer(WithArgon2Params(weakArgon2Params))
+	if err := unpeppered.UnmarshalPasswordFile(testusername, file); err != nil {
+		t.Fatal(err)
+	}
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := unpeppered.NewSession(sess); !errors.Is(err, ErrUnknownPepperEpoch) {
+		t.Fatalf("expected ErrUnknownPepperEpoch without the pepper, got %v", err)
+	}
+
+	// the server advances to epoch 2, holding both peppers.
+	rotating := NewServer(WithArgon2Params(weakArgon2Params), WithPeppers(2, map[uint32][]byte{1: pepper1, 2: pepper2}))
+	if err := rotating.UnmarshalPasswordFile(testusername, file); err != nil {
+		t.Fatal(err)
+	}
+	cv := login(t, rotating, c, testpassword, "")
+	if _, err := rotating.FinishSession(cv); err != nil {
+		t.Fatal(err)
+	}
+	if epoch := rotating.passwordFiles[testusername].pepperEpoch; epoch != 2 {
+		t.Fatalf("password file was not upgraded to epoch 2, got epoch %v", epoch)
+	}
+
+	// once upgraded, the first pepper is no longer needed.
+	file, err = rotating.MarshalPasswordFile(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated := NewServer(WithArgon2Params(weakArgon2Params), WithPeppers(2, map[uint32][]byte{2: pepper2}))
+	if err := rotated.UnmarshalPasswordFile(testusername, file); err != nil {
+		t.Fatal(err)
+	}
+	cv = login(t, rotated, c, testpassword, "")
+	if _, err := rotated.FinishSession(cv); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// verify that a password file registered without a pepper is upgraded to the
+// current epoch on login.
+func TestPepperUpgradeUnpeppered(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+	file, err := s.MarshalPasswordFile(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peppered := NewServer(WithArgon2Params(weakArgon2Params), WithPeppers(1, map[uint32][]byte{1: []byte("this is a test pepper")}))
+	if err := peppered.UnmarshalPasswordFile(testusername, file); err != nil {
+		t.Fatal(err)
+	}
+	login(t, peppered, c, testpassword, "")
+	if epoch := peppered.passwordFiles[testusername].pepperEpoch; epoch != 1 {
+		t.Fatalf("password file was not upgraded to epoch 1, got epoch %v", epoch)
+	}
+	login(t, peppered, c, testpassword, "")
+}
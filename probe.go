@@ -0,0 +1,36 @@
+package occlude
+
+// NewProbeSession is NewPendingSession for a probe: a login, typically to a
+// synthetic test account, made only to confirm that it still succeeds end to
+// end, for monitoring. The handshake is the same as any other login's, and
+// the client completes it as usual, but the probe has no effect on the
+// Server: it is not recorded for FinishSession, and neither it nor Finish on
+// the returned PendingSession counts in the Server's metrics, records an
+// audit event, reports a LoginTiming, calls the WithOnAuthenticated hook,
+// advances the login sequence number, re-peppers the password file or asks
+// for an Argon2Upgrade. A failed probe returns its error as the login would,
+// without counting as a failed login.
+func (s *Server) NewProbeSession(session *UsrSession) (*SvrSession, *PendingSession, error) {
+	done, err := s.permitAuthentication()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer done()
+	s.mu.Lock()
+	svrSession, sess, err := s.startSessionLocked(session, randomScalar(), true)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, nil, err
+	}
+	return svrSession, &PendingSession{
+		id:       session.Sid,
+		sk:       sess.sk,
+		fk2:      sess.fk2,
+		created:  sess.created,
+		response: svrSession,
+		server:   s,
+		sequence: sess.sequence,
+		unknown:  sess.unknown,
+		probe:    true,
+	}, nil
+}
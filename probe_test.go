@@ -0,0 +1,77 @@
+package occlude
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// verify that a probe login succeeds end to end, or fails, without changing
+// the Server's metrics, audit log, session state or login sequence, and
+// without asking for an Argon2Upgrade.
+func TestProbeSession(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	log := &auditLog{}
+	s := NewServer(WithArgon2Params(weakArgon2Params), WithAuditSink(log), WithLoginSequence(), WithArgon2UpgradeOnLogin())
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+	s.SetActiveScheme(Scheme{Version: DefaultScheme.Version, TranscriptHash: DefaultScheme.TranscriptHash, Argon2: Argon2Params{Time: 2, Memory: 64, Threads: 1}})
+	events := len(log.events)
+	var before bytes.Buffer
+	if err := s.WriteMetrics(&before); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, password := range []string{testpassword, "this is the wrong password"} {
+		sess, err := c.NewSession(password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		svrsess, probe, err := s.NewProbeSession(sess)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if svrsess.Argon2Upgrade != nil {
+			t.Fatal("probe asked for an Argon2Upgrade")
+		}
+		result, err := c.FinishLogin(svrsess, password)
+		if password != testpassword {
+			if !errors.Is(err, ErrAuthenticationFailed) {
+				t.Fatal("expected ErrAuthenticationFailed, got", err)
+			}
+			if _, err := probe.Finish(&ClientVerification{ID: testusername, FK2: make([]byte, prfSize)}); err == nil {
+				t.Fatal("probe with the wrong password succeeded")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		sk, err := probe.Finish(result.Verification())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(sk, result.SessionKey) {
+			t.Fatal("session keys differ")
+		}
+		if _, err := s.FinishSession(result.Verification()); err == nil {
+			t.Fatal("probe was recorded for FinishSession")
+		}
+	}
+
+	if len(log.events) != events+1 || log.events[events].Reason != ReasonNoSession {
+		t.Fatal("probes recorded audit events:", log.events[events:])
+	}
+	var after bytes.Buffer
+	if err := s.WriteMetrics(&after); err != nil {
+		t.Fatal(err)
+	}
+	if before.String() != after.String() {
+		t.Fatalf("probes changed the metrics from\n%s\nto\n%s", before.String(), after.String())
+	}
+	if s.passwordFiles[testusername].sequence != 0 {
+		t.Fatal("probe advanced the login sequence")
+	}
+}
@@ -0,0 +1,411 @@
+package occlude
+
+import (
+	"fmt"
+
+	ristretto "github.com/gtank/ristretto255"
+
+	"occlude/occludepb"
+)
+
+// ToProto converts the UsrSession to its protocol buffer representation.
+func (u *UsrSession) ToProto() *occludepb.UsrSession {
+	return &occludepb.UsrSession{
+		Alpha:    encodeElement(u.Alpha),
+		Xu:       encodeElement(u.Xu),
+		Sid:      u.Sid,
+		Envelope: u.Envelope,
+		Sequence: u.Sequence,
+	}
+}
+
+// FromProto sets the UsrSession from its protocol buffer representation,
+// validating the encodings of its group elements.
+func (u *UsrSession) FromProto(p *occludepb.UsrSession) error {
+	return u.fromProto(p, false)
+}
+
+// FromProtoStrict is FromProto, additionally rejecting group elements which
+// are the identity with ErrIdentityElement.
+func (u *UsrSession) FromProtoStrict(p *occludepb.UsrSession) error {
+	return u.fromProto(p, true)
+}
+
+func (u *UsrSession) fromProto(p *occludepb.UsrSession, strict bool) error {
+	if p == nil {
+		return ErrNilMessage
+	}
+	alpha, err := decodeElement("Alpha", p.Alpha, strict)
+	if err != nil {
+		return err
+	}
+	xu, err := decodeElement("Xu", p.Xu, strict)
+	if err != nil {
+		return err
+	}
+	*u = UsrSession{
+		Alpha:    alpha,
+		Xu:       xu,
+		Sid:      p.Sid,
+		Envelope: p.Envelope,
+		Sequence: p.Sequence,
+	}
+	return nil
+}
+
+// ToProto converts the SvrSession to its protocol buffer representation.
+func (v *SvrSession) ToProto() *occludepb.SvrSession {
+	p := &occludepb.SvrSession{
+		Version:        uint32(v.Version),
+		TranscriptHash: uint32(v.TranscriptHash),
+		Beta:           encodeElement(v.Beta),
+		Xs:             encodeElement(v.Xs),
+		Fk1:            v.fk1,
+		C:              v.c.toProto(),
+		Signature:      v.Signature,
+		Argon2:         v.Argon2.toProto(),
+		Xu:             encodeElement(v.Xu),
+		Sequence:       v.Sequence,
+		SessionEpoch:   v.SessionEpoch,
+		OprfKey:        encodeElement(v.OPRFKey),
+		OprfProof:      v.OPRFProof,
+	}
+	if v.Envelope != nil {
+		p.Envelope = v.Envelope.ToProto()
+	}
+	if v.Argon2Upgrade != nil {
+		p.Argon2Upgrade = v.Argon2Upgrade.toProto()
+	}
+	return p
+}
+
+// FromProto sets the SvrSession from its protocol buffer representation,
+// validating the encodings of its group elements.
+func (v *SvrSession) FromProto(p *occludepb.SvrSession) error {
+	return v.fromProto(p, false)
+}
+
+// FromProtoStrict is FromProto, additionally rejecting group elements which
+// are the identity with ErrIdentityElement.
+func (v *SvrSession) FromProtoStrict(p *occludepb.SvrSession) error {
+	return v.fromProto(p, true)
+}
+
+func (v *SvrSession) fromProto(p *occludepb.SvrSession, strict bool) error {
+	if p == nil {
+		return ErrNilMessage
+	}
+	if p.Version > 0xff || p.TranscriptHash > 0xff {
+		return fmt.Errorf("%w: Version", ErrMalformedMessage)
+	}
+	argon2, err := argon2ParamsFromProto(p.Argon2)
+	if err != nil {
+		return err
+	}
+	beta, err := decodeElement("Beta", p.Beta, strict)
+	if err != nil {
+		return err
+	}
+	xs, err := decodeElement("Xs", p.Xs, strict)
+	if err != nil {
+		return err
+	}
+	decoded := SvrSession{
+		Version:        Version(p.Version),
+		TranscriptHash: TranscriptHash(p.TranscriptHash),
+		Argon2:         argon2,
+		Beta:           beta,
+		Xs:             xs,
+		fk1:            p.Fk1,
+		c:              authCiphertextFromProto(p.C),
+		Signature:      p.Signature,
+		Sequence:       p.Sequence,
+		SessionEpoch:   p.SessionEpoch,
+		OPRFProof:      p.OprfProof,
+	}
+	if len(p.Xu) != 0 {
+		decoded.Xu, err = decodeElement("Xu", p.Xu, strict)
+		if err != nil {
+			return err
+		}
+	}
+	if len(p.OprfKey) != 0 {
+		decoded.OPRFKey, err = decodeElement("OPRFKey", p.OprfKey, strict)
+		if err != nil {
+			return err
+		}
+	}
+	if p.Argon2Upgrade != nil {
+		upgrade, err := argon2ParamsFromProto(p.Argon2Upgrade)
+		if err != nil {
+			return err
+		}
+		decoded.Argon2Upgrade = &upgrade
+	}
+	if p.Envelope != nil {
+		decoded.Envelope = new(Envelope)
+		if err := decoded.Envelope.FromProto(p.Envelope); err != nil {
+			return err
+		}
+	}
+	*v = decoded
+	return nil
+}
+
+// ToProto converts the Registration to its protocol buffer representation.
+func (r *Registration) ToProto() *occludepb.Registration {
+	p := &occludepb.Registration{
+		Id:             r.ID,
+		Aci:            r.aci.toProto(),
+		Pu:             encodeElement(r.Pu),
+		PasswordPrefix: r.PasswordPrefix,
+		IdempotencyKey: r.IdempotencyKey,
+		Argon2:         r.Argon2.toProto(),
+		SecondFactor:   encodeElement(r.SecondFactor),
+		StrengthScore:  uint32(r.StrengthScore),
+	}
+	if r.recovery != nil {
+		p.Recovery = r.recovery.toProto()
+	}
+	if r.appData != nil {
+		p.AppData = &occludepb.ChunkedEnvelope{Salt: r.appData.Salt}
+		for _, chunk := range r.appData.chunks {
+			p.AppData.Chunks = append(p.AppData.Chunks, chunk.toProto())
+		}
+	}
+	return p
+}
+
+// FromProto sets the Registration from its protocol buffer representation,
+// validating the encodings of its group elements.
+func (r *Registration) FromProto(p *occludepb.Registration) error {
+	return r.fromProto(p, false)
+}
+
+// FromProtoStrict is FromProto, additionally rejecting group elements which
+// are the identity with ErrIdentityElement.
+func (r *Registration) FromProtoStrict(p *occludepb.Registration) error {
+	return r.fromProto(p, true)
+}
+
+func (r *Registration) fromProto(p *occludepb.Registration, strict bool) error {
+	if p == nil {
+		return ErrNilMessage
+	}
+	pu, err := decodeElement("Pu", p.Pu, strict)
+	if err != nil {
+		return err
+	}
+	argon2, err := argon2ParamsFromProto(p.Argon2)
+	if err != nil {
+		return err
+	}
+	if p.StrengthScore > 0xff {
+		return fmt.Errorf("%w: StrengthScore", ErrMalformedMessage)
+	}
+	decoded := Registration{
+		ID:             p.Id,
+		aci:            authCiphertextFromProto(p.Aci),
+		Pu:             pu,
+		Argon2:         argon2,
+		PasswordPrefix: p.PasswordPrefix,
+		IdempotencyKey: p.IdempotencyKey,
+		StrengthScore:  uint8(p.StrengthScore),
+	}
+	if len(p.SecondFactor) != 0 {
+		decoded.SecondFactor, err = decodeElement("SecondFactor", p.SecondFactor, strict)
+		if err != nil {
+			return err
+		}
+	}
+	if p.Recovery != nil {
+		recovery, err := recoveryEnvelopeFromProto(p.Recovery)
+		if err != nil {
+			return err
+		}
+		decoded.recovery = &recovery
+	}
+	if p.AppData != nil {
+		decoded.appData = &ChunkedEnvelope{Salt: p.AppData.Salt}
+		for _, chunk := range p.AppData.Chunks {
+			decoded.appData.chunks = append(decoded.appData.chunks, authCiphertextFromProto(chunk))
+		}
+	}
+	*r = decoded
+	return nil
+}
+
+// ToProto converts the ClientVerification to its protocol buffer
+// representation.
+func (cv *ClientVerification) ToProto() *occludepb.ClientVerification {
+	return &occludepb.ClientVerification{
+		Id:  cv.ID,
+		Fk2: cv.FK2,
+	}
+}
+
+// FromProto sets the ClientVerification from its protocol buffer
+// representation.
+func (cv *ClientVerification) FromProto(p *occludepb.ClientVerification) error {
+	if p == nil {
+		return ErrNilMessage
+	}
+	*cv = ClientVerification{
+		ID:  p.Id,
+		FK2: p.Fk2,
+	}
+	return nil
+}
+
+// ToProto converts the Envelope to its protocol buffer representation.
+func (env *Envelope) ToProto() *occludepb.Envelope {
+	return &occludepb.Envelope{
+		Label:       env.Label,
+		Salt:        env.Salt,
+		C:           env.c.toProto(),
+		ExportKeyed: env.ExportKeyed,
+	}
+}
+
+// FromProto sets the Envelope from its protocol buffer representation.
+func (env *Envelope) FromProto(p *occludepb.Envelope) error {
+	if p == nil {
+		return ErrNilMessage
+	}
+	*env = Envelope{
+		Label:       p.Label,
+		Salt:        p.Salt,
+		ExportKeyed: p.ExportKeyed,
+		c:           authCiphertextFromProto(p.C),
+	}
+	return nil
+}
+
+// ToProto converts the RecoveryChallenge to its protocol buffer
+// representation.
+func (rc *RecoveryChallenge) ToProto() *occludepb.RecoveryChallenge {
+	return &occludepb.RecoveryChallenge{
+		Id:       rc.ID,
+		Nonce:    rc.Nonce,
+		Envelope: rc.envelope.toProto(),
+	}
+}
+
+// FromProto sets the RecoveryChallenge from its protocol buffer
+// representation.
+func (rc *RecoveryChallenge) FromProto(p *occludepb.RecoveryChallenge) error {
+	if p == nil || p.Envelope == nil {
+		return ErrNilMessage
+	}
+	envelope, err := recoveryEnvelopeFromProto(p.Envelope)
+	if err != nil {
+		return err
+	}
+	*rc = RecoveryChallenge{
+		ID:       p.Id,
+		Nonce:    p.Nonce,
+		envelope: envelope,
+	}
+	return nil
+}
+
+// ToProto converts the RecoveryProof to its protocol buffer representation.
+func (rp *RecoveryProof) ToProto() *occludepb.RecoveryProof {
+	return &occludepb.RecoveryProof{
+		Id:        rp.ID,
+		Signature: rp.Signature,
+	}
+}
+
+// FromProto sets the RecoveryProof from its protocol buffer representation.
+func (rp *RecoveryProof) FromProto(p *occludepb.RecoveryProof) error {
+	if p == nil {
+		return ErrNilMessage
+	}
+	*rp = RecoveryProof{
+		ID:        p.Id,
+		Signature: p.Signature,
+	}
+	return nil
+}
+
+func (env recoveryEnvelope) toProto() *occludepb.RecoveryEnvelope {
+	return &occludepb.RecoveryEnvelope{
+		Argon2: env.Argon2.toProto(),
+		Salt:   env.Salt,
+		C:      env.c.toProto(),
+	}
+}
+
+func recoveryEnvelopeFromProto(p *occludepb.RecoveryEnvelope) (recoveryEnvelope, error) {
+	argon2, err := argon2ParamsFromProto(p.Argon2)
+	if err != nil {
+		return recoveryEnvelope{}, err
+	}
+	return recoveryEnvelope{
+		Argon2: argon2,
+		Salt:   p.Salt,
+		c:      authCiphertextFromProto(p.C),
+	}, nil
+}
+
+func (a authCiphertext) toProto() *occludepb.AuthCiphertext {
+	return &occludepb.AuthCiphertext{
+		Tag:        a.Tag,
+		Ciphertext: a.Ciphertext,
+		Salt:       a.Salt,
+	}
+}
+
+func authCiphertextFromProto(p *occludepb.AuthCiphertext) authCiphertext {
+	return authCiphertext{
+		Tag:        p.GetTag(),
+		Ciphertext: p.GetCiphertext(),
+		Salt:       p.GetSalt(),
+	}
+}
+
+func (p Argon2Params) toProto() *occludepb.Argon2Params {
+	return &occludepb.Argon2Params{
+		Time:    p.Time,
+		Memory:  p.Memory,
+		Threads: uint32(p.Threads),
+	}
+}
+
+func argon2ParamsFromProto(p *occludepb.Argon2Params) (Argon2Params, error) {
+	if p.GetThreads() > 0xff {
+		return Argon2Params{}, fmt.Errorf("%w: Argon2", ErrMalformedMessage)
+	}
+	return Argon2Params{
+		Time:    p.GetTime(),
+		Memory:  p.GetMemory(),
+		Threads: uint8(p.GetThreads()),
+	}, nil
+}
+
+// encodeElement returns the canonical encoding of e, or nil if e is nil.
+func encodeElement(e *ristretto.Element) []byte {
+	if e == nil {
+		return nil
+	}
+	return e.Encode(nil)
+}
+
+// decodeElement decodes the canonical encoding of the group element named
+// field, rejecting the identity if strict is set.
+func decodeElement(field string, b []byte, strict bool) (*ristretto.Element, error) {
+	if len(b) != elementSize {
+		return nil, fmt.Errorf("%w: %s", ErrMalformedMessage, field)
+	}
+	e, err := group.element(b)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrMalformedMessage, field)
+	}
+	if strict {
+		if err := checkNonIdentity(field, e); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
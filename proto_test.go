@@ -0,0 +1,156 @@
+package occlude
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	"occlude/occludepb"
+)
+
+// protoRoundTrip marshals m with protobuf and unmarshals the result into out.
+func protoRoundTrip(t *testing.T, m proto.Message, out proto.Message) {
+	t.Helper()
+	data, err := proto.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := proto.Unmarshal(data, out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// verify that a full registration and login succeeds with every message sent
+// through its protobuf encoding.
+func TestProtoHandshake(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer()
+	c := NewClient(testusername)
+	pr, err := s.NewRegistration(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err := c.NewRegistration(pr, testusername, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pbReg occludepb.Registration
+	protoRoundTrip(t, reg.ToProto(), &pbReg)
+	var decodedReg Registration
+	if err := decodedReg.FromProto(&pbReg); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register(&decodedReg); err != nil {
+		t.Fatal(err)
+	}
+
+	cv := login(t, s, c, testpassword, "")
+	env, err := c.SealEnvelope("laptop", []byte("laptop device key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pbEnv occludepb.Envelope
+	protoRoundTrip(t, env.ToProto(), &pbEnv)
+	var decodedEnv Envelope
+	if err := decodedEnv.FromProto(&pbEnv); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddEnvelope(cv, &decodedEnv); err != nil {
+		t.Fatal(err)
+	}
+
+	sess, err := c.NewSessionWithEnvelope(testpassword, "laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pbSess occludepb.UsrSession
+	protoRoundTrip(t, sess.ToProto(), &pbSess)
+	var decodedSess UsrSession
+	if err := decodedSess.FromProto(&pbSess); err != nil {
+		t.Fatal(err)
+	}
+	svrsess, sessionKey, err := s.NewSession(&decodedSess)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pbSvrsess occludepb.SvrSession
+	protoRoundTrip(t, svrsess.ToProto(), &pbSvrsess)
+	var decodedSvrsess SvrSession
+	if err := decodedSvrsess.FromProto(&pbSvrsess); err != nil {
+		t.Fatal(err)
+	}
+	clientSessionKey, fk2, err := c.SessionKey(&decodedSvrsess, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sessionKey, clientSessionKey) {
+		t.Fatal("client and server did not compute identical session key")
+	}
+	if !bytes.Equal(c.EnvelopeData(), []byte("laptop device key")) {
+		t.Fatal("envelope did not survive encoding")
+	}
+
+	var pbCV occludepb.ClientVerification
+	protoRoundTrip(t, (&ClientVerification{ID: testusername, FK2: fk2}).ToProto(), &pbCV)
+	var decodedCV ClientVerification
+	if err := decodedCV.FromProto(&pbCV); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.FinishSession(&decodedCV); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// verify that FromProto rejects invalid element encodings and nil messages.
+func TestProtoInvalidElements(t *testing.T) {
+	sess, err := NewClient("this is a test username").NewSession("this is a test password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mutate := range []func(*occludepb.UsrSession){
+		func(p *occludepb.UsrSession) { p.Alpha = nil },
+		func(p *occludepb.UsrSession) { p.Xu = p.Xu[:elementSize-1] },
+		func(p *occludepb.UsrSession) { p.Alpha = bytes.Repeat([]byte{0xff}, elementSize) },
+	} {
+		p := sess.ToProto()
+		mutate(p)
+		if err := new(UsrSession).FromProto(p); !errors.Is(err, ErrMalformedMessage) {
+			t.Fatal("expected ErrMalformedMessage, got", err)
+		}
+	}
+	if err := new(SvrSession).FromProto(&occludepb.SvrSession{Version: 0x100}); !errors.Is(err, ErrMalformedMessage) {
+		t.Fatal("expected ErrMalformedMessage, got", err)
+	}
+	if err := new(Registration).FromProto(nil); err != ErrNilMessage {
+		t.Fatal("expected ErrNilMessage, got", err)
+	}
+}
+
+// verify that FromProto accepts identity elements, while FromProtoStrict
+// rejects them with ErrIdentityElement.
+func TestProtoStrictDecoding(t *testing.T) {
+	usr, svr, reg := identityMessages()
+	if err := new(UsrSession).FromProto(usr.ToProto()); err != nil {
+		t.Fatal(err)
+	}
+	if err := new(UsrSession).FromProtoStrict(usr.ToProto()); !errors.Is(err, ErrIdentityElement) {
+		t.Fatal("expected ErrIdentityElement, got", err)
+	}
+	if err := new(SvrSession).FromProto(svr.ToProto()); err != nil {
+		t.Fatal(err)
+	}
+	if err := new(SvrSession).FromProtoStrict(svr.ToProto()); !errors.Is(err, ErrIdentityElement) {
+		t.Fatal("expected ErrIdentityElement, got", err)
+	}
+	if err := new(Registration).FromProto(reg.ToProto()); err != nil {
+		t.Fatal(err)
+	}
+	if err := new(Registration).FromProtoStrict(reg.ToProto()); !errors.Is(err, ErrIdentityElement) {
+		t.Fatal("expected ErrIdentityElement, got", err)
+	}
+}
@@ -0,0 +1,54 @@
+package occlude
+
+import (
+	"crypto/hmac"
+	"encoding/hex"
+	"errors"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// minPseudonymKeySize is the minimum length of a pseudonym key, in bytes.
+const minPseudonymKeySize = 32
+
+// ErrNoPseudonymKey is returned by Server.Pseudonym when the Server was not
+// configured WithPseudonymKey.
+var ErrNoPseudonymKey = errors.New("no pseudonym key configured")
+
+// WithPseudonymKey configures the secret from which the Server derives the
+// per-realm pseudonyms returned by Pseudonym. The key must be at least 32
+// bytes, and must be kept stable, and secret, for pseudonyms to remain stable
+// and unlinkable.
+func WithPseudonymKey(key []byte) ServerOption {
+	return func(s *Server) {
+		s.pseudonymKey = append([]byte(nil), key...)
+	}
+}
+
+// Pseudonym returns the pseudonym of the registered user id for the relying
+// party realm, for example after their login has been finished with
+// FinishSession. A user's pseudonym is stable within a realm, while the
+// pseudonyms of the same user in different realms can not be linked without
+// the Server's pseudonym key, so relying parties can not correlate users
+// across realms.
+func (s *Server) Pseudonym(id string, realm string) (string, error) {
+	if len(s.pseudonymKey) < minPseudonymKeySize {
+		return "", ErrNoPseudonymKey
+	}
+	id = s.userID(id)
+	s.mu.Lock()
+	_, exists := s.passwordFiles[id]
+	s.mu.Unlock()
+	if !exists {
+		return "", errors.New("no such sid")
+	}
+	mac := hmac.New(sha3.New256, realmKey(s.pseudonymKey, realm))
+	mac.Write([]byte(id))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// realmKey derives the key that pseudonyms for realm are computed with from
+// the Server's pseudonym key.
+func realmKey(pseudonymKey []byte, realm string) []byte {
+	return deriveKey(pseudonymKey, nil, append([]byte("occlude realm "), realm...), 32)
+}
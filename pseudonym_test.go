@@ -0,0 +1,69 @@
+package occlude
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// verify that a user's pseudonyms are stable within a realm, differ between
+// realms and users, and depend on the Server's pseudonym key.
+func TestPseudonym(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+	key := bytes.Repeat([]byte{1}, minPseudonymKeySize)
+
+	s := NewServer(WithArgon2Params(weakArgon2Params), WithPseudonymKey(key))
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+	register(t, s, NewClient("another user"), "another user", testpassword)
+	cv := login(t, s, c, testpassword, "")
+	if _, err := s.FinishSession(cv); err != nil {
+		t.Fatal(err)
+	}
+
+	pseudonym := func(s *Server, id string, realm string) string {
+		t.Helper()
+		p, err := s.Pseudonym(id, realm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	mail := pseudonym(s, testusername, "mail.example.com")
+	if pseudonym(s, testusername, "mail.example.com") != mail {
+		t.Fatal("pseudonym is not stable within a realm")
+	}
+	if pseudonym(s, testusername, "chat.example.com") == mail {
+		t.Fatal("pseudonyms in different realms are equal")
+	}
+	if pseudonym(s, "another user", "mail.example.com") == mail {
+		t.Fatal("different users have the same pseudonym")
+	}
+
+	restarted := NewServer(WithPseudonymKey(key))
+	encoded, err := s.MarshalPasswordFile(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.UnmarshalPasswordFile(testusername, encoded); err != nil {
+		t.Fatal(err)
+	}
+	if pseudonym(restarted, testusername, "mail.example.com") != mail {
+		t.Fatal("pseudonym changed with the same key")
+	}
+	other := NewServer(WithPseudonymKey(bytes.Repeat([]byte{2}, minPseudonymKeySize)))
+	if err := other.UnmarshalPasswordFile(testusername, encoded); err != nil {
+		t.Fatal(err)
+	}
+	if pseudonym(other, testusername, "mail.example.com") == mail {
+		t.Fatal("pseudonym does not depend on the key")
+	}
+
+	if _, err := s.Pseudonym("unknown user", "mail.example.com"); err == nil {
+		t.Fatal("derived a pseudonym for an unknown user")
+	}
+	if _, err := NewServer().Pseudonym(testusername, "mail.example.com"); !errors.Is(err, ErrNoPseudonymKey) {
+		t.Fatal("expected ErrNoPseudonymKey, got", err)
+	}
+}
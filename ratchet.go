@@ -0,0 +1,81 @@
+package occlude
+
+import (
+	"errors"
+	"sync"
+)
+
+// maxRatchetSkip is the most message keys Ratchet.KeyAt will skip over at
+// once.
+const maxRatchetSkip = 1 << 16
+
+// ErrRatchetIndex is returned by Ratchet.KeyAt for an index the Ratchet has
+// already passed, or which is too far ahead of it.
+var ErrRatchetIndex = errors.New("ratchet index is out of range")
+
+// Ratchet derives a sequence of per-message keys from a session key, for
+// forward-secure messaging over a session established by the handshake. Each
+// step derives the next message key and chain key from the current chain key
+// with HKDF, and erases the current chain key, so that the keys of earlier
+// messages can not be recovered from the Ratchet's state, even if it is later
+// compromised.
+//
+// Both parties create a Ratchet from the same session key and derive the
+// keys in the same order, so that the nth key of each is the same. A message
+// should be sent with the Index of its key, so that a receiver which has
+// missed messages can catch up with KeyAt. A Ratchet is one-way: two parties
+// which both send should use a separate Ratchet for each direction, seeded
+// from distinct keys derived from the session key. A Ratchet is safe for
+// concurrent use by multiple goroutines.
+type Ratchet struct {
+	mu    sync.Mutex
+	chain []byte
+	index uint64
+}
+
+// NewKeyRatchet creates a Ratchet seeded from the session key SK.
+func NewKeyRatchet(sessionKey []byte) *Ratchet {
+	return &Ratchet{chain: deriveKey(sessionKey, nil, []byte("occlude ratchet seed"), 32)}
+}
+
+// Index returns the index of the key the next call to Next will return.
+func (r *Ratchet) Index() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.index
+}
+
+// Next returns the next message key and advances the Ratchet.
+func (r *Ratchet) Next() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.step()
+}
+
+// KeyAt returns the message key at index, advancing the Ratchet past it and
+// past any skipped keys, which are erased. It returns ErrRatchetIndex if index
+// has already been passed, or is more than 65536 keys ahead.
+func (r *Ratchet) KeyAt(index uint64) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if index < r.index || index-r.index > maxRatchetSkip {
+		return nil, ErrRatchetIndex
+	}
+	for r.index < index {
+		clear(r.step())
+	}
+	return r.step(), nil
+}
+
+// step returns the current message key and replaces the chain key with the
+// next one. The caller must hold r.mu.
+func (r *Ratchet) step() []byte {
+	keys := deriveKeys(r.chain, nil, []keySpec{
+		{[]byte("occlude ratchet message"), 32},
+		{[]byte("occlude ratchet chain"), 32},
+	})
+	clear(r.chain)
+	r.chain = keys[1]
+	r.index++
+	return keys[0]
+}
@@ -0,0 +1,83 @@
+package occlude
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// verify that both parties of a login derive identical ratchet sequences, and
+// that a receiver which missed messages can catch up.
+func TestRatchetSync(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, serverKey, err := s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientKey, _, err := c.SessionKey(svrsess, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sender, receiver := NewKeyRatchet(serverKey), NewKeyRatchet(clientKey)
+	seen := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		index := sender.Index()
+		key := sender.Next()
+		if seen[string(key)] {
+			t.Fatal("ratchet repeated a key")
+		}
+		seen[string(key)] = true
+		if i%3 == 0 {
+			// the receiver misses this message.
+			continue
+		}
+		got, err := receiver.KeyAt(index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, key) {
+			t.Fatalf("ratchets disagree at index %v", index)
+		}
+	}
+	if _, err := receiver.KeyAt(0); !errors.Is(err, ErrRatchetIndex) {
+		t.Fatalf("expected ErrRatchetIndex for a passed index, got %v", err)
+	}
+	if _, err := receiver.KeyAt(receiver.Index() + maxRatchetSkip + 1); !errors.Is(err, ErrRatchetIndex) {
+		t.Fatalf("expected ErrRatchetIndex for a distant index, got %v", err)
+	}
+}
+
+// verify that the Ratchet's state after a step can not reproduce any earlier
+// key, and that it erases the chain keys it has passed.
+func TestRatchetForwardSecrecy(t *testing.T) {
+	r := NewKeyRatchet([]byte("this is a test session key"))
+	var earlier [][]byte
+	for i := 0; i < 5; i++ {
+		earlier = append(earlier, r.Next())
+	}
+
+	old := r.chain
+	compromised := &Ratchet{chain: append([]byte(nil), r.chain...), index: r.index}
+	r.Next()
+	if !bytes.Equal(old, make([]byte, len(old))) {
+		t.Fatal("ratchet did not erase its previous chain key")
+	}
+	for i := 0; i < 100; i++ {
+		key := compromised.Next()
+		for _, e := range earlier {
+			if bytes.Equal(key, e) {
+				t.Fatal("compromised ratchet state reproduced an earlier key")
+			}
+		}
+	}
+}
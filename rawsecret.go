@@ -0,0 +1,56 @@
+package occlude
+
+import (
+	"errors"
+)
+
+// ErrRawSecretNotAllowed is returned by Server.FinishSessionRaw when the
+// Server was not configured WithAllowRawSecret.
+var ErrRawSecretNotAllowed = errors.New("raw shared secret not allowed")
+
+// WithAllowRawSecret configures the Server to release the raw shared secret K
+// of each login, from which SK, fk1 and fk2 are derived, from
+// FinishSessionRaw, for integrations which run their own key schedule. The
+// client releases the same K with WithClientAllowRawSecret.
+//
+// NOTE: this is for experts only. K is as powerful as every key derived from
+// it: anyone who learns it can compute the session key, and forge the
+// confirmation of the login. Keys derived from it must be domain separated
+// from occlude's own, for example with HKDF and a distinct info, and K must be
+// cleared once they are. It is the output of the TranscriptHash, so it is 32
+// bytes long with the default TranscriptSHA3_256, and 64 with the others.
+func WithAllowRawSecret() ServerOption {
+	return func(s *Server) {
+		s.allowRawSecret = true
+	}
+}
+
+// WithClientAllowRawSecret configures the Client to release the raw shared
+// secret K of each login in LoginResult.RawSecret. The NOTE on
+// WithAllowRawSecret applies.
+func WithClientAllowRawSecret() ClientOption {
+	return func(c *Client) {
+		c.allowRawSecret = true
+	}
+}
+
+// FinishSessionRaw is FinishSession, additionally returning the raw shared
+// secret K of the login. It returns ErrRawSecretNotAllowed, without finishing
+// the session, if the Server was not configured WithAllowRawSecret. Like SK
+// in strict mode, K is only released once the client has proven that it
+// derived the same K.
+func (s *Server) FinishSessionRaw(cv *ClientVerification) (sk []byte, rawSecret []byte, err error) {
+	done, err := s.permitAuthentication()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer done()
+	if !s.allowRawSecret {
+		return nil, nil, ErrRawSecretNotAllowed
+	}
+	sess, err := s.finishSession(cv)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sess.sk, sess.rawSecret, nil
+}
@@ -0,0 +1,59 @@
+package occlude
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// verify that the client and server release the same raw shared secret K
+// only when allowed to.
+func TestRawSecret(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	for _, allowed := range []bool{false, true} {
+		var serverOpts []ServerOption
+		var clientOpts []ClientOption
+		if allowed {
+			serverOpts = []ServerOption{WithAllowRawSecret()}
+			clientOpts = []ClientOption{WithClientAllowRawSecret()}
+		}
+		s := NewServer(append(serverOpts, WithArgon2Params(weakArgon2Params))...)
+		c := NewClient(testusername, clientOpts...)
+		register(t, s, c, testusername, testpassword)
+
+		sess, err := c.NewSession(testpassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		svrsess, _, err := s.NewSession(sess)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := c.FinishLogin(svrsess, testpassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sk, K, err := s.FinishSessionRaw(result.Verification())
+		if !allowed {
+			if !errors.Is(err, ErrRawSecretNotAllowed) || result.RawSecret != nil {
+				t.Fatal("raw secret released without being allowed", err)
+			}
+			// the session is not consumed.
+			if _, err := s.FinishSession(result.Verification()); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(K) != 32 || !bytes.Equal(K, result.RawSecret) {
+			t.Fatal("client and server raw secrets differ")
+		}
+		if !bytes.Equal(sk, result.SessionKey) {
+			t.Fatal("session keys differ")
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package occlude
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	// recoveryCodeSize is the number of secret bytes encoded in a recovery
+	// code, and recoveryChecksumSize the number of checksum bytes appended to
+	// them.
+	recoveryCodeSize     = 16
+	recoveryChecksumSize = 4
+
+	// recoveryGroupSize is the number of characters in each dash-separated
+	// group of a formatted recovery code.
+	recoveryGroupSize = 4
+)
+
+var (
+	// ErrInvalidRecoveryCode is returned when a recovery code is malformed or
+	// its checksum does not match, for example because it was mistyped.
+	ErrInvalidRecoveryCode = errors.New("invalid recovery code")
+
+	// ErrRecoveryCodeMismatch is returned when a well-formed recovery code was
+	// not derived from the provided export key.
+	ErrRecoveryCodeMismatch = errors.New("recovery code does not match")
+
+	recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// DeriveRecoveryCode deterministically derives a human-presentable recovery
+// code from an export key, as returned by Client.ExportKey. Since the export
+// key is stable across logins with the same password, so is the recovery code.
+// The code is a base32 string in dash-separated groups, ending in a checksum
+// that detects typing mistakes.
+//
+// NOTE: the recovery code is as sensitive as the export key it was derived
+// from, and should be shown to the user only once for offline storage.
+func DeriveRecoveryCode(exportKey []byte) (string, error) {
+	if len(exportKey) < 32 {
+		return "", errors.New("export key is too short")
+	}
+	return formatRecoveryCode(deriveKey(exportKey, nil, []byte("occlude recovery code"), recoveryCodeSize)), nil
+}
+
+// GenerateRecoveryCode returns a random recovery code, formatted as by
+// DeriveRecoveryCode, holding 128 bits of entropy. It is the recovery secret
+// for NewRegistrationWithRecovery, and should be shown to the user only once
+// for offline storage.
+func GenerateRecoveryCode() (string, error) {
+	code := make([]byte, recoveryCodeSize)
+	if _, err := rand.Read(code); err != nil {
+		return "", err
+	}
+	defer clear(code)
+	return formatRecoveryCode(code), nil
+}
+
+// VerifyRecoveryCode checks that code is a well-formed recovery code which was
+// derived from exportKey. Case, dashes and spaces in code are ignored.
+func VerifyRecoveryCode(exportKey []byte, code string) error {
+	decoded, err := parseRecoveryCode(code)
+	if err != nil {
+		return err
+	}
+	if len(exportKey) < 32 {
+		return errors.New("export key is too short")
+	}
+	expected := deriveKey(exportKey, nil, []byte("occlude recovery code"), recoveryCodeSize)
+	if subtle.ConstantTimeCompare(expected, decoded) != 1 {
+		return ErrRecoveryCodeMismatch
+	}
+	return nil
+}
+
+// formatRecoveryCode encodes the secret bytes of a recovery code, with their
+// checksum, as a base32 string in dash-separated groups.
+func formatRecoveryCode(code []byte) string {
+	encoded := recoveryEncoding.EncodeToString(append(append([]byte(nil), code...), recoveryChecksum(code)...))
+	var groups []string
+	for i := 0; i < len(encoded); i += recoveryGroupSize {
+		groups = append(groups, encoded[i:i+recoveryGroupSize])
+	}
+	return strings.Join(groups, "-")
+}
+
+// parseRecoveryCode decodes a recovery code formatted by formatRecoveryCode,
+// ignoring case, dashes and spaces, and returns its secret bytes. It returns
+// ErrInvalidRecoveryCode if code is malformed or its checksum does not match.
+func parseRecoveryCode(code string) ([]byte, error) {
+	normalized := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	decoded, err := recoveryEncoding.DecodeString(normalized)
+	if err != nil || len(decoded) != recoveryCodeSize+recoveryChecksumSize {
+		return nil, ErrInvalidRecoveryCode
+	}
+	if subtle.ConstantTimeCompare(recoveryChecksum(decoded[:recoveryCodeSize]), decoded[recoveryCodeSize:]) != 1 {
+		return nil, ErrInvalidRecoveryCode
+	}
+	return decoded[:recoveryCodeSize], nil
+}
+
+// recoveryChecksum computes the checksum appended to a recovery code.
+func recoveryChecksum(code []byte) []byte {
+	sum := sha3.Sum256(code)
+	return sum[:recoveryChecksumSize]
+}
@@ -0,0 +1,86 @@
+package occlude
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// verify that the recovery code is stable across logins with the same
+// password.
+func TestRecoveryCodeDeterministic(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer()
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+	registrationExportKey := c.ExportKey()
+	if len(registrationExportKey) == 0 {
+		t.Fatal("registration did not derive an export key")
+	}
+
+	var codes []string
+	for i := 0; i < 2; i++ {
+		sess, err := c.NewSession(testpassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		svrsess, _, err := s.NewSession(sess)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := c.SessionKey(svrsess, testpassword); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(c.ExportKey(), registrationExportKey) {
+			t.Fatal("login derived a different export key than registration")
+		}
+		code, err := DeriveRecoveryCode(c.ExportKey())
+		if err != nil {
+			t.Fatal(err)
+		}
+		codes = append(codes, code)
+	}
+	if codes[0] != codes[1] {
+		t.Fatal("recovery code changed across logins")
+	}
+	if err := VerifyRecoveryCode(registrationExportKey, codes[0]); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// verify that the recovery code checksum detects mistyped codes, and that a
+// code only verifies against the export key it was derived from.
+func TestRecoveryCodeChecksum(t *testing.T) {
+	exportKey := bytes.Repeat([]byte{1}, 32)
+	code, err := DeriveRecoveryCode(exportKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := VerifyRecoveryCode(exportKey, strings.ToLower(strings.ReplaceAll(code, "-", " "))); err != nil {
+		t.Fatal("recovery code did not verify after reformatting:", err)
+	}
+
+	typo := []byte(code)
+	if typo[0] == 'A' {
+		typo[0] = 'B'
+	} else {
+		typo[0] = 'A'
+	}
+	if err := VerifyRecoveryCode(exportKey, string(typo)); err != ErrInvalidRecoveryCode {
+		t.Fatal("expected ErrInvalidRecoveryCode for a mistyped code, got", err)
+	}
+	if err := VerifyRecoveryCode(exportKey, code[:len(code)-5]); err != ErrInvalidRecoveryCode {
+		t.Fatal("expected ErrInvalidRecoveryCode for a truncated code, got", err)
+	}
+
+	otherKey := bytes.Repeat([]byte{2}, 32)
+	if err := VerifyRecoveryCode(otherKey, code); err != ErrRecoveryCodeMismatch {
+		t.Fatal("expected ErrRecoveryCodeMismatch, got", err)
+	}
+	if _, err := DeriveRecoveryCode(nil); err == nil {
+		t.Fatal("derived a recovery code from an empty export key")
+	}
+}
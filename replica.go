@@ -0,0 +1,37 @@
+package occlude
+
+import (
+	"errors"
+)
+
+// ErrReadOnly is returned by a Server constructed WithReadOnlyReplica for an
+// operation which would modify its password files.
+var ErrReadOnly = errors.New("server is a read-only replica")
+
+// WithReadOnlyReplica configures the Server as an edge replica of a primary
+// Server, which logs users in against password files replicated from the
+// primary but never modifies them itself. Registration and every other
+// operation which writes a password file, including Register, Deregister,
+// NewUpgrade, AddEnvelope, RemoveEnvelope and UpgradeArgon2, is rejected with
+// ErrReadOnly, and the lazy upgrades made on login, re-peppering and
+// WithArgon2UpgradeOnLogin, are disabled. The store is updated only by
+// replication, with UnmarshalPasswordFile and RestoreSnapshot.
+//
+// NOTE: logins at a replica do not advance the user's login sequence, so
+// WithLoginSequence only protects them against replays older than the
+// primary's most recently replicated login.
+func WithReadOnlyReplica() ServerOption {
+	return func(s *Server) {
+		s.role = roleReplica
+	}
+}
+
+// permitMutation is permitAuthentication for operations which modify the
+// password file of a user logging in. It returns ErrReadOnly if the Server is
+// a replica.
+func (s *Server) permitMutation() (done func(), err error) {
+	if s.role == roleReplica {
+		return nil, ErrReadOnly
+	}
+	return s.permitAuthentication()
+}
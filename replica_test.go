@@ -0,0 +1,78 @@
+package occlude
+
+import (
+	"testing"
+)
+
+// verify that a replica logs users in against a snapshot of its primary, but
+// refuses every mutation and makes no lazy upgrades.
+func TestReadOnlyReplica(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	k := GenerateIdentityKey()
+	primary := NewServer(WithArgon2Params(weakArgon2Params), WithIdentityKey(k))
+	c := NewClient(testusername)
+	register(t, primary, c, testusername, testpassword)
+	snapshot, err := primary.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stronger := weakArgon2Params
+	stronger.Time++
+	replica := NewServer(WithArgon2Params(stronger), WithIdentityKey(k), WithReadOnlyReplica(), WithArgon2UpgradeOnLogin())
+	if err := replica.RestoreSnapshot(snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := replica.NewRegistration("new user"); err != ErrReadOnly {
+		t.Fatal("expected ErrReadOnly from NewRegistration, got", err)
+	}
+	if err := replica.Register(&Registration{ID: "new user"}); err != ErrReadOnly {
+		t.Fatal("expected ErrReadOnly from Register, got", err)
+	}
+	if err := replica.Deregister(testusername); err != ErrReadOnly {
+		t.Fatal("expected ErrReadOnly from Deregister, got", err)
+	}
+
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err := replica.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svrsess.Argon2Upgrade != nil {
+		t.Fatal("replica asked for an Argon2 upgrade")
+	}
+	_, fk2, err := c.SessionKey(svrsess, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cv := &ClientVerification{ID: sess.Sid, FK2: fk2}
+	if err := replica.AddEnvelope(cv, &Envelope{Label: "label"}); err != ErrReadOnly {
+		t.Fatal("expected ErrReadOnly from AddEnvelope, got", err)
+	}
+	if err := replica.RemoveEnvelope(cv, "label"); err != ErrReadOnly {
+		t.Fatal("expected ErrReadOnly from RemoveEnvelope, got", err)
+	}
+	if err := replica.UpgradeArgon2(cv, &Argon2Upgrade{ID: testusername}); err != ErrReadOnly {
+		t.Fatal("expected ErrReadOnly from UpgradeArgon2, got", err)
+	}
+	if _, err := replica.NewUpgrade(cv); err != ErrReadOnly {
+		t.Fatal("expected ErrReadOnly from NewUpgrade, got", err)
+	}
+	if _, err := replica.FinishSession(cv); err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := replica.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(snapshot) {
+		t.Fatal("replica modified its password files")
+	}
+}
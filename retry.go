@@ -0,0 +1,55 @@
+package occlude
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// A login is retried by resending the same UsrSession when the SvrSession
+// sent in reply was lost or damaged in transit. SessionKey reports a damaged
+// SvrSession with ErrMalformedMessage, leaving the Client's ephemeral keys
+// intact, and Server.NewSession answers a retried UsrSession with the same
+// SvrSession as before, without repeating the OPRF evaluation or the key
+// exchange. A retry is only answered while the login remains within the
+// session TTL and has not been finished.
+//
+// An ErrServerAuthFailed, in contrast, means the SvrSession was intact but
+// did not authenticate under the password, which a retry can not change: the
+// login should be restarted from NewSession.
+
+// InFlightSession returns the UsrSession of the Client's login in progress, to
+// be resent to the server if SessionKey fails with ErrMalformedMessage, or
+// nil if there is no login in progress.
+func (c *Client) InFlightSession() *UsrSession {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.session
+}
+
+// checkWellFormed reports the length errors Validate would find in the
+// SvrSession, which a network-corrupted message may have, as
+// ErrMalformedMessage. They are checked before any other work, so that a
+// SessionKey which fails with ErrMalformedMessage can be retried.
+func (v *SvrSession) checkWellFormed() error {
+	if len(v.fk1) != prfSize {
+		return fmt.Errorf("%w: fk1", ErrMalformedMessage)
+	}
+	if err := v.c.validate(); err != nil {
+		return fmt.Errorf("%w: c", ErrMalformedMessage)
+	}
+	if v.Envelope != nil {
+		if err := v.Envelope.c.validate(); err != nil {
+			return fmt.Errorf("%w: Envelope", ErrMalformedMessage)
+		}
+	}
+	return nil
+}
+
+// retriedBy reports whether u is a retry of the UsrSession which started the
+// login sess.
+func (sess serverSession) retriedBy(u *UsrSession) bool {
+	r := sess.request
+	return r != nil && r.Sid == u.Sid && r.Envelope == u.Envelope && r.Sequence == u.Sequence &&
+		r.Alpha.Equal(u.Alpha) == 1 && r.Xu.Equal(u.Xu) == 1 &&
+		bytes.Equal(r.channelBinding, u.channelBinding)
+}
@@ -0,0 +1,91 @@
+package occlude
+
+import (
+	"errors"
+	"testing"
+)
+
+// verify that a SvrSession damaged in transit fails with ErrMalformedMessage
+// and the login can be retried from the same UsrSession, without the server
+// repeating its work, while a wrong password fails with ErrServerAuthFailed.
+func TestRetryLastRound(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err := s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := svrsess.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a truncated message fails to decode.
+	var damaged SvrSession
+	if err := damaged.UnmarshalBinary(data[:len(data)-1]); !errors.Is(err, ErrMalformedMessage) {
+		t.Fatalf("expected ErrMalformedMessage decoding a truncated SvrSession, got %v", err)
+	}
+	// a message which decodes but has a damaged field is rejected by
+	// SessionKey before it does any work.
+	damaged = *svrsess
+	damaged.fk1 = svrsess.fk1[:prfSize-1]
+	if _, _, err := c.SessionKey(&damaged, testpassword); !errors.Is(err, ErrMalformedMessage) {
+		t.Fatalf("expected ErrMalformedMessage, got %v", err)
+	}
+
+	// the retried request is answered with the same response.
+	retry := c.InFlightSession()
+	if retry != sess {
+		t.Fatal("PendingSession did not return the login in progress")
+	}
+	resent, _, err := s.NewSession(retry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resent.Xs.Equal(svrsess.Xs) != 1 {
+		t.Fatal("server repeated the key exchange for a retried request")
+	}
+	_, fk2, err := c.SessionKey(resent, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.FinishSession(&ClientVerification{ID: testusername, FK2: fk2}); err != nil {
+		t.Fatal(err)
+	}
+
+	// an intact response under the wrong password is an authentication
+	// failure.
+	sess, err = c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err = s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.SessionKey(svrsess, "this is the wrong password"); !errors.Is(err, ErrServerAuthFailed) {
+		t.Fatalf("expected ErrServerAuthFailed, got %v", err)
+	}
+
+	// a new request starts a new key exchange.
+	sess, err = c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh, _, err := s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fresh.Xs.Equal(svrsess.Xs) == 1 {
+		t.Fatal("server reused a response for a new request")
+	}
+}
@@ -0,0 +1,78 @@
+package occlude
+
+import (
+	"errors"
+)
+
+// ErrOperationNotPermitted is returned by a Server constructed
+// WithRegistrationOnly or WithAuthenticationOnly for an operation outside its
+// role.
+var ErrOperationNotPermitted = errors.New("operation not permitted by the server's role")
+
+// serverRole is the set of operations a Server performs.
+type serverRole uint8
+
+const (
+	// roleFull performs both registration and authentication.
+	roleFull serverRole = iota
+
+	// roleRegistration only performs registration: NewRegistration,
+	// Register, Deregister, ImportLegacyUser, NewLegacyRegistration,
+	// NewRecovery, FinishRecovery and NewUpgrade.
+	roleRegistration
+
+	// roleAuthentication only performs authentication: NewSession,
+	// NewPendingSession, FinishSession, NewPasswordCheck, CheckPasswordProof,
+	// AddEnvelope and RemoveEnvelope.
+	roleAuthentication
+
+	// roleReplica only performs authentication, and never modifies the
+	// password files it was loaded with.
+	roleReplica
+)
+
+// WithRegistrationOnly configures the Server as an enrollment service, which
+// registers users but rejects logins with ErrOperationNotPermitted. Together
+// with a server configured WithAuthenticationOnly it allows a deployment to
+// run least-privilege services over a shared set of password files.
+//
+// NOTE: NewUpgrade authenticates the user with a login in progress, so lazy
+// upgrades with SetActiveScheme are only available on a Server with both
+// roles.
+func WithRegistrationOnly() ServerOption {
+	return func(s *Server) {
+		s.role = roleRegistration
+	}
+}
+
+// WithAuthenticationOnly configures the Server as an authentication service,
+// which logs users in but rejects registrations with
+// ErrOperationNotPermitted.
+func WithAuthenticationOnly() ServerOption {
+	return func(s *Server) {
+		s.role = roleAuthentication
+	}
+}
+
+// permitRegistration returns ErrOperationNotPermitted if the Server does not
+// perform registration, or ErrReadOnly if it is a replica. Otherwise it begins the operation as with begin, and
+// the caller must call done when it completes.
+func (s *Server) permitRegistration() (done func(), err error) {
+	if s.role == roleReplica {
+		return nil, ErrReadOnly
+	}
+	if s.role == roleAuthentication {
+		return nil, ErrOperationNotPermitted
+	}
+	return s.begin()
+}
+
+// permitAuthentication returns ErrOperationNotPermitted if the Server does not
+// perform authentication. Otherwise it begins the operation as with begin,
+// and the caller must call done when it completes.
+func (s *Server) permitAuthentication() (done func(), err error) {
+	if s.role == roleRegistration {
+		return nil, ErrOperationNotPermitted
+	}
+	return s.begin()
+}
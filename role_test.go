@@ -0,0 +1,44 @@
+package occlude
+
+import (
+	"testing"
+)
+
+// verify that an authentication-only server refuses registration, and that a
+// registration-only server refuses logins.
+func TestServerRoles(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	auth := NewServer(WithAuthenticationOnly(), WithArgon2Params(weakArgon2Params))
+	if _, err := auth.NewRegistration(testusername); err != ErrOperationNotPermitted {
+		t.Fatal("expected ErrOperationNotPermitted from NewRegistration, got", err)
+	}
+	if err := auth.Register(&Registration{ID: testusername}); err != ErrOperationNotPermitted {
+		t.Fatal("expected ErrOperationNotPermitted from Register, got", err)
+	}
+	if err := auth.ImportLegacyUser(testusername, BcryptHash(nil)); err != ErrOperationNotPermitted {
+		t.Fatal("expected ErrOperationNotPermitted from ImportLegacyUser, got", err)
+	}
+
+	reg := NewServer(WithRegistrationOnly(), WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername)
+	register(t, reg, c, testusername, testpassword)
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := reg.NewSession(sess); err != ErrOperationNotPermitted {
+		t.Fatal("expected ErrOperationNotPermitted from NewSession, got", err)
+	}
+	if _, err := reg.FinishSession(&ClientVerification{ID: testusername}); err != ErrOperationNotPermitted {
+		t.Fatal("expected ErrOperationNotPermitted from FinishSession, got", err)
+	}
+	if _, err := reg.NewPasswordCheck(sess); err != ErrOperationNotPermitted {
+		t.Fatal("expected ErrOperationNotPermitted from NewPasswordCheck, got", err)
+	}
+
+	full := NewServer(WithArgon2Params(weakArgon2Params))
+	register(t, full, c, testusername, testpassword)
+	login(t, full, c, testpassword, "")
+}
@@ -0,0 +1,158 @@
+package occlude
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ExportRuntimeState encodes the in-flight state of the Server: the
+// registrations started with NewRegistration but not yet completed with
+// Register, and the logins started with NewSession but not yet finished with
+// FinishSession. A new Server loads it with ImportRuntimeState, so that a
+// process handing its sockets over to an upgraded binary can do so without
+// failing the handshakes in progress. Password files are not included, and
+// are persisted with Snapshot as before. If the Server is configured
+// WithStoreKey, the state is encrypted under the store key.
+//
+// NOTE: the state is secret. It holds the private keys of the pending
+// registrations and the session keys of the pending logins, from which a
+// holder can complete either in the user's place. The ephemeral key xs of a
+// login is discarded by NewSession, so it is the keys derived from it that are
+// exported, along with the shared secret K for a Server configured
+// WithAllowRawSecret. Password checks and account recoveries in progress are
+// not exported.
+func (s *Server) ExportRuntimeState() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := newEncoder(messageRuntimeState)
+	ids := make([]string, 0, len(s.pendingRegistrations))
+	for id := range s.pendingRegistrations {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	e.uint32(uint32(len(ids)))
+	for _, id := range ids {
+		pr := s.pendingRegistrations[id]
+		e.string(id)
+		e.scalar(pr.ks)
+		e.element(pr.Ps)
+		e.scalar(pr.ps)
+		e.uint8(uint8(pr.scheme.Version))
+		e.uint8(uint8(pr.scheme.TranscriptHash))
+		e.argon2Params(pr.scheme.Argon2)
+		e.boolean(pr.replace)
+		e.bytes(pr.expected)
+		e.boolean(pr.legacy)
+		e.uint64(uint64(pr.created.UnixNano()))
+		e.uint32(pr.pepperEpoch)
+		e.bytes(pr.cancelToken)
+		e.bytes(pr.keyNonce)
+	}
+
+	ids = ids[:0]
+	for id := range s.sessions {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	e.uint32(uint32(len(ids)))
+	for _, id := range ids {
+		sess := s.sessions[id]
+		request, err := sess.request.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		response, err := sess.response.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		e.string(id)
+		e.bytes(sess.sk)
+		e.bytes(sess.fk2)
+		e.uint64(uint64(sess.created.UnixNano()))
+		e.bytes(request)
+		e.bytes(sess.request.channelBinding)
+		e.bytes(response)
+		e.bytes(sess.rawSecret)
+		e.uint64(sess.sequence)
+		e.boolean(sess.unknown)
+	}
+	return s.sealPasswordFile(e.b)
+}
+
+// ImportRuntimeState loads the in-flight state encoded by ExportRuntimeState,
+// replacing any pending registration or login of the same user, so that the
+// handshakes in progress on the exporting Server can be completed on this
+// one. The Server must be configured as the exporting Server was, with the
+// same IdentityKey and, if any, store key. If the state fails to decode,
+// ImportRuntimeState returns its error and loads none of it.
+func (s *Server) ImportRuntimeState(data []byte) error {
+	data, err := s.openPasswordFile(data)
+	if err != nil {
+		return err
+	}
+	d := newDecoder(messageRuntimeState, data)
+	pendingRegistrations := make(map[string]pendingRegistration)
+	n := d.uint32("pending registrations")
+	for i := uint32(0); i < n && d.err == nil; i++ {
+		id := d.string("registration ID")
+		pendingRegistrations[id] = pendingRegistration{
+			ks: d.scalar("ks"),
+			Ps: d.element("Ps"),
+			ps: d.scalar("ps"),
+			scheme: Scheme{
+				Version:        Version(d.uint8("Version")),
+				TranscriptHash: TranscriptHash(d.uint8("TranscriptHash")),
+				Argon2:         d.argon2Params("Argon2"),
+			},
+			replace:     d.boolean("replace"),
+			expected:    d.bytes("expected"),
+			legacy:      d.boolean("legacy"),
+			created:     time.Unix(0, int64(d.uint64("registration created"))),
+			pepperEpoch: d.uint32("pepperEpoch"),
+			cancelToken: d.bytes("cancelToken"),
+			keyNonce:    d.bytes("keyNonce"),
+		}
+	}
+
+	sessions := make(map[string]serverSession)
+	n = d.uint32("sessions")
+	for i := uint32(0); i < n && d.err == nil; i++ {
+		id := d.string("session ID")
+		sess := serverSession{
+			sk:      d.bytes("sk"),
+			fk2:     d.bytes("fk2"),
+			created: time.Unix(0, int64(d.uint64("session created"))),
+		}
+		request, binding, response := d.bytes("request"), d.bytes("channel binding"), d.bytes("response")
+		sess.rawSecret = d.bytes("rawSecret")
+		sess.sequence = d.uint64("sequence")
+		sess.unknown = d.boolean("unknown")
+		if d.err != nil {
+			break
+		}
+		sess.request, sess.response = new(UsrSession), new(SvrSession)
+		if err := sess.request.UnmarshalBinary(request); err != nil {
+			return fmt.Errorf("session %q request: %w", id, err)
+		}
+		if err := sess.response.UnmarshalBinary(response); err != nil {
+			return fmt.Errorf("session %q response: %w", id, err)
+		}
+		sess.request.channelBinding = binding
+		sessions[id] = sess
+	}
+	if err := d.finish(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, pr := range pendingRegistrations {
+		s.pendingRegistrations[id] = pr
+	}
+	for id, sess := range sessions {
+		s.sessions[id] = sess
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package occlude
+
+import (
+	"bytes"
+	"testing"
+)
+
+// verify that a registration and a login started on one Server can be
+// completed on a new Server which imported its runtime state, and that the
+// state is encrypted under the store key.
+func TestExportRuntimeState(t *testing.T) {
+	testpassword := "this is a test password"
+	identity := GenerateIdentityKey()
+	storeKey := bytes.Repeat([]byte{7}, storeKeySize)
+	newServer := func() *Server {
+		return NewServer(WithArgon2Params(weakArgon2Params), WithIdentityKey(identity), WithStoreKey(storeKey))
+	}
+
+	old := newServer()
+	existing := NewClient("existing", WithServerKey(identity.PublicKey()))
+	register(t, old, existing, "existing", testpassword)
+	usrsess, err := existing.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err := old.NewSession(usrsess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, err := old.NewRegistration("new")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := old.ExportRuntimeState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := old.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewServer().ImportRuntimeState(state); err == nil {
+		t.Fatal("imported runtime state without the store key")
+	}
+	upgraded := newServer()
+	if err := upgraded.RestoreSnapshot(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if err := upgraded.ImportRuntimeState(state); err != nil {
+		t.Fatal(err)
+	}
+
+	sk, fk2, err := existing.SessionKey(svrsess, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverKey, err := upgraded.FinishSession(&ClientVerification{ID: "existing", FK2: fk2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sk, serverKey) {
+		t.Fatal("session keys differ after the import")
+	}
+
+	c := NewClient("new")
+	reg, err := c.NewRegistration(pr, "new", testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := upgraded.Register(reg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := upgraded.FinishSession(login(t, upgraded, c, testpassword, "")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,52 @@
+package occlude
+
+import (
+	"crypto/subtle"
+	"time"
+)
+
+// rwCacheEntry records the password and Argon2Params the Client's cached
+// `rw` was computed for, and when.
+type rwCacheEntry struct {
+	passwordHash [64]byte
+	argon2       Argon2Params
+	created      time.Time
+}
+
+// WithRWCache configures the Client to reuse the OPRF output `rw` from its most
+// recent successful SessionKey for up to ttl, so that logging in again with
+// the same password, for example when a sync client reconnects, skips the
+// expensive Argon2 hardening. Every login still runs a fresh key exchange
+// with new ephemeral keys, and the server still evaluates the OPRF: only the
+// client's unblinding and hardening are skipped. The cached `rw` is not used
+// for another password or other Argon2Params, nor once ttl has passed since
+// it was computed, and if it no longer opens the envelope, for example
+// because the user has re-registered, it is recomputed.
+//
+// NOTE: `rw` is password-equivalent for this user: together with the envelope
+// it yields their private key. Caching it keeps it in the Client's memory for
+// the TTL, so the TTL should be short, and the Client should be closed with
+// Close when it is no longer needed, which erases the cache.
+func WithRWCache(ttl time.Duration) ClientOption {
+	return func(c *Client) {
+		c.rwCacheTTL = ttl
+	}
+}
+
+// cachedRW returns the cached `rw` if it may be reused for the password hash x
+// and the Argon2Params p.
+func (c *Client) cachedRW(x [64]byte, p Argon2Params) ([]byte, bool) {
+	if c.rwCacheTTL <= 0 {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := c.rwCache
+	if c.rw == nil || entry == nil || entry.argon2 != p || c.now().Sub(entry.created) > c.rwCacheTTL {
+		return nil, false
+	}
+	if subtle.ConstantTimeCompare(entry.passwordHash[:], x[:]) != 1 {
+		return nil, false
+	}
+	return c.rw, true
+}
@@ -0,0 +1,59 @@
+package occlude
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// verify that a Client configured WithRWCache reuses `rw` within the TTL,
+// recomputes it once the TTL has passed or for another password, and that a
+// Client without the cache keeps nothing to check the password against.
+func TestRWCache(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	now := time.Unix(1700000000, 0)
+	oprfs := 0
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername, WithRWCache(time.Minute), WithOPRFCallbacks(func() { oprfs++ }, nil))
+	c.now = func() time.Time { return now }
+	register(t, s, c, testusername, testpassword)
+
+	login(t, s, c, testpassword, "")
+	if oprfs != 2 {
+		t.Fatal("first login did not run the OPRF")
+	}
+	now = now.Add(30 * time.Second)
+	login(t, s, c, testpassword, "")
+	if oprfs != 2 {
+		t.Fatal("login within the TTL did not reuse the cached rw")
+	}
+
+	sess, err := c.NewSession("this is another password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err := s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.SessionKey(svrsess, "this is another password"); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatal("expected ErrAuthenticationFailed, got", err)
+	}
+	if oprfs != 3 {
+		t.Fatal("the cached rw was used for another password")
+	}
+
+	now = now.Add(2 * time.Minute)
+	login(t, s, c, testpassword, "")
+	if oprfs != 4 {
+		t.Fatal("login after the TTL did not recompute rw")
+	}
+
+	uncached := NewClient(testusername)
+	login(t, s, uncached, testpassword, "")
+	if uncached.rwCache != nil {
+		t.Fatal("a Client without WithRWCache cached the password hash")
+	}
+}
@@ -0,0 +1,144 @@
+package occlude
+
+import (
+	"errors"
+)
+
+// ErrUnsupportedScheme is returned when a password file, or a Server's
+// configuration, is bound to a Scheme that this implementation does not
+// support. Logins against such a file fail closed: falling back to defaults
+// would compute the wrong keys.
+var ErrUnsupportedScheme = errors.New("unsupported password file scheme")
+
+// ErrParamsMismatch is returned by Client.SessionKey when the Client was
+// configured WithPinnedScheme, and the SvrSession uses a different Scheme.
+var ErrParamsMismatch = errors.New("server parameters do not match the pinned scheme")
+
+// Scheme is the set of algorithms a password file is bound to at
+// registration. Every login against the file uses its Scheme, regardless of
+// how the Server is currently configured.
+type Scheme struct {
+	Version        Version
+	TranscriptHash TranscriptHash
+	Argon2         Argon2Params
+}
+
+// DefaultScheme is the Scheme used by a Server unless configured otherwise.
+var DefaultScheme = Scheme{
+	Version:        DefaultVersion,
+	TranscriptHash: DefaultTranscriptHash,
+	Argon2:         DefaultArgon2Params,
+}
+
+// supported reports whether every algorithm in the Scheme is known to this
+// implementation.
+func (s Scheme) supported() bool {
+	return s.Version.supported() && s.TranscriptHash.supported() && s.Argon2.supported()
+}
+
+// encode encodes the Scheme.
+func (s Scheme) encode() []byte {
+	return append([]byte{byte(s.Version), byte(s.TranscriptHash)}, s.Argon2.encode()...)
+}
+
+// satisfies reports whether a password file bound to the Scheme needs no
+// upgrade to meet target. Argon2 parameters stronger than the target's, as
+// chosen by some clients at registration, satisfy it.
+func (s Scheme) satisfies(target Scheme) bool {
+	return s.Version == target.Version &&
+		s.TranscriptHash == target.TranscriptHash &&
+		!s.Argon2.weakerThan(target.Argon2)
+}
+
+// WithPinnedScheme pins the Scheme the Client expects every login to use, as
+// published out-of-band by the server's operator, for example from
+// Server.ActiveScheme. SessionKey rejects a SvrSession whose Version,
+// TranscriptHash or Argon2Params differ from it with ErrParamsMismatch,
+// before spending any work on the OPRF. This detects a server whose
+// configuration changed unexpectedly. The deployment context is pinned
+// separately, with WithClientContext.
+//
+// NOTE: logins use the Scheme the user's password file is bound to, so a
+// pinned Client can not log in to a password file registered under another
+// Scheme until it is upgraded with NewUpgrade.
+func WithPinnedScheme(scheme Scheme) ClientOption {
+	return func(c *Client) {
+		c.pinnedScheme = &scheme
+	}
+}
+
+// checkPinnedScheme returns ErrParamsMismatch if the Client was configured
+// WithPinnedScheme and session does not use the pinned Scheme.
+func (c *Client) checkPinnedScheme(session *SvrSession) error {
+	if c.pinnedScheme == nil {
+		return nil
+	}
+	used := Scheme{
+		Version:        session.Version,
+		TranscriptHash: session.TranscriptHash,
+		Argon2:         session.Argon2,
+	}
+	if used != *c.pinnedScheme {
+		return ErrParamsMismatch
+	}
+	return nil
+}
+
+// ActiveScheme returns the Scheme that new registrations are bound to, for
+// publishing to clients configured WithPinnedScheme.
+func (s *Server) ActiveScheme() Scheme {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.scheme
+}
+
+// SetActiveScheme atomically replaces the Scheme that new registrations are
+// bound to. Existing password files keep their Scheme, so their users can
+// still log in, and logins and registrations already in progress complete
+// with the Scheme they started with. Users whose password file does not
+// satisfy the new Scheme are reported by NeedsUpgrade, and can be upgraded on
+// their next login with NewUpgrade.
+func (s *Server) SetActiveScheme(scheme Scheme) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scheme = scheme
+	s.audit(AuditSchemeChange, "")
+}
+
+// NeedsUpgrade reports whether the password file of the user id is bound to
+// a Scheme older than the Server's active Scheme.
+func (s *Server) NeedsUpgrade(id string) bool {
+	id = s.userID(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pf, exists := s.passwordFiles[id]
+	return exists && !pf.scheme.satisfies(s.scheme)
+}
+
+// NewUpgrade starts a registration which replaces the password file of the
+// user authenticated by cv with one bound to the active Scheme. It must be
+// called after the client's SessionKey and before FinishSession, while the
+// client still holds the password: the client completes the upgrade by
+// registering the same password with NewRegistration, and the server with
+// Register.
+//
+// NOTE: the new password file derives a different export key, and the user's
+// Envelopes and recovery envelope, which were sealed under the old password
+// file, are not carried over.
+func (s *Server) NewUpgrade(cv *ClientVerification) (*pendingRegistration, error) {
+	done, err := s.permitRegistration()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	if cv == nil {
+		return nil, ErrNilMessage
+	}
+	id := s.userID(cv.ID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.authenticate(cv); err != nil {
+		return nil, err
+	}
+	return s.newPendingRegistration(id, true)
+}
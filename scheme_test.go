@@ -0,0 +1,137 @@
+package occlude
+
+import (
+	"errors"
+	"testing"
+)
+
+// verify that after switching the active Scheme, new users are bound to it,
+// while existing users still log in and can be upgraded on login.
+func TestSetActiveScheme(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+	newusername := "this is a new test username"
+
+	oldScheme := DefaultScheme
+	oldScheme.Argon2 = weakArgon2Params
+	newScheme := Scheme{
+		Version:        Version2,
+		TranscriptHash: TranscriptSHA3_512,
+		Argon2:         Argon2Params{Time: 2, Memory: 64, Threads: 1},
+	}
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+
+	// a login in flight across the switch completes with the old Scheme.
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err := s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetActiveScheme(newScheme)
+	_, fk2, err := c.SessionKey(svrsess, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.FinishSession(&ClientVerification{ID: testusername, FK2: fk2}); err != nil {
+		t.Fatal(err)
+	}
+
+	register(t, s, NewClient(newusername), newusername, testpassword)
+	if s.passwordFiles[newusername].scheme != newScheme {
+		t.Fatal("new user was not bound to the active Scheme")
+	}
+	if s.NeedsUpgrade(newusername) {
+		t.Fatal("new user reported as needing an upgrade")
+	}
+
+	if !s.NeedsUpgrade(testusername) {
+		t.Fatal("existing user not reported as needing an upgrade")
+	}
+	sess, err = c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err = s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svrsess.Version != oldScheme.Version || svrsess.TranscriptHash != oldScheme.TranscriptHash || svrsess.Argon2 != oldScheme.Argon2 {
+		t.Fatal("existing user did not log in with their original Scheme")
+	}
+	_, fk2, err = c.SessionKey(svrsess, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cv := &ClientVerification{ID: testusername, FK2: fk2}
+	if _, err := s.NewUpgrade(&ClientVerification{ID: testusername, FK2: make([]byte, prfSize)}); err == nil {
+		t.Fatal("upgrade started without a valid client verification")
+	}
+	pr, err := s.NewUpgrade(cv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err := c.NewRegistration(pr, testusername, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register(reg); err != nil {
+		t.Fatal(err)
+	}
+	if s.NeedsUpgrade(testusername) {
+		t.Fatal("user still needs an upgrade after upgrading")
+	}
+
+	sess, err = c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err = s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svrsess.Version != newScheme.Version || svrsess.TranscriptHash != newScheme.TranscriptHash || svrsess.Argon2 != newScheme.Argon2 {
+		t.Fatal("upgraded user did not log in with the active Scheme")
+	}
+	if _, _, err := c.SessionKey(svrsess, testpassword); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// verify that a Client pinned to the server's published Scheme logs in, and
+// one pinned to different parameters rejects the SvrSession with
+// ErrParamsMismatch.
+func TestPinnedScheme(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	register(t, s, NewClient(testusername), testusername, testpassword)
+
+	pinned := NewClient(testusername, WithPinnedScheme(s.ActiveScheme()))
+	login(t, s, pinned, testpassword, "")
+
+	stronger := s.ActiveScheme()
+	stronger.Argon2.Time++
+	otherHash := s.ActiveScheme()
+	otherHash.TranscriptHash = TranscriptSHA512
+	for _, scheme := range []Scheme{stronger, otherHash} {
+		c := NewClient(testusername, WithPinnedScheme(scheme))
+		sess, err := c.NewSession(testpassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		svrsess, _, err := s.NewSession(sess)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := c.SessionKey(svrsess, testpassword); !errors.Is(err, ErrParamsMismatch) {
+			t.Fatal("expected ErrParamsMismatch, got", err)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package occlude
+
+import (
+	ristretto "github.com/gtank/ristretto255"
+	"golang.org/x/crypto/sha3"
+)
+
+// WithSecondFactor configures the Client with a secret from a second factor,
+// such as the output of a FIDO2 authenticator's hmac-secret extension. When
+// registering, the Client includes a public verifier of the secret in the
+// Registration, as SecondFactor. When logging in to a password file with a
+// verifier, the secret is folded into the key exchange with an additional
+// Diffie-Hellman term, so that a login with the correct password but without
+// the secret fails with ErrAuthenticationFailed. The server stores only the
+// verifier, from which the secret can not be recovered.
+//
+// The secret must be the same, high-entropy, value at every login: it is not a
+// one-time code.
+func WithSecondFactor(secret []byte) ClientOption {
+	return func(c *Client) {
+		c.secondFactor = secondFactorScalar(secret)
+	}
+}
+
+// secondFactorScalar derives the private scalar of a second factor from its
+// secret.
+func secondFactorScalar(secret []byte) *ristretto.Scalar {
+	h := sha3.New512()
+	h.Write([]byte("occlude second factor"))
+	h.Write(secret)
+	return new(ristretto.Scalar).FromUniformBytes(h.Sum(nil))
+}
+
+// bindSecondFactor folds the Diffie-Hellman term between the second factor's
+// key and the server's ephemeral key into the shared secret K.
+func bindSecondFactor(K []byte, dh *ristretto.Element) []byte {
+	return deriveKey(append(append([]byte(nil), K...), dh.Encode(nil)...), nil, []byte("occlude second factor"), len(K))
+}
@@ -0,0 +1,117 @@
+package occlude
+
+import (
+	"errors"
+	"testing"
+
+	"occlude/occludepb"
+)
+
+// verify that a user registered with a second factor can only log in with it.
+func TestSecondFactor(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+	secret := []byte("this is a test hmac-secret output")
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername, WithSecondFactor(secret))
+	register(t, s, c, testusername, testpassword)
+	cv := login(t, s, c, testpassword, "")
+	if _, err := s.FinishSession(cv); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []*Client{
+		NewClient(testusername),
+		NewClient(testusername, WithSecondFactor([]byte("this is another secret"))),
+	} {
+		sess, err := c.NewSession(testpassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		svrsess, _, err := s.NewSession(sess)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := c.SessionKey(svrsess, testpassword); !errors.Is(err, ErrAuthenticationFailed) {
+			t.Fatal("expected ErrAuthenticationFailed, got", err)
+		}
+	}
+
+	// a client with a second factor can't log in to a password file without
+	// one either.
+	register(t, s, NewClient("another user"), "another user", testpassword)
+	c = NewClient("another user", WithSecondFactor(secret))
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err := s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.SessionKey(svrsess, testpassword); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatal("expected ErrAuthenticationFailed, got", err)
+	}
+}
+
+// verify that the second factor verifier survives the binary and protobuf
+// encodings of the Registration, and the password file encoding.
+func TestSecondFactorEncoding(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+	secret := []byte("this is a test hmac-secret output")
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername, WithSecondFactor(secret))
+	pr, err := s.NewRegistration(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err := c.NewRegistration(pr, testusername, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	var decodedReg Registration
+	roundTrip(t, reg, &decodedReg)
+	if decodedReg.SecondFactor == nil || decodedReg.SecondFactor.Equal(reg.SecondFactor) != 1 {
+		t.Fatal("SecondFactor did not survive the binary encoding")
+	}
+	var pbReg occludepb.Registration
+	protoRoundTrip(t, reg.ToProto(), &pbReg)
+	var protoReg Registration
+	if err := protoReg.FromProto(&pbReg); err != nil {
+		t.Fatal(err)
+	}
+	if protoReg.SecondFactor == nil || protoReg.SecondFactor.Equal(reg.SecondFactor) != 1 {
+		t.Fatal("SecondFactor did not survive the protobuf encoding")
+	}
+	if err := s.Register(&decodedReg); err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := s.MarshalPasswordFile(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewServer()
+	if err := loaded.UnmarshalPasswordFile(testusername, encoded); err != nil {
+		t.Fatal(err)
+	}
+	login(t, loaded, c, testpassword, "")
+	without := NewClient(testusername)
+	sess, err := without.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err := loaded.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := without.SessionKey(svrsess, testpassword); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatal("expected ErrAuthenticationFailed, got", err)
+	}
+}
@@ -0,0 +1,73 @@
+package occlude
+
+import (
+	"bytes"
+	"errors"
+
+	ristretto "github.com/gtank/ristretto255"
+)
+
+var (
+	// ErrSelfCheckFailed is returned by NewRegistration on a Client
+	// configured WithRegistrationSelfCheck when the envelope it sealed does
+	// not open to the credentials it sealed.
+	ErrSelfCheckFailed = errors.New("registration envelope failed its self-check")
+
+	// ErrPuMismatch is returned by SessionKey when the user's public key Pu
+	// sealed in the envelope is not the public key of the private key pu
+	// sealed with it, and by the registration self-check when it is not or
+	// differs from the Pu sent to the server in the Registration.
+	ErrPuMismatch = errors.New("envelope Pu does not match pu")
+)
+
+// WithRegistrationSelfCheck configures the Client to open each envelope it
+// seals in NewRegistration with the `rw` it was sealed under, and to return
+// ErrSelfCheckFailed instead of a Registration if it does not open to the
+// same credentials. The server can not check an envelope, as it does not know
+// the password, so a local bug which seals an unusable envelope would
+// otherwise only be found at the user's first failed login.
+//
+// The check also returns ErrPuMismatch if the opened envelope's Pu is not
+// the public key of its pu, or is not the Pu in the Registration, which the
+// server stores and runs the key exchange with: either would make every login
+// fail.
+//
+// NOTE: the check reuses the `rw` derived for the registration, so it costs
+// no extra Argon2 hashing, but it also does not catch a bug in deriving `rw`
+// itself.
+func WithRegistrationSelfCheck() ClientOption {
+	return func(c *Client) {
+		c.selfCheck = true
+	}
+}
+
+// selfCheckEnvelope returns ErrSelfCheckFailed unless aci, sealed under rw
+// for a password file bound to params, opens to the encoded credentials, and
+// ErrPuMismatch unless their keys match the registered public key Pu.
+func selfCheckEnvelope(rw []byte, params Argon2Params, aci authCiphertext, credentials []byte, Pu *ristretto.Element) error {
+	plaintext, err := openEnvelope(rw, passwordFileAD(params), aci)
+	if err != nil {
+		return ErrSelfCheckFailed
+	}
+	defer clear(plaintext)
+	var ca ciphertextData
+	if !bytes.Equal(plaintext, credentials) || ca.UnmarshalJSON(plaintext) != nil {
+		return ErrSelfCheckFailed
+	}
+	if err := ca.checkPu(); err != nil {
+		return err
+	}
+	if ca.Pu.Equal(Pu) != 1 {
+		return ErrPuMismatch
+	}
+	return nil
+}
+
+// checkPu returns ErrPuMismatch if the credentials' Pu is not the public key
+// of their pu.
+func (c *ciphertextData) checkPu() error {
+	if new(ristretto.Element).ScalarBaseMult(c.pu).Equal(c.Pu) != 1 {
+		return ErrPuMismatch
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package occlude
+
+import (
+	"encoding/json"
+	"testing"
+
+	ristretto "github.com/gtank/ristretto255"
+)
+
+// verify that the registration self-check accepts the envelopes
+// NewRegistration seals, and catches a corrupted envelope or a mismatched key.
+func TestRegistrationSelfCheck(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername, WithRegistrationSelfCheck())
+	register(t, s, c, testusername, testpassword)
+	login(t, s, c, testpassword, "")
+
+	credentials := []byte(`{"pu":"credentials"}`)
+	rw := []byte("this is a test rw")
+	aci, err := sealEnvelope(rw, passwordFileAD(weakArgon2Params), credentials)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupted := authCiphertext{Ciphertext: append([]byte(nil), aci.Ciphertext...), Tag: aci.Tag}
+	corrupted.Ciphertext[0] ^= 1
+	for _, test := range []struct {
+		rw  []byte
+		aci authCiphertext
+	}{
+		{rw, corrupted},
+		{[]byte("another rw"), aci},
+		// the envelope opens, but to credentials that do not decode.
+		{rw, aci},
+	} {
+		if err := selfCheckEnvelope(test.rw, weakArgon2Params, test.aci, credentials, nil); err != ErrSelfCheckFailed {
+			t.Fatal("expected ErrSelfCheckFailed, got", err)
+		}
+	}
+}
+
+// verify that credentials whose Pu does not match their pu, or the registered
+// Pu, are caught by the registration self-check, and the former at login.
+func TestPuMismatch(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	pu := randomScalar()
+	Pu := new(ristretto.Element).ScalarBaseMult(pu)
+	Ps := new(ristretto.Element).ScalarBaseMult(randomScalar())
+	other := new(ristretto.Element).ScalarBaseMult(randomScalar())
+	rw := []byte("this is a test rw")
+	for _, test := range []struct {
+		credentials  ciphertextData
+		registeredPu *ristretto.Element
+	}{
+		{ciphertextData{pu: pu, Pu: other, Ps: Ps}, other},
+		{ciphertextData{pu: pu, Pu: Pu, Ps: Ps}, other},
+	} {
+		credentials, err := json.Marshal(&test.credentials)
+		if err != nil {
+			t.Fatal(err)
+		}
+		aci, err := sealEnvelope(rw, passwordFileAD(weakArgon2Params), credentials)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := selfCheckEnvelope(rw, weakArgon2Params, aci, credentials, test.registeredPu); err != ErrPuMismatch {
+			t.Fatal("expected ErrPuMismatch, got", err)
+		}
+	}
+
+	// replace the stored envelope with one, sealed under the user's rw,
+	// whose Pu is not the public key of its pu.
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+	pf := s.passwordFiles[testusername]
+	credentials, err := json.Marshal(&ciphertextData{pu: pu, Pu: other, Ps: pf.Ps})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pf.c, err = sealEnvelope(c.rw, passwordFileAD(weakArgon2Params), credentials)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.passwordFiles[testusername] = pf
+
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err := s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.SessionKey(svrsess, testpassword); err != ErrPuMismatch {
+		t.Fatal("expected ErrPuMismatch, got", err)
+	}
+}
@@ -0,0 +1,76 @@
+package occlude
+
+import (
+	"errors"
+)
+
+// ErrReplayedSession is returned by Server.NewSession, for a Server configured
+// WithLoginSequence, when the UsrSession's Sequence is not that of the user's
+// last finished login, because the UsrSession was replayed from an earlier
+// login, or the client's sequence is out of date.
+var ErrReplayedSession = errors.New("replayed or out of order login")
+
+// WithLoginSequence configures the Server to reject replayed UsrSessions by a
+// per-user login sequence number, which unlike a timestamp does not depend on
+// the clock of either side. Each SvrSession carries the sequence number its
+// login takes once finished with FinishSession, which the client remembers and
+// includes in its next UsrSession. NewSession accepts only the sequence
+// number of the user's last finished login, and the one after it, which a
+// client holds when the ClientVerification of its last login was lost. It
+// rejects any other with ErrReplayedSession, so that the UsrSession of every
+// earlier login, once replayed, is rejected before any other work.
+//
+// A client's sequence number is held in memory: a client which restarts, or
+// logs in from another device, must first restore it with WithLastSequence.
+//
+// NOTE: the sequence number of a user reveals how many times they have logged
+// in, and a UsrSession with the wrong sequence number is rejected before any
+// other work, so a Server configured WithEnumerationProtection no longer hides
+// which users exist from an attacker who can observe their logins.
+func WithLoginSequence() ServerOption {
+	return func(s *Server) {
+		s.loginSequence = true
+	}
+}
+
+// WithLastSequence configures the login sequence number the Client includes
+// in its next UsrSession, as returned by Sequence before the Client was last
+// closed.
+func WithLastSequence(sequence uint64) ClientOption {
+	return func(c *Client) {
+		c.sequence = sequence
+	}
+}
+
+// Sequence returns the login sequence number of the Client's most recent
+// successful SessionKey, or the one it was configured WithLastSequence.
+func (c *Client) Sequence() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sequence
+}
+
+// checkSequence returns ErrReplayedSession if the Server is configured
+// WithLoginSequence and session's Sequence is neither that of the last login
+// finished against pf nor the one after it. The caller must hold s.mu.
+func (s *Server) checkSequence(session *UsrSession, pf pwdFile) error {
+	if !s.loginSequence {
+		return nil
+	}
+	if session.Sequence != pf.sequence && session.Sequence != pf.sequence+1 {
+		return ErrReplayedSession
+	}
+	return nil
+}
+
+// advanceSequence records that the login with the sequence number sequence
+// has finished for the user id, unless the Server is a replica. The caller
+// must hold s.mu.
+func (s *Server) advanceSequence(id string, sequence uint64) {
+	pf, exists := s.passwordFiles[id]
+	if !exists || sequence <= pf.sequence || s.role == roleReplica {
+		return
+	}
+	pf.sequence = sequence
+	s.setPasswordFile(id, pf)
+}
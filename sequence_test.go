@@ -0,0 +1,79 @@
+package occlude
+
+import (
+	"errors"
+	"testing"
+)
+
+// verify that, with login sequence numbers, repeated and out of order
+// UsrSessions are rejected, while a client whose last verification was lost
+// can still log in.
+func TestLoginSequence(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer(WithArgon2Params(weakArgon2Params), WithLoginSequence())
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+
+	first, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err := s.NewSession(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, fk2, err := c.SessionKey(svrsess, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.FinishSession(&ClientVerification{ID: testusername, FK2: fk2}); err != nil {
+		t.Fatal(err)
+	}
+	if c.Sequence() != 1 {
+		t.Fatal("expected sequence 1, got", c.Sequence())
+	}
+	if _, err := s.FinishSession(login(t, s, c, testpassword, "")); err != nil {
+		t.Fatal(err)
+	}
+	if c.Sequence() != 2 {
+		t.Fatal("expected sequence 2, got", c.Sequence())
+	}
+
+	// the first login's UsrSession, replayed, is rejected.
+	if _, _, err := s.NewSession(first); !errors.Is(err, ErrReplayedSession) {
+		t.Fatal("expected ErrReplayedSession for a replayed session, got", err)
+	}
+	// as are sequence numbers out of order, in either direction.
+	for _, sequence := range []uint64{1, 4} {
+		stale := NewClient(testusername, WithLastSequence(sequence))
+		sess, err := stale.NewSession(testpassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := s.NewSession(sess); !errors.Is(err, ErrReplayedSession) {
+			t.Fatal("expected ErrReplayedSession for sequence", sequence, "got", err)
+		}
+	}
+
+	// a login whose verification is lost leaves the client one ahead, which
+	// the server still accepts.
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err = s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.SessionKey(svrsess, testpassword); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.FinishSession(login(t, s, c, testpassword, "")); err != nil {
+		t.Fatal(err)
+	}
+	if c.Sequence() != 3 || s.passwordFiles[testusername].sequence != 3 {
+		t.Fatal("client and server sequences diverged", c.Sequence(), s.passwordFiles[testusername].sequence)
+	}
+}
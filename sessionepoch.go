@@ -0,0 +1,51 @@
+package occlude
+
+import (
+	"encoding/binary"
+)
+
+// WithSessionEpochs configures the Server to keep a session epoch for each
+// user, which counts the times they have changed their password, and to bind
+// every session key to it. Each SvrSession carries the user's epoch, which
+// the client mixes into its key derivation as the server does, and returns in
+// LoginResult.SessionEpoch. The epoch is incremented whenever the user's
+// password file is replaced by a registration started with NewUpgrade, as it
+// is to change their password, or FinishRecovery, so that an application
+// which records the epoch of each session can revoke every session
+// established before a password change, such as one made after a suspected
+// compromise, by rejecting those of an epoch older than SessionEpoch returns.
+// Session keys of different epochs are unrelated.
+//
+// NOTE: occlude does not track the sessions an application establishes, so it
+// is the application which must reject them. A NewUpgrade which re-registers
+// the same password, to upgrade its Scheme, increments the epoch too.
+func WithSessionEpochs() ServerOption {
+	return func(s *Server) {
+		s.sessionEpochs = true
+	}
+}
+
+// SessionEpoch returns the current session epoch of the user id, for a Server
+// configured WithSessionEpochs: sessions established in an earlier epoch
+// predate the user's latest password change. It returns zero for a user who
+// has never changed their password, or has none.
+func (s *Server) SessionEpoch(id string) uint64 {
+	id = s.userID(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.passwordFiles[id].sessionEpoch
+}
+
+// bindSessionEpoch folds the user's session epoch into the shared secret K. The
+// zero epoch returns K unchanged, so that users who have never changed their
+// password, and Servers without WithSessionEpochs, derive the same keys as
+// before session epochs were introduced.
+func bindSessionEpoch(K []byte, epoch uint64) []byte {
+	if epoch == 0 {
+		return K
+	}
+	info := make([]byte, 8)
+	binary.BigEndian.PutUint64(info, epoch)
+	info = append([]byte("occlude session epoch "), info...)
+	return deriveKey(K, nil, info, len(K))
+}
@@ -0,0 +1,74 @@
+package occlude
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// verify that the session epoch increments on a password change, and that it
+// is bound into the session key: a SvrSession claiming another epoch fails to
+// authenticate.
+func TestSessionEpochs(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+	newpassword := "this is a new test password"
+
+	s := NewServer(WithArgon2Params(weakArgon2Params), WithSessionEpochs())
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+	loginResult := func(password string) *LoginResult {
+		t.Helper()
+		sess, err := c.NewSession(password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		svrsess, _, err := s.NewSession(sess)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := c.FinishLogin(svrsess, password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+	if result := loginResult(testpassword); result.SessionEpoch != 0 {
+		t.Fatal("expected session epoch 0, got", result.SessionEpoch)
+	}
+
+	pr, err := s.NewUpgrade(loginResult(testpassword).Verification())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err := c.NewRegistration(pr, testusername, newpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register(reg); err != nil {
+		t.Fatal(err)
+	}
+	if epoch := s.SessionEpoch(testusername); epoch != 1 {
+		t.Fatal("expected session epoch 1 after the password change, got", epoch)
+	}
+	if result := loginResult(newpassword); result.SessionEpoch != 1 {
+		t.Fatal("expected session epoch 1, got", result.SessionEpoch)
+	}
+
+	K := bytes.Repeat([]byte{1}, 64)
+	if bytes.Equal(bindSessionEpoch(K, 1), bindSessionEpoch(K, 2)) || bytes.Equal(bindSessionEpoch(K, 0), bindSessionEpoch(K, 1)) {
+		t.Fatal("session keys of different epochs are equal")
+	}
+	sess, err := c.NewSession(newpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err := s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess.SessionEpoch = 0
+	if _, err := c.FinishLogin(svrsess, newpassword); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatal("expected ErrAuthenticationFailed, got", err)
+	}
+}
@@ -0,0 +1,75 @@
+package occlude
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrServerClosed is returned by the Server's operations once Shutdown has
+// been called.
+var ErrServerClosed = errors.New("server is shut down")
+
+// begin starts an operation on the Server, returning ErrServerClosed if the
+// Server is shutting down. Shutdown waits for every operation begun to call
+// done.
+func (s *Server) begin() (done func(), err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		return nil, ErrServerClosed
+	}
+	if err := s.failures.inject(); err != nil {
+		return nil, err
+	}
+	s.inflight++
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.inflight--
+		if s.inflight == 0 && s.idle != nil {
+			close(s.idle)
+			s.idle = nil
+		}
+	}, nil
+}
+
+// Shutdown gracefully shuts down the Server. It stops the background sweeper
+// started by WithSweepInterval, refuses new operations with ErrServerClosed,
+// and waits for the operations in progress to complete. If ctx expires first,
+// Shutdown returns the context's error, and the operations in progress
+// continue in the background. The Server's state, such as its password files,
+// remains available to MarshalPasswordFile.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.closing {
+		s.closing = true
+		if s.stopSweeper != nil {
+			close(s.stopSweeper)
+		}
+	}
+	sweeperDone := s.sweeperDone
+	var idle chan struct{}
+	if s.inflight > 0 {
+		if s.idle == nil {
+			s.idle = make(chan struct{})
+		}
+		idle = s.idle
+	}
+	s.mu.Unlock()
+
+	if sweeperDone != nil {
+		select {
+		case <-sweeperDone:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if idle != nil {
+		select {
+		case <-idle:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
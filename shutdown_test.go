@@ -0,0 +1,77 @@
+package occlude
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// verify that the background sweeper sweeps, and that Shutdown stops it.
+func TestShutdownStopsSweeper(t *testing.T) {
+	clock := &testClock{t: time.Unix(1700000000, 0)}
+	s := NewServer(withClock(clock.now), WithSweepInterval(time.Millisecond))
+	if _, err := s.NewRegistration("this is a test username"); err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	clock.t = clock.t.Add(DefaultRegistrationTTL + DefaultRegistrationGrace + time.Second)
+	s.mu.Unlock()
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		s.mu.Lock()
+		pending := len(s.pendingRegistrations)
+		s.mu.Unlock()
+		if pending == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("background sweeper did not sweep")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-s.sweeperDone:
+	default:
+		t.Fatal("Shutdown returned before the sweeper exited")
+	}
+	if _, err := s.NewRegistration("another user"); !errors.Is(err, ErrServerClosed) {
+		t.Fatal("expected ErrServerClosed, got", err)
+	}
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal("second Shutdown failed:", err)
+	}
+}
+
+// verify that Shutdown waits for operations in progress, unless its context
+// expires first.
+func TestShutdownDrainsInFlight(t *testing.T) {
+	s := NewServer()
+	done, err := s.begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected context.DeadlineExceeded, got", err)
+	}
+
+	returned := make(chan error)
+	go func() {
+		returned <- s.Shutdown(context.Background())
+	}()
+	select {
+	case <-returned:
+		t.Fatal("Shutdown returned with an operation in progress")
+	case <-time.After(10 * time.Millisecond):
+	}
+	done()
+	if err := <-returned; err != nil {
+		t.Fatal(err)
+	}
+}
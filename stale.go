@@ -0,0 +1,24 @@
+package occlude
+
+import (
+	"errors"
+)
+
+// ErrStaleResponse is returned by Client.SessionKey when the SvrSession
+// responds to a different UsrSession than the Client's login in progress, for
+// example because it was replayed, or misrouted by a load balancer. fk1 is
+// derived from the client's ephemeral key, so such a response would in any
+// case fail with ErrAuthenticationFailed, but only after the OPRF: the echoed
+// SvrSession.Xu lets the client reject it before any work, with an error
+// which is not mistaken for a wrong password. A SvrSession without Xu is
+// checked by fk1 alone.
+var ErrStaleResponse = errors.New("server response is for a different login")
+
+// checkFresh returns ErrStaleResponse if v echoes an ephemeral key other than
+// that of the UsrSession u.
+func checkFresh(u *UsrSession, v *SvrSession) error {
+	if v.Xu != nil && v.Xu.Equal(u.Xu) != 1 {
+		return ErrStaleResponse
+	}
+	return nil
+}
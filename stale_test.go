@@ -0,0 +1,52 @@
+package occlude
+
+import (
+	"errors"
+	"testing"
+)
+
+// verify that a SvrSession replayed to a client with a different UsrSession
+// is rejected as stale before the OPRF runs.
+func TestStaleResponse(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	oprfs := 0
+	c := NewClient(testusername, WithOPRFCallbacks(func() { oprfs++ }, nil))
+	register(t, s, c, testusername, testpassword)
+	oprfs = 0
+
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale, _, err := s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := stale.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var replayed SvrSession
+	if err := replayed.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.NewSession(testpassword); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.SessionKey(&replayed, testpassword); !errors.Is(err, ErrStaleResponse) {
+		t.Fatal("expected ErrStaleResponse, got", err)
+	}
+	if oprfs != 0 {
+		t.Fatal("the OPRF ran for a stale response")
+	}
+
+	// without the echo, the replay still fails fk1.
+	replayed.Xu = nil
+	if _, _, err := c.SessionKey(&replayed, testpassword); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatal("expected ErrAuthenticationFailed, got", err)
+	}
+}
@@ -0,0 +1,161 @@
+package occlude
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// storeKeySize is the length of a store key, in bytes.
+const storeKeySize = 32
+
+var (
+	// ErrInvalidStoreKey is returned when the Server's store key is not 32
+	// bytes long.
+	ErrInvalidStoreKey = errors.New("store key must be 32 bytes")
+
+	// ErrPasswordFileDecryption is returned by UnmarshalPasswordFile and
+	// RestoreSnapshot when an encrypted password file does not decrypt under
+	// the Server's store key, because it was encrypted under another key or
+	// has been modified.
+	ErrPasswordFileDecryption = errors.New("password file failed to decrypt")
+)
+
+// WithStoreKey configures the Server to encrypt the password files it encodes
+// with MarshalPasswordFile and Snapshot under key, a 32 byte operator key,
+// with AES-256-GCM. Every file is encrypted with a fresh random nonce, stored
+// alongside its ciphertext, so that one key may encrypt the files of many
+// users without reusing a nonce. UnmarshalPasswordFile and RestoreSnapshot
+// then accept only files encrypted under key.
+//
+// NOTE: random 96 bit nonces are unlikely to collide for up to about 2^32
+// encryptions under one key. Deployments which encrypt more files than that,
+// counting every snapshot, should rotate the store key.
+func WithStoreKey(key []byte) ServerOption {
+	return func(s *Server) {
+		s.storeKey = append([]byte(nil), key...)
+	}
+}
+
+// storeAEAD returns the AEAD password files are encrypted with under the
+// Server's store key.
+func (s *Server) storeAEAD() (cipher.AEAD, error) {
+	if len(s.storeKey) != storeKeySize {
+		return nil, ErrInvalidStoreKey
+	}
+	block, err := aes.NewCipher(s.storeKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealPasswordFile encrypts the encoded password file data under the
+// Server's store key with a fresh nonce, if it is configured WithStoreKey.
+func (s *Server) sealPasswordFile(data []byte) ([]byte, error) {
+	if s.storeKey == nil {
+		return data, nil
+	}
+	aead, err := s.storeAEAD()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	e := newEncoder(messageSealedPasswordFile)
+	header := append([]byte(nil), e.b...)
+	e.bytes(nonce)
+	e.bytes(aead.Seal(nil, nonce, data, header))
+	clear(data)
+	return e.b, nil
+}
+
+// openPasswordFile decrypts a password file encrypted by sealPasswordFile, if
+// the Server is configured WithStoreKey.
+func (s *Server) openPasswordFile(data []byte) ([]byte, error) {
+	if s.storeKey == nil {
+		return data, nil
+	}
+	aead, err := s.storeAEAD()
+	if err != nil {
+		return nil, err
+	}
+	d := newDecoder(messageSealedPasswordFile, data)
+	nonce := d.bytes("nonce")
+	ciphertext := d.bytes("ciphertext")
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("%w: nonce", ErrMalformedMessage)
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, data[:2])
+	if err != nil {
+		return nil, ErrPasswordFileDecryption
+	}
+	return plaintext, nil
+}
+
+// Snapshot encodes the password files of every user, as MarshalPasswordFile
+// does for one, so that the whole store can be persisted or replicated and
+// later loaded with RestoreSnapshot. If the Server is configured WithStoreKey,
+// each file in the snapshot is encrypted with its own nonce. The NOTE on
+// MarshalPasswordFile applies to snapshots too.
+func (s *Server) Snapshot() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, 0, len(s.passwordFiles))
+	for id := range s.passwordFiles {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	e := newEncoder(messageSnapshot)
+	e.uint32(uint32(len(ids)))
+	for _, id := range ids {
+		file, err := s.sealPasswordFile(encodePasswordFile(id, s.passwordFiles[id]))
+		if err != nil {
+			return nil, err
+		}
+		e.bytes(file)
+	}
+	return e.b, nil
+}
+
+// RestoreSnapshot loads the password files in a snapshot encoded with
+// Snapshot, replacing the existing file of each user in it. Users with a file
+// on the Server but not in the snapshot are kept. If any file in the snapshot
+// fails to load, RestoreSnapshot returns its error and loads none of them.
+func (s *Server) RestoreSnapshot(data []byte) error {
+	d := newDecoder(messageSnapshot, data)
+	n := d.uint32("files")
+	var files [][]byte
+	for i := uint32(0); i < n && d.err == nil; i++ {
+		files = append(files, d.bytes("file"))
+	}
+	if err := d.finish(); err != nil {
+		return err
+	}
+
+	passwordFiles := make(map[string]pwdFile, len(files))
+	for i, file := range files {
+		id, pf, err := s.decodePasswordFile(file)
+		if err != nil {
+			return fmt.Errorf("snapshot file %d: %w", i, err)
+		}
+		passwordFiles[s.userID(id)] = pf
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, pf := range passwordFiles {
+		s.setPasswordFile(id, pf)
+		delete(s.legacyUsers, id)
+	}
+	return nil
+}
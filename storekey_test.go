@@ -0,0 +1,97 @@
+package occlude
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// verify that a snapshot encrypted under a store key uses a distinct nonce
+// for every file, and that every user can log in after it is restored.
+func TestSnapshotStoreKey(t *testing.T) {
+	testpassword := "this is a test password"
+	key := bytes.Repeat([]byte{7}, storeKeySize)
+	const users = 20
+
+	s := NewServer(WithArgon2Params(weakArgon2Params), WithStoreKey(key))
+	for i := 0; i < users; i++ {
+		id := fmt.Sprintf("user %d", i)
+		register(t, s, NewClient(id), id, testpassword)
+	}
+	snapshot, err := s.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nonces := make(map[string]bool)
+	d := newDecoder(messageSnapshot, snapshot)
+	n := d.uint32("files")
+	for i := uint32(0); i < n; i++ {
+		file := newDecoder(messageSealedPasswordFile, d.bytes("file"))
+		nonces[string(file.bytes("nonce"))] = true
+	}
+	if err := d.finish(); err != nil {
+		t.Fatal(err)
+	}
+	if n != users || len(nonces) != users {
+		t.Fatalf("expected %d distinct nonces, got %d for %d files", users, len(nonces), n)
+	}
+
+	restored := NewServer(WithArgon2Params(weakArgon2Params), WithStoreKey(key))
+	if err := restored.RestoreSnapshot(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < users; i++ {
+		id := fmt.Sprintf("user %d", i)
+		login(t, restored, NewClient(id), testpassword, "")
+	}
+
+	wrongKey := NewServer(WithStoreKey(bytes.Repeat([]byte{8}, storeKeySize)))
+	if err := wrongKey.RestoreSnapshot(snapshot); !errors.Is(err, ErrPasswordFileDecryption) {
+		t.Fatal("expected ErrPasswordFileDecryption, got", err)
+	}
+	if len(wrongKey.passwordFiles) != 0 {
+		t.Fatal("a failed restore loaded password files")
+	}
+}
+
+// verify that MarshalPasswordFile encrypts each encoding of a file with a
+// fresh nonce, and that only encrypted files are accepted under a store key.
+func TestMarshalPasswordFileStoreKey(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+	key := bytes.Repeat([]byte{7}, storeKeySize)
+
+	s := NewServer(WithArgon2Params(weakArgon2Params), WithStoreKey(key))
+	register(t, s, NewClient(testusername), testusername, testpassword)
+	first, err := s.MarshalPasswordFile(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.MarshalPasswordFile(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(first[:2+4+12], second[:2+4+12]) {
+		t.Fatal("two encodings of a password file reused a nonce")
+	}
+	if err := s.UnmarshalPasswordFile(testusername, second); err != nil {
+		t.Fatal(err)
+	}
+
+	plain := NewServer(WithArgon2Params(weakArgon2Params))
+	register(t, plain, NewClient(testusername), testusername, testpassword)
+	unencrypted, err := plain.MarshalPasswordFile(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UnmarshalPasswordFile(testusername, unencrypted); !errors.Is(err, ErrWrongMessageType) {
+		t.Fatal("expected ErrWrongMessageType, got", err)
+	}
+	short := NewServer(WithArgon2Params(weakArgon2Params), WithStoreKey(key[:16]))
+	register(t, short, NewClient(testusername), testusername, testpassword)
+	if _, err := short.MarshalPasswordFile(testusername); !errors.Is(err, ErrInvalidStoreKey) {
+		t.Fatal("expected ErrInvalidStoreKey, got", err)
+	}
+}
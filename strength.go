@@ -0,0 +1,33 @@
+package occlude
+
+import (
+	"errors"
+)
+
+// ErrPasswordTooWeak is returned by Server.Register when the Registration's
+// StrengthScore is below the Server's minimum.
+var ErrPasswordTooWeak = errors.New("password strength score is too low")
+
+// WithStrengthEstimator configures the Client to set the StrengthScore of each
+// Registration to estimate(password), for example a zxcvbn score from 0 to 4,
+// so that servers configured WithMinStrengthScore can enforce a strength
+// policy without learning the password.
+func WithStrengthEstimator(estimate func(password string) uint8) ClientOption {
+	return func(c *Client) {
+		c.strengthEstimator = estimate
+	}
+}
+
+// WithMinStrengthScore configures the Server to reject, with
+// ErrPasswordTooWeak, registrations whose StrengthScore is below min.
+// Registrations without a score have a StrengthScore of 0.
+//
+// NOTE: the score is computed and reported by the client, and the server
+// can not verify it: a modified client can register any password with any
+// score. The check therefore only guards against weak passwords chosen by
+// users of honest clients, and is no substitute for rate limiting logins.
+func WithMinStrengthScore(min uint8) ServerOption {
+	return func(s *Server) {
+		s.minStrengthScore = min
+	}
+}
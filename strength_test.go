@@ -0,0 +1,80 @@
+package occlude
+
+import (
+	"errors"
+	"testing"
+
+	ristretto "github.com/gtank/ristretto255"
+
+	"occlude/occludepb"
+)
+
+// verify that the Server rejects registrations whose self-reported strength
+// score is below its minimum, and accepts those which meet it.
+func TestMinStrengthScore(t *testing.T) {
+	testusername := "this is a test username"
+
+	// a toy estimator, scoring one point per four characters.
+	estimate := func(password string) uint8 { return uint8(len(password) / 4) }
+	s := NewServer(WithArgon2Params(weakArgon2Params), WithMinStrengthScore(3))
+	c := NewClient(testusername, WithStrengthEstimator(estimate))
+
+	pr, err := s.NewRegistration(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err := c.NewRegistration(pr, testusername, "weak pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reg.StrengthScore != 1 {
+		t.Fatal("wrong StrengthScore", reg.StrengthScore)
+	}
+	if err := s.Register(reg); !errors.Is(err, ErrPasswordTooWeak) {
+		t.Fatalf("expected ErrPasswordTooWeak, got %v", err)
+	}
+
+	// a registration without a score is also rejected.
+	pr, err = s.NewRegistration(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err = NewClient(testusername).NewRegistration(pr, testusername, "this is a strong password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register(reg); !errors.Is(err, ErrPasswordTooWeak) {
+		t.Fatalf("expected ErrPasswordTooWeak without a score, got %v", err)
+	}
+
+	register(t, s, c, testusername, "this is a strong password")
+}
+
+// verify that the StrengthScore survives the binary and protobuf encodings.
+func TestStrengthScoreEncoding(t *testing.T) {
+	reg := &Registration{
+		ID:            "this is a test username",
+		aci:           authCiphertext{Tag: make([]byte, macSize), Ciphertext: []byte("ciphertext")},
+		Pu:            new(ristretto.Element).ScalarBaseMult(randomScalar()),
+		Argon2:        weakArgon2Params,
+		StrengthScore: 4,
+	}
+	var decoded Registration
+	roundTrip(t, reg, &decoded)
+	if decoded.StrengthScore != 4 {
+		t.Fatal("StrengthScore did not survive the binary encoding")
+	}
+	var pbReg occludepb.Registration
+	protoRoundTrip(t, reg.ToProto(), &pbReg)
+	var protoReg Registration
+	if err := protoReg.FromProto(&pbReg); err != nil {
+		t.Fatal(err)
+	}
+	if protoReg.StrengthScore != 4 {
+		t.Fatal("StrengthScore did not survive the protobuf encoding")
+	}
+	pbReg.StrengthScore = 256
+	if err := protoReg.FromProto(&pbReg); !errors.Is(err, ErrMalformedMessage) {
+		t.Fatalf("expected ErrMalformedMessage for an out of range score, got %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package occlude
+
+import (
+	"time"
+)
+
+// DefaultSessionTTL is how long a login started with NewSession remains
+// available to FinishSession, unless configured otherwise with
+// WithSessionTTL.
+const DefaultSessionTTL = 5 * time.Minute
+
+const (
+	// DefaultRegistrationTTL is how long a registration started with
+	// NewRegistration remains available to Register, unless configured
+	// otherwise with WithRegistrationTTL.
+	DefaultRegistrationTTL = 10 * time.Minute
+
+	// DefaultRegistrationGrace is how much longer than the registration TTL
+	// Register still accepts a registration, unless configured otherwise with
+	// WithRegistrationTTL.
+	DefaultRegistrationGrace = 30 * time.Second
+)
+
+// WithSessionTTL configures how long a login started with NewSession remains
+// available to FinishSession, and a password check started with
+// NewPasswordCheck to CheckPasswordProof. Logins and password checks which are
+// not finished within the TTL are rejected and removed by Sweep.
+func WithSessionTTL(ttl time.Duration) ServerOption {
+	return func(s *Server) {
+		s.sessionTTL = ttl
+	}
+}
+
+// WithRegistrationTTL configures how long a registration started with
+// NewRegistration remains available to Register. Register still accepts a
+// registration up to grace beyond the TTL, so that slow clients and clock
+// skew do not cause spurious enrollment failures, but counts it in the
+// occlude_late_registrations_total metric. Registrations older than the TTL
+// plus grace are rejected by Register and removed by Sweep.
+func WithRegistrationTTL(ttl time.Duration, grace time.Duration) ServerOption {
+	return func(s *Server) {
+		s.registrationTTL = ttl
+		s.registrationGrace = grace
+	}
+}
+
+// WithSweepInterval starts a background goroutine which calls Sweep every
+// interval, until the Server is stopped with Shutdown.
+func WithSweepInterval(interval time.Duration) ServerOption {
+	return func(s *Server) {
+		s.sweepInterval = interval
+	}
+}
+
+// withClock configures the Server to read the current time from now. It is
+// unexported and intended for tests.
+func withClock(now func() time.Time) ServerOption {
+	return func(s *Server) {
+		s.now = now
+	}
+}
+
+// Sweep removes the state of logins which were started with NewSession but
+// abandoned, and of password checks started with NewPasswordCheck but never
+// answered, which have outlived the session TTL, and of registrations which
+// have outlived the registration TTL and its grace period. It should be
+// called periodically.
+func (s *Server) Sweep() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkClock()
+	now := s.now()
+	for id, sess := range s.sessions {
+		if s.sessionExpired(sess, now) {
+			delete(s.sessions, id)
+		}
+	}
+	for id, check := range s.passwordChecks {
+		if s.passwordCheckExpired(check, now) {
+			delete(s.passwordChecks, id)
+		}
+	}
+	for id, pr := range s.pendingRegistrations {
+		if s.registrationExpired(pr, now) {
+			delete(s.pendingRegistrations, id)
+		}
+	}
+}
+
+// startSweeper starts the background sweeper configured with
+// WithSweepInterval.
+func (s *Server) startSweeper() {
+	s.stopSweeper = make(chan struct{})
+	s.sweeperDone = make(chan struct{})
+	go func() {
+		defer close(s.sweeperDone)
+		ticker := time.NewTicker(s.sweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.Sweep()
+			case <-s.stopSweeper:
+				return
+			}
+		}
+	}()
+}
+
+// AbortSession immediately removes the state of the login in progress for
+// the user id, for example when the client cancels the login.
+func (s *Server) AbortSession(id string) {
+	id = s.userID(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+}
+
+// sessionExpired reports whether sess has outlived the session TTL at now. The
+// caller must hold s.mu.
+func (s *Server) sessionExpired(sess serverSession, now time.Time) bool {
+	return s.enforceTime() && now.Sub(sess.created) > s.sessionTTL
+}
+
+// registrationExpired reports whether pr has outlived the registration TTL
+// and its grace period at now. The caller must hold s.mu.
+func (s *Server) registrationExpired(pr pendingRegistration, now time.Time) bool {
+	return s.enforceTime() && now.Sub(pr.created) > s.registrationTTL+s.registrationGrace
+}
@@ -0,0 +1,122 @@
+package occlude
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// testClock is a manually advanced clock for tests.
+type testClock struct {
+	t time.Time
+}
+
+func (c *testClock) now() time.Time {
+	return c.t
+}
+
+// verify that abandoned logins are swept once they outlive the session TTL,
+// and that an expired login can't be finished.
+func TestSweepSessions(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	clock := &testClock{t: time.Unix(1700000000, 0)}
+	s := NewServer(withClock(clock.now), WithSessionTTL(time.Minute))
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+
+	cv := login(t, s, c, testpassword, "")
+	s.Sweep()
+	if _, exists := s.sessions[testusername]; !exists {
+		t.Fatal("sweep removed a live session")
+	}
+
+	clock.t = clock.t.Add(2 * time.Minute)
+	if _, err := s.FinishSession(cv); err == nil {
+		t.Fatal("finished an expired session")
+	}
+
+	login(t, s, c, testpassword, "")
+	clock.t = clock.t.Add(2 * time.Minute)
+	s.Sweep()
+	if len(s.sessions) != 0 {
+		t.Fatal("sweep did not remove an abandoned session")
+	}
+}
+
+// verify that AbortSession immediately frees a login's state.
+func TestAbortSession(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer()
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+
+	cv := login(t, s, c, testpassword, "")
+	s.AbortSession(testusername)
+	if len(s.sessions) != 0 {
+		t.Fatal("AbortSession did not free the session")
+	}
+	if _, err := s.FinishSession(cv); err == nil {
+		t.Fatal("finished an aborted session")
+	}
+}
+
+// verify that Register accepts a registration finished within the grace period
+// after its TTL, counting it as late, and rejects one finished after the grace
+// period.
+func TestRegistrationGrace(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	clock := &testClock{t: time.Unix(1700000000, 0)}
+	s := NewServer(withClock(clock.now), WithRegistrationTTL(time.Minute, 30*time.Second))
+	c := NewClient(testusername)
+
+	pr, err := s.NewRegistration(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err := c.NewRegistration(pr, testusername, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.t = clock.t.Add(time.Minute + 10*time.Second)
+	if err := s.Register(reg); err != nil {
+		t.Fatal("rejected a registration within the grace period:", err)
+	}
+	var buf bytes.Buffer
+	if err := s.WriteMetrics(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if late := parseMetrics(t, buf.String())["occlude_late_registrations_total"]; late != 1 {
+		t.Fatal("expected 1 late registration, got", late)
+	}
+
+	pr, err = s.NewRegistration("another user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err = c.NewRegistration(pr, "another user", testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.t = clock.t.Add(time.Minute + 31*time.Second)
+	if err := s.Register(reg); err == nil {
+		t.Fatal("accepted a registration after the grace period")
+	}
+	if _, exists := s.passwordFiles["another user"]; exists {
+		t.Fatal("stored an expired registration")
+	}
+
+	if _, err := s.NewRegistration("third user"); err != nil {
+		t.Fatal(err)
+	}
+	clock.t = clock.t.Add(time.Minute + 31*time.Second)
+	s.Sweep()
+	if len(s.pendingRegistrations) != 0 {
+		t.Fatal("sweep did not remove an expired registration")
+	}
+}
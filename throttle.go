@@ -0,0 +1,90 @@
+package occlude
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned when a request is refused by a Throttle.
+var ErrRateLimited = errors.New("rate limited")
+
+// Throttle limits the rate of requests from each source, such as a client IP
+// address. It must be safe for concurrent use.
+type Throttle interface {
+	// Allow reports whether a request from source may proceed, counting it
+	// against the source's limit if so.
+	Allow(source string) bool
+}
+
+// WithRegistrationThrottle configures the Server to check every
+// NewRegistrationFrom, NewLegacyRegistrationFrom and RegisterFrom against t,
+// refusing requests it does not allow with ErrRateLimited. NewRegistration,
+// NewLegacyRegistration and Register are checked with the empty source, which
+// all such requests share.
+func WithRegistrationThrottle(t Throttle) ServerOption {
+	return func(s *Server) {
+		s.registrationThrottle = t
+	}
+}
+
+// throttleRegistration returns ErrRateLimited if the Server's registration
+// Throttle refuses a request from source.
+func (s *Server) throttleRegistration(source string) error {
+	if s.registrationThrottle == nil || s.registrationThrottle.Allow(source) {
+		return nil
+	}
+	s.mu.Lock()
+	s.stats.rateLimitRejections++
+	s.mu.Unlock()
+	return ErrRateLimited
+}
+
+// windowThrottle is the Throttle returned by NewWindowThrottle.
+type windowThrottle struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	now    func() time.Time
+	starts map[string]time.Time
+	counts map[string]int
+}
+
+// NewWindowThrottle returns a Throttle which allows each source limit requests
+// in every fixed window of the given duration, starting from the source's
+// first request.
+func NewWindowThrottle(limit int, window time.Duration) Throttle {
+	return newWindowThrottle(limit, window, time.Now)
+}
+
+func newWindowThrottle(limit int, window time.Duration, now func() time.Time) *windowThrottle {
+	return &windowThrottle{
+		limit:  limit,
+		window: window,
+		now:    now,
+		starts: make(map[string]time.Time),
+		counts: make(map[string]int),
+	}
+}
+
+func (w *windowThrottle) Allow(source string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := w.now()
+	if start, exists := w.starts[source]; !exists || now.Sub(start) >= w.window {
+		// Expired windows are dropped as they are found, so that the maps
+		// only hold sources seen within the last window.
+		for s, start := range w.starts {
+			if now.Sub(start) >= w.window {
+				delete(w.starts, s)
+				delete(w.counts, s)
+			}
+		}
+		w.starts[source] = now
+	}
+	if w.counts[source] >= w.limit {
+		return false
+	}
+	w.counts[source]++
+	return true
+}
@@ -0,0 +1,46 @@
+package occlude
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// verify that registrations beyond the throttle's limit are refused with
+// ErrRateLimited for the offending source only, and allowed again once the
+// window has passed.
+func TestRegistrationThrottle(t *testing.T) {
+	clock := &testClock{t: time.Unix(1700000000, 0)}
+	throttle := newWindowThrottle(2, time.Minute, clock.now)
+	s := NewServer(WithRegistrationThrottle(throttle))
+
+	for i := 0; i < 2; i++ {
+		if _, err := s.NewRegistrationFrom("192.0.2.1", "user"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.NewRegistrationFrom("192.0.2.1", "user"); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited, got %v", err)
+	}
+	if err := s.RegisterFrom("192.0.2.1", &Registration{}); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited from RegisterFrom, got %v", err)
+	}
+	if _, err := s.NewRegistrationFrom("192.0.2.2", "other"); err != nil {
+		t.Fatalf("throttle limited an unrelated source: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := s.WriteMetrics(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "occlude_rate_limit_rejections_total 2\n") {
+		t.Fatalf("rejections were not counted:\n%s", buf.String())
+	}
+
+	clock.t = clock.t.Add(time.Minute)
+	if _, err := s.NewRegistrationFrom("192.0.2.1", "user"); err != nil {
+		t.Fatalf("throttle did not reset after the window: %v", err)
+	}
+}
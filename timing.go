@@ -0,0 +1,107 @@
+package occlude
+
+import (
+	"time"
+)
+
+// LoginStep identifies the step of a login a LoginTiming measures.
+type LoginStep uint8
+
+const (
+	// LoginStepNewSession is the server's response to a UsrSession, by
+	// NewSession, NewSessionBatch or NewPendingSession.
+	LoginStepNewSession LoginStep = iota + 1
+
+	// LoginStepFinishSession is the server's verification of the client, by
+	// FinishSession, FinishSessionRaw or PendingSession.Finish.
+	LoginStepFinishSession
+)
+
+// LoginTiming is the time the Server spent in each phase of one successful
+// login step. Store is the time spent looking up and checking the user's
+// password file and deriving their OPRF key, OPRF the time spent evaluating
+// the OPRF, and AKE the time spent in the key exchange, including signing the
+// SvrSession, or verifying the client and running any WithOnAuthenticated
+// hook. Together they account for all but a negligible part of Total.
+//
+// The password is hardened with Argon2 by the client, so its cost appears in
+// none of these, but in the time the client takes between NewSession and
+// FinishSession.
+type LoginTiming struct {
+	Step  LoginStep
+	Store time.Duration
+	OPRF  time.Duration
+	AKE   time.Duration
+	Total time.Duration
+}
+
+// TimingObserver receives a LoginTiming for every successful login step.
+// ObserveTiming is called with the Server's lock held, so it must not call
+// back into the Server, and should return quickly.
+type TimingObserver interface {
+	ObserveTiming(timing LoginTiming)
+}
+
+// WithTimingObserver configures the Server to report the timing of each login
+// step to o, such as to monitor login latency objectives. Without an observer
+// the Server does not read the clock to time logins.
+func WithTimingObserver(o TimingObserver) ServerOption {
+	return func(s *Server) {
+		s.timingObserver = o
+	}
+}
+
+// loginPhase is a phase of a login step measured by a LoginTiming.
+type loginPhase uint8
+
+const (
+	phaseStore loginPhase = iota
+	phaseOPRF
+	phaseAKE
+)
+
+// loginTimer times the phases of one login step. A nil loginTimer, as
+// returned when the Server has no TimingObserver, does nothing.
+type loginTimer struct {
+	timing      LoginTiming
+	start, last time.Time
+}
+
+// startTimer returns a loginTimer for step, or nil if the Server has no
+// TimingObserver.
+func (s *Server) startTimer(step LoginStep) *loginTimer {
+	if s.timingObserver == nil {
+		return nil
+	}
+	now := time.Now()
+	return &loginTimer{timing: LoginTiming{Step: step}, start: now, last: now}
+}
+
+// lap attributes the time since the previous lap, or since the timer started,
+// to phase.
+func (t *loginTimer) lap(phase loginPhase) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	d := now.Sub(t.last)
+	t.last = now
+	switch phase {
+	case phaseStore:
+		t.timing.Store += d
+	case phaseOPRF:
+		t.timing.OPRF += d
+	case phaseAKE:
+		t.timing.AKE += d
+	}
+}
+
+// observeTiming reports the timing of a finished login step to the Server's
+// TimingObserver. The caller must hold s.mu.
+func (s *Server) observeTiming(t *loginTimer) {
+	if t == nil {
+		return
+	}
+	t.timing.Total = time.Since(t.start)
+	s.timingObserver.ObserveTiming(t.timing)
+}
@@ -0,0 +1,56 @@
+package occlude
+
+import (
+	"testing"
+	"time"
+)
+
+// timingRecorder is a TimingObserver which records every LoginTiming.
+type timingRecorder struct {
+	timings []LoginTiming
+}
+
+func (r *timingRecorder) ObserveTiming(timing LoginTiming) {
+	r.timings = append(r.timings, timing)
+}
+
+// verify that a TimingObserver receives a LoginTiming for each step of a
+// successful login, with its phases populated and summing to about its total.
+func TestTimingObserver(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	r := &timingRecorder{}
+	s := NewServer(WithArgon2Params(weakArgon2Params), WithTimingObserver(r))
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+	if _, err := s.FinishSession(login(t, s, c, testpassword, "")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.timings) != 2 {
+		t.Fatal("expected 2 timings, got", len(r.timings))
+	}
+	for i, step := range []LoginStep{LoginStepNewSession, LoginStepFinishSession} {
+		timing := r.timings[i]
+		if timing.Step != step {
+			t.Fatalf("timing %d: expected step %d, got %d", i, step, timing.Step)
+		}
+		sum := timing.Store + timing.OPRF + timing.AKE
+		if timing.Total <= 0 || sum > timing.Total || timing.Total-sum > time.Millisecond {
+			t.Fatalf("timing %d: phases sum to %v of %v", i, sum, timing.Total)
+		}
+	}
+	newSession := r.timings[0]
+	if newSession.Store <= 0 || newSession.OPRF <= 0 || newSession.AKE <= 0 {
+		t.Fatalf("NewSession timing has an empty phase: %+v", newSession)
+	}
+
+	// failed logins are not timed.
+	if _, err := s.FinishSession(&ClientVerification{ID: testusername}); err == nil {
+		t.Fatal("finished a session which was never started")
+	}
+	if len(r.timings) != 2 {
+		t.Fatal("timed a failed login")
+	}
+}
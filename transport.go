@@ -0,0 +1,218 @@
+package occlude
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// transportHeaderSize is the length of a Transport frame header: the
+	// 8-byte sequence number followed by the 4-byte ciphertext length.
+	transportHeaderSize = 12
+
+	// maxTransportMessage is the maximum length of a Transport message, in
+	// bytes.
+	maxTransportMessage = 1 << 20
+)
+
+var (
+	// ErrReplayedMessage is returned by Transport.ReadMessage when a frame
+	// with an already received sequence number arrives.
+	ErrReplayedMessage = errors.New("replayed transport message")
+
+	// ErrReorderedMessage is returned by Transport.ReadMessage when a frame
+	// arrives before an earlier one in the sequence.
+	ErrReorderedMessage = errors.New("reordered transport message")
+
+	// ErrMessageTooLarge is returned by the Transport when a message exceeds
+	// the maximum message length.
+	ErrMessageTooLarge = errors.New("transport message too large")
+
+	// ErrTransportAuthentication is returned by Transport.ReadMessage when a
+	// frame fails to authenticate.
+	ErrTransportAuthentication = errors.New("transport message failed authentication")
+
+	// ErrSessionKeyExpired is returned by the Transport once the expiry set
+	// with SetExpiry has passed.
+	ErrSessionKeyExpired = errors.New("session key expired")
+
+	// ErrTransportBroken is returned by Transport.WriteMessage once a write
+	// to the connection has failed.
+	ErrTransportBroken = errors.New("transport broken by a failed write")
+)
+
+// Transport is an authenticated, encrypted channel over a connection, keyed
+// by the session key SK of a completed login. Each direction has its own
+// AES-GCM key, and every frame carries a sequence number which is used as its
+// nonce, so that replayed, reordered or modified frames are rejected.
+//
+// A Transport is safe for one concurrent reader and one concurrent writer.
+type Transport struct {
+	conn io.ReadWriteCloser
+
+	sendMu  sync.Mutex
+	send    cipher.AEAD
+	sendSeq uint64
+
+	// sendErr is the error of the write which broke the Transport, if any.
+	sendErr error
+
+	recvMu  sync.Mutex
+	recv    cipher.AEAD
+	recvSeq uint64
+
+	// expiry is the time after which the Transport refuses to send or
+	// receive, set with SetExpiry, or zero if it never expires.
+	expiryMu sync.Mutex
+	expiry   time.Time
+	now      func() time.Time
+}
+
+// NewTransport returns a Transport over conn keyed by sessionKey. The client
+// and the server of a login each create their end with the SK they derived,
+// passing isClient to select which per-direction key they send with.
+func NewTransport(conn io.ReadWriteCloser, sessionKey []byte, isClient bool) (*Transport, error) {
+	if len(sessionKey) < prfSize {
+		return nil, fmt.Errorf("%w: sessionKey", ErrInvalidLength)
+	}
+	keys := deriveKeys(sessionKey, nil, []keySpec{
+		{[]byte("occlude transport client to server"), 32},
+		{[]byte("occlude transport server to client"), 32},
+	})
+	clientToServer, err := transportAEAD(keys[0])
+	if err != nil {
+		return nil, err
+	}
+	serverToClient, err := transportAEAD(keys[1])
+	if err != nil {
+		return nil, err
+	}
+	t := &Transport{conn: conn, send: serverToClient, recv: clientToServer, now: time.Now}
+	if isClient {
+		t.send, t.recv = clientToServer, serverToClient
+	}
+	return t, nil
+}
+
+// transportAEAD returns the AES-GCM AEAD for one direction of a Transport,
+// keyed by key.
+func transportAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// transportNonce returns the AES-GCM nonce for the frame with sequence number
+// seq.
+func transportNonce(seq uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], seq)
+	return nonce
+}
+
+// SetExpiry configures the Transport to refuse to encrypt or decrypt any
+// further message after expiry, with ErrSessionKeyExpired, so that the session
+// key it is keyed by can not be used past a maximum age. The client would
+// typically expire it at LoginResult.IssuedAt plus the maximum age, and the
+// server at the time of its FinishSession plus the same. The zero time
+// removes the expiry.
+func (t *Transport) SetExpiry(expiry time.Time) {
+	t.expiryMu.Lock()
+	defer t.expiryMu.Unlock()
+	t.expiry = expiry
+}
+
+// checkExpiry returns ErrSessionKeyExpired if the Transport's expiry has
+// passed.
+func (t *Transport) checkExpiry() error {
+	t.expiryMu.Lock()
+	defer t.expiryMu.Unlock()
+	if !t.expiry.IsZero() && t.now().After(t.expiry) {
+		return ErrSessionKeyExpired
+	}
+	return nil
+}
+
+// WriteMessage encrypts msg and writes it to the connection as a single
+// frame. If the write fails, the frame may have been partly written, and its
+// nonce used, so the Transport is broken: every later WriteMessage fails with
+// ErrTransportBroken, and a new Transport must be established.
+func (t *Transport) WriteMessage(msg []byte) error {
+	if len(msg) > maxTransportMessage {
+		return ErrMessageTooLarge
+	}
+	if err := t.checkExpiry(); err != nil {
+		return err
+	}
+	t.sendMu.Lock()
+	defer t.sendMu.Unlock()
+	if t.sendErr != nil {
+		return fmt.Errorf("%w: %v", ErrTransportBroken, t.sendErr)
+	}
+	if t.sendSeq == math.MaxUint64 {
+		return errors.New("transport sequence number exhausted")
+	}
+
+	frame := make([]byte, transportHeaderSize, transportHeaderSize+len(msg)+t.send.Overhead())
+	binary.BigEndian.PutUint64(frame[0:8], t.sendSeq)
+	binary.BigEndian.PutUint32(frame[8:12], uint32(len(msg)+t.send.Overhead()))
+	frame = t.send.Seal(frame, transportNonce(t.sendSeq), msg, frame[:transportHeaderSize])
+	if _, err := t.conn.Write(frame); err != nil {
+		t.sendErr = err
+		return err
+	}
+	t.sendSeq++
+	return nil
+}
+
+// ReadMessage reads the next frame from the connection and returns its
+// decrypted message. A frame which is replayed, arrives out of order or fails
+// to authenticate is rejected without advancing the sequence.
+func (t *Transport) ReadMessage() ([]byte, error) {
+	t.recvMu.Lock()
+	defer t.recvMu.Unlock()
+	if err := t.checkExpiry(); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, transportHeaderSize)
+	if _, err := io.ReadFull(t.conn, header); err != nil {
+		return nil, err
+	}
+	seq := binary.BigEndian.Uint64(header[0:8])
+	n := binary.BigEndian.Uint32(header[8:12])
+	if n > maxTransportMessage+uint32(t.recv.Overhead()) {
+		return nil, ErrMessageTooLarge
+	}
+	ciphertext := make([]byte, n)
+	if _, err := io.ReadFull(t.conn, ciphertext); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case seq < t.recvSeq:
+		return nil, ErrReplayedMessage
+	case seq > t.recvSeq:
+		return nil, ErrReorderedMessage
+	}
+	msg, err := t.recv.Open(nil, transportNonce(seq), ciphertext, header)
+	if err != nil {
+		return nil, ErrTransportAuthentication
+	}
+	t.recvSeq++
+	return msg, nil
+}
+
+// Close closes the underlying connection.
+func (t *Transport) Close() error {
+	return t.conn.Close()
+}
@@ -0,0 +1,255 @@
+package occlude
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// bufferConn is an in-memory connection which reads back what was written to
+// it, so that tests can capture and replay frames.
+type bufferConn struct {
+	bytes.Buffer
+}
+
+func (*bufferConn) Close() error { return nil }
+
+// failingConn is a bufferConn whose writes fail, after writing part of the
+// frame, while fail is set.
+type failingConn struct {
+	bufferConn
+	fail bool
+}
+
+func (c *failingConn) Write(p []byte) (int, error) {
+	if c.fail {
+		n, _ := c.bufferConn.Write(p[:len(p)/2])
+		return n, errors.New("connection reset")
+	}
+	return c.bufferConn.Write(p)
+}
+
+// transportSessionKeys runs a login and returns the client and server session
+// keys.
+func transportSessionKeys(t *testing.T) ([]byte, []byte) {
+	t.Helper()
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, serverKey, err := s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientKey, _, err := c.SessionKey(svrsess, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return clientKey, serverKey
+}
+
+// verify that messages sent in both directions over a Transport keyed by a
+// completed login arrive intact.
+func TestTransport(t *testing.T) {
+	clientKey, serverKey := transportSessionKeys(t)
+	clientConn, serverConn := net.Pipe()
+	client, err := NewTransport(clientConn, clientKey, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := NewTransport(serverConn, serverKey, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		for i := 0; i < 3; i++ {
+			msg, err := server.ReadMessage()
+			if err != nil {
+				errs <- err
+				return
+			}
+			if err := server.WriteMessage(append([]byte("echo: "), msg...)); err != nil {
+				errs <- err
+				return
+			}
+		}
+		errs <- nil
+	}()
+	for _, msg := range []string{"hello", "", "hello"} {
+		if err := client.WriteMessage([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		reply, err := client.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(reply) != "echo: "+msg {
+			t.Fatalf("expected %q, got %q", "echo: "+msg, reply)
+		}
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// verify that the Transport rejects replayed, reordered and modified frames,
+// and frames sent in the wrong direction.
+func TestTransportRejectsTampering(t *testing.T) {
+	clientKey, serverKey := transportSessionKeys(t)
+	conn := new(bufferConn)
+	client, err := NewTransport(conn, clientKey, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewTransport(conn, serverKey, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.WriteMessage([]byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	first := append([]byte(nil), conn.Bytes()...)
+	msg, err := server.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg) != "first" {
+		t.Fatal("expected first, got", string(msg))
+	}
+	conn.Write(first)
+	if _, err := server.ReadMessage(); err != ErrReplayedMessage {
+		t.Fatal("expected ErrReplayedMessage, got", err)
+	}
+
+	if err := client.WriteMessage([]byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	second := append([]byte(nil), conn.Bytes()...)
+	conn.Reset()
+	if err := client.WriteMessage([]byte("third")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.ReadMessage(); err != ErrReorderedMessage {
+		t.Fatal("expected ErrReorderedMessage, got", err)
+	}
+
+	second[len(second)-1] ^= 0xff
+	conn.Write(second)
+	if _, err := server.ReadMessage(); err != ErrTransportAuthentication {
+		t.Fatal("expected ErrTransportAuthentication, got", err)
+	}
+	second[len(second)-1] ^= 0xff
+	conn.Write(second)
+	msg, err = server.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg) != "second" {
+		t.Fatal("expected second, got", string(msg))
+	}
+
+	reflector, err := NewTransport(new(bufferConn), serverKey, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reflector.WriteMessage([]byte("reflected")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reflector.ReadMessage(); err != ErrTransportAuthentication {
+		t.Fatal("expected ErrTransportAuthentication for a reflected frame, got", err)
+	}
+
+	if _, err := NewTransport(conn, clientKey[:16], true); err == nil {
+		t.Fatal("expected error for a short session key")
+	}
+}
+
+// verify that a Transport keyed by a session key older than its maximum age
+// refuses to send or receive.
+func TestTransportExpiry(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+	const maxAge = time.Hour
+
+	s := NewServer(WithArgon2Params(weakArgon2Params))
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err := s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := c.FinishLogin(svrsess, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Expired(result.IssuedAt.Add(maxAge), maxAge) {
+		t.Fatal("session key expired at its maximum age")
+	}
+	if !result.Expired(result.IssuedAt.Add(maxAge+time.Second), maxAge) {
+		t.Fatal("session key did not expire past its maximum age")
+	}
+
+	conn := new(bufferConn)
+	client, err := NewTransport(conn, result.SessionKey, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &testClock{t: result.IssuedAt}
+	client.now = clock.now
+	client.SetExpiry(result.IssuedAt.Add(maxAge))
+	if err := client.WriteMessage([]byte("fresh")); err != nil {
+		t.Fatal(err)
+	}
+	clock.t = clock.t.Add(maxAge + time.Second)
+	if err := client.WriteMessage([]byte("stale")); err != ErrSessionKeyExpired {
+		t.Fatal("expected ErrSessionKeyExpired, got", err)
+	}
+	if _, err := client.ReadMessage(); err != ErrSessionKeyExpired {
+		t.Fatal("expected ErrSessionKeyExpired, got", err)
+	}
+	client.SetExpiry(time.Time{})
+	if err := client.WriteMessage([]byte("unexpiring")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// verify that a failed write breaks the Transport, so that no later message
+// is sealed under the nonce of the failed frame.
+func TestTransportFailedWrite(t *testing.T) {
+	clientKey, _ := transportSessionKeys(t)
+	conn := new(failingConn)
+	client, err := NewTransport(conn, clientKey, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.fail = true
+	if err := client.WriteMessage([]byte("first")); err == nil {
+		t.Fatal("write to a failing connection succeeded")
+	}
+	conn.fail = false
+	conn.Reset()
+	if err := client.WriteMessage([]byte("second")); !errors.Is(err, ErrTransportBroken) {
+		t.Fatal("expected ErrTransportBroken, got", err)
+	}
+	if conn.Len() != 0 {
+		t.Fatal("a broken Transport wrote a frame")
+	}
+}
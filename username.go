@@ -0,0 +1,98 @@
+package occlude
+
+import (
+	"errors"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// ErrUsernameRejected is returned by Server.NewRegistration and
+// Server.ImportLegacyUser when the user id is refused by the Server's
+// UsernamePolicy.
+var ErrUsernameRejected = errors.New("username rejected by policy")
+
+// UsernamePolicy decides which user ids may be registered, for example to
+// refuse ids which are confusable with existing ones. It is consulted with the
+// normalized id, if the Server was configured WithUsernameNormalization.
+type UsernamePolicy interface {
+	// Allowed reports whether id may be registered.
+	Allowed(id string) bool
+}
+
+// WithUsernameNormalization configures the Server to normalize every user id
+// to Unicode Normalization Form C before using it to look up or store the
+// user's state, so that ids which differ only in their normalization, such as
+// a composed and a decomposed "é", name the same user. The ids in the
+// protocol messages themselves are not modified, so clients need not
+// normalize.
+//
+// NOTE: enabling normalization on a Server with existing users who were
+// registered under non-NFC ids makes them unreachable; their password files
+// must be migrated to the normalized ids.
+func WithUsernameNormalization() ServerOption {
+	return func(s *Server) {
+		s.normalizeUsernames = true
+	}
+}
+
+// WithUsernamePolicy configures the Server to refuse registrations of user
+// ids not allowed by p with ErrUsernameRejected.
+func WithUsernamePolicy(p UsernamePolicy) ServerOption {
+	return func(s *Server) {
+		s.usernamePolicy = p
+	}
+}
+
+// MixedScriptPolicy is a UsernamePolicy which refuses ids containing letters
+// from more than one script, such as a Latin "a" alongside a Cyrillic "а",
+// the most common form of confusable id. Digits, punctuation and combining
+// marks, which belong to the Common and Inherited scripts, are allowed with
+// any script.
+type MixedScriptPolicy struct{}
+
+// Allowed reports whether the letters of id are all from a single script.
+func (MixedScriptPolicy) Allowed(id string) bool {
+	var script *unicode.RangeTable
+	for _, r := range id {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		if script != nil && unicode.Is(script, r) {
+			continue
+		}
+		if script != nil {
+			return false
+		}
+		script = scriptOf(r)
+	}
+	return true
+}
+
+// scriptOf returns the script of the letter r, or nil if it is not in any
+// script other than Common and Inherited.
+func scriptOf(r rune) *unicode.RangeTable {
+	for name, table := range unicode.Scripts {
+		if name != "Common" && name != "Inherited" && unicode.Is(table, r) {
+			return table
+		}
+	}
+	return nil
+}
+
+// userID returns the key the Server stores the state of the user id under.
+func (s *Server) userID(id string) string {
+	if s.normalizeUsernames {
+		return norm.NFC.String(id)
+	}
+	return id
+}
+
+// checkUsername returns ErrUsernameRejected if the Server's UsernamePolicy
+// does not allow the user id, which must already be normalized.
+func (s *Server) checkUsername(id string) error {
+	if s.usernamePolicy != nil && !s.usernamePolicy.Allowed(id) {
+		return ErrUsernameRejected
+	}
+	return nil
+}
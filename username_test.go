@@ -0,0 +1,67 @@
+package occlude
+
+import (
+	"errors"
+	"testing"
+)
+
+// verify that with username normalization, user ids which differ only in
+// their Unicode normalization name the same credential, and that without it
+// they don't.
+func TestUsernameNormalization(t *testing.T) {
+	testpassword := "this is a test password"
+	composed := "josé"
+	decomposed := "josé"
+
+	s := NewServer(WithArgon2Params(weakArgon2Params), WithUsernameNormalization())
+	register(t, s, NewClient(decomposed), decomposed, testpassword)
+	pr, err := s.NewRegistration(composed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err := NewClient(composed).NewRegistration(pr, composed, "another password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register(reg); err == nil {
+		t.Fatal("registered an id equivalent to an existing user")
+	}
+
+	c := NewClient(composed)
+	cv := login(t, s, c, testpassword, "")
+	if _, err := s.FinishSession(cv); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := s.passwordFiles[composed]; !exists || len(s.passwordFiles) != 1 {
+		t.Fatal("password file is not stored under the normalized id")
+	}
+
+	unnormalized := NewServer(WithArgon2Params(weakArgon2Params))
+	register(t, unnormalized, NewClient(decomposed), decomposed, testpassword)
+	sess, err := NewClient(composed).NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := unnormalized.NewSession(sess); err == nil {
+		t.Fatal("logged in as a differently normalized id without normalization")
+	}
+}
+
+// verify that a UsernamePolicy refuses registrations, and that
+// MixedScriptPolicy refuses ids mixing scripts.
+func TestUsernamePolicy(t *testing.T) {
+	s := NewServer(WithUsernamePolicy(MixedScriptPolicy{}))
+	for _, id := range []string{"paypal", "josé", "josé", "пользователь", "user 42", "用户42"} {
+		if _, err := s.NewRegistration(id); err != nil {
+			t.Fatalf("refused %q: %v", id, err)
+		}
+	}
+	for _, id := range []string{"p\u0430ypal", "user\u043f\u043e\u043b\u044c"} {
+		if _, err := s.NewRegistration(id); !errors.Is(err, ErrUsernameRejected) {
+			t.Fatalf("expected ErrUsernameRejected for %q, got %v", id, err)
+		}
+		if err := s.ImportLegacyUser(id, BcryptHash([]byte("$2a$"))); !errors.Is(err, ErrUsernameRejected) {
+			t.Fatalf("expected ErrUsernameRejected for %q, got %v", id, err)
+		}
+	}
+}
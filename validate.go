@@ -0,0 +1,196 @@
+package occlude
+
+import (
+	"errors"
+	"fmt"
+
+	ristretto "github.com/gtank/ristretto255"
+)
+
+const (
+	// maxIDLength is the maximum length of a user id, in bytes.
+	maxIDLength = 1024
+
+	// maxCiphertextLength is the maximum length of an envelope ciphertext, in
+	// bytes.
+	maxCiphertextLength = 1 << 16
+
+	// macSize is the length of an HMAC-SHA3-256 tag, and prfSize the length
+	// of a prf output, in bytes.
+	macSize = 32
+	prfSize = 32
+)
+
+var (
+	// ErrMissingField is returned by Validate when a required field of a
+	// message is unset.
+	ErrMissingField = errors.New("message is missing a required field")
+
+	// ErrIdentityElement is returned by Validate when a group element in a
+	// message is the identity element, which is never a legitimate value.
+	ErrIdentityElement = errors.New("message contains the identity element")
+
+	// ErrInvalidLength is returned by Validate when a field of a message is
+	// outside of its allowed length bounds.
+	ErrInvalidLength = errors.New("message field has an invalid length")
+)
+
+// Validate checks that the UsrSession is well-formed. It is intended to be
+// called by transports on untrusted input, before the message is handed to
+// Server.NewSession.
+func (u *UsrSession) Validate() error {
+	if u == nil {
+		return ErrNilMessage
+	}
+	if err := validateID("Sid", u.Sid); err != nil {
+		return err
+	}
+	if err := validateElement("Alpha", u.Alpha); err != nil {
+		return err
+	}
+	return validateElement("Xu", u.Xu)
+}
+
+// Validate checks that the SvrSession is well-formed. It is intended to be
+// called by transports on untrusted input, before the message is handed to
+// Client.SessionKey.
+func (v *SvrSession) Validate() error {
+	if v == nil {
+		return ErrNilMessage
+	}
+	if !v.Version.supported() {
+		return ErrUnsupportedVersion
+	}
+	if !v.TranscriptHash.supported() {
+		return ErrUnsupportedTranscriptHash
+	}
+	if err := validateElement("Beta", v.Beta); err != nil {
+		return err
+	}
+	if err := validateElement("Xs", v.Xs); err != nil {
+		return err
+	}
+	if v.Xu != nil {
+		if err := validateElement("Xu", v.Xu); err != nil {
+			return err
+		}
+	}
+	if v.Argon2Upgrade != nil && !v.Argon2Upgrade.supported() {
+		return ErrUnsupportedScheme
+	}
+	if v.OPRFKey != nil {
+		if err := validateElement("OPRFKey", v.OPRFKey); err != nil {
+			return err
+		}
+		if err := validateLength("OPRFProof", v.OPRFProof, oprfProofSize, oprfProofSize); err != nil {
+			return err
+		}
+	}
+	if err := validateLength("fk1", v.fk1, prfSize, prfSize); err != nil {
+		return err
+	}
+	if err := validateLength("Signature", v.Signature, elementSize+scalarSize, elementSize+scalarSize); err != nil {
+		return err
+	}
+	return v.c.validate()
+}
+
+// Validate checks that the Registration is well-formed. It is intended to be
+// called by transports on untrusted input, before the message is handed to
+// Server.Register.
+func (r *Registration) Validate() error {
+	if r == nil {
+		return ErrNilMessage
+	}
+	if err := validateID("ID", r.ID); err != nil {
+		return err
+	}
+	if err := validateElement("Pu", r.Pu); err != nil {
+		return err
+	}
+	if err := validateLength("IdempotencyKey", []byte(r.IdempotencyKey), 0, maxIDLength); err != nil {
+		return err
+	}
+	if r.SecondFactor != nil {
+		if err := validateElement("SecondFactor", r.SecondFactor); err != nil {
+			return err
+		}
+	}
+	if r.recovery != nil {
+		if err := validateLength("recovery Salt", r.recovery.Salt, recoverySaltSize, recoverySaltSize); err != nil {
+			return err
+		}
+		if err := r.recovery.c.validate(); err != nil {
+			return err
+		}
+	}
+	if r.appData != nil {
+		if err := r.appData.validate(); err != nil {
+			return err
+		}
+	}
+	return r.aci.validate()
+}
+
+// Validate checks that the ClientVerification is well-formed. It is intended
+// to be called by transports on untrusted input, before the message is handed
+// to Server.FinishSession.
+func (cv *ClientVerification) Validate() error {
+	if cv == nil {
+		return ErrNilMessage
+	}
+	if err := validateID("ID", cv.ID); err != nil {
+		return err
+	}
+	return validateLength("FK2", cv.FK2, prfSize, prfSize)
+}
+
+// validate checks the length bounds of an authCiphertext.
+func (a *authCiphertext) validate() error {
+	if err := validateLength("Ciphertext", a.Ciphertext, 1, maxCiphertextLength); err != nil {
+		return err
+	}
+	if err := validateLength("Salt", a.Salt, envelopeSaltSize, envelopeSaltSize); err != nil {
+		return err
+	}
+	return validateLength("Tag", a.Tag, macSize, macSize)
+}
+
+func validateID(name string, id string) error {
+	if id == "" {
+		return fmt.Errorf("%w: %s", ErrMissingField, name)
+	}
+	if len(id) > maxIDLength {
+		return fmt.Errorf("%w: %s", ErrInvalidLength, name)
+	}
+	return nil
+}
+
+func validateElement(name string, e *ristretto.Element) error {
+	if e == nil {
+		return fmt.Errorf("%w: %s", ErrMissingField, name)
+	}
+	return checkNonIdentity(name, e)
+}
+
+// checkNonIdentity rejects the identity element for the group element field
+// name. Every group element in the protocol, Alpha, Xu, Beta, Xs, Pu and Ps,
+// is a public key or a blinded hash, for which the identity is never a
+// legitimate value. It is the single check used by Validate and by strict
+// decoding.
+func checkNonIdentity(name string, e *ristretto.Element) error {
+	if isIdentity(e) {
+		return fmt.Errorf("%w: %s", ErrIdentityElement, name)
+	}
+	return nil
+}
+
+func validateLength(name string, b []byte, minLen int, maxLen int) error {
+	if len(b) == 0 && minLen > 0 {
+		return fmt.Errorf("%w: %s", ErrMissingField, name)
+	}
+	if len(b) < minLen || len(b) > maxLen {
+		return fmt.Errorf("%w: %s", ErrInvalidLength, name)
+	}
+	return nil
+}
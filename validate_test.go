@@ -0,0 +1,117 @@
+package occlude
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	ristretto "github.com/gtank/ristretto255"
+)
+
+// verify that Validate accepts well-formed messages and reports each kind of
+// malformed field.
+func TestValidate(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	s := NewServer()
+	c := NewClient(testusername)
+	pr, err := s.NewRegistration(testusername)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err := c.NewRegistration(pr, testusername, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register(reg); err != nil {
+		t.Fatal(err)
+	}
+	sess, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sess.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	svrsess, _, err := s.NewSession(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svrsess.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	cv := &ClientVerification{ID: testusername, FK2: make([]byte, prfSize)}
+	if err := cv.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	identity := new(ristretto.Element).Zero()
+	longID := strings.Repeat("a", maxIDLength+1)
+	tests := []struct {
+		name     string
+		validate func() error
+		expected error
+	}{
+		{"UsrSession empty Sid", func() error { u := *sess; u.Sid = ""; return u.Validate() }, ErrMissingField},
+		{"UsrSession long Sid", func() error { u := *sess; u.Sid = longID; return u.Validate() }, ErrInvalidLength},
+		{"UsrSession nil Alpha", func() error { u := *sess; u.Alpha = nil; return u.Validate() }, ErrMissingField},
+		{"UsrSession identity Alpha", func() error { u := *sess; u.Alpha = identity; return u.Validate() }, ErrIdentityElement},
+		{"UsrSession nil Xu", func() error { u := *sess; u.Xu = nil; return u.Validate() }, ErrMissingField},
+		{"UsrSession identity Xu", func() error { u := *sess; u.Xu = identity; return u.Validate() }, ErrIdentityElement},
+
+		{"SvrSession unknown Version", func() error { v := *svrsess; v.Version = 0; return v.Validate() }, ErrUnsupportedVersion},
+		{"SvrSession nil Beta", func() error { v := *svrsess; v.Beta = nil; return v.Validate() }, ErrMissingField},
+		{"SvrSession identity Beta", func() error { v := *svrsess; v.Beta = identity; return v.Validate() }, ErrIdentityElement},
+		{"SvrSession nil Xs", func() error { v := *svrsess; v.Xs = nil; return v.Validate() }, ErrMissingField},
+		{"SvrSession identity Xs", func() error { v := *svrsess; v.Xs = identity; return v.Validate() }, ErrIdentityElement},
+		{"SvrSession missing fk1", func() error { v := *svrsess; v.fk1 = nil; return v.Validate() }, ErrMissingField},
+		{"SvrSession short fk1", func() error { v := *svrsess; v.fk1 = v.fk1[:4]; return v.Validate() }, ErrInvalidLength},
+		{"SvrSession missing Signature", func() error { v := *svrsess; v.Signature = nil; return v.Validate() }, ErrMissingField},
+		{"SvrSession long Signature", func() error {
+			v := *svrsess
+			v.Signature = append(append([]byte(nil), v.Signature...), 0)
+			return v.Validate()
+		}, ErrInvalidLength},
+		{"SvrSession missing Ciphertext", func() error { v := *svrsess; v.c.Ciphertext = nil; return v.Validate() }, ErrMissingField},
+		{"SvrSession long Ciphertext", func() error {
+			v := *svrsess
+			v.c.Ciphertext = make([]byte, maxCiphertextLength+1)
+			return v.Validate()
+		}, ErrInvalidLength},
+		{"SvrSession short Tag", func() error { v := *svrsess; v.c.Tag = v.c.Tag[:4]; return v.Validate() }, ErrInvalidLength},
+
+		{"Registration empty ID", func() error { r := *reg; r.ID = ""; return r.Validate() }, ErrMissingField},
+		{"Registration long ID", func() error { r := *reg; r.ID = longID; return r.Validate() }, ErrInvalidLength},
+		{"Registration nil Pu", func() error { r := *reg; r.Pu = nil; return r.Validate() }, ErrMissingField},
+		{"Registration identity Pu", func() error { r := *reg; r.Pu = identity; return r.Validate() }, ErrIdentityElement},
+		{"Registration missing Tag", func() error { r := *reg; r.aci.Tag = nil; return r.Validate() }, ErrMissingField},
+
+		{"ClientVerification empty ID", func() error { v := *cv; v.ID = ""; return v.Validate() }, ErrMissingField},
+		{"ClientVerification long ID", func() error { v := *cv; v.ID = longID; return v.Validate() }, ErrInvalidLength},
+		{"ClientVerification missing FK2", func() error { v := *cv; v.FK2 = nil; return v.Validate() }, ErrMissingField},
+		{"ClientVerification short FK2", func() error { v := *cv; v.FK2 = v.FK2[:31]; return v.Validate() }, ErrInvalidLength},
+	}
+	for _, test := range tests {
+		if err := test.validate(); !errors.Is(err, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, err)
+		}
+	}
+}
+
+// verify that Validate reports nil messages.
+func TestValidateNil(t *testing.T) {
+	for _, m := range []interface{ Validate() error }{
+		(*UsrSession)(nil),
+		(*SvrSession)(nil),
+		(*Registration)(nil),
+		(*ClientVerification)(nil),
+	} {
+		if err := m.Validate(); err != ErrNilMessage {
+			t.Fatalf("%T: expected ErrNilMessage, got %v", m, err)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package occlude
+
+import (
+	"errors"
+
+	ristretto "github.com/gtank/ristretto255"
+	"golang.org/x/crypto/sha3"
+)
+
+// ErrInvalidOPRFProof is returned by Client.FinishLogin when a SvrSession of
+// Version3 or later lacks an OPRFProof, or carries one which does not prove
+// that Beta was computed from the client's Alpha with the key committed to by
+// OPRFKey. It is also returned when OPRFKey is not the key the Client pinned
+// WithOPRFKey, or when a Client configured WithOPRFKey or WithRequiredOPRFProof
+// receives a SvrSession of a Version without proofs.
+var ErrInvalidOPRFProof = errors.New("invalid OPRF proof")
+
+// WithOPRFKey pins the commitment to the user's OPRF key, as returned by
+// Client.OPRFKey after their registration. The Client then rejects, before
+// running Argon2, any SvrSession whose Beta is not proven to have been
+// computed with that key, including one of a Version without proofs.
+//
+// NOTE: the effective OPRF key, and so its commitment, changes when the user
+// re-registers, and when the Server configured WithPeppers re-peppers their
+// password file at a login under a new pepper epoch. A client which pins the
+// commitment must then learn the new one, as from Client.OPRFKey after a
+// login whose Beta was proven against the key it has just verified.
+func WithOPRFKey(K *ristretto.Element) ClientOption {
+	return func(c *Client) {
+		c.pinnedOPRFKey = K
+		c.requireOPRFProof = true
+	}
+}
+
+// WithRequiredOPRFProof configures the Client to reject, with
+// ErrInvalidOPRFProof, any SvrSession of a Version without an OPRFProof.
+// Without it, a server which advertises an earlier Version skips the proof.
+// The proof is checked against the OPRFKey in the SvrSession itself, so it
+// catches a corrupted Beta but not a server which commits to another key;
+// pinning the key WithOPRFKey catches both.
+func WithRequiredOPRFProof() ClientOption {
+	return func(c *Client) {
+		c.requireOPRFProof = true
+	}
+}
+
+// OPRFKey returns the commitment to the user's OPRF key from the Client's most
+// recent successful Version3 registration or login, or nil if there was none,
+// for WithOPRFKey.
+func (c *Client) OPRFKey() *ristretto.Element {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.oprfKey
+}
+
+// oprfProofSize is the length of an OPRFProof, a challenge and a response
+// scalar.
+const oprfProofSize = 2 * scalarSize
+
+// proveOPRF returns the commitment K = ks*G to the OPRF key ks, and a
+// Chaum-Pedersen proof that log_G(K) = log_alpha(beta), making the OPRF
+// verifiable: a client which checks the proof with verifyOPRF knows that beta
+// is alpha raised to the committed key before it spends the Argon2 hardening
+// on it.
+//
+// NOTE: the proof binds beta to K, not to the key the user registered with.
+// Unless the client pins K WithOPRFKey, a server may commit to any key, and a
+// Beta computed under it is still only detected by the envelope failing to
+// open.
+func proveOPRF(ks *ristretto.Scalar, alpha, beta *ristretto.Element) (*ristretto.Element, []byte) {
+	K := new(ristretto.Element).ScalarBaseMult(ks)
+	r := randomScalar()
+	T1 := new(ristretto.Element).ScalarBaseMult(r)
+	T2 := new(ristretto.Element).ScalarMult(r, alpha)
+	c := oprfChallenge(K, alpha, beta, T1, T2)
+	s := new(ristretto.Scalar).Multiply(c, ks)
+	s.Subtract(r, s)
+	return K, s.Encode(c.Encode(nil))
+}
+
+// verifyOPRF checks the SvrSession's OPRFProof that Beta was computed from
+// alpha with the key the Client pinned, or else the key committed to by
+// OPRFKey. Sessions before Version3 carry no proof, and are only accepted if
+// the Client requires none.
+func (c *Client) verifyOPRF(v *SvrSession, alpha *ristretto.Element) error {
+	if v.Version < Version3 {
+		if c.requireOPRFProof {
+			return ErrInvalidOPRFProof
+		}
+		return nil
+	}
+	if c.pinnedOPRFKey != nil && (v.OPRFKey == nil || v.OPRFKey.Equal(c.pinnedOPRFKey) != 1) {
+		return ErrInvalidOPRFProof
+	}
+	return v.verifyOPRF(alpha)
+}
+
+// verifyOPRF checks the SvrSession's OPRFProof that Beta was computed from
+// alpha with the key committed to by OPRFKey.
+func (v *SvrSession) verifyOPRF(alpha *ristretto.Element) error {
+	if v.OPRFKey == nil || len(v.OPRFProof) != oprfProofSize || isIdentity(v.OPRFKey) {
+		return ErrInvalidOPRFProof
+	}
+	c, err := group.scalar(v.OPRFProof[:scalarSize])
+	if err != nil {
+		return ErrInvalidOPRFProof
+	}
+	s, err := group.scalar(v.OPRFProof[scalarSize:])
+	if err != nil {
+		return ErrInvalidOPRFProof
+	}
+	// T1 = s*G + c*K, T2 = s*alpha + c*beta
+	T1 := new(ristretto.Element).VarTimeDoubleScalarBaseMult(c, v.OPRFKey, s)
+	T2 := new(ristretto.Element).VarTimeMultiScalarMult([]*ristretto.Scalar{s, c}, []*ristretto.Element{alpha, v.Beta})
+	if oprfChallenge(v.OPRFKey, alpha, v.Beta, T1, T2).Equal(c) != 1 {
+		return ErrInvalidOPRFProof
+	}
+	return nil
+}
+
+// oprfChallenge computes the Chaum-Pedersen challenge c = H(K, alpha, beta,
+// T1, T2).
+func oprfChallenge(K, alpha, beta, T1, T2 *ristretto.Element) *ristretto.Scalar {
+	h := sha3.New512()
+	h.Write([]byte("occlude oprf proof"))
+	for _, el := range []*ristretto.Element{K, alpha, beta, T1, T2} {
+		h.Write(el.Encode(nil))
+	}
+	return new(ristretto.Scalar).FromUniformBytes(h.Sum(nil))
+}
@@ -0,0 +1,177 @@
+package occlude
+
+import (
+	"bytes"
+	"testing"
+
+	ristretto "github.com/gtank/ristretto255"
+)
+
+// verify that a Version3 SvrSession proves Beta, that the proof survives each
+// encoding, and that a forged Beta or a missing proof is rejected before the
+// client runs Argon2.
+func TestOPRFProof(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	argonCalls := 0
+	defer func(f func([]byte, []byte, uint32, uint32, uint8, uint32) []byte) { argon2IDKey = f }(argon2IDKey)
+	idKey := argon2IDKey
+	argon2IDKey = func(password, salt []byte, time, memory uint32, threads uint8, keyLen uint32) []byte {
+		argonCalls++
+		return idKey(password, salt, time, memory, threads, keyLen)
+	}
+
+	s := NewServer(WithArgon2Params(weakArgon2Params), WithVersion(Version3))
+	c := NewClient(testusername, WithServerKey(s.PublicKey()))
+	register(t, s, c, testusername, testpassword)
+
+	usrSession, err := c.NewSession(testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svrSession, sessionKey, err := s.NewSession(usrSession)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svrSession.OPRFKey == nil || len(svrSession.OPRFProof) != oprfProofSize {
+		t.Fatal("Version3 SvrSession carries no OPRF proof")
+	}
+	if err := svrSession.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := svrSession.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded SvrSession
+	if err := decoded.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	var fromProto SvrSession
+	if err := fromProto.FromProto(decoded.ToProto()); err != nil {
+		t.Fatal(err)
+	}
+	result, err := c.FinishLogin(&fromProto, testpassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(result.SessionKey, sessionKey) {
+		t.Fatal("client and server did not compute identical session key")
+	}
+
+	// a client which does not pin the server's key checks the proof too.
+	unpinned := NewClient(testusername)
+	for _, forge := range []func(v *SvrSession){
+		func(v *SvrSession) { v.Beta = new(ristretto.Element).Add(v.Beta, new(ristretto.Element).Base()) },
+		func(v *SvrSession) { v.Beta = new(ristretto.Element).ScalarMult(randomScalar(), usrSession.Alpha) },
+		func(v *SvrSession) { v.OPRFProof = nil },
+		func(v *SvrSession) { v.OPRFKey, v.OPRFProof = nil, nil },
+	} {
+		usrSession, err = unpinned.NewSession(testpassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		svrSession, _, err := s.NewSession(usrSession)
+		if err != nil {
+			t.Fatal(err)
+		}
+		forge(svrSession)
+		argonCalls = 0
+		if _, err := unpinned.FinishLogin(svrSession, testpassword); err != ErrInvalidOPRFProof {
+			t.Fatal("expected ErrInvalidOPRFProof, got", err)
+		}
+		if argonCalls != 0 {
+			t.Fatal("ran Argon2 before rejecting the OPRF proof")
+		}
+	}
+}
+
+// verify that a Client pinned to the OPRF key committed to at registration
+// rejects a SvrSession proving Beta against any other key, and that a Client
+// pinned to it, or requiring a proof, rejects a SvrSession downgraded to a
+// Version without proofs, in each case before running Argon2.
+func TestOPRFKeyPinning(t *testing.T) {
+	testpassword := "this is a test password"
+	testusername := "this is a test username"
+
+	argonCalls := 0
+	defer func(f func([]byte, []byte, uint32, uint32, uint8, uint32) []byte) { argon2IDKey = f }(argon2IDKey)
+	idKey := argon2IDKey
+	argon2IDKey = func(password, salt []byte, time, memory uint32, threads uint8, keyLen uint32) []byte {
+		argonCalls++
+		return idKey(password, salt, time, memory, threads, keyLen)
+	}
+
+	s := NewServer(WithArgon2Params(weakArgon2Params), WithVersion(Version3))
+	c := NewClient(testusername)
+	register(t, s, c, testusername, testpassword)
+	K := c.OPRFKey()
+	if K == nil {
+		t.Fatal("registration did not commit to the OPRF key")
+	}
+	pf := s.passwordFiles[testusername]
+	if K.Equal(new(ristretto.Element).ScalarBaseMult(pf.ks)) != 1 {
+		t.Fatal("registration committed to the wrong OPRF key")
+	}
+
+	// a new device learns the commitment from its first login, and a
+	// device pinned to it logs in.
+	device := NewClient(testusername)
+	login(t, s, device, testpassword, "")
+	if device.OPRFKey() == nil || device.OPRFKey().Equal(K) != 1 {
+		t.Fatal("login did not learn the committed OPRF key")
+	}
+	pinned := NewClient(testusername, WithOPRFKey(K))
+	login(t, s, pinned, testpassword, "")
+
+	substitute := func(v *SvrSession, alpha *ristretto.Element) {
+		k := randomScalar()
+		v.Beta = new(ristretto.Element).ScalarMult(k, alpha)
+		v.OPRFKey, v.OPRFProof = proveOPRF(k, alpha, v.Beta)
+	}
+	downgrade := func(v *SvrSession, alpha *ristretto.Element) {
+		v.Version, v.OPRFKey, v.OPRFProof = Version2, nil, nil
+	}
+	for _, test := range []struct {
+		client   *Client
+		forge    func(v *SvrSession, alpha *ristretto.Element)
+		rejected bool
+	}{
+		// a substituted key with a valid proof of its own is caught only
+		// by the pinned key...
+		{NewClient(testusername), substitute, false},
+		{pinned, substitute, true},
+		// ...and a downgrade only by a Client which requires a proof.
+		{NewClient(testusername), downgrade, false},
+		{pinned, downgrade, true},
+		{NewClient(testusername, WithRequiredOPRFProof()), downgrade, true},
+	} {
+		usrSession, err := test.client.NewSession(testpassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		svrSession, _, err := s.NewSession(usrSession)
+		if err != nil {
+			t.Fatal(err)
+		}
+		test.forge(svrSession, usrSession.Alpha)
+		argonCalls = 0
+		_, err = test.client.FinishLogin(svrSession, testpassword)
+		if test.rejected {
+			if err != ErrInvalidOPRFProof {
+				t.Fatal("expected ErrInvalidOPRFProof, got", err)
+			}
+			if argonCalls != 0 {
+				t.Fatal("ran Argon2 before rejecting the OPRF proof")
+			}
+			continue
+		}
+		if err == nil || err == ErrInvalidOPRFProof {
+			t.Fatal("expected the login to fail after the OPRF, got", err)
+		}
+		if argonCalls == 0 {
+			t.Fatal("rejected the SvrSession before the OPRF")
+		}
+	}
+}